@@ -11,7 +11,9 @@ func main() {
 		log.Fatalf("Ошибка создания приложения: %v", err)
 	}
 
-	app.BuildWalletLayer()
+	if err := app.BuildWalletLayer(); err != nil {
+		log.Fatalf("Ошибка сборки слоя кошельков: %v", err)
+	}
 	if err := app.Run(); err != nil {
 		log.Fatalf("Ошибка при работе приложения: %v", err)
 	}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/middlew"
+	"api_wallet/internal/service"
+	"api_wallet/pkg/response"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminHandler обслуживает служебные эндпоинты: просмотр и replay dead letter.
+type AdminHandler struct {
+	deadLetters service.DeadLetterServicer
+}
+
+func NewAdminHandler(deadLetters service.DeadLetterServicer) *AdminHandler {
+	return &AdminHandler{
+		deadLetters: deadLetters,
+	}
+}
+
+func (h *AdminHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.ListDeadLetters"
+	log := middlew.GetLogger(r.Context())
+
+	letters, err := h.deadLetters.ListDeadLetters(r.Context())
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	response.WriteJSONSuccess(w, log, http.StatusOK, letters)
+}
+
+func (h *AdminHandler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.ReplayDeadLetter"
+	log := middlew.GetLogger(r.Context())
+
+	id, violations := parseUUIDParam(chi.URLParam(r, "letterID"), "letterId")
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if err := h.deadLetters.ReplayDeadLetter(r.Context(), id); err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("dead letter записан в БД", slog.String("op", op), slog.String("id", id.String()))
+	w.WriteHeader(http.StatusNoContent)
+}
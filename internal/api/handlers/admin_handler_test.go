@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/service"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ service.DeadLetterServicer = (*mockDeadLetterService)(nil)
+
+type mockDeadLetterService struct {
+	ListDeadLettersFunc  func(ctx context.Context) ([]models.DeadLetter, error)
+	ReplayDeadLetterFunc func(ctx context.Context, id uuid.UUID) error
+}
+
+func (m *mockDeadLetterService) ListDeadLetters(ctx context.Context) ([]models.DeadLetter, error) {
+	if m.ListDeadLettersFunc != nil {
+		return m.ListDeadLettersFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *mockDeadLetterService) ReplayDeadLetter(ctx context.Context, id uuid.UUID) error {
+	if m.ReplayDeadLetterFunc != nil {
+		return m.ReplayDeadLetterFunc(ctx, id)
+	}
+	return nil
+}
+
+func TestAdminHandler_ListDeadLetters(t *testing.T) {
+	mockService := &mockDeadLetterService{}
+	handler := NewAdminHandler(mockService)
+
+	letterID := uuid.New()
+	walletID := uuid.New()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		mockLetters    []models.DeadLetter
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+	}{
+		{
+			name: "Success",
+			mockLetters: []models.DeadLetter{{
+				ID:         letterID,
+				WalletIDs:  []uuid.UUID{walletID},
+				Operations: []models.Operation{},
+				Attempts:   4,
+				LastError:  "connection refused",
+				CreatedAt:  createdAt,
+			}},
+			expectedStatus: http.StatusOK,
+			expectedBody: fmt.Sprintf(`[{"id":"%s","walletIds":["%s"],"operations":[],"attempts":4,"lastError":"connection refused","createdAt":"2024-01-02T03:04:05Z"}]`,
+				letterID, walletID),
+		},
+		{
+			name:           "Success - Empty",
+			mockLetters:    []models.DeadLetter{},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+		{
+			name:           "Error - Internal Server Error",
+			mockError:      errors.New("disk failure"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.ListDeadLettersFunc = func(ctx context.Context) ([]models.DeadLetter, error) {
+				return tc.mockLetters, tc.mockError
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/dead-letters", nil)
+			rr := httptest.NewRecorder()
+			handler.ListDeadLetters(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminHandler_ReplayDeadLetter(t *testing.T) {
+	mockService := &mockDeadLetterService{}
+	handler := NewAdminHandler(mockService)
+
+	letterID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		letterIDParam  string
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success",
+			letterIDParam:  letterID.String(),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Error - Invalid UUID",
+			letterIDParam:  "not-a-valid-uuid",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"letterId"},
+		},
+		{
+			name:           "Error - Not Found",
+			letterIDParam:  letterID.String(),
+			mockError:      custom_err.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+		{
+			name:           "Error - Service Stopping",
+			letterIDParam:  letterID.String(),
+			mockError:      custom_err.ErrServiceStopping,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.ServiceStopping,
+		},
+		{
+			name:           "Error - Database Unavailable",
+			letterIDParam:  letterID.String(),
+			mockError:      errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.ReplayDeadLetterFunc = func(ctx context.Context, id uuid.UUID) error {
+				assert.Equal(t, letterID, id)
+				return tc.mockError
+			}
+
+			url := fmt.Sprintf("/api/v1/admin/dead-letters/%s/replay", tc.letterIDParam)
+			req := httptest.NewRequest(http.MethodPost, url, nil)
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("letterID", tc.letterIDParam)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			rr := httptest.NewRecorder()
+			handler.ReplayDeadLetter(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
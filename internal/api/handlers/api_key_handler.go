@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/middlew"
+	"api_wallet/internal/models"
+	"api_wallet/pkg/request"
+	"api_wallet/pkg/response"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// APIKeyManager выдаёт и отзывает API-ключи.
+type APIKeyManager interface {
+	CreateKey(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error)
+	ListKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeKey(ctx context.Context, id uuid.UUID) error
+}
+
+// APIKeyHandler обслуживает /api/v1/admin/api-keys.
+type APIKeyHandler struct {
+	keys    APIKeyManager
+	decoder *request.Decoder
+}
+
+func NewAPIKeyHandler(keys APIKeyManager, decoder *request.Decoder) *APIKeyHandler {
+	return &APIKeyHandler{
+		keys:    keys,
+		decoder: decoder,
+	}
+}
+
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.CreateAPIKey"
+	log := middlew.GetLogger(r.Context())
+
+	defer r.Body.Close()
+
+	var req models.CreateAPIKeyRequest
+	violations, err := decodeJSON(h.decoder, r.Body, &req)
+	if err != nil {
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	violations.Merge(req.Validate())
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	key, err := h.keys.CreateKey(r.Context(), req)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("API-ключ создан", slog.String("op", op), slog.String("id", key.ID.String()), slog.Any("scopes", key.Scopes))
+	response.WriteJSONSuccess(w, log, http.StatusCreated, key)
+}
+
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.ListAPIKeys"
+	log := middlew.GetLogger(r.Context())
+
+	keys, err := h.keys.ListKeys(r.Context())
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	response.WriteJSONSuccess(w, log, http.StatusOK, keys)
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.RevokeAPIKey"
+	log := middlew.GetLogger(r.Context())
+
+	id, violations := parseUUIDParam(chi.URLParam(r, "keyID"), "keyId")
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if err := h.keys.RevokeKey(r.Context(), id); err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("API-ключ отозван", slog.String("op", op), slog.String("id", id.String()))
+	w.WriteHeader(http.StatusNoContent)
+}
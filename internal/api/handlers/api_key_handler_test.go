@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/pkg/request"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ APIKeyManager = (*mockAPIKeyManager)(nil)
+
+type mockAPIKeyManager struct {
+	CreateKeyFunc func(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error)
+	ListKeysFunc  func(ctx context.Context) ([]models.APIKey, error)
+	RevokeKeyFunc func(ctx context.Context, id uuid.UUID) error
+}
+
+func (m *mockAPIKeyManager) CreateKey(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error) {
+	if m.CreateKeyFunc != nil {
+		return m.CreateKeyFunc(ctx, req)
+	}
+	return nil, nil
+}
+
+func (m *mockAPIKeyManager) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	if m.ListKeysFunc != nil {
+		return m.ListKeysFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *mockAPIKeyManager) RevokeKey(ctx context.Context, id uuid.UUID) error {
+	if m.RevokeKeyFunc != nil {
+		return m.RevokeKeyFunc(ctx, id)
+	}
+	return nil
+}
+
+func TestAPIKeyHandler_CreateAPIKey(t *testing.T) {
+	mockKeys := &mockAPIKeyManager{}
+	handler := NewAPIKeyHandler(mockKeys, request.NewDecoder())
+
+	keyID := uuid.New()
+	walletID := uuid.New()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		inputBody      string
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success",
+			inputBody:      fmt.Sprintf(`{"name":" shop ","scopes":["wallets:read","wallets:write"],"walletIds":["%s"]}`, walletID),
+			expectedStatus: http.StatusCreated,
+			expectedBody: fmt.Sprintf(`{"id":"%s","name":"shop","prefix":"wk_abcdefgh","scopes":["wallets:read","wallets:write"],"walletIds":["%s"],"createdAt":"2024-01-02T03:04:05Z","key":"wk_abcdefgh-secret"}`,
+				keyID, walletID),
+		},
+		{
+			name:           "Error - Missing Name",
+			inputBody:      `{"scopes":["admin"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"name"},
+		},
+		{
+			name:           "Error - Missing Scopes",
+			inputBody:      `{"name":"shop"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"scopes"},
+		},
+		{
+			name:           "Error - Unknown Scope",
+			inputBody:      `{"name":"shop","scopes":["wallets:delete"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"scopes"},
+		},
+		{
+			name:           "Error - Empty Wallet List",
+			inputBody:      `{"name":"shop","scopes":["wallets:read"],"walletIds":[]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"walletIds"},
+		},
+		{
+			name:           "Error - Invalid JSON",
+			inputBody:      `{"name":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidJSON,
+		},
+		{
+			name:           "Error - Internal",
+			inputBody:      `{"name":"shop","scopes":["admin"]}`,
+			mockError:      errors.New("db is down"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockKeys.CreateKeyFunc = func(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error) {
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.CreatedAPIKey{
+					APIKey: models.APIKey{
+						ID: keyID, Name: req.Name, Prefix: "wk_abcdefgh", Scopes: req.Scopes, WalletIDs: req.WalletIDs, CreatedAt: createdAt,
+					},
+					Key: "wk_abcdefgh-secret",
+				}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/api-keys", bytes.NewBufferString(tc.inputBody))
+			rr := httptest.NewRecorder()
+			handler.CreateAPIKey(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeyHandler_RevokeAPIKey(t *testing.T) {
+	mockKeys := &mockAPIKeyManager{}
+	handler := NewAPIKeyHandler(mockKeys, request.NewDecoder())
+
+	keyID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		keyIDParam     string
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success",
+			keyIDParam:     keyID.String(),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Error - Not Found",
+			keyIDParam:     keyID.String(),
+			mockError:      custom_err.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+		{
+			name:           "Error - Invalid UUID",
+			keyIDParam:     "not-a-valid-uuid",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"keyId"},
+		},
+		{
+			name:           "Error - Internal",
+			keyIDParam:     keyID.String(),
+			mockError:      errors.New("db is down"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockKeys.RevokeKeyFunc = func(ctx context.Context, id uuid.UUID) error {
+				assert.Equal(t, keyID, id)
+				return tc.mockError
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/api-keys/"+tc.keyIDParam, nil)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("keyID", tc.keyIDParam)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			rr := httptest.NewRecorder()
+			handler.RevokeAPIKey(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/pkg/request"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// writeError отвечает на ошибку сервиса кодом из каталога problem. Ошибки
+// сервера пишутся в лог как Error, отсутствие записи — как Info, остальные — как Warn.
+func writeError(w http.ResponseWriter, r *http.Request, log *slog.Logger, op string, err error) {
+	p := problem.FromError(err)
+	attrs := []any{slog.String("op", op), slog.String("code", string(p.Code)), slog.String("error", err.Error())}
+	switch p.Code {
+	case problem.Internal:
+		log.Error("ошибка обработки запроса", attrs...)
+	case problem.NotFound:
+		log.Info("запись не найдена", attrs...)
+	default:
+		log.Warn("запрос отклонён", attrs...)
+	}
+	if p.Code == problem.Overloaded {
+		setRetryAfter(w, err)
+	}
+	problem.Write(w, r, log, p)
+}
+
+// writeViolations отвечает validation_failed со всеми нарушениями запроса.
+func writeViolations(w http.ResponseWriter, r *http.Request, log *slog.Logger, op string, violations models.Violations) {
+	log.Warn("невалидный запрос", slog.String("op", op), slog.Any("violations", violations))
+	problem.Write(w, r, log, problem.Validation(violations))
+}
+
+// writeInvalidJSON отвечает на тело, которое не разбирается как один JSON-объект:
+// слишком большое тело — body_too_large, остальное — invalid_json с местом ошибки.
+func writeInvalidJSON(w http.ResponseWriter, r *http.Request, log *slog.Logger, op string, err error) {
+	log.Warn("ошибка декодирования JSON", slog.String("op", op), slog.String("error", err.Error()))
+
+	var decodeErr *request.Error
+	if !errors.As(err, &decodeErr) {
+		problem.Write(w, r, log, problem.New(problem.InvalidJSON, "Request body could not be read"))
+		return
+	}
+	if errors.Is(err, request.ErrTooLarge) {
+		problem.Write(w, r, log, problem.New(problem.BodyTooLarge, ""))
+		return
+	}
+
+	var detail string
+	switch {
+	case errors.Is(err, request.ErrEmpty):
+		detail = "Request body is empty"
+	case errors.Is(err, request.ErrSyntax):
+		detail = "Request body is not valid JSON: " + decodeErr.Reason
+	case errors.Is(err, request.ErrTrailingData):
+		detail = "Request body must contain a single JSON object"
+	default:
+		detail = "Request body must be a JSON object"
+	}
+	p := problem.New(problem.InvalidJSON, detail)
+	if decodeErr.Position.Line > 0 {
+		p.Position = position(decodeErr.Position)
+	}
+	problem.Write(w, r, log, p)
+}
+
+// parseUUIDParam разбирает UUID из параметра маршрута. field — имя поля в нарушении.
+func parseUUIDParam(value, field string) (uuid.UUID, models.Violations) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		var v models.Violations
+		v.Add(field, models.ViolationInvalid, field+" must be a UUID")
+		return uuid.Nil, v
+	}
+	return id, nil
+}
+
+// decodeJSON разбирает тело в dst. Ошибки в отдельных полях возвращаются
+// нарушениями, чтобы ответить о них вместе с нарушениями Validate; ошибка тела
+// целиком возвращается как error.
+func decodeJSON(decoder *request.Decoder, body io.Reader, dst any) (models.Violations, error) {
+	err := decoder.Decode(body, dst)
+	var fieldErrs request.FieldErrors
+	if !errors.As(err, &fieldErrs) {
+		return nil, err
+	}
+
+	var violations models.Violations
+	for _, f := range fieldErrs {
+		var v models.Violation
+		switch f.Kind {
+		case request.FieldUnknown:
+			v = models.Violation{Field: f.Field, Code: models.ViolationUnknownField, Detail: f.Field + " is not a known field"}
+		case request.FieldDuplicate:
+			v = models.Violation{Field: f.Field, Code: models.ViolationDuplicate, Detail: f.Field + " is set more than once"}
+		default:
+			v = models.Violation{Field: f.Field, Code: models.ViolationInvalid, Detail: f.Field + " must be " + f.Expected}
+		}
+		v.Position = position(f.Position)
+		violations = append(violations, v)
+	}
+	return violations, nil
+}
+
+func position(p request.Position) *models.Position {
+	return &models.Position{Offset: p.Offset, Line: p.Line, Column: p.Column}
+}
+
+// setRetryAfter выставляет заголовок Retry-After в целых секундах, не меньше одной.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	seconds := 1
+	var retry *custom_err.RetryAfterError
+	if errors.As(err, &retry) && retry.After > time.Second {
+		seconds = int((retry.After + time.Second - 1) / time.Second)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
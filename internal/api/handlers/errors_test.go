@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/models"
+	"api_wallet/pkg/request"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertProblem проверяет, что ответ — problem+json с кодом code и нарушениями
+// ровно в полях fields.
+func assertProblem(t *testing.T, rr *httptest.ResponseRecorder, code problem.Code, fields ...string) {
+	t.Helper()
+
+	assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+
+	var p problem.Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	assert.Equal(t, code, p.Code)
+	assert.Equal(t, rr.Code, p.Status)
+	assert.Equal(t, problem.TypeBase+string(code), p.Type)
+	assert.NotEmpty(t, p.Title)
+	assert.NotEmpty(t, p.Instance)
+
+	got := make([]string, 0, len(p.Errors))
+	for _, v := range p.Errors {
+		got = append(got, v.Field)
+		assert.NotEmpty(t, v.Code)
+		assert.NotEmpty(t, v.Detail)
+	}
+	if fields == nil {
+		fields = []string{}
+	}
+	assert.Equal(t, fields, got)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	walletID := uuid.New()
+	decoder := request.NewDecoder(request.WithAliases(map[string]string{"valletId": "walletId"}))
+
+	testCases := []struct {
+		name               string
+		body               string
+		expectedErr        error
+		expectedViolations models.Violations
+		expectedReq        models.WalletOperationRequest
+	}{
+		{
+			name:        "Valid Body",
+			body:        `{"walletId":"` + walletID.String() + `","operationType":"DEPOSIT","amount":100}`,
+			expectedReq: models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 100},
+		},
+		{
+			name:        "Legacy Field Name",
+			body:        `{"valletId":"` + walletID.String() + `","operationType":"DEPOSIT","amount":100}`,
+			expectedReq: models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 100},
+		},
+		{
+			name: "Field Errors Reported Per Field",
+			body: `{"walletId":"not-a-uuid","operationType":7,"amount":1,"amount":2,"note":"x"}`,
+			expectedViolations: models.Violations{
+				{Field: "walletId", Code: models.ViolationInvalid, Detail: "walletId must be a UUID", Position: &models.Position{Offset: 12, Line: 1, Column: 13}},
+				{Field: "operationType", Code: models.ViolationInvalid, Detail: "operationType must be a string", Position: &models.Position{Offset: 41, Line: 1, Column: 42}},
+				{Field: "amount", Code: models.ViolationDuplicate, Detail: "amount is set more than once", Position: &models.Position{Offset: 54, Line: 1, Column: 55}},
+				{Field: "note", Code: models.ViolationUnknownField, Detail: "note is not a known field", Position: &models.Position{Offset: 65, Line: 1, Column: 66}},
+			},
+			expectedReq: models.WalletOperationRequest{Amount: 1},
+		},
+		{
+			name:        "Malformed JSON",
+			body:        `{"walletId":`,
+			expectedErr: request.ErrSyntax,
+		},
+		{
+			name:        "Trailing Data",
+			body:        `{"amount":1}{"amount":2}`,
+			expectedErr: request.ErrTrailingData,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req models.WalletOperationRequest
+			violations, err := decodeJSON(decoder, strings.NewReader(tc.body), &req)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedViolations, violations)
+			assert.Equal(t, tc.expectedReq, req)
+		})
+	}
+}
+
+func TestWriteInvalidJSON(t *testing.T) {
+	decoder := request.NewDecoder(request.WithMaxBytes(32))
+
+	testCases := []struct {
+		name             string
+		body             string
+		expectedStatus   int
+		expectedCode     problem.Code
+		expectedPosition *models.Position
+	}{
+		{"Syntax Error", "{\n  \"amount\": 1,,\n}", http.StatusBadRequest, problem.InvalidJSON, &models.Position{Offset: 16, Line: 2, Column: 15}},
+		{"Not An Object", `"DEPOSIT"`, http.StatusBadRequest, problem.InvalidJSON, &models.Position{Offset: 0, Line: 1, Column: 1}},
+		{"Empty", ``, http.StatusBadRequest, problem.InvalidJSON, nil},
+		{"Too Large", `{"operationType":"` + strings.Repeat("A", 32) + `"}`, http.StatusRequestEntityTooLarge, problem.BodyTooLarge, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req models.WalletOperationRequest
+			_, err := decodeJSON(decoder, strings.NewReader(tc.body), &req)
+			require.Error(t, err)
+
+			rr := httptest.NewRecorder()
+			writeInvalidJSON(rr, httptest.NewRequest(http.MethodPost, "/api/v1/wallet", nil), slog.Default(), "test", err)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assertProblem(t, rr, tc.expectedCode)
+			var p problem.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, tc.expectedPosition, p.Position)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/middlew"
+	"api_wallet/internal/health"
+	"api_wallet/pkg/response"
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// ReadinessChecker выполняет проверки готовности.
+type ReadinessChecker interface {
+	Run(ctx context.Context) health.Report
+}
+
+// HealthHandler обслуживает /healthz и /readyz.
+type HealthHandler struct {
+	readiness ReadinessChecker
+}
+
+func NewHealthHandler(readiness ReadinessChecker) *HealthHandler {
+	return &HealthHandler{
+		readiness: readiness,
+	}
+}
+
+// Liveness отвечает, пока процесс обрабатывает запросы. Зависимости не
+// проверяются: их недоступность не лечится перезапуском.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	log := middlew.GetLogger(r.Context())
+	response.WriteJSONSuccess(w, log, http.StatusOK, map[string]string{"status": health.StatusOK})
+}
+
+// Readiness выполняет проверки готовности и отвечает 200 или 503 с результатом каждой.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.Readiness"
+	log := middlew.GetLogger(r.Context())
+
+	report := h.readiness.Run(r.Context())
+	if !report.Ready() {
+		log.Warn("экземпляр не готов", slog.String("op", op), slog.Any("checks", report.Checks))
+		response.WriteJSONSuccess(w, log, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	response.WriteJSONSuccess(w, log, http.StatusOK, report)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"api_wallet/internal/health"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockReadinessChecker struct {
+	report health.Report
+}
+
+func (m *mockReadinessChecker) Run(ctx context.Context) health.Report {
+	return m.report
+}
+
+func TestHealthHandler_Liveness(t *testing.T) {
+	handler := NewHealthHandler(&mockReadinessChecker{})
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rr := httptest.NewRecorder()
+	handler.Liveness(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+}
+
+func TestHealthHandler_Readiness(t *testing.T) {
+	checker := &mockReadinessChecker{}
+	handler := NewHealthHandler(checker)
+
+	testCases := []struct {
+		name           string
+		report         health.Report
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "Ready",
+			report: health.Report{Status: health.StatusOK, Checks: map[string]health.Result{
+				"database": {Status: health.StatusOK},
+			}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"ok","checks":{"database":{"status":"ok"}}}`,
+		},
+		{
+			name: "Not ready",
+			report: health.Report{Status: health.StatusFail, Checks: map[string]health.Result{
+				"database": {Status: health.StatusOK},
+				"shutdown": {Status: health.StatusFail, Error: "экземпляр останавливается"},
+			}},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status":"fail","checks":{"database":{"status":"ok"},"shutdown":{"status":"fail","error":"экземпляр останавливается"}}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker.report = tc.report
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			rr := httptest.NewRecorder()
+			handler.Readiness(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/middlew"
+	"api_wallet/internal/models"
+	"api_wallet/pkg/request"
+	"api_wallet/pkg/response"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *WalletHandler) CreateHold(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.CreateHold"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.CreateHold")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	defer r.Body.Close()
+
+	walletID, violations := parseUUIDParam(chi.URLParam(r, "walletID"), "walletId")
+	var req models.CreateHoldRequest
+	bodyViolations, err := decodeJSON(h.decoder, r.Body, &req)
+	if err != nil {
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	violations.Merge(bodyViolations)
+	violations.Merge(req.Validate())
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if !allowWallet(w, r, log, op, walletID) {
+		return
+	}
+
+	hold, err := h.service.CreateHold(r.Context(), walletID, req)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("холд создан", slog.String("op", op), slog.String("id", hold.ID.String()))
+	response.WriteJSONSuccess(w, log, http.StatusCreated, hold)
+}
+
+func (h *WalletHandler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.CaptureHold"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.CaptureHold")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	defer r.Body.Close()
+
+	walletID, violations := parseUUIDParam(chi.URLParam(r, "walletID"), "walletId")
+	holdID, holdViolations := parseUUIDParam(chi.URLParam(r, "holdID"), "holdId")
+	violations.Merge(holdViolations)
+
+	// Тело необязательно: без суммы списывается весь холд.
+	var req models.CaptureHoldRequest
+	bodyViolations, err := decodeJSON(h.decoder, r.Body, &req)
+	if err != nil && !errors.Is(err, request.ErrEmpty) {
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	violations.Merge(bodyViolations)
+	violations.Merge(req.Validate())
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if !allowWallet(w, r, log, op, walletID) {
+		return
+	}
+
+	hold, err := h.service.CaptureHold(r.Context(), walletID, holdID, req)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("холд списан", slog.String("op", op), slog.String("id", hold.ID.String()), slog.Int64("amount", hold.CapturedAmount))
+	response.WriteJSONSuccess(w, log, http.StatusOK, hold)
+}
+
+func (h *WalletHandler) VoidHold(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.VoidHold"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.VoidHold")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	walletID, violations := parseUUIDParam(chi.URLParam(r, "walletID"), "walletId")
+	holdID, holdViolations := parseUUIDParam(chi.URLParam(r, "holdID"), "holdId")
+	violations.Merge(holdViolations)
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if !allowWallet(w, r, log, op, walletID) {
+		return
+	}
+
+	hold, err := h.service.VoidHold(r.Context(), walletID, holdID)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("холд отменён", slog.String("op", op), slog.String("id", hold.ID.String()))
+	response.WriteJSONSuccess(w, log, http.StatusOK, hold)
+}
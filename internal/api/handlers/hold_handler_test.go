@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/pkg/request"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// withHoldParams подставляет параметры маршрута холда, как это делает chi.
+func withHoldParams(req *http.Request, walletID, holdID string) *http.Request {
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("walletID", walletID)
+	if holdID != "" {
+		chiCtx.URLParams.Add("holdID", holdID)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+}
+
+func TestWalletHandler_CreateHold(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	walletID := uuid.MustParse("a7c9a494-386b-436d-8a58-29b7a3f754a3")
+	holdID := uuid.MustParse("5b0c7e4e-7a3c-4a9b-9d1e-2f6a8c3b1d40")
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		walletIDParam  string
+		inputBody      string
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success",
+			walletIDParam:  walletID.String(),
+			inputBody:      `{"amount": 300, "expiresInSeconds": 60}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody: fmt.Sprintf(`{"id":"%s","walletId":"%s","amount":300,"capturedAmount":0,"status":"active",`+
+				`"requestId":"00000000-0000-0000-0000-000000000000","expiresAt":"2026-01-02T03:05:05Z",`+
+				`"createdAt":"2026-01-02T03:04:05Z","updatedAt":"2026-01-02T03:04:05Z"}`, holdID, walletID),
+		},
+		{
+			name:           "Error - Invalid Fields",
+			walletIDParam:  "not-a-uuid",
+			inputBody:      `{"amount": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"walletId", "amount", "expiresInSeconds"},
+		},
+		{
+			name:           "Error - Expiry Out Of Range",
+			walletIDParam:  walletID.String(),
+			inputBody:      fmt.Sprintf(`{"amount": 1, "expiresInSeconds": %d}`, models.MaxHoldExpiresIn+1),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"expiresInSeconds"},
+		},
+		{
+			name:           "Error - Empty Body",
+			walletIDParam:  walletID.String(),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidJSON,
+		},
+		{
+			name:           "Error - Insufficient Funds",
+			walletIDParam:  walletID.String(),
+			inputBody:      `{"amount": 300, "expiresInSeconds": 60}`,
+			mockError:      custom_err.ErrInsufficientFunds,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InsufficientFunds,
+		},
+		{
+			name:           "Error - Wallet Frozen",
+			walletIDParam:  walletID.String(),
+			inputBody:      `{"amount": 300, "expiresInSeconds": 60}`,
+			mockError:      custom_err.ErrWalletFrozen,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.WalletFrozen,
+		},
+		{
+			name:           "Error - Duplicate Request",
+			walletIDParam:  walletID.String(),
+			inputBody:      `{"amount": 300, "expiresInSeconds": 60, "requestId": "bc0fa7c5-4a6e-44d8-a330-8803942f9fc2"}`,
+			mockError:      custom_err.ErrDuplicateRequest,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.DuplicateRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.CreateHoldFunc = func(ctx context.Context, walletID uuid.UUID, req models.CreateHoldRequest) (*models.Hold, error) {
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.Hold{
+					ID:        holdID,
+					WalletID:  walletID,
+					Amount:    req.Amount,
+					Status:    models.HoldActive,
+					ExpiresAt: createdAt.Add(time.Duration(req.ExpiresIn) * time.Second),
+					CreatedAt: createdAt,
+					UpdatedAt: createdAt,
+				}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallets/"+tc.walletIDParam+"/holds", bytes.NewBufferString(tc.inputBody))
+			req = withHoldParams(req, tc.walletIDParam, "")
+
+			rr := httptest.NewRecorder()
+			handler.CreateHold(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWalletHandler_CaptureHold(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	walletID := uuid.New()
+	holdID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		holdIDParam    string
+		inputBody      string
+		mockError      error
+		expectedStatus int
+		expectedAmount *int64
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success - Full Amount Without Body",
+			holdIDParam:    holdID.String(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Success - Partial Amount",
+			holdIDParam:    holdID.String(),
+			inputBody:      `{"amount": 120}`,
+			expectedStatus: http.StatusOK,
+			expectedAmount: func() *int64 { v := int64(120); return &v }(),
+		},
+		{
+			name:           "Error - Invalid Hold ID And Amount",
+			holdIDParam:    "not-a-uuid",
+			inputBody:      `{"amount": -5}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"holdId", "amount"},
+		},
+		{
+			name:           "Error - Malformed JSON",
+			holdIDParam:    holdID.String(),
+			inputBody:      `{"amount":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidJSON,
+		},
+		{
+			name:           "Error - Exceeds Hold",
+			holdIDParam:    holdID.String(),
+			inputBody:      `{"amount": 1000}`,
+			mockError:      custom_err.ErrCaptureExceedsHold,
+			expectedStatus: http.StatusBadRequest,
+			expectedAmount: func() *int64 { v := int64(1000); return &v }(),
+			expectedCode:   problem.CaptureExceedsHold,
+		},
+		{
+			name:           "Error - Hold Expired",
+			holdIDParam:    holdID.String(),
+			mockError:      custom_err.ErrHoldExpired,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.HoldExpired,
+		},
+		{
+			name:           "Error - Hold Not Active",
+			holdIDParam:    holdID.String(),
+			mockError:      custom_err.ErrHoldNotActive,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.HoldNotActive,
+		},
+		{
+			name:           "Error - Not Found",
+			holdIDParam:    holdID.String(),
+			mockError:      custom_err.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.CaptureHoldFunc = func(ctx context.Context, gotWalletID, gotHoldID uuid.UUID, req models.CaptureHoldRequest) (*models.Hold, error) {
+				assert.Equal(t, walletID, gotWalletID)
+				assert.Equal(t, holdID, gotHoldID)
+				assert.Equal(t, tc.expectedAmount, req.Amount)
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.Hold{ID: gotHoldID, WalletID: gotWalletID, Amount: 300, CapturedAmount: 120, Status: models.HoldCaptured}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallets/"+walletID.String()+"/holds/"+tc.holdIDParam+"/capture", bytes.NewBufferString(tc.inputBody))
+			req = withHoldParams(req, walletID.String(), tc.holdIDParam)
+
+			rr := httptest.NewRecorder()
+			handler.CaptureHold(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else {
+				assert.Contains(t, rr.Body.String(), `"status":"captured"`)
+			}
+		})
+	}
+}
+
+func TestWalletHandler_VoidHold(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	walletID := uuid.New()
+	holdID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		walletIDParam  string
+		mockError      error
+		expectedStatus int
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success",
+			walletIDParam:  walletID.String(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Error - Invalid Wallet ID",
+			walletIDParam:  "not-a-uuid",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"walletId"},
+		},
+		{
+			name:           "Error - Hold Not Active",
+			walletIDParam:  walletID.String(),
+			mockError:      custom_err.ErrHoldNotActive,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.HoldNotActive,
+		},
+		{
+			name:           "Error - Service Stopping",
+			walletIDParam:  walletID.String(),
+			mockError:      custom_err.ErrServiceStopping,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.ServiceStopping,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.VoidHoldFunc = func(ctx context.Context, walletID, holdID uuid.UUID) (*models.Hold, error) {
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.Hold{ID: holdID, WalletID: walletID, Amount: 300, Status: models.HoldVoided}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallets/"+tc.walletIDParam+"/holds/"+holdID.String()+"/void", nil)
+			req = withHoldParams(req, tc.walletIDParam, holdID.String())
+
+			rr := httptest.NewRecorder()
+			handler.VoidHold(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else {
+				assert.Contains(t, rr.Body.String(), `"status":"voided"`)
+			}
+		})
+	}
+}
@@ -2,97 +2,318 @@ package handlers
 
 import (
 	"api_wallet/internal/api/middlew"
-	"api_wallet/internal/custom_err"
+	"api_wallet/internal/api/problem"
 	"api_wallet/internal/models"
 	"api_wallet/internal/service"
+	"api_wallet/pkg/request"
 	"api_wallet/pkg/response"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("api_wallet/internal/api/handlers")
+
 type WalletHandler struct {
 	service service.WalletServicer
+	decoder *request.Decoder
 }
 
-func NewWalletHandler(service service.WalletServicer) *WalletHandler {
+func NewWalletHandler(service service.WalletServicer, decoder *request.Decoder) *WalletHandler {
 	return &WalletHandler{
 		service: service,
+		decoder: decoder,
 	}
 }
 
 func (h *WalletHandler) GetWalletByID(w http.ResponseWriter, r *http.Request) {
 	const op = "handler.GetWalletByID"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.GetWalletByID")
+	defer span.End()
+	r = r.WithContext(ctx)
 	log := middlew.GetLogger(r.Context())
 
-	idStr := chi.URLParam(r, "walletID")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		log.Warn("невалидный UUID", slog.String("op", op), slog.String("uuid", idStr))
-		response.WriteJSONError(w, log, http.StatusBadRequest, "invalid_request", "Invalid wallet ID format")
+	id, violations := parseUUIDParam(chi.URLParam(r, "walletID"), "walletId")
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if !allowWallet(w, r, log, op, id) {
 		return
 	}
 
 	wallet, err := h.service.GetWalletByID(r.Context(), id)
 	if err != nil {
-		switch {
-		case errors.Is(err, custom_err.ErrNotFound):
-			log.Info("кошелек не найден", slog.String("op", op), slog.String("id", id.String()))
-			response.WriteJSONError(w, log, http.StatusNotFound, "not_found", "Wallet not found")
-		default:
-			log.Error("ошибка получения кошелька", slog.String("op", op), slog.String("error", err.Error()))
-			response.WriteJSONError(w, log, http.StatusInternalServerError, "internal_error", "Failed to retrieve wallet")
-		}
+		writeError(w, r, log, op, err)
 		return
 	}
 
 	response.WriteJSONSuccess(w, log, http.StatusOK, wallet)
 }
 
+func (h *WalletHandler) CreateWallet(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.CreateWallet"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.CreateWallet")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	defer r.Body.Close()
+
+	// Тело необязательно: без него id кошелька генерирует сервер.
+	var req models.CreateWalletRequest
+	violations, err := decodeJSON(h.decoder, r.Body, &req)
+	if err != nil && !errors.Is(err, request.ErrEmpty) {
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	// Ключ, ограниченный кошельками, создаёт только кошельки из своего списка.
+	if !allowWallet(w, r, log, op, req.ID) {
+		return
+	}
+
+	wallet, err := h.service.CreateWallet(r.Context(), req.ID)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("кошелек создан", slog.String("op", op), slog.String("id", wallet.ID.String()))
+	response.WriteJSONSuccess(w, log, http.StatusCreated, wallet)
+}
+
+func (h *WalletHandler) UpdateWalletStatus(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.UpdateWalletStatus"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.UpdateWalletStatus")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	defer r.Body.Close()
+
+	id, violations := parseUUIDParam(chi.URLParam(r, "walletID"), "walletId")
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	var req models.UpdateWalletStatusRequest
+	violations, err := decodeJSON(h.decoder, r.Body, &req)
+	if err != nil {
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	violations.Merge(req.Validate())
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	if !allowWallet(w, r, log, op, id) {
+		return
+	}
+
+	wallet, err := h.service.UpdateWalletStatus(r.Context(), id, req.Status)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("статус кошелька изменен", slog.String("op", op), slog.String("id", id.String()), slog.String("status", string(wallet.Status)))
+	response.WriteJSONSuccess(w, log, http.StatusOK, wallet)
+}
+
 func (h *WalletHandler) UpdateBalance(w http.ResponseWriter, r *http.Request) {
 	const op = "handler.UpdateBalance"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.UpdateBalance")
+	defer span.End()
+	r = r.WithContext(ctx)
 	log := middlew.GetLogger(r.Context())
 
 	defer r.Body.Close()
 
 	var req models.WalletOperationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Warn("ошибка декодирования JSON", slog.String("op", op), slog.String("error", err.Error()))
-		response.WriteJSONError(w, log, http.StatusBadRequest, "invalid_json", "Invalid JSON body")
+	violations, err := decodeJSON(h.decoder, r.Body, &req)
+	if err != nil {
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	violations.Merge(req.Validate())
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
 		return
 	}
 
-	if !req.OperationType.IsValid() {
-		log.Warn("невалидный тип операции", slog.String("op", op), slog.Any("req", req))
-		response.WriteJSONError(w, log, http.StatusBadRequest, "invalid_field", "Invalid operationType")
+	if !allowWallet(w, r, log, op, req.WalletID) {
 		return
 	}
-	if req.Amount <= 0 {
-		log.Warn("сумма операции должна быть положительной", slog.String("op", op), slog.Any("req", req))
-		response.WriteJSONError(w, log, http.StatusBadRequest, "invalid_field", "Amount must be positive")
+
+	receipt, err := h.service.UpdateBalance(r.Context(), req)
+	if err != nil {
+		writeError(w, r, log, op, err)
 		return
 	}
 
-	err := h.service.UpdateBalance(r.Context(), req)
+	log.Info("операция принята", slog.String("op", op), slog.String("operation_id", receipt.OperationID.String()), slog.Bool("persisted", receipt.Persisted))
+	response.WriteJSONSuccess(w, log, http.StatusCreated, receipt)
+}
 
+func (h *WalletHandler) Transfer(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.Transfer"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.Transfer")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	defer r.Body.Close()
+
+	var req models.TransferRequest
+	violations, err := decodeJSON(h.decoder, r.Body, &req)
 	if err != nil {
-		switch {
-		case errors.Is(err, custom_err.ErrNotFound):
-			log.Info("кошелек не найден", slog.String("op", op), slog.Any("req", req))
-			response.WriteJSONError(w, log, http.StatusNotFound, "not_found", "Wallet not found")
-		case errors.Is(err, custom_err.ErrInsufficientFunds):
-			log.Warn("недостаточно средств", slog.String("op", op), slog.Any("req", req))
-			response.WriteJSONError(w, log, http.StatusBadRequest, "insufficient_funds", "Insufficient funds in the wallet")
-		default:
-			log.Error("не удалось выполнить операцию", slog.String("op", op), slog.String("error", err.Error()))
-			response.WriteJSONError(w, log, http.StatusInternalServerError, "internal_error", "An internal error occurred")
-		}
+		writeInvalidJSON(w, r, log, op, err)
+		return
+	}
+	violations.Merge(req.Validate())
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
+		return
+	}
+
+	// Зачислять можно на любой кошелек, списывать — только со своего.
+	if !allowWallet(w, r, log, op, req.FromWalletID) {
+		return
+	}
+
+	transfer, err := h.service.Transfer(r.Context(), req)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	log.Info("перевод выполнен", slog.String("op", op), slog.String("id", transfer.ID.String()))
+	response.WriteJSONSuccess(w, log, http.StatusCreated, transfer)
+}
+
+func (h *WalletHandler) ListOperations(w http.ResponseWriter, r *http.Request) {
+	const op = "handler.ListOperations"
+	ctx, span := tracer.Start(r.Context(), "WalletHandler.ListOperations")
+	defer span.End()
+	r = r.WithContext(ctx)
+	log := middlew.GetLogger(r.Context())
+
+	id, violations := parseUUIDParam(chi.URLParam(r, "walletID"), "walletId")
+	filter, filterViolations := parseOperationFilter(r.URL.Query())
+	violations.Merge(filterViolations)
+	if violations != nil {
+		writeViolations(w, r, log, op, violations)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if !allowWallet(w, r, log, op, id) {
+		return
+	}
+
+	page, err := h.service.ListOperations(r.Context(), id, filter)
+	if err != nil {
+		writeError(w, r, log, op, err)
+		return
+	}
+
+	response.WriteJSONSuccess(w, log, http.StatusOK, page)
+}
+
+// parseOperationFilter разбирает параметры запроса истории и собирает
+// нарушения во всех параметрах.
+func parseOperationFilter(query url.Values) (filter models.OperationFilter, violations models.Violations) {
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > service.MaxHistoryLimit {
+			violations.Add("limit", models.ViolationOutOfRange, fmt.Sprintf("limit must be between 1 and %d", service.MaxHistoryLimit))
+		} else {
+			filter.Limit = limit
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := models.ParseOperationCursor(v)
+		if err != nil {
+			violations.Add("cursor", models.ViolationInvalid, "cursor must be a value of nextCursor")
+		} else {
+			filter.Cursor = &cursor
+		}
+	}
+	// type можно повторять или перечислять через запятую.
+types:
+	for _, v := range query["type"] {
+		for _, t := range strings.Split(v, ",") {
+			opType := models.OperationType(strings.ToUpper(strings.TrimSpace(t)))
+			if !opType.IsValid() {
+				violations.Add("type", models.ViolationNotAllowed, fmt.Sprintf("type must be one of [%s %s]", models.DepositOperation, models.WithdrawOperation))
+				break types
+			}
+			filter.Types = append(filter.Types, opType)
+		}
+	}
+	amounts := []struct {
+		name string
+		dst  **int64
+	}{{"minAmount", &filter.MinAmount}, {"maxAmount", &filter.MaxAmount}}
+	for _, a := range amounts {
+		if v := query.Get(a.name); v != "" {
+			amount, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || amount < 0 {
+				violations.Add(a.name, models.ViolationInvalid, a.name+" must be a non-negative integer")
+				continue
+			}
+			*a.dst = &amount
+		}
+	}
+	times := []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}}
+	for _, tm := range times {
+		if v := query.Get(tm.name); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				violations.Add(tm.name, models.ViolationInvalid, tm.name+" must be an RFC 3339 timestamp")
+				continue
+			}
+			*tm.dst = &t
+		}
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		violations.Add("minAmount", models.ViolationOutOfRange, "minAmount must not exceed maxAmount")
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		violations.Add("from", models.ViolationOutOfRange, "from must be before to")
+	}
+	return filter, violations
+}
+
+// allowWallet отвечает 403, если ключ запроса ограничен набором кошельков и id
+// в него не входит. Без принципала, то есть с выключенной аутентификацией,
+// доступ не ограничен.
+func allowWallet(w http.ResponseWriter, r *http.Request, log *slog.Logger, op string, id uuid.UUID) bool {
+	principal, ok := middlew.GetPrincipal(r.Context())
+	if !ok || principal.CanAccessWallet(id) {
+		return true
+	}
+	log.Warn("доступ к кошельку запрещен", slog.String("op", op), slog.String("id", id.String()))
+	problem.Write(w, r, log, problem.New(problem.WalletForbidden, ""))
+	return false
 }
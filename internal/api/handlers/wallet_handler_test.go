@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"api_wallet/internal/api/middlew"
+	"api_wallet/internal/api/problem"
 	"api_wallet/internal/custom_err"
 	"api_wallet/internal/models"
 	"api_wallet/internal/service"
+	"api_wallet/pkg/request"
 	"bytes"
 	"context"
 	"errors"
@@ -11,6 +14,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -23,16 +27,23 @@ var _ service.WalletServicer = (*mockWalletService)(nil)
 
 // 1. Создаем "подделку" (мок) нашего сервиса
 type mockWalletService struct {
-	UpdateBalanceFunc func(ctx context.Context, req models.WalletOperationRequest) error
-	GetWalletByIDFunc func(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	UpdateBalanceFunc      func(ctx context.Context, req models.WalletOperationRequest) (*models.OperationReceipt, error)
+	GetWalletByIDFunc      func(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	CreateWalletFunc       func(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	UpdateWalletStatusFunc func(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error)
+	TransferFunc           func(ctx context.Context, req models.TransferRequest) (*models.Transfer, error)
+	ListOperationsFunc     func(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) (*models.OperationPage, error)
+	CreateHoldFunc         func(ctx context.Context, walletID uuid.UUID, req models.CreateHoldRequest) (*models.Hold, error)
+	CaptureHoldFunc        func(ctx context.Context, walletID, holdID uuid.UUID, req models.CaptureHoldRequest) (*models.Hold, error)
+	VoidHoldFunc           func(ctx context.Context, walletID, holdID uuid.UUID) (*models.Hold, error)
 }
 
 // Реализуем методы интерфейса, которые просто вызывают наши функции-заглушки
-func (m *mockWalletService) UpdateBalance(ctx context.Context, req models.WalletOperationRequest) error {
+func (m *mockWalletService) UpdateBalance(ctx context.Context, req models.WalletOperationRequest) (*models.OperationReceipt, error) {
 	if m.UpdateBalanceFunc != nil {
 		return m.UpdateBalanceFunc(ctx, req)
 	}
-	return nil
+	return nil, nil
 }
 
 func (m *mockWalletService) GetWalletByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
@@ -42,11 +53,60 @@ func (m *mockWalletService) GetWalletByID(ctx context.Context, id uuid.UUID) (*m
 	return nil, nil
 }
 
+func (m *mockWalletService) CreateWallet(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	if m.CreateWalletFunc != nil {
+		return m.CreateWalletFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (m *mockWalletService) UpdateWalletStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error) {
+	if m.UpdateWalletStatusFunc != nil {
+		return m.UpdateWalletStatusFunc(ctx, id, status)
+	}
+	return nil, nil
+}
+
+func (m *mockWalletService) Transfer(ctx context.Context, req models.TransferRequest) (*models.Transfer, error) {
+	if m.TransferFunc != nil {
+		return m.TransferFunc(ctx, req)
+	}
+	return nil, nil
+}
+
+func (m *mockWalletService) ListOperations(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) (*models.OperationPage, error) {
+	if m.ListOperationsFunc != nil {
+		return m.ListOperationsFunc(ctx, walletID, filter)
+	}
+	return nil, nil
+}
+
+func (m *mockWalletService) CreateHold(ctx context.Context, walletID uuid.UUID, req models.CreateHoldRequest) (*models.Hold, error) {
+	if m.CreateHoldFunc != nil {
+		return m.CreateHoldFunc(ctx, walletID, req)
+	}
+	return nil, nil
+}
+
+func (m *mockWalletService) CaptureHold(ctx context.Context, walletID, holdID uuid.UUID, req models.CaptureHoldRequest) (*models.Hold, error) {
+	if m.CaptureHoldFunc != nil {
+		return m.CaptureHoldFunc(ctx, walletID, holdID, req)
+	}
+	return nil, nil
+}
+
+func (m *mockWalletService) VoidHold(ctx context.Context, walletID, holdID uuid.UUID) (*models.Hold, error) {
+	if m.VoidHoldFunc != nil {
+		return m.VoidHoldFunc(ctx, walletID, holdID)
+	}
+	return nil, nil
+}
+
 // 2. Основной тест для хендлера UpdateBalance
 func TestWalletHandler_UpdateBalance(t *testing.T) {
 	// Создаем экземпляры мока и хендлера
 	mockService := &mockWalletService{}
-	handler := NewWalletHandler(mockService)
+	handler := NewWalletHandler(mockService, request.NewDecoder())
 
 	// Определяем тестовые сценарии
 	testCases := []struct {
@@ -55,48 +115,131 @@ func TestWalletHandler_UpdateBalance(t *testing.T) {
 		mockError      error // Ошибка, которую вернет наш мок-сервис
 		expectedStatus int
 		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+		// expectedRetryAfter — ожидаемый заголовок Retry-After, пустой — заголовка нет.
+		expectedRetryAfter string
 	}{
 		{
 			name:           "Success - Deposit",
 			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
 			mockError:      nil,
-			expectedStatus: http.StatusOK,
-			expectedBody:   "",
+			expectedStatus: http.StatusCreated,
+			expectedBody: `{"operationId":"5b0c7e4e-7a3c-4a9b-9d1e-2f6a8c3b1d40","walletId":"a7c9a494-386b-436d-8a58-29b7a3f754a3",` +
+				`"operationType":"DEPOSIT","amount":100,"balanceAfter":1100,"version":7,"acceptedAt":"2026-01-02T03:04:05Z","persisted":true}`,
 		},
 		{
 			name:           "Error - Wallet Not Found",
 			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
 			mockError:      custom_err.ErrNotFound, // Мок вернет ошибку "не найдено"
 			expectedStatus: http.StatusNotFound,
-			expectedBody:   `{"error":"not_found","message":"Wallet not found"}`,
+			expectedCode:   problem.NotFound,
 		},
 		{
 			name:           "Error - Insufficient Funds",
 			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "WITHDRAW", "amount": 500}`,
 			mockError:      custom_err.ErrInsufficientFunds, // Мок вернет ошибку "недостаточно средств"
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error":"insufficient_funds","message":"Insufficient funds in the wallet"}`,
+			expectedCode:   problem.InsufficientFunds,
+		},
+		{
+			name:           "Error - Wallet Frozen",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "WITHDRAW", "amount": 100}`,
+			mockError:      custom_err.ErrWalletFrozen,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.WalletFrozen,
+		},
+		{
+			name:           "Error - Wallet Closed",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
+			mockError:      custom_err.ErrWalletClosed,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.WalletClosed,
+		},
+		{
+			name:           "Error - Request ID Reused",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100, "requestId": "0e3a2c9e-0f4b-4d56-9d0e-3f3f7a4b1c2d"}`,
+			mockError:      custom_err.ErrDuplicateRequest,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.DuplicateRequest,
+		},
+		{
+			name:           "Error - Max Retries Exceeded",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
+			mockError:      fmt.Errorf("service.applyOperationSync: %w", custom_err.ErrMaxRetriesExceeded),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.MaxRetriesExceeded,
+		},
+		{
+			name:               "Error - Overloaded",
+			inputBody:          `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
+			mockError:          &custom_err.RetryAfterError{Err: custom_err.ErrOverloaded, After: 1500 * time.Millisecond},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCode:       problem.Overloaded,
+			expectedRetryAfter: "2",
+		},
+		{
+			name:           "Error - Service Stopping",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
+			mockError:      custom_err.ErrServiceStopping,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.ServiceStopping,
+		},
+		{
+			name:           "Error - Wallet Not Owned",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
+			mockError:      custom_err.ErrNotOwner,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.WalletNotOwned,
 		},
 		{
 			name:           "Error - Invalid JSON",
 			inputBody:      `{`,
 			mockError:      nil,
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error":"invalid_json","message":"Invalid JSON body"}`,
+			expectedCode:   problem.InvalidJSON,
 		},
 		{
 			name:           "Error - Negative Amount",
 			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": -100}`,
 			mockError:      nil,
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error":"invalid_field","message":"Amount must be positive"}`,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"amount"},
+		},
+		{
+			name:           "Error - Unknown Field",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100, "currency": "USD"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"currency"},
+		},
+		{
+			name:           "Error - Trailing Data",
+			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100} garbage`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidJSON,
+		},
+		{
+			name:           "Error - All Fields Reported",
+			inputBody:      `{"walletId": "not-a-uuid", "operationType": "REFUND", "amount": 0, "requestId": 42}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"walletId", "requestId", "operationType", "amount"},
+		},
+		{
+			name:           "Error - Empty Body Object",
+			inputBody:      `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"walletId", "operationType", "amount"},
 		},
 		{
 			name:           "Error - Internal Server Error",
 			inputBody:      `{"walletId": "a7c9a494-386b-436d-8a58-29b7a3f754a3", "operationType": "DEPOSIT", "amount": 100}`,
 			mockError:      errors.New("some unexpected database error"), // Мок вернет неизвестную ошибку
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"error":"internal_error","message":"An internal error occurred"}`,
+			expectedCode:   problem.Internal,
 		},
 	}
 
@@ -104,8 +247,21 @@ func TestWalletHandler_UpdateBalance(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Настраиваем мок-сервис для текущего теста
-			mockService.UpdateBalanceFunc = func(ctx context.Context, req models.WalletOperationRequest) error {
-				return tc.mockError
+			mockService.UpdateBalanceFunc = func(ctx context.Context, req models.WalletOperationRequest) (*models.OperationReceipt, error) {
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				balance, version := int64(1100), int64(7)
+				return &models.OperationReceipt{
+					OperationID:  uuid.MustParse("5b0c7e4e-7a3c-4a9b-9d1e-2f6a8c3b1d40"),
+					WalletID:     req.WalletID,
+					Type:         req.OperationType,
+					Amount:       req.Amount,
+					BalanceAfter: &balance,
+					Version:      &version,
+					AcceptedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+					Persisted:    true,
+				}, nil
 			}
 
 			// Создаем фейковый HTTP-запрос
@@ -120,7 +276,10 @@ func TestWalletHandler_UpdateBalance(t *testing.T) {
 
 			// 4. Проверяем результат
 			assert.Equal(t, tc.expectedStatus, rr.Code)
-			if tc.expectedBody != "" {
+			assert.Equal(t, tc.expectedRetryAfter, rr.Header().Get("Retry-After"))
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
 				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
 			}
 		})
@@ -130,7 +289,7 @@ func TestWalletHandler_UpdateBalance(t *testing.T) {
 // 3. Тесты для GetWalletByID
 func TestWalletHandler_GetWalletByID(t *testing.T) {
 	mockService := &mockWalletService{}
-	handler := NewWalletHandler(mockService)
+	handler := NewWalletHandler(mockService, request.NewDecoder())
 
 	walletID := uuid.New()
 
@@ -141,14 +300,16 @@ func TestWalletHandler_GetWalletByID(t *testing.T) {
 		mockError      error
 		expectedStatus int
 		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
 	}{
 		{
 			name:           "Success",
 			walletIDParam:  walletID.String(),
-			mockWallet:     &models.Wallet{ID: walletID, Balance: 123},
+			mockWallet:     &models.Wallet{ID: walletID, Balance: 123, Reserved: 23, Available: 100},
 			mockError:      nil,
 			expectedStatus: http.StatusOK,
-			expectedBody:   fmt.Sprintf(`{"id":"%s","balance":123,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`, walletID.String()),
+			expectedBody:   fmt.Sprintf(`{"id":"%s","balance":123,"reserved":23,"available":100,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`, walletID.String()),
 		},
 		{
 			name:           "Error - Not Found",
@@ -156,7 +317,15 @@ func TestWalletHandler_GetWalletByID(t *testing.T) {
 			mockWallet:     nil,
 			mockError:      custom_err.ErrNotFound,
 			expectedStatus: http.StatusNotFound,
-			expectedBody:   `{"error":"not_found","message":"Wallet not found"}`,
+			expectedCode:   problem.NotFound,
+		},
+		{
+			name:           "Error - Wallet Not Owned",
+			walletIDParam:  walletID.String(),
+			mockWallet:     nil,
+			mockError:      custom_err.ErrNotOwner,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.WalletNotOwned,
 		},
 		{
 			name:           "Error - Invalid UUID",
@@ -164,7 +333,8 @@ func TestWalletHandler_GetWalletByID(t *testing.T) {
 			mockWallet:     nil,
 			mockError:      nil, // Сервис не будет вызван
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error":"invalid_request","message":"Invalid wallet ID format"}`,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"walletId"},
 		},
 		{
 			name:           "Error - Internal Server Error",
@@ -172,7 +342,7 @@ func TestWalletHandler_GetWalletByID(t *testing.T) {
 			mockWallet:     nil,
 			mockError:      errors.New("unexpected db error"),
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"error":"internal_error","message":"Failed to retrieve wallet"}`,
+			expectedCode:   problem.Internal,
 		},
 	}
 
@@ -193,9 +363,529 @@ func TestWalletHandler_GetWalletByID(t *testing.T) {
 			handler.GetWalletByID(rr, req)
 
 			assert.Equal(t, tc.expectedStatus, rr.Code)
-			if tc.expectedBody != "" {
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWalletHandler_CreateWallet(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	walletID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		inputBody      string
+		mockError      error
+		expectedID     uuid.UUID
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success - Server Generated ID",
+			inputBody:      ``,
+			expectedID:     uuid.Nil,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Success - Client Supplied ID",
+			inputBody:      fmt.Sprintf(`{"id": "%s"}`, walletID),
+			expectedID:     walletID,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   fmt.Sprintf(`{"id":"%s","balance":0,"reserved":0,"available":0,"status":"active","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`, walletID),
+		},
+		{
+			name:           "Error - Already Exists",
+			inputBody:      fmt.Sprintf(`{"id": "%s"}`, walletID),
+			mockError:      custom_err.ErrAlreadyExists,
+			expectedID:     walletID,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.AlreadyExists,
+		},
+		{
+			name:           "Error - Invalid JSON",
+			inputBody:      `{"id":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidJSON,
+		},
+		{
+			name:           "Error - Invalid ID",
+			inputBody:      `{"id": 42}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"id"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.CreateWalletFunc = func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				assert.Equal(t, tc.expectedID, id)
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				if id == uuid.Nil {
+					id = uuid.New()
+				}
+				return &models.Wallet{ID: id, Status: models.WalletActive}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallets", bytes.NewBufferString(tc.inputBody))
+			rr := httptest.NewRecorder()
+			handler.CreateWallet(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
 				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
 			}
 		})
 	}
 }
+
+func TestWalletHandler_UpdateWalletStatus(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	walletID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		inputBody      string
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success - Freeze",
+			inputBody:      `{"status": "frozen"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   fmt.Sprintf(`{"id":"%s","balance":10,"reserved":0,"available":10,"status":"frozen","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`, walletID),
+		},
+		{
+			name:           "Error - Unknown Status",
+			inputBody:      `{"status": "deleted"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"status"},
+		},
+		{
+			name:           "Error - Invalid Transition",
+			inputBody:      `{"status": "active"}`,
+			mockError:      custom_err.ErrInvalidStatusTransition,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   problem.InvalidStatusTransition,
+		},
+		{
+			name:           "Error - Service Stopping",
+			inputBody:      `{"status": "frozen"}`,
+			mockError:      custom_err.ErrServiceStopping,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.ServiceStopping,
+		},
+		{
+			name:           "Error - Wallet Not Owned",
+			inputBody:      `{"status": "frozen"}`,
+			mockError:      custom_err.ErrNotOwner,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.WalletNotOwned,
+		},
+		{
+			name:           "Error - Not Found",
+			inputBody:      `{"status": "closed"}`,
+			mockError:      custom_err.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.UpdateWalletStatusFunc = func(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error) {
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.Wallet{ID: id, Balance: 10, Available: 10, Status: status}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/wallets/"+walletID.String()+"/status", bytes.NewBufferString(tc.inputBody))
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("walletID", walletID.String())
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			rr := httptest.NewRecorder()
+			handler.UpdateWalletStatus(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWalletHandler_Transfer(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	fromID := uuid.New()
+	toID := uuid.New()
+	transferID := uuid.New()
+
+	testCases := []struct {
+		name           string
+		inputBody      string
+		mockError      error
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:           "Success",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			expectedStatus: http.StatusCreated,
+			expectedBody: fmt.Sprintf(`{"id":"%s","fromWalletId":"%s","toWalletId":"%s","amount":100,"createdAt":"0001-01-01T00:00:00Z"}`,
+				transferID, fromID, toID),
+		},
+		{
+			name:           "Error - Missing Wallet",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "amount": 100}`, fromID),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"toWalletId"},
+		},
+		{
+			name:           "Error - Invalid Amount",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 0}`, fromID, toID),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"amount"},
+		},
+		{
+			name:           "Error - Same Wallet",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, fromID),
+			mockError:      custom_err.ErrSameWallet,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.SameWallet,
+		},
+		{
+			name:           "Error - Insufficient Funds",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      custom_err.ErrInsufficientFunds,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InsufficientFunds,
+		},
+		{
+			name:           "Error - Not Found",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      custom_err.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+		{
+			name:           "Error - Max Retries Exceeded",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      fmt.Errorf("service.transferSync: %w", custom_err.ErrMaxRetriesExceeded),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.MaxRetriesExceeded,
+		},
+		{
+			name:           "Error - Overloaded",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      custom_err.ErrOverloaded,
+			expectedStatus: http.StatusTooManyRequests,
+			expectedCode:   problem.Overloaded,
+		},
+		{
+			name:           "Error - Service Stopping",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      custom_err.ErrServiceStopping,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.ServiceStopping,
+		},
+		{
+			name:           "Error - Wallet Not Owned",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      custom_err.ErrNotOwner,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.WalletNotOwned,
+		},
+		{
+			name:           "Error - Internal",
+			inputBody:      fmt.Sprintf(`{"fromWalletId": "%s", "toWalletId": "%s", "amount": 100}`, fromID, toID),
+			mockError:      errors.New("db is down"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.TransferFunc = func(ctx context.Context, req models.TransferRequest) (*models.Transfer, error) {
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.Transfer{ID: transferID, FromWalletID: req.FromWalletID, ToWalletID: req.ToWalletID, Amount: req.Amount}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/transfers", bytes.NewBufferString(tc.inputBody))
+			rr := httptest.NewRecorder()
+			handler.Transfer(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+			if tc.expectedStatus == http.StatusTooManyRequests {
+				// Без подсказки сервиса клиенту предлагается повторить через секунду.
+				assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestWalletHandler_ListOperations(t *testing.T) {
+	mockService := &mockWalletService{}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	walletID := uuid.New()
+	opID := uuid.New()
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cursor := models.OperationCursor{CreatedAt: createdAt, ID: opID}.String()
+
+	testCases := []struct {
+		name           string
+		query          string
+		mockError      error
+		checkFilter    func(t *testing.T, filter models.OperationFilter)
+		expectedStatus int
+		expectedBody   string
+		expectedCode   problem.Code
+		expectedFields []string
+	}{
+		{
+			name:  "Success - Filters Parsed",
+			query: "?limit=10&type=withdraw&minAmount=5&maxAmount=100&from=2024-05-01T00:00:00Z&to=2024-05-02T00:00:00Z&cursor=" + cursor,
+			checkFilter: func(t *testing.T, filter models.OperationFilter) {
+				assert.Equal(t, 10, filter.Limit)
+				assert.Equal(t, []models.OperationType{models.WithdrawOperation}, filter.Types)
+				assert.Equal(t, int64(5), *filter.MinAmount)
+				assert.Equal(t, int64(100), *filter.MaxAmount)
+				assert.True(t, filter.From.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+				assert.True(t, filter.To.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)))
+				require.NotNil(t, filter.Cursor)
+				assert.Equal(t, opID, filter.Cursor.ID)
+				assert.True(t, createdAt.Equal(filter.Cursor.CreatedAt))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: fmt.Sprintf(`{"items":[{"id":"%s","walletId":"%s","operationType":"DEPOSIT","amount":10,"requestId":"00000000-0000-0000-0000-000000000000","createdAt":"2024-05-01T12:00:00Z"}],"nextCursor":"%s"}`,
+				opID, walletID, cursor),
+		},
+		{
+			name:  "Success - Types Comma Separated",
+			query: "?type=DEPOSIT,WITHDRAW",
+			checkFilter: func(t *testing.T, filter models.OperationFilter) {
+				assert.Equal(t, []models.OperationType{models.DepositOperation, models.WithdrawOperation}, filter.Types)
+				assert.Zero(t, filter.Limit, "service applies the default limit")
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Error - Invalid Limit",
+			query:          "?limit=100000",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"limit"},
+		},
+		{
+			name:           "Error - Invalid Cursor",
+			query:          "?cursor=garbage",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"cursor"},
+		},
+		{
+			name:           "Error - Invalid Type",
+			query:          "?type=REFUND",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"type"},
+		},
+		{
+			name:           "Error - Inverted Amount Range",
+			query:          "?minAmount=10&maxAmount=5",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"minAmount"},
+		},
+		{
+			name:           "Error - Invalid Time",
+			query:          "?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"from"},
+		},
+		{
+			name:           "Error - Several Invalid Parameters",
+			query:          "?limit=0&type=REFUND&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.ValidationFailed,
+			expectedFields: []string{"limit", "type", "from"},
+		},
+		{
+			name:           "Error - Not Found",
+			mockError:      custom_err.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+		{
+			name:           "Error - Wallet Not Owned",
+			mockError:      custom_err.ErrNotOwner,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   problem.WalletNotOwned,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService.ListOperationsFunc = func(ctx context.Context, id uuid.UUID, filter models.OperationFilter) (*models.OperationPage, error) {
+				assert.Equal(t, walletID, id)
+				if tc.checkFilter != nil {
+					tc.checkFilter(t, filter)
+				}
+				if tc.mockError != nil {
+					return nil, tc.mockError
+				}
+				return &models.OperationPage{
+					Items: []models.Operation{{
+						ID: opID, WalletID: walletID, Type: models.DepositOperation, Amount: 10, CreatedAt: createdAt,
+					}},
+					NextCursor: cursor,
+				}, nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/wallets/"+walletID.String()+"/operations"+tc.query, nil)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("walletID", walletID.String())
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			rr := httptest.NewRecorder()
+			handler.ListOperations(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				assertProblem(t, rr, tc.expectedCode, tc.expectedFields...)
+			} else if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWalletHandler_WalletRestrictions(t *testing.T) {
+	allowedID := uuid.New()
+	otherID := uuid.New()
+
+	var called bool
+	mockService := &mockWalletService{
+		GetWalletByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			called = true
+			return &models.Wallet{ID: id}, nil
+		},
+		CreateWalletFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			called = true
+			return &models.Wallet{ID: id}, nil
+		},
+		UpdateWalletStatusFunc: func(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error) {
+			called = true
+			return &models.Wallet{ID: id, Status: status}, nil
+		},
+		UpdateBalanceFunc: func(ctx context.Context, req models.WalletOperationRequest) (*models.OperationReceipt, error) {
+			called = true
+			return &models.OperationReceipt{WalletID: req.WalletID, Type: req.OperationType, Amount: req.Amount}, nil
+		},
+		TransferFunc: func(ctx context.Context, req models.TransferRequest) (*models.Transfer, error) {
+			called = true
+			return &models.Transfer{FromWalletID: req.FromWalletID, ToWalletID: req.ToWalletID, Amount: req.Amount}, nil
+		},
+		ListOperationsFunc: func(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) (*models.OperationPage, error) {
+			called = true
+			return &models.OperationPage{Items: []models.Operation{}}, nil
+		},
+	}
+	handler := NewWalletHandler(mockService, request.NewDecoder())
+
+	router := chi.NewRouter()
+	router.Post("/wallets", handler.CreateWallet)
+	router.Get("/wallets/{walletID}", handler.GetWalletByID)
+	router.Get("/wallets/{walletID}/operations", handler.ListOperations)
+	router.Put("/wallets/{walletID}/status", handler.UpdateWalletStatus)
+	router.Post("/wallet", handler.UpdateBalance)
+	router.Post("/transfers", handler.Transfer)
+
+	restricted := &models.Principal{
+		Subject:   "shop",
+		Scopes:    []models.Scope{models.ScopeAdmin},
+		WalletIDs: []uuid.UUID{allowedID},
+	}
+	unrestricted := &models.Principal{Subject: "ops", Scopes: []models.Scope{models.ScopeAdmin}}
+
+	testCases := []struct {
+		name           string
+		principal      *models.Principal
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+	}{
+		{"Get - Allowed", restricted, http.MethodGet, "/wallets/" + allowedID.String(), "", http.StatusOK},
+		{"Get - Forbidden", restricted, http.MethodGet, "/wallets/" + otherID.String(), "", http.StatusForbidden},
+		{"Operations - Forbidden", restricted, http.MethodGet, "/wallets/" + otherID.String() + "/operations", "", http.StatusForbidden},
+		{"Status - Forbidden", restricted, http.MethodPut, "/wallets/" + otherID.String() + "/status", `{"status":"frozen"}`, http.StatusForbidden},
+		{"Create - Allowed Listed ID", restricted, http.MethodPost, "/wallets", fmt.Sprintf(`{"id":"%s"}`, allowedID), http.StatusCreated},
+		{"Create - Forbidden Generated ID", restricted, http.MethodPost, "/wallets", "", http.StatusForbidden},
+		{"Balance - Allowed", restricted, http.MethodPost, "/wallet",
+			fmt.Sprintf(`{"walletId":"%s","operationType":"WITHDRAW","amount":10}`, allowedID), http.StatusCreated},
+		{"Balance - Forbidden", restricted, http.MethodPost, "/wallet",
+			fmt.Sprintf(`{"walletId":"%s","operationType":"WITHDRAW","amount":10}`, otherID), http.StatusForbidden},
+		{"Transfer - Allowed To Any Wallet", restricted, http.MethodPost, "/transfers",
+			fmt.Sprintf(`{"fromWalletId":"%s","toWalletId":"%s","amount":10}`, allowedID, otherID), http.StatusCreated},
+		{"Transfer - Forbidden From Foreign Wallet", restricted, http.MethodPost, "/transfers",
+			fmt.Sprintf(`{"fromWalletId":"%s","toWalletId":"%s","amount":10}`, otherID, allowedID), http.StatusForbidden},
+		{"Unrestricted Key", unrestricted, http.MethodGet, "/wallets/" + otherID.String(), "", http.StatusOK},
+		{"Authentication Disabled", nil, http.MethodGet, "/wallets/" + otherID.String(), "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+			if tc.principal != nil {
+				req = req.WithContext(middlew.WithPrincipal(req.Context(), tc.principal))
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusForbidden {
+				assert.False(t, called, "service must not be called for a foreign wallet")
+				assertProblem(t, rr, problem.WalletForbidden)
+			}
+		})
+	}
+}
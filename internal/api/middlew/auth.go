@@ -0,0 +1,116 @@
+package middlew
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader — заголовок, в котором передаётся API-ключ.
+const APIKeyHeader = "X-API-Key"
+
+const principalKey = contextKey("principal")
+
+// Authenticator проверяет предъявленный клиентом ключ или токен.
+// Неподходящий ключ — custom_err.ErrUnauthorized.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (*models.Principal, error)
+}
+
+// Authenticate пропускает только запросы с действующим API-ключом в X-API-Key
+// или JWT в Authorization: Bearer и кладёт принципала в контекст. Если tokens
+// nil, JWT не принимаются.
+func Authenticate(apiKeys, tokens Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.Authenticate"
+			log := GetLogger(r.Context())
+
+			var (
+				auth       Authenticator
+				credential string
+			)
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				auth, credential = apiKeys, key
+			} else if token, ok := bearerToken(r); ok && tokens != nil {
+				auth, credential = tokens, token
+			}
+			if auth == nil {
+				log.Warn("запрос без ключа", slog.String("op", op))
+				writeUnauthorized(w, r, log, tokens != nil, "Missing API key or bearer token")
+				return
+			}
+
+			principal, err := auth.Authenticate(r.Context(), credential)
+			if err != nil {
+				if errors.Is(err, custom_err.ErrUnauthorized) {
+					log.Warn("ключ не принят", slog.String("op", op), slog.String("error", err.Error()))
+					writeUnauthorized(w, r, log, tokens != nil, "Invalid API key or bearer token")
+					return
+				}
+				log.Error("ошибка проверки ключа", slog.String("op", op), slog.String("error", err.Error()))
+				problem.Write(w, r, log, problem.New(problem.Internal, ""))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), loggerKey, log.With(slog.String("principal", principal.Subject)))
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(ctx, principal)))
+		})
+	}
+}
+
+// RequireScope пропускает только запросы принципала с правом scope.
+// Подключается после Authenticate.
+func RequireScope(scope models.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.RequireScope"
+			log := GetLogger(r.Context())
+
+			principal, ok := GetPrincipal(r.Context())
+			if !ok {
+				log.Error("запрос не прошёл аутентификацию", slog.String("op", op))
+				problem.Write(w, r, log, problem.New(problem.Unauthorized, "Missing API key or bearer token"))
+				return
+			}
+			if !principal.HasScope(scope) {
+				log.Warn("недостаточно прав", slog.String("op", op), slog.String("scope", string(scope)))
+				problem.Write(w, r, log, problem.New(problem.Forbidden, "Missing scope "+string(scope)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithPrincipal кладёт принципала в контекст.
+func WithPrincipal(ctx context.Context, principal *models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// GetPrincipal возвращает принципала запроса. false — аутентификация выключена.
+func GetPrincipal(ctx context.Context) (*models.Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*models.Principal)
+	return principal, ok
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, log *slog.Logger, bearer bool, detail string) {
+	if bearer {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api_wallet"`)
+	}
+	problem.Write(w, r, log, problem.New(problem.Unauthorized, detail))
+}
@@ -0,0 +1,169 @@
+package middlew
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticAuthenticator принимает только известные ключи.
+type staticAuthenticator map[string]*models.Principal
+
+func (a staticAuthenticator) Authenticate(ctx context.Context, credential string) (*models.Principal, error) {
+	if credential == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	principal, ok := a[credential]
+	if !ok {
+		return nil, custom_err.ErrUnauthorized
+	}
+	return principal, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	reader := &models.Principal{Subject: "reader", Scopes: []models.Scope{models.ScopeWalletsRead}}
+	service := &models.Principal{Subject: "service", Scopes: []models.Scope{models.ScopeAdmin}}
+	apiKeys := staticAuthenticator{"wk_reader": reader, "broken": nil}
+	tokens := staticAuthenticator{"jwt": service}
+
+	var got *models.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = GetPrincipal(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name              string
+		tokens            Authenticator
+		header            string
+		value             string
+		expectedStatus    int
+		expectedPrincipal *models.Principal
+		expectedChallenge string
+	}{
+		{
+			name:              "API key",
+			tokens:            tokens,
+			header:            APIKeyHeader,
+			value:             "wk_reader",
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: reader,
+		},
+		{
+			name:              "Bearer token",
+			tokens:            tokens,
+			header:            "Authorization",
+			value:             "bearer jwt",
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: service,
+		},
+		{
+			name:              "Unknown API key",
+			tokens:            tokens,
+			header:            APIKeyHeader,
+			value:             "wk_unknown",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="api_wallet"`,
+		},
+		{
+			name:              "No credentials",
+			tokens:            tokens,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="api_wallet"`,
+		},
+		{
+			name:           "Bearer token without JWKS",
+			header:         "Authorization",
+			value:          "Bearer jwt",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:              "Basic auth is not accepted",
+			tokens:            tokens,
+			header:            "Authorization",
+			value:             "Basic dXNlcjpwYXNz",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="api_wallet"`,
+		},
+		{
+			name:           "Key store failure",
+			header:         APIKeyHeader,
+			value:          "broken",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/wallets", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			rr := httptest.NewRecorder()
+
+			Authenticate(apiKeys, tc.tokens)(next).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.Equal(t, tc.expectedPrincipal, got)
+			assert.Equal(t, tc.expectedChallenge, rr.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name           string
+		principal      *models.Principal
+		scope          models.Scope
+		expectedStatus int
+	}{
+		{
+			name:           "Scope granted",
+			principal:      &models.Principal{Scopes: []models.Scope{models.ScopeWalletsRead, models.ScopeWalletsWrite}},
+			scope:          models.ScopeWalletsWrite,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Admin implies every scope",
+			principal:      &models.Principal{Scopes: []models.Scope{models.ScopeAdmin}},
+			scope:          models.ScopeWalletsWrite,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Scope missing",
+			principal:      &models.Principal{Scopes: []models.Scope{models.ScopeWalletsRead}},
+			scope:          models.ScopeWalletsWrite,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Not authenticated",
+			scope:          models.ScopeWalletsRead,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/wallets", nil)
+			if tc.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tc.principal))
+			}
+			rr := httptest.NewRecorder()
+
+			RequireScope(tc.scope)(next).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package middlew
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	router := chi.NewRouter()
+	router.Use(NewHTTPMetrics(reg).Middleware)
+	router.Get("/wallets/{walletID}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "walletID") == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+
+	for _, path := range []string{"/wallets/a", "/wallets/b", "/wallets/missing", "/unknown/path"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	expected := `
+# HELP http_requests_total Обработанные HTTP-запросы.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/wallets/{walletID}",status="200"} 2
+http_requests_total{method="GET",route="/wallets/{walletID}",status="404"} 1
+http_requests_total{method="GET",route="unmatched",status="404"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "http_requests_total"))
+
+	count, err := testutil.GatherAndCount(reg, "http_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count, "one histogram per method, route and status")
+}
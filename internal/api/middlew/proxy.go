@@ -0,0 +1,181 @@
+package middlew
+
+import (
+	"api_wallet/internal/api/problem"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ForwardedHeader помечает запрос, уже перенаправленный владельцу кошелька.
+// Такой запрос обрабатывается на месте, даже если маршруты успели измениться,
+// чтобы экземпляры не пересылали его друг другу по кругу. Значение —
+// подпись экземпляра кластера, см. signForwarded; без неё заголовок убирается.
+const ForwardedHeader = "X-Wallet-Forwarded"
+
+// maxForwardedSkew — насколько подпись перенаправленного запроса может
+// разойтись с часами получателя.
+const maxForwardedSkew = time.Minute
+
+// maxProxyBodySize ограничивает тело, которое читается для поиска кошелька.
+const maxProxyBodySize = 1 << 20
+
+// OwnerResolver сообщает, какой экземпляр обслуживает кошелек.
+type OwnerResolver interface {
+	OwnerOf(walletID uuid.UUID) (addr string, local bool)
+}
+
+// WalletIDFunc достаёт из запроса кошелек, по которому выбирается экземпляр.
+// false означает, что кошелька нет и запрос обрабатывается на месте.
+type WalletIDFunc func(r *http.Request) (uuid.UUID, bool)
+
+// ProxyToOwner перенаправляет запрос экземпляру, который обслуживает кошелек.
+// secret — общий ключ экземпляров кластера: им подписываются перенаправленные
+// запросы, и только запрос с верной подписью обрабатывается без проверки владельца.
+func ProxyToOwner(resolver OwnerResolver, walletID WalletIDFunc, secret []byte) func(http.Handler) http.Handler {
+	var proxies sync.Map // addr -> *httputil.ReverseProxy
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if value := r.Header.Get(ForwardedHeader); value != "" {
+				if verifyForwarded(secret, r, value, time.Now()) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				// Заголовок от клиента, а не от экземпляра кластера: запрос идёт владельцу как обычно.
+				r.Header.Del(ForwardedHeader)
+			}
+			id, ok := walletID(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			addr, local := resolver.OwnerOf(id)
+			if local {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			proxy, ok := proxies.Load(addr)
+			if !ok {
+				target, err := url.Parse(addr)
+				if err != nil {
+					log := GetLogger(r.Context())
+					log.Error("невалидный адрес владельца кошелька", slog.String("addr", addr), slog.String("error", err.Error()))
+					problem.Write(w, r, log, problem.New(problem.OwnerUnavailable, ""))
+					return
+				}
+				proxy, _ = proxies.LoadOrStore(addr, newOwnerProxy(target, secret))
+			}
+			proxy.(*httputil.ReverseProxy).ServeHTTP(w, r)
+		})
+	}
+}
+
+func newOwnerProxy(target *url.URL, secret []byte) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set(ForwardedHeader, signForwarded(secret, pr.Out, time.Now()))
+			injectTraceContext(pr.In, pr.Out.Header)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log := GetLogger(r.Context())
+			log.Error("владелец кошелька недоступен", slog.String("addr", target.String()), slog.String("error", err.Error()))
+			problem.Write(w, r, log, problem.New(problem.OwnerUnavailable, ""))
+		},
+	}
+}
+
+// signForwarded подписывает перенаправляемый запрос: "<unix-время>.<HMAC-SHA256>"
+// от времени, метода и пути с параметрами.
+func signForwarded(secret []byte, r *http.Request, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return ts + "." + forwardedMAC(secret, r, ts)
+}
+
+// verifyForwarded проверяет подпись value, поставленную signForwarded.
+func verifyForwarded(secret []byte, r *http.Request, value string, now time.Time) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	ts, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxForwardedSkew || skew < -maxForwardedSkew {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(forwardedMAC(secret, r, ts)))
+}
+
+func forwardedMAC(secret []byte, r *http.Request, ts string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts + "\n" + r.Method + "\n" + r.URL.RequestURI()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// URLParamWalletID берёт кошелек из параметра маршрута. Подключается через
+// r.With, чтобы параметры маршрута уже были разобраны.
+func URLParamWalletID(name string) WalletIDFunc {
+	return func(r *http.Request) (uuid.UUID, bool) {
+		id, err := uuid.Parse(chi.URLParam(r, name))
+		return id, err == nil
+	}
+}
+
+// BodyWalletID берёт кошелек из первого найденного поля fields JSON-тела. Тело
+// восстанавливается, чтобы его прочитал обработчик или экземпляр, которому уходит запрос.
+func BodyWalletID(fields ...string) WalletIDFunc {
+	return func(r *http.Request) (uuid.UUID, bool) {
+		if r.Body == nil {
+			return uuid.Nil, false
+		}
+		orig := r.Body
+		body, err := io.ReadAll(io.LimitReader(orig, maxProxyBodySize))
+		// Непрочитанный остаток тела отдаётся дальше вместе с прочитанным.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), orig), orig}
+		if err != nil || len(body) == maxProxyBodySize {
+			return uuid.Nil, false
+		}
+
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(body, &values); err != nil {
+			return uuid.Nil, false
+		}
+		for _, field := range fields {
+			raw, ok := values[field]
+			if !ok {
+				continue
+			}
+			var id uuid.UUID
+			if err := json.Unmarshal(raw, &id); err != nil || id == uuid.Nil {
+				return uuid.Nil, false
+			}
+			return id, true
+		}
+		return uuid.Nil, false
+	}
+}
@@ -0,0 +1,137 @@
+package middlew
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticResolver map[uuid.UUID]string
+
+func (r staticResolver) OwnerOf(walletID uuid.UUID) (string, bool) {
+	addr, ok := r[walletID]
+	return addr, !ok
+}
+
+func TestProxyToOwner(t *testing.T) {
+	remoteWallet, localWallet := uuid.New(), uuid.New()
+	secret := []byte("cluster-secret")
+
+	var remoteBody string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		remoteBody = string(body)
+		assert.True(t, verifyForwarded(secret, r, r.Header.Get(ForwardedHeader), time.Now()), "forwarded request must be signed")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer remote.Close()
+
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+	resolver := staticResolver{remoteWallet: remote.URL}
+	handler := ProxyToOwner(resolver, BodyWalletID("walletId", "valletId"), secret)(local)
+	signed := func(r *http.Request) string { return signForwarded(secret, r, time.Now()) }
+
+	testCases := []struct {
+		name           string
+		body           string
+		forwarded      func(r *http.Request) string
+		expectedStatus int
+	}{
+		{
+			name:           "Wallet of another instance is proxied",
+			body:           `{"walletId":"` + remoteWallet.String() + `","amount":10}`,
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Legacy field name is proxied",
+			body:           `{"valletId":"` + remoteWallet.String() + `","amount":10}`,
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Local wallet is served here",
+			body:           `{"walletId":"` + localWallet.String() + `","amount":10}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Already forwarded request is served here",
+			body:           `{"walletId":"` + remoteWallet.String() + `","amount":10}`,
+			forwarded:      signed,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unsigned forwarded header is ignored",
+			body:           `{"walletId":"` + remoteWallet.String() + `","amount":10}`,
+			forwarded:      func(*http.Request) string { return "1" },
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name: "Forwarded header signed with another secret is ignored",
+			body: `{"walletId":"` + remoteWallet.String() + `","amount":10}`,
+			forwarded: func(r *http.Request) string {
+				return signForwarded([]byte("other"), r, time.Now())
+			},
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name: "Stale signature is ignored",
+			body: `{"walletId":"` + remoteWallet.String() + `","amount":10}`,
+			forwarded: func(r *http.Request) string {
+				return signForwarded(secret, r, time.Now().Add(-2*maxForwardedSkew))
+			},
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Invalid body is left to the handler",
+			body:           `{"walletId":`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			remoteBody = ""
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", bytes.NewBufferString(tc.body))
+			if tc.forwarded != nil {
+				req.Header.Set(ForwardedHeader, tc.forwarded(req))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			// Тело доходит до адресата целиком, где бы его ни обработали.
+			if tc.expectedStatus == http.StatusTeapot {
+				assert.Equal(t, tc.body, remoteBody)
+			} else {
+				assert.Equal(t, tc.body, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("Unreachable owner", func(t *testing.T) {
+		handler := ProxyToOwner(staticResolver{remoteWallet: "http://127.0.0.1:1"}, URLParamWalletID("walletID"), secret)(local)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/wallets/"+remoteWallet.String(), nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("walletID", remoteWallet.String())
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadGateway, rr.Code)
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"type":"https://github.com/ayrinwave/api-wallet-service/blob/main/docs/errors.md#owner_unavailable",`+
+			`"title":"Wallet owner is unavailable, retry the request later","status":502,"instance":"`+req.URL.Path+`","code":"owner_unavailable"}`, rr.Body.String())
+	})
+}
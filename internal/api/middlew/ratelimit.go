@@ -0,0 +1,102 @@
+package middlew
+
+import (
+	"api_wallet/internal/api/problem"
+	"api_wallet/internal/ratelimit"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateKeyFunc возвращает, по какому ключу ограничивается запрос.
+// false — запрос этим ограничением не учитывается.
+type RateKeyFunc func(r *http.Request) (string, bool)
+
+// RateLimit отклоняет с 429 запросы сверх limit для ключа key. name отделяет
+// вёдра разных ограничений в одном хранилище. Заголовки RateLimit-* описывают
+// самое строгое из ограничений, через которые прошёл запрос. Если хранилище
+// недоступно, запрос пропускается.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key RateKeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.RateLimit"
+			k, ok := key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log := GetLogger(r.Context())
+			res, err := store.Take(r.Context(), name+":"+k, limit)
+			if err != nil {
+				log.Error("ошибка ограничения частоты запросов", slog.String("op", op), slog.String("limiter", name), slog.String("error", err.Error()))
+				next.ServeHTTP(w, r)
+				return
+			}
+			setRateLimitHeaders(w.Header(), res, limit)
+
+			if !res.Allowed {
+				log.Warn("превышена частота запросов", slog.String("op", op), slog.String("limiter", name), slog.String("key", k))
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				problem.Write(w, r, log, problem.New(problem.RateLimited, ""))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setRateLimitHeaders выставляет RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset и RateLimit-Policy, если у этого ограничения осталось меньше
+// запросов, чем у уже пройденных.
+func setRateLimitHeaders(h http.Header, res ratelimit.Result, limit ratelimit.Limit) {
+	if prev, err := strconv.Atoi(h.Get("RateLimit-Remaining")); err == nil && prev <= res.Remaining && res.Allowed {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", strconv.Itoa(limit.Burst)+";w="+strconv.Itoa(ceilSeconds(limit.Window())))
+}
+
+// ceilSeconds округляет вверх до целых секунд, не меньше одной.
+func ceilSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// ClientIPKey ограничивает запросы по адресу клиента. Адрес берётся из
+// RemoteAddr, который middleware.RealIP заменяет адресом из X-Forwarded-For.
+func ClientIPKey(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host, host != ""
+}
+
+// PrincipalKey ограничивает запросы по API-ключу или субъекту токена.
+// Без аутентификации запросы не учитываются.
+func PrincipalKey(r *http.Request) (string, bool) {
+	principal, ok := GetPrincipal(r.Context())
+	if !ok {
+		return "", false
+	}
+	return principal.Subject, true
+}
+
+// WalletKey ограничивает запросы по кошельку, к которому они обращаются.
+func WalletKey(walletID WalletIDFunc) RateKeyFunc {
+	return func(r *http.Request) (string, bool) {
+		id, ok := walletID(r)
+		if !ok {
+			return "", false
+		}
+		return id.String(), true
+	}
+}
@@ -0,0 +1,136 @@
+package middlew
+
+import (
+	"api_wallet/internal/models"
+	"api_wallet/internal/ratelimit"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimit(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+
+	t.Run("Rejects Requests Over The Limit", func(t *testing.T) {
+		handler := RateLimit(ratelimit.NewMemoryStore(), "ip", limit, ClientIPKey)(ok)
+
+		for _, remaining := range []string{"1", "0"} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/wallet", nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, remaining, rr.Header().Get("RateLimit-Remaining"))
+			assert.Equal(t, "2;w=2", rr.Header().Get("RateLimit-Policy"))
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/wallet", nil))
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Reset"))
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"type":"https://github.com/ayrinwave/api-wallet-service/blob/main/docs/errors.md#rate_limited",`+
+			`"title":"Too many requests, retry the request later","status":429,"instance":"/api/v1/wallet","code":"rate_limited"}`, rr.Body.String())
+
+		// Другой клиент ограничивается отдельно.
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", nil)
+		req.RemoteAddr = "10.0.0.2:5555"
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Client IP From RealIP", func(t *testing.T) {
+		handler := middleware.RealIP(RateLimit(ratelimit.NewMemoryStore(), "ip", ratelimit.Limit{Rate: 1, Burst: 1}, ClientIPKey)(ok))
+
+		statuses := make([]int, 0, 3)
+		for _, ip := range []string{"203.0.113.1", "203.0.113.1", "203.0.113.2"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Forwarded-For", ip)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			statuses = append(statuses, rr.Code)
+		}
+		assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK}, statuses)
+	})
+
+	t.Run("Per Wallet", func(t *testing.T) {
+		walletID := uuid.New()
+		handler := RateLimit(ratelimit.NewMemoryStore(), "wallet", ratelimit.Limit{Rate: 1, Burst: 1}, WalletKey(BodyWalletID("walletId")))(ok)
+
+		send := func(body string) int {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/wallet", strings.NewReader(body)))
+			return rr.Code
+		}
+		body := `{"walletId":"` + walletID.String() + `","amount":1}`
+		assert.Equal(t, http.StatusOK, send(body))
+		assert.Equal(t, http.StatusTooManyRequests, send(body))
+		assert.Equal(t, http.StatusOK, send(`{"walletId":"`+uuid.NewString()+`","amount":1}`))
+		// Запросы без кошелька этим ограничением не учитываются.
+		assert.Equal(t, http.StatusOK, send(`{}`))
+	})
+
+	t.Run("Per Principal", func(t *testing.T) {
+		handler := RateLimit(ratelimit.NewMemoryStore(), "key", ratelimit.Limit{Rate: 1, Burst: 1}, PrincipalKey)(ok)
+		send := func(principal *models.Principal) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), principal))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			return rr.Code
+		}
+		shop := &models.Principal{Subject: "shop"}
+		assert.Equal(t, http.StatusOK, send(shop))
+		assert.Equal(t, http.StatusTooManyRequests, send(shop))
+		assert.Equal(t, http.StatusOK, send(&models.Principal{Subject: "billing"}))
+		assert.Equal(t, http.StatusOK, send(nil))
+	})
+
+	t.Run("Headers Describe The Strictest Limit", func(t *testing.T) {
+		store := ratelimit.NewMemoryStore()
+		loose := RateLimit(store, "ip", ratelimit.Limit{Rate: 100, Burst: 100}, ClientIPKey)
+		strict := RateLimit(store, "wallet", ratelimit.Limit{Rate: 1, Burst: 5}, ClientIPKey)
+
+		for _, handler := range []http.Handler{loose(strict(ok)), strict(loose(ok))} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, "5", rr.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("Store Failure Lets Requests Through", func(t *testing.T) {
+		handler := RateLimit(failingStore{}, "ip", limit, ClientIPKey)(ok)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Disabled Limit", func(t *testing.T) {
+		handler := RateLimit(failingStore{}, "ip", ratelimit.Limit{}, ClientIPKey)(ok)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+	})
+}
@@ -0,0 +1,180 @@
+// Package problem описывает ошибки API в формате RFC 7807 (application/problem+json).
+package problem
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ContentType — тип тела ответа об ошибке.
+const ContentType = "application/problem+json"
+
+// TypeBase — начало URI типа ошибки, за ним идёт код. По URI открывается
+// описание кода в каталоге ошибок.
+const TypeBase = "https://github.com/ayrinwave/api-wallet-service/blob/main/docs/errors.md#"
+
+// Code — стабильный код ошибки. Коды не переименовываются: на них опираются клиенты.
+type Code string
+
+const (
+	InvalidJSON      Code = "invalid_json"
+	BodyTooLarge     Code = "body_too_large"
+	ValidationFailed Code = "validation_failed"
+	Unauthorized     Code = "unauthorized"
+	Forbidden        Code = "forbidden"
+	WalletForbidden  Code = "wallet_forbidden"
+	NotFound         Code = "not_found"
+	AlreadyExists    Code = "already_exists"
+	Conflict         Code = "conflict"
+	DuplicateRequest Code = "duplicate_request"
+
+	InsufficientFunds       Code = "insufficient_funds"
+	SameWallet              Code = "same_wallet"
+	WalletFrozen            Code = "wallet_frozen"
+	WalletClosed            Code = "wallet_closed"
+	InvalidStatusTransition Code = "invalid_status_transition"
+	InvalidCursor           Code = "invalid_cursor"
+	HoldNotActive           Code = "hold_not_active"
+	HoldExpired             Code = "hold_expired"
+	CaptureExceedsHold      Code = "capture_exceeds_hold"
+
+	RateLimited        Code = "rate_limited"
+	Overloaded         Code = "overloaded"
+	MaxRetriesExceeded Code = "max_retries_exceeded"
+	ServiceStopping    Code = "service_stopping"
+	WalletNotOwned     Code = "wallet_not_owned"
+	OwnerUnavailable   Code = "owner_unavailable"
+	Internal           Code = "internal_error"
+)
+
+// definition — статус и заголовок, общие для всех ошибок одного кода.
+type definition struct {
+	status int
+	title  string
+}
+
+var catalog = map[Code]definition{
+	InvalidJSON:      {http.StatusBadRequest, "Request body is not valid JSON"},
+	BodyTooLarge:     {http.StatusRequestEntityTooLarge, "Request body is too large"},
+	ValidationFailed: {http.StatusBadRequest, "Request has invalid fields"},
+	Unauthorized:     {http.StatusUnauthorized, "Missing or invalid API key or bearer token"},
+	Forbidden:        {http.StatusForbidden, "Credentials lack the required scope"},
+	WalletForbidden:  {http.StatusForbidden, "Access to this wallet is not allowed"},
+	NotFound:         {http.StatusNotFound, "Resource not found"},
+	AlreadyExists:    {http.StatusConflict, "Resource already exists"},
+	Conflict:         {http.StatusConflict, "Resource was modified concurrently"},
+	DuplicateRequest: {http.StatusConflict, "Request ID was already used with different parameters"},
+
+	InsufficientFunds:       {http.StatusBadRequest, "Insufficient funds in the wallet"},
+	SameWallet:              {http.StatusBadRequest, "Cannot transfer to the same wallet"},
+	WalletFrozen:            {http.StatusConflict, "Wallet is frozen"},
+	WalletClosed:            {http.StatusConflict, "Wallet is closed"},
+	InvalidStatusTransition: {http.StatusConflict, "Wallet cannot be moved to this status"},
+	InvalidCursor:           {http.StatusBadRequest, "Invalid cursor"},
+	HoldNotActive:           {http.StatusConflict, "Hold is already captured, voided or expired"},
+	HoldExpired:             {http.StatusConflict, "Hold has expired"},
+	CaptureExceedsHold:      {http.StatusBadRequest, "Capture amount exceeds the hold"},
+
+	RateLimited:        {http.StatusTooManyRequests, "Too many requests, retry the request later"},
+	Overloaded:         {http.StatusTooManyRequests, "Service is overloaded, retry the request later"},
+	MaxRetriesExceeded: {http.StatusServiceUnavailable, "Wallet is busy, retry the request later"},
+	ServiceStopping:    {http.StatusServiceUnavailable, "Service is shutting down"},
+	WalletNotOwned:     {http.StatusServiceUnavailable, "Wallet is served by another instance, retry the request later"},
+	OwnerUnavailable:   {http.StatusBadGateway, "Wallet owner is unavailable, retry the request later"},
+	Internal:           {http.StatusInternalServerError, "An internal error occurred"},
+}
+
+// sentinels сопоставляет каждой ошибке custom_err код каталога.
+var sentinels = []struct {
+	err  error
+	code Code
+}{
+	{custom_err.ErrNotFound, NotFound},
+	{custom_err.ErrInsufficientFunds, InsufficientFunds},
+	{custom_err.ErrDuplicateRequest, DuplicateRequest},
+	{custom_err.ErrMaxRetriesExceeded, MaxRetriesExceeded},
+	{custom_err.ErrConflict, Conflict},
+	{custom_err.ErrAlreadyExists, AlreadyExists},
+	{custom_err.ErrWalletFrozen, WalletFrozen},
+	{custom_err.ErrWalletClosed, WalletClosed},
+	{custom_err.ErrInvalidStatusTransition, InvalidStatusTransition},
+	{custom_err.ErrSameWallet, SameWallet},
+	{custom_err.ErrInvalidCursor, InvalidCursor},
+	{custom_err.ErrHoldNotActive, HoldNotActive},
+	{custom_err.ErrHoldExpired, HoldExpired},
+	{custom_err.ErrCaptureExceedsHold, CaptureExceedsHold},
+	{custom_err.ErrServiceStopping, ServiceStopping},
+	{custom_err.ErrNotOwner, WalletNotOwned},
+	{custom_err.ErrOverloaded, Overloaded},
+	{custom_err.ErrUnauthorized, Unauthorized},
+}
+
+// Problem — тело ответа об ошибке. Code, TraceID и Errors — расширения RFC 7807.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     Code   `json:"code"`
+	// TraceID — тот же trace_id, что WithLogger пишет в лог запроса.
+	TraceID string            `json:"traceId,omitempty"`
+	Errors  models.Violations `json:"errors,omitempty"`
+	// Position — место синтаксической ошибки в теле запроса.
+	Position *models.Position `json:"position,omitempty"`
+}
+
+// New создаёт ошибку кода code. detail описывает конкретный случай и может быть пустым.
+func New(code Code, detail string) *Problem {
+	def, ok := catalog[code]
+	if !ok {
+		code, def = Internal, catalog[Internal]
+	}
+	return &Problem{
+		Type:   TypeBase + string(code),
+		Title:  def.title,
+		Status: def.status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Validation — ошибка validation_failed с нарушениями в полях.
+func Validation(violations models.Violations) *Problem {
+	p := New(ValidationFailed, "")
+	p.Errors = violations
+	return p
+}
+
+// CodeOf возвращает код ошибки err. Ошибки вне custom_err — internal_error.
+func CodeOf(err error) Code {
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return s.code
+		}
+	}
+	return Internal
+}
+
+// FromError создаёт ответ на ошибку err. Текст ошибки в ответ не попадает.
+func FromError(err error) *Problem {
+	return New(CodeOf(err), "")
+}
+
+// Write отправляет ошибку p от имени запроса r.
+func Write(w http.ResponseWriter, r *http.Request, log *slog.Logger, p *Problem) {
+	p.Instance = r.URL.Path
+	p.TraceID = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Error("ошибка при кодировании ответа об ошибке", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,75 @@
+package problem
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeOf(t *testing.T) {
+	for _, s := range sentinels {
+		t.Run(string(s.code), func(t *testing.T) {
+			assert.NotEqual(t, Internal, s.code)
+			assert.Contains(t, catalog, s.code)
+			assert.Equal(t, s.code, CodeOf(s.err))
+			assert.Equal(t, s.code, CodeOf(fmt.Errorf("service.Op: %w", s.err)), "wrapped error")
+		})
+	}
+
+	assert.Equal(t, Overloaded, CodeOf(&custom_err.RetryAfterError{Err: custom_err.ErrOverloaded, After: time.Second}))
+	assert.Equal(t, Internal, CodeOf(errors.New("connection refused")))
+}
+
+func TestNew(t *testing.T) {
+	p := New(WalletFrozen, "Wallet 42 is frozen")
+	assert.Equal(t, &Problem{
+		Type:   TypeBase + "wallet_frozen",
+		Title:  "Wallet is frozen",
+		Status: http.StatusConflict,
+		Detail: "Wallet 42 is frozen",
+		Code:   WalletFrozen,
+	}, p)
+
+	// Код вне каталога не должен дойти до клиента со статусом 0.
+	assert.Equal(t, Internal, New("no_such_code", "").Code)
+	assert.Equal(t, http.StatusInternalServerError, New("no_such_code", "").Status)
+}
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet?x=1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "host/abc-000001"))
+	rr := httptest.NewRecorder()
+
+	var violations models.Violations
+	violations.Add("amount", models.ViolationNotPositive, "amount must be positive")
+	Write(rr, req, slog.Default(), Validation(violations))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, ContentType, rr.Header().Get("Content-Type"))
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, map[string]any{
+		"type":     TypeBase + "validation_failed",
+		"title":    "Request has invalid fields",
+		"status":   float64(http.StatusBadRequest),
+		"instance": "/api/v1/wallet",
+		"code":     "validation_failed",
+		"traceId":  "host/abc-000001",
+		"errors": []any{map[string]any{
+			"field": "amount", "code": "not_positive", "detail": "amount must be positive",
+		}},
+	}, body)
+}
@@ -2,7 +2,15 @@ package app
 
 import (
 	"api_wallet/internal/api/middlew"
+	"api_wallet/internal/auth"
+	"api_wallet/internal/cluster"
+	"api_wallet/internal/deadletter"
+	"api_wallet/internal/health"
+	"api_wallet/internal/journal"
+	"api_wallet/internal/models"
+	"api_wallet/internal/ratelimit"
 	"api_wallet/internal/repository/postgres"
+	"api_wallet/internal/tracing"
 	"api_wallet/pkg/logger"
 	"context"
 	"errors"
@@ -11,6 +19,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,16 +29,30 @@ import (
 	"api_wallet/internal/db"
 	"api_wallet/internal/server"
 	"api_wallet/internal/service"
+	"api_wallet/pkg/request"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type App struct {
-	log    *slog.Logger
-	server *server.Server
-	pool   *pgxpool.Pool
+	cfg           *config.Config
+	log           *slog.Logger
+	server        *server.Server
+	pool          *pgxpool.Pool
+	journal       *journal.Journal
+	walletService *service.WalletService
+	leases        *cluster.Manager
+	metrics       *prometheus.Registry
+	readiness     *health.Checker
+	// stopTracing дописывает накопленные спаны при остановке.
+	stopTracing func(context.Context) error
+	// draining выставляется при получении сигнала остановки: /readyz отвечает 503.
+	draining atomic.Bool
 }
 
 func NewApp() (*App, error) {
@@ -41,11 +65,23 @@ func NewApp() (*App, error) {
 	}
 	log.Info("конфигурация загружена", slog.String("port", cfg.HTTPPort))
 
+	stopTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "api_wallet",
+		Exporter:    cfg.Tracing.Exporter,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка настройки трейсинга: %w", err)
+	}
+	log.Info("трейсинг настроен", slog.String("exporter", cfg.Tracing.Exporter))
+
 	log.Info("выполнение миграций базы данных")
-	if err := db.RunMigrations(cfg.DB.MigrationURL(), "migrations"); err != nil {
+	schemaVersion, err := db.RunMigrations(cfg.DB.MigrationURL(), "migrations")
+	if err != nil {
 		return nil, fmt.Errorf("ошибка выполнения миграций: %w", err)
 	}
-	log.Info("миграции успешно применены")
+	log.Info("миграции успешно применены", slog.Uint64("version", uint64(schemaVersion)))
 
 	pool, err := db.NewPool(context.Background(), cfg.DB.DSN())
 	if err != nil {
@@ -53,32 +89,294 @@ func NewApp() (*App, error) {
 	}
 	log.Info("подключение к базе данных установлено")
 
+	var walletJournal *journal.Journal
+	if cfg.Journal.Enabled {
+		walletJournal, err = journal.Open(journal.Config{
+			Dir:          cfg.Journal.Dir,
+			SegmentSize:  cfg.Journal.SegmentSize,
+			SyncInterval: cfg.Journal.SyncInterval,
+		})
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("ошибка открытия журнала операций: %w", err)
+		}
+		log.Info("журнал операций открыт", slog.String("dir", cfg.Journal.Dir))
+	}
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		db.NewPoolCollector(pool),
+	)
+
 	srv := server.NewServer(cfg.HTTPPort)
 	log.Info("сервер инициализирован", slog.String("port", cfg.HTTPPort))
 
 	srv.Router.Use(middleware.RequestID)
 	srv.Router.Use(middlew.WithLogger(log))
 	srv.Router.Use(middleware.RealIP)
+	srv.Router.Use(middlew.NewHTTPMetrics(metrics).Middleware)
+	srv.Router.Use(middlew.Tracing)
 	srv.Router.Use(middleware.Recoverer)
 
-	return &App{
-		log:    log,
-		server: srv,
-		pool:   pool,
-	}, nil
+	srv.Router.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{}))
+
+	a := &App{
+		cfg:         cfg,
+		log:         log,
+		server:      srv,
+		pool:        pool,
+		journal:     walletJournal,
+		metrics:     metrics,
+		readiness:   health.NewChecker(cfg.Health.CheckTimeout),
+		stopTracing: stopTracing,
+	}
+
+	a.readiness.Add("database", health.Ping(pool))
+	a.readiness.Add("migrations", health.Migrations(db.NewSchemaVersion(pool), schemaVersion))
+	a.readiness.Add("shutdown", health.Running(a.draining.Load))
+	healthHandler := handlers.NewHealthHandler(a.readiness)
+	srv.Router.Get("/healthz", healthHandler.Liveness)
+	srv.Router.Get("/readyz", healthHandler.Readiness)
+
+	return a, nil
 }
 
-func (a *App) BuildWalletLayer() {
+func (a *App) BuildWalletLayer() error {
 	walletRepo := postgres.NewWalletRepository(a.pool)
-	walletService := service.NewWalletService(walletRepo, a.pool)
-	walletHandler := handlers.NewWalletHandler(walletService)
+
+	mode := service.ConsistencyMode(a.cfg.Wallet.ConsistencyMode)
+	if !mode.IsValid() {
+		return fmt.Errorf("неизвестный режим согласованности %q", mode)
+	}
+
+	opts := []service.Option{
+		service.WithIdempotencyWindow(a.cfg.Wallet.IdempotencyWindow),
+		service.WithConsistencyMode(mode),
+		service.WithSyncMaxRetries(a.cfg.Wallet.SyncMaxRetries),
+		service.WithCacheLimit(a.cfg.Wallet.CacheMaxWallets),
+		service.WithCacheTTL(a.cfg.Wallet.CacheIdleTTL),
+		service.WithNegativeCacheTTL(a.cfg.Wallet.NegativeCacheTTL),
+		service.WithHoldExpiryInterval(a.cfg.Wallet.HoldExpiryInterval),
+	}
+	if a.journal != nil {
+		opts = append(opts, service.WithJournal(a.journal))
+	}
+	if bp := a.cfg.Wallet.Backpressure; bp.Enabled {
+		opts = append(opts, service.WithBackpressure(service.Backpressure{
+			Soft:       service.Backlog{PendingOps: bp.SoftPendingOps, DirtyWallets: bp.SoftDirtyWallets, RetryQueue: bp.SoftRetryQueue},
+			Hard:       service.Backlog{PendingOps: bp.HardPendingOps, DirtyWallets: bp.HardDirtyWallets, RetryQueue: bp.HardRetryQueue},
+			MaxDelay:   bp.MaxDelay,
+			RetryAfter: bp.RetryAfter,
+		}))
+	}
+	if a.cfg.DeadLetter.Enabled {
+		store, err := deadletter.Open(a.cfg.DeadLetter.Dir)
+		if err != nil {
+			return fmt.Errorf("ошибка открытия хранилища dead letter: %w", err)
+		}
+		opts = append(opts, service.WithDeadLetters(store))
+	}
+	if a.cfg.Wallet.CacheCoherence {
+		opts = append(opts, service.WithChangeFeed(postgres.NewWalletChangeListener(a.pool)))
+	}
+	if a.cfg.Cluster.Enabled {
+		// Владелец кредитуемого кошелька узнаёт о переводе с чужого шарда только из уведомлений.
+		if !a.cfg.Wallet.CacheCoherence {
+			return errors.New("CLUSTER_ENABLED требует WALLET_CACHE_COHERENCE")
+		}
+		opts = append(opts, service.WithShardOwnership())
+	}
+	walletService := service.NewWalletService(walletRepo, a.pool, opts...)
+	if err := walletService.ReplayJournal(context.Background()); err != nil {
+		return fmt.Errorf("ошибка восстановления состояния кошельков: %w", err)
+	}
+	// Всё, что осталось в журнале с прошлого запуска, записывается в БД до первого
+	// запроса: синхронные записи идут мимо кэша, а после записи баланс в кэше
+	// сверяется с БД, которую за это время могли изменить другие экземпляры.
+	if _, err := walletService.FlushAll(context.Background()); err != nil {
+		return fmt.Errorf("ошибка записи восстановленных кошельков: %w", err)
+	}
+	walletService.Start()
+	a.walletService = walletService
+	a.metrics.MustRegister(walletService.Collector())
+	a.readiness.Add("flushers", health.Flushers(walletService, a.cfg.Health.FlushStaleAfter))
+	a.readiness.Add("retryQueue", health.RetryQueue(walletService, a.cfg.Health.RetryQueueMaxFill))
+	a.log.Info("режим согласованности", slog.String("mode", string(mode)), slog.Bool("cache_coherence", a.cfg.Wallet.CacheCoherence))
+
+	decoder := request.NewDecoder(
+		request.WithMaxBytes(a.cfg.Request.MaxBodyBytes),
+		request.WithAliases(a.cfg.Request.FieldAliases),
+	)
+	// Кошелек ищется в теле и под устаревшими именами поля, которые принимает decoder.
+	walletIDs := decoder.Names("walletId")
+	fromWalletIDs := decoder.Names("fromWalletId")
+
+	// Без кластера каждый экземпляр обслуживает все кошельки сам.
+	byURL := func(next http.Handler) http.Handler { return next }
+	byWallet, byFromWallet := byURL, byURL
+	if a.cfg.Cluster.Enabled {
+		leases, err := a.startLeases(walletService)
+		if err != nil {
+			return err
+		}
+		a.leases = leases
+		secret := []byte(a.cfg.Cluster.Secret)
+		byURL = middlew.ProxyToOwner(leases, middlew.URLParamWalletID("walletID"), secret)
+		byWallet = middlew.ProxyToOwner(leases, middlew.BodyWalletID(walletIDs...), secret)
+		byFromWallet = middlew.ProxyToOwner(leases, middlew.BodyWalletID(fromWalletIDs...), secret)
+	}
+
+	// Без аутентификации запросы не проверяются и права не требуются.
+	authenticate := func(next http.Handler) http.Handler { return next }
+	requireScope := func(models.Scope) func(http.Handler) http.Handler { return authenticate }
+	var apiKeyHandler *handlers.APIKeyHandler
+	if a.cfg.Auth.Enabled {
+		keys, tokens, err := a.startAuth()
+		if err != nil {
+			return err
+		}
+		authenticate = middlew.Authenticate(keys, tokens)
+		requireScope = middlew.RequireScope
+		apiKeyHandler = handlers.NewAPIKeyHandler(keys, decoder)
+	} else {
+		a.log.Warn("аутентификация выключена: API доступен без ключа")
+	}
+	read := requireScope(models.ScopeWalletsRead)
+	write := requireScope(models.ScopeWalletsWrite)
+	admin := requireScope(models.ScopeAdmin)
+
+	// Лимиты хранятся в памяти: каждый экземпляр считает запросы, которые обработал сам.
+	// Лимит на кошелек считается после пересылки, то есть у владельца кошелька,
+	// через которого проходят все запросы к кошельку.
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	limitByIP := middlew.RateLimit(limits, "ip", a.limit(a.cfg.RateLimit.IPRate, a.cfg.RateLimit.IPBurst), middlew.ClientIPKey)
+	limitByKey := middlew.RateLimit(limits, "key", a.limit(a.cfg.RateLimit.KeyRate, a.cfg.RateLimit.KeyBurst), middlew.PrincipalKey)
+	walletLimit := a.limit(a.cfg.RateLimit.WalletRate, a.cfg.RateLimit.WalletBurst)
+	limitByURL := middlew.RateLimit(limits, "wallet", walletLimit, middlew.WalletKey(middlew.URLParamWalletID("walletID")))
+	limitByWallet := middlew.RateLimit(limits, "wallet", walletLimit, middlew.WalletKey(middlew.BodyWalletID(walletIDs...)))
+	limitByFromWallet := middlew.RateLimit(limits, "wallet", walletLimit, middlew.WalletKey(middlew.BodyWalletID(fromWalletIDs...)))
+
+	walletHandler := handlers.NewWalletHandler(walletService, decoder)
+	adminHandler := handlers.NewAdminHandler(walletService)
 
 	a.server.Router.Route("/api/v1", func(r chi.Router) {
-		r.Get("/wallets/{walletID}", walletHandler.GetWalletByID)
-		r.Post("/wallet", walletHandler.UpdateBalance)
+		// Ключ проверяется до пересылки владельцу кошелька; владелец проверяет его ещё раз.
+		// Адрес ограничивается до проверки ключа, чтобы перебор ключей не доходил до БД.
+		r.Use(limitByIP, authenticate, limitByKey)
+
+		r.With(write).Post("/wallets", walletHandler.CreateWallet)
+		r.With(read, byURL, limitByURL).Get("/wallets/{walletID}", walletHandler.GetWalletByID)
+		r.With(read, byURL, limitByURL).Get("/wallets/{walletID}/operations", walletHandler.ListOperations)
+		// Заморозка и закрытие — решение оператора, а не владельца кошелька.
+		r.With(admin, byURL, limitByURL).Put("/wallets/{walletID}/status", walletHandler.UpdateWalletStatus)
+		r.With(write, byWallet, limitByWallet).Post("/wallet", walletHandler.UpdateBalance)
+		// Перевод выполняет владелец списываемого кошелька.
+		r.With(write, byFromWallet, limitByFromWallet).Post("/transfers", walletHandler.Transfer)
+		r.With(write, byURL, limitByURL).Post("/wallets/{walletID}/holds", walletHandler.CreateHold)
+		r.With(write, byURL, limitByURL).Post("/wallets/{walletID}/holds/{holdID}/capture", walletHandler.CaptureHold)
+		r.With(write, byURL, limitByURL).Post("/wallets/{walletID}/holds/{holdID}/void", walletHandler.VoidHold)
+
+		// Dead letter хранится локально, поэтому обращаться нужно к тому экземпляру, где он записан.
+		r.With(admin).Get("/admin/dead-letters", adminHandler.ListDeadLetters)
+		r.With(admin).Post("/admin/dead-letters/{letterID}/replay", adminHandler.ReplayDeadLetter)
+
+		if apiKeyHandler != nil {
+			r.With(admin).Get("/admin/api-keys", apiKeyHandler.ListAPIKeys)
+			r.With(admin).Post("/admin/api-keys", apiKeyHandler.CreateAPIKey)
+			r.With(admin).Delete("/admin/api-keys/{keyID}", apiKeyHandler.RevokeAPIKey)
+		}
 	})
 
 	a.log.Info("слой 'wallet' собран и маршруты зарегистрированы")
+	return nil
+}
+
+// limit возвращает лимит запросов или нулевой, если ограничения выключены.
+func (a *App) limit(rate float64, burst int) ratelimit.Limit {
+	if !a.cfg.RateLimit.Enabled {
+		return ratelimit.Limit{}
+	}
+	return ratelimit.Limit{Rate: rate, Burst: burst}
+}
+
+// startAuth готовит проверку API-ключей и, если задан AUTH_JWKS_FILE, JWT.
+// Ключ из AUTH_BOOTSTRAP_KEY сохраняется в БД с правом admin.
+func (a *App) startAuth() (*auth.KeyStore, middlew.Authenticator, error) {
+	cfg := a.cfg.Auth
+	keys := auth.NewKeyStore(postgres.NewAPIKeyRepository(a.pool), cfg.KeyCacheTTL)
+	if cfg.BootstrapKey != "" {
+		err := keys.EnsureKey(context.Background(), models.CreateAPIKeyRequest{
+			Name:   "bootstrap",
+			Scopes: []models.Scope{models.ScopeAdmin},
+		}, cfg.BootstrapKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка создания начального ключа: %w", err)
+		}
+		a.log.Info("начальный ключ администратора сохранён")
+	}
+
+	// nil-интерфейс, а не nil-указатель: без JWKS токены не принимаются.
+	var tokens middlew.Authenticator
+	if cfg.JWKSFile != "" {
+		verifier, err := auth.NewJWTVerifier(auth.JWTConfig{
+			JWKSFile: cfg.JWKSFile,
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+			Leeway:   cfg.JWTLeeway,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка загрузки JWKS: %w", err)
+		}
+		tokens = verifier
+		a.log.Info("JWT принимаются", slog.String("jwks", cfg.JWKSFile))
+	}
+
+	// Без начального ключа, JWKS и выданных раньше ключей каждый запрос получил бы 401.
+	if cfg.BootstrapKey == "" && tokens == nil {
+		stored, err := keys.ListKeys(context.Background())
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка чтения API-ключей: %w", err)
+		}
+		if !slices.ContainsFunc(stored, func(key models.APIKey) bool { return key.RevokedAt == nil }) {
+			return nil, nil, errors.New("аутентификация включена, но нет ни одного действующего ключа: " +
+				"задайте AUTH_BOOTSTRAP_KEY или AUTH_JWKS_FILE либо выключите AUTH_ENABLED")
+		}
+	}
+	return keys, tokens, nil
+}
+
+// startLeases арендует шарды кошельков до того, как сервер начнёт принимать запросы.
+func (a *App) startLeases(walletService *service.WalletService) (*cluster.Manager, error) {
+	cfg := a.cfg.Cluster
+	if cfg.AdvertiseAddr == "" {
+		return nil, errors.New("CLUSTER_ADVERTISE_ADDR обязателен при CLUSTER_ENABLED")
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("CLUSTER_SECRET обязателен при CLUSTER_ENABLED")
+	}
+	if cfg.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка определения имени хоста: %w", err)
+		}
+		cfg.InstanceID = hostname
+	}
+
+	leases := cluster.NewManager(postgres.NewLeaseRepository(a.pool), walletService, service.NumShards, service.ShardIndex, cluster.Config{
+		InstanceID: cfg.InstanceID,
+		Addr:       cfg.AdvertiseAddr,
+		TTL:        cfg.LeaseTTL,
+	})
+	if err := leases.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	a.log.Info("аренда шардов запущена",
+		slog.String("instance_id", cfg.InstanceID),
+		slog.String("addr", cfg.AdvertiseAddr),
+		slog.Duration("ttl", cfg.LeaseTTL))
+	return leases, nil
 }
 
 func (a *App) Run() error {
@@ -102,6 +400,14 @@ func (a *App) Run() error {
 	}
 
 	a.log.Info("приложение останавливается")
+	// Сначала экземпляр перестаёт быть готовым, чтобы балансировщик убрал его
+	// до того, как сервер перестанет принимать соединения.
+	a.draining.Store(true)
+	if delay := a.cfg.Health.ShutdownDelay; delay > 0 {
+		a.log.Info("ожидание вывода из балансировки", slog.Duration("delay", delay))
+		time.Sleep(delay)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -109,9 +415,45 @@ func (a *App) Run() error {
 		a.log.Error("ошибка при остановке http сервера", slog.String("error", err.Error()))
 	}
 
+	if a.walletService != nil {
+		a.log.Info("финальная запись кошельков в базу данных")
+		report, err := a.walletService.Stop(ctx)
+		if err != nil {
+			a.log.Error("не все изменения записаны в базу данных",
+				slog.String("error", err.Error()),
+				slog.Bool("journaled", report.Journaled))
+			for _, w := range report.Unpersisted {
+				a.log.Error("кошелек не записан",
+					slog.String("id", w.ID.String()),
+					slog.Int64("balance", w.Balance),
+					slog.Int("pending_ops", w.PendingOps))
+			}
+		}
+		a.log.Info("кошельки записаны", slog.Int("flushed", report.Flushed))
+	}
+
+	// Аренды отдаются после финальной записи, чтобы новый владелец прочитал из БД актуальные балансы.
+	if a.leases != nil {
+		a.log.Info("освобождение шардов")
+		if err := a.leases.Stop(ctx); err != nil {
+			a.log.Error("ошибка при освобождении шардов", slog.String("error", err.Error()))
+		}
+	}
+
+	if a.journal != nil {
+		a.log.Info("закрытие журнала операций")
+		if err := a.journal.Close(); err != nil {
+			a.log.Error("ошибка при закрытии журнала операций", slog.String("error", err.Error()))
+		}
+	}
+
 	a.log.Info("закрытие соединения с базой данных")
 	a.pool.Close()
 
+	if err := a.stopTracing(ctx); err != nil {
+		a.log.Error("ошибка при выгрузке трейсов", slog.String("error", err.Error()))
+	}
+
 	a.log.Info("приложение остановлено")
 	return nil
 }
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"time"
+)
+
+// keyCache — кэш результатов проверки ключей по хэшу с ограничением размера:
+// при переполнении вытесняется самая старая запись. Не потокобезопасен.
+type keyCache[V any] struct {
+	limit   int
+	entries map[[sha256.Size]byte]*list.Element
+	// order — записи от старых к новым.
+	order *list.List
+}
+
+type cacheEntry[V any] struct {
+	hash    [sha256.Size]byte
+	value   V
+	expires time.Time
+}
+
+func newKeyCache[V any](limit int) *keyCache[V] {
+	return &keyCache[V]{
+		limit:   limit,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get возвращает запись, если она ещё не истекла к now. Истёкшая удаляется.
+func (c *keyCache[V]) get(hash [sha256.Size]byte, now time.Time) (V, bool) {
+	var zero V
+	elem, ok := c.entries[hash]
+	if !ok {
+		return zero, false
+	}
+	entry := elem.Value.(*cacheEntry[V])
+	if now.After(entry.expires) {
+		c.remove(elem)
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *keyCache[V]) put(hash [sha256.Size]byte, value V, expires time.Time) {
+	if elem, ok := c.entries[hash]; ok {
+		c.remove(elem)
+	}
+	for c.order.Len() >= c.limit {
+		c.remove(c.order.Front())
+	}
+	c.entries[hash] = c.order.PushBack(&cacheEntry[V]{hash: hash, value: value, expires: expires})
+}
+
+func (c *keyCache[V]) delete(hash [sha256.Size]byte) {
+	if elem, ok := c.entries[hash]; ok {
+		c.remove(elem)
+	}
+}
+
+// deleteFunc удаляет записи, для значений которых fn возвращает true.
+func (c *keyCache[V]) deleteFunc(fn func(V) bool) {
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if fn(elem.Value.(*cacheEntry[V]).value) {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+func (c *keyCache[V]) len() int {
+	return c.order.Len()
+}
+
+func (c *keyCache[V]) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry[V]).hash)
+	c.order.Remove(elem)
+}
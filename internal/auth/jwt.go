@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// JWTConfig — параметры проверки JWT. Пустые Issuer и Audience не проверяются.
+type JWTConfig struct {
+	JWKSFile string
+	Issuer   string
+	Audience string
+	// Leeway — допустимое расхождение часов при проверке exp и nbf.
+	Leeway time.Duration
+}
+
+// tokenClaims — поля токена, из которых строится принципал. scope — права
+// через пробел (RFC 8693), wallets — кошельки, которыми ограничен токен.
+// Без wallets токен не ограничен.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope   string      `json:"scope"`
+	Wallets []uuid.UUID `json:"wallets"`
+}
+
+// JWTVerifier проверяет подпись JWT ключами из локального файла JWKS.
+// Если токен подписан неизвестным ключом, файл перечитывается, когда он
+// изменился: ключи можно ротировать без перезапуска.
+type JWTVerifier struct {
+	cfg    JWTConfig
+	parser *jwt.Parser
+
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	modTime time.Time
+}
+
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	const op = "auth.NewJWTVerifier"
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{
+			"RS256", "RS384", "RS512",
+			"PS256", "PS384", "PS512",
+			"ES256", "ES384", "ES512",
+			"EdDSA",
+		}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	v := &JWTVerifier{cfg: cfg, parser: jwt.NewParser(opts...)}
+	if err := v.reload(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return v, nil
+}
+
+// Authenticate возвращает принципала, от имени которого выдан token, или custom_err.ErrUnauthorized.
+func (v *JWTVerifier) Authenticate(_ context.Context, token string) (*models.Principal, error) {
+	const op = "auth.AuthenticateToken"
+	var claims tokenClaims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.key); err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, custom_err.ErrUnauthorized, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%s: %w: в токене нет sub", op, custom_err.ErrUnauthorized)
+	}
+
+	principal := &models.Principal{Subject: claims.Subject, WalletIDs: claims.Wallets}
+	// Права других сервисов в том же токене пропускаются.
+	for _, s := range strings.Fields(claims.Scope) {
+		if scope := models.Scope(s); scope.IsValid() {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+	return principal, nil
+}
+
+func (v *JWTVerifier) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	if err := v.reload(); err != nil {
+		return nil, err
+	}
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("неизвестный ключ %q", kid)
+}
+
+// lookup ищет ключ по kid. Токен без kid проверяется единственным ключом JWKS.
+func (v *JWTVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// reload перечитывает JWKS, если файл изменился с прошлого чтения.
+func (v *JWTVerifier) reload() error {
+	info, err := os.Stat(v.cfg.JWKSFile)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.keys != nil && info.ModTime().Equal(v.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(v.cfg.JWKSFile)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	v.keys, v.modTime = keys, info.ModTime()
+	return nil
+}
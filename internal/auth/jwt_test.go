@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// toJWK описывает открытый ключ в формате JWKS.
+func toJWK(t *testing.T, kid string, key crypto.PublicKey) map[string]string {
+	t.Helper()
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "crv": k.Curve.Params().Name, "x": b64(k.X.FillBytes(make([]byte, size))), "y": b64(k.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return nil
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwks, toJWK(t, "rsa", &rsaKey.PublicKey), toJWK(t, "ec", &ecKey.PublicKey), toJWK(t, "ed", edPub))
+
+	verifier, err := NewJWTVerifier(JWTConfig{JWKSFile: jwks, Issuer: "https://issuer", Audience: "api_wallet"})
+	require.NoError(t, err)
+
+	walletID := uuid.New()
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":   "billing",
+			"iss":   "https://issuer",
+			"aud":   "api_wallet",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "openid wallets:read wallets:write",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	testCases := []struct {
+		name              string
+		token             string
+		expectedPrincipal *models.Principal
+	}{
+		{
+			name:  "RS256",
+			token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)),
+			expectedPrincipal: &models.Principal{
+				Subject: "billing",
+				Scopes:  []models.Scope{models.ScopeWalletsRead, models.ScopeWalletsWrite},
+			},
+		},
+		{
+			name:  "ES256 restricted to wallets",
+			token: sign(t, jwt.SigningMethodES256, "ec", ecKey, claims(jwt.MapClaims{"wallets": []string{walletID.String()}})),
+			expectedPrincipal: &models.Principal{
+				Subject:   "billing",
+				Scopes:    []models.Scope{models.ScopeWalletsRead, models.ScopeWalletsWrite},
+				WalletIDs: []uuid.UUID{walletID},
+			},
+		},
+		{
+			name:  "EdDSA",
+			token: sign(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(jwt.MapClaims{"scope": "admin"})),
+			expectedPrincipal: &models.Principal{
+				Subject: "billing",
+				Scopes:  []models.Scope{models.ScopeAdmin},
+			},
+		},
+		{name: "Expired", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}))},
+		{name: "Without exp", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": nil}))},
+		{name: "Without sub", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"sub": nil}))},
+		{name: "Wrong issuer", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"iss": "https://evil"}))},
+		{name: "Wrong audience", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"aud": "other"}))},
+		{name: "Unknown kid", token: sign(t, jwt.SigningMethodES256, "other", otherKey, claims(nil))},
+		{name: "Signed by another key", token: sign(t, jwt.SigningMethodES256, "ec", otherKey, claims(nil))},
+		{name: "HMAC is not accepted", token: sign(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil))},
+		{name: "Unsigned", token: sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, claims(nil))},
+		{name: "Garbage", token: "not-a-token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			principal, err := verifier.Authenticate(context.Background(), tc.token)
+			if tc.expectedPrincipal == nil {
+				assert.ErrorIs(t, err, custom_err.ErrUnauthorized)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPrincipal, principal)
+		})
+	}
+
+	t.Run("Rotated JWKS Is Reloaded", func(t *testing.T) {
+		writeJWKS(t, jwks, toJWK(t, "other", &otherKey.PublicKey))
+		// Время изменения файла может совпасть с прошлой записью на грубых файловых системах.
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(jwks, later, later))
+
+		_, err := verifier.Authenticate(context.Background(), sign(t, jwt.SigningMethodES256, "other", otherKey, claims(nil)))
+		assert.NoError(t, err)
+		// Неизвестный теперь ключ проверяется по новому набору.
+		_, err = verifier.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)))
+		assert.ErrorIs(t, err, custom_err.ErrUnauthorized)
+	})
+}
+
+func TestParseJWKS(t *testing.T) {
+	testCases := []struct {
+		name    string
+		jwks    string
+		wantErr bool
+		kids    []string
+	}{
+		{
+			name: "Encryption keys and unknown types are skipped",
+			jwks: `{"keys":[
+				{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"},
+				{"kty":"oct","kid":"hmac","k":"c2VjcmV0"},
+				{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+			]}`,
+			kids: []string{"ed"},
+		},
+		{
+			name:    "No signing keys",
+			jwks:    `{"keys":[{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Point not on curve",
+			jwks:    `{"keys":[{"kty":"EC","kid":"ec","crv":"P-256","x":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE","y":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid JSON",
+			jwks:    `{"keys":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := parseJWKS([]byte(tc.jwks))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var kids []string
+			for kid := range keys {
+				kids = append(kids, kid)
+			}
+			assert.ElementsMatch(t, tc.kids, kids)
+		})
+	}
+}
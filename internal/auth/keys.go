@@ -0,0 +1,175 @@
+// Package auth проверяет API-ключи и JWT и определяет по ним models.Principal.
+package auth
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// keyPrefix отличает API-ключи сервиса от других секретов, например в логах сканеров.
+	keyPrefix = "wk_"
+	// shownPrefixLen — сколько первых символов ключа хранится открыто, чтобы его можно было узнать.
+	shownPrefixLen = len(keyPrefix) + 8
+	// maxCachedKeys ограничивает кэш действующих ключей; при переполнении
+	// вытесняется самый старый.
+	maxCachedKeys = 10000
+	// maxUnknownKeys ограничивает отдельный кэш ненайденных ключей, чтобы перебор
+	// ключей не вытеснял действующие и не заставлял перечитывать их из БД.
+	maxUnknownKeys = 1000
+)
+
+// KeyStore выдаёт, отзывает и проверяет API-ключи. Результат проверки ключа
+// кэшируется на ttl, поэтому ключ, отозванный на другом экземпляре, действует
+// там ещё до ttl.
+type KeyStore struct {
+	repo repository.APIKeys
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	keys    *keyCache[*models.Principal]
+	unknown *keyCache[struct{}]
+}
+
+func NewKeyStore(repo repository.APIKeys, ttl time.Duration) *KeyStore {
+	return &KeyStore{
+		repo:    repo,
+		ttl:     ttl,
+		keys:    newKeyCache[*models.Principal](maxCachedKeys),
+		unknown: newKeyCache[struct{}](maxUnknownKeys),
+	}
+}
+
+// Authenticate возвращает принципала действующего ключа key или custom_err.ErrUnauthorized.
+func (s *KeyStore) Authenticate(ctx context.Context, key string) (*models.Principal, error) {
+	const op = "auth.AuthenticateKey"
+	hash := sha256.Sum256([]byte(key))
+	principal, known, ok := s.cached(hash)
+	if ok {
+		if !known {
+			return nil, fmt.Errorf("%s: %w", op, custom_err.ErrUnauthorized)
+		}
+		return principal, nil
+	}
+
+	stored, err := s.repo.GetByHash(ctx, hash[:])
+	switch {
+	case errors.Is(err, custom_err.ErrNotFound):
+		s.store(hash, nil)
+		return nil, fmt.Errorf("%s: %w", op, custom_err.ErrUnauthorized)
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	principal = stored.Principal()
+	s.store(hash, principal)
+	return principal, nil
+}
+
+// CreateKey выдаёт новый ключ. Сам ключ возвращается только здесь.
+func (s *KeyStore) CreateKey(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error) {
+	const op = "auth.CreateKey"
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	created := &models.CreatedAPIKey{Key: key}
+	if err := s.create(ctx, &created.APIKey, req, key); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return created, nil
+}
+
+// EnsureKey сохраняет заданный в конфигурации ключ key, если его ещё нет.
+// Отозванный ключ остаётся отозванным.
+func (s *KeyStore) EnsureKey(ctx context.Context, req models.CreateAPIKeyRequest, key string) error {
+	const op = "auth.EnsureKey"
+	var stored models.APIKey
+	if err := s.create(ctx, &stored, req, key); err != nil && !errors.Is(err, custom_err.ErrAlreadyExists) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *KeyStore) create(ctx context.Context, stored *models.APIKey, req models.CreateAPIKeyRequest, key string) error {
+	hash := sha256.Sum256([]byte(key))
+	*stored = models.APIKey{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		Prefix:    key[:min(len(key), shownPrefixLen)],
+		Scopes:    req.Scopes,
+		WalletIDs: req.WalletIDs,
+	}
+	if err := s.repo.Create(ctx, stored, hash[:]); err != nil {
+		return err
+	}
+	// Ключ мог попасть в кэш как неизвестный.
+	s.mu.Lock()
+	s.unknown.delete(hash)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *KeyStore) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	const op = "auth.ListKeys"
+	keys, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return keys, nil
+}
+
+// RevokeKey отзывает ключ. На этом экземпляре ключ перестаёт действовать сразу.
+func (s *KeyStore) RevokeKey(ctx context.Context, id uuid.UUID) error {
+	const op = "auth.RevokeKey"
+	if err := s.repo.Revoke(ctx, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	subject := id.String()
+	s.mu.Lock()
+	s.keys.deleteFunc(func(principal *models.Principal) bool {
+		return principal.Subject == subject
+	})
+	s.mu.Unlock()
+	return nil
+}
+
+// cached возвращает закэшированный результат проверки ключа: known — ключ
+// действует, ok — результат есть в кэше.
+func (s *KeyStore) cached(hash [sha256.Size]byte) (principal *models.Principal, known, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if principal, ok := s.keys.get(hash, now); ok {
+		return principal, true, true
+	}
+	_, ok = s.unknown.get(hash, now)
+	return nil, false, ok
+}
+
+// store кэширует результат проверки ключа; principal nil — ключ не найден.
+func (s *KeyStore) store(hash [sha256.Size]byte, principal *models.Principal) {
+	if s.ttl <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(s.ttl)
+	if principal == nil {
+		s.unknown.put(hash, struct{}{}, expires)
+		return
+	}
+	s.keys.put(hash, principal, expires)
+}
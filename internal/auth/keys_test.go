@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ repository.APIKeys = (*memoryKeys)(nil)
+
+// memoryKeys хранит ключи в памяти и считает обращения по хэшу.
+type memoryKeys struct {
+	mu      sync.Mutex
+	keys    map[string]models.APIKey
+	lookups int
+}
+
+func newMemoryKeys() *memoryKeys {
+	return &memoryKeys{keys: make(map[string]models.APIKey)}
+}
+
+func (m *memoryKeys) Create(ctx context.Context, key *models.APIKey, hash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[string(hash)]; ok {
+		return custom_err.ErrAlreadyExists
+	}
+	key.CreatedAt = time.Now()
+	m.keys[string(hash)] = *key
+	return nil
+}
+
+func (m *memoryKeys) GetByHash(ctx context.Context, hash []byte) (*models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookups++
+	key, ok := m.keys[string(hash)]
+	if !ok || key.RevokedAt != nil {
+		return nil, custom_err.ErrNotFound
+	}
+	return &key, nil
+}
+
+func (m *memoryKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]models.APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *memoryKeys) Revoke(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, key := range m.keys {
+		if key.ID == id {
+			now := time.Now()
+			key.RevokedAt = &now
+			m.keys[hash] = key
+			return nil
+		}
+	}
+	return custom_err.ErrNotFound
+}
+
+func TestKeyStore(t *testing.T) {
+	ctx := context.Background()
+	walletID := uuid.New()
+
+	t.Run("Created Key Authenticates", func(t *testing.T) {
+		store := NewKeyStore(newMemoryKeys(), time.Minute)
+		created, err := store.CreateKey(ctx, models.CreateAPIKeyRequest{
+			Name:      "shop",
+			Scopes:    []models.Scope{models.ScopeWalletsWrite},
+			WalletIDs: []uuid.UUID{walletID},
+		})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(created.Key, keyPrefix))
+		assert.Equal(t, created.Key[:shownPrefixLen], created.Prefix)
+
+		principal, err := store.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+		assert.Equal(t, created.ID.String(), principal.Subject)
+		assert.True(t, principal.HasScope(models.ScopeWalletsWrite))
+		assert.False(t, principal.HasScope(models.ScopeAdmin))
+		assert.True(t, principal.CanAccessWallet(walletID))
+		assert.False(t, principal.CanAccessWallet(uuid.New()))
+	})
+
+	t.Run("Unknown Key Is Rejected", func(t *testing.T) {
+		store := NewKeyStore(newMemoryKeys(), time.Minute)
+		_, err := store.Authenticate(ctx, "wk_unknown")
+		assert.ErrorIs(t, err, custom_err.ErrUnauthorized)
+	})
+
+	t.Run("Results Are Cached", func(t *testing.T) {
+		repo := newMemoryKeys()
+		store := NewKeyStore(repo, time.Minute)
+		created, err := store.CreateKey(ctx, models.CreateAPIKeyRequest{Name: "a", Scopes: []models.Scope{models.ScopeWalletsRead}})
+		require.NoError(t, err)
+
+		for range 3 {
+			_, err := store.Authenticate(ctx, created.Key)
+			require.NoError(t, err)
+			_, err = store.Authenticate(ctx, "wk_unknown")
+			require.ErrorIs(t, err, custom_err.ErrUnauthorized)
+		}
+		assert.Equal(t, 2, repo.lookups, "each key must be looked up once")
+	})
+
+	t.Run("Unknown Keys Do Not Evict Valid Ones", func(t *testing.T) {
+		repo := newMemoryKeys()
+		store := NewKeyStore(repo, time.Minute)
+		created, err := store.CreateKey(ctx, models.CreateAPIKeyRequest{Name: "a", Scopes: []models.Scope{models.ScopeWalletsRead}})
+		require.NoError(t, err)
+		_, err = store.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+
+		for i := range maxUnknownKeys + 10 {
+			_, err := store.Authenticate(ctx, fmt.Sprintf("wk_unknown_%d", i))
+			require.ErrorIs(t, err, custom_err.ErrUnauthorized)
+		}
+		assert.Equal(t, maxUnknownKeys, store.unknown.len())
+		lookups := repo.lookups
+
+		_, err = store.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+		_, err = store.Authenticate(ctx, fmt.Sprintf("wk_unknown_%d", maxUnknownKeys+9))
+		require.ErrorIs(t, err, custom_err.ErrUnauthorized)
+		assert.Equal(t, lookups, repo.lookups, "the valid key and the newest unknown key stay cached")
+
+		_, err = store.Authenticate(ctx, "wk_unknown_0")
+		require.ErrorIs(t, err, custom_err.ErrUnauthorized)
+		assert.Equal(t, lookups+1, repo.lookups, "the oldest unknown key is evicted")
+	})
+
+	t.Run("Revoked Key Stops Working Immediately", func(t *testing.T) {
+		store := NewKeyStore(newMemoryKeys(), time.Minute)
+		created, err := store.CreateKey(ctx, models.CreateAPIKeyRequest{Name: "a", Scopes: []models.Scope{models.ScopeWalletsRead}})
+		require.NoError(t, err)
+		_, err = store.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+
+		require.NoError(t, store.RevokeKey(ctx, created.ID))
+		_, err = store.Authenticate(ctx, created.Key)
+		assert.ErrorIs(t, err, custom_err.ErrUnauthorized)
+		assert.ErrorIs(t, store.RevokeKey(ctx, uuid.New()), custom_err.ErrNotFound)
+	})
+
+	t.Run("Ensured Key Is Created Once", func(t *testing.T) {
+		repo := newMemoryKeys()
+		store := NewKeyStore(repo, time.Minute)
+		req := models.CreateAPIKeyRequest{Name: "bootstrap", Scopes: []models.Scope{models.ScopeAdmin}}
+
+		// Ключ, отвергнутый до создания, начинает работать сразу после него.
+		_, err := store.Authenticate(ctx, "configured-secret")
+		require.ErrorIs(t, err, custom_err.ErrUnauthorized)
+
+		require.NoError(t, store.EnsureKey(ctx, req, "configured-secret"))
+		require.NoError(t, store.EnsureKey(ctx, req, "configured-secret"))
+		keys, err := store.ListKeys(ctx)
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+
+		principal, err := store.Authenticate(ctx, "configured-secret")
+		require.NoError(t, err)
+		assert.True(t, principal.HasScope(models.ScopeWalletsWrite))
+		assert.False(t, principal.Restricted())
+	})
+}
@@ -0,0 +1,283 @@
+package cluster
+
+import (
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShardOwner — сторона, которая обслуживает кошельки выданных ей шардов.
+type ShardOwner interface {
+	AcquireShard(ctx context.Context, index int) error
+	// ReleaseShard возвращает, сколько кошельков шарда осталось не записано в БД.
+	ReleaseShard(ctx context.Context, index int) (unpersisted int, err error)
+}
+
+type Config struct {
+	// InstanceID различает экземпляры в таблице аренд.
+	InstanceID string
+	// Addr — адрес, по которому другие экземпляры проксируют запросы, например http://10.0.0.5:8080.
+	Addr string
+	// TTL — срок аренды; продлевается каждые TTL/3.
+	TTL time.Duration
+}
+
+// Manager арендует шарды кошельков в БД и распределяет их поровну между живыми
+// экземплярами. Перед отдачей шарда его изменения записываются в БД, поэтому
+// у каждого кошелька в любой момент не больше одного пишущего экземпляра.
+type Manager struct {
+	repo    repository.Leases
+	owner   ShardOwner
+	cfg     Config
+	shards  int
+	shardOf func(uuid.UUID) int
+
+	mu sync.Mutex
+	// owned — шарды, которые сейчас обслуживает этот экземпляр.
+	owned       map[int]bool
+	lastRenewal time.Time
+
+	routesMu sync.RWMutex
+	routes   map[int]models.ShardLease
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewManager создаёт менеджер для shards шардов; shardOf относит кошелек к шарду.
+func NewManager(repo repository.Leases, owner ShardOwner, shards int, shardOf func(uuid.UUID) int, cfg Config) *Manager {
+	return &Manager{
+		repo:    repo,
+		owner:   owner,
+		cfg:     cfg,
+		shards:  shards,
+		shardOf: shardOf,
+		owned:   make(map[int]bool),
+		routes:  make(map[int]models.ShardLease),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start выполняет первый раунд аренды синхронно, чтобы экземпляр начал
+// принимать запросы уже со своими шардами, и запускает продление в фоне.
+func (m *Manager) Start(ctx context.Context) error {
+	if err := m.rebalance(ctx); err != nil {
+		return fmt.Errorf("ошибка аренды шардов: %w", err)
+	}
+	go m.run()
+	return nil
+}
+
+func (m *Manager) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.cfg.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.cfg.TTL/3)
+		if err := m.rebalance(ctx); err != nil {
+			log.Printf("[Leases] Rebalance failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Stop прекращает продление и освобождает аренды. Вызывается после того, как
+// сервис записал все изменения в БД.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	shards := sortedKeys(m.owned)
+	m.owned = make(map[int]bool)
+	if err := m.repo.Release(ctx, m.cfg.InstanceID, shards); err != nil {
+		return err
+	}
+	return m.repo.Leave(ctx, m.cfg.InstanceID)
+}
+
+// OwnerOf возвращает адрес экземпляра, который обслуживает кошелек. local
+// означает, что запрос нужно обработать здесь: шард свой или у него нет владельца.
+func (m *Manager) OwnerOf(walletID uuid.UUID) (addr string, local bool) {
+	shard := m.shardOf(walletID)
+	m.routesMu.RLock()
+	lease, ok := m.routes[shard]
+	m.routesMu.RUnlock()
+	if !ok || lease.OwnerID == m.cfg.InstanceID {
+		return "", true
+	}
+	return lease.OwnerAddr, false
+}
+
+func (m *Manager) rebalance(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members, err := m.repo.Heartbeat(ctx, m.cfg.InstanceID, m.cfg.Addr, m.cfg.TTL)
+	var renewed []int
+	if err == nil {
+		renewed, err = m.repo.Renew(ctx, m.cfg.InstanceID, m.cfg.Addr, m.cfg.TTL)
+	}
+	if err != nil {
+		// Без продления аренда истечёт, и шарды заберут другие: отпускаем их заранее.
+		if !m.lastRenewal.IsZero() && time.Since(m.lastRenewal) > m.cfg.TTL/2 {
+			log.Printf("[Leases] Leases not renewed for %v, releasing %d shards", time.Since(m.lastRenewal), len(m.owned))
+			// Срок раунда уже потрачен на попытки продления, а шардам нужно время
+			// записать изменения в БД.
+			releaseCtx, cancel := context.WithTimeout(context.Background(), m.cfg.TTL)
+			unpersisted := 0
+			for _, shard := range sortedKeys(m.owned) {
+				unpersisted += m.releaseLocal(releaseCtx, shard)
+			}
+			cancel()
+			if unpersisted > 0 {
+				log.Printf("[Leases] %d wallets of released shards are not persisted", unpersisted)
+			}
+		}
+		return fmt.Errorf("продление аренды: %w", err)
+	}
+	m.lastRenewal = time.Now()
+
+	held := make(map[int]bool, len(renewed))
+	for _, shard := range renewed {
+		held[shard] = true
+	}
+	// Аренда, которой нет в БД, потеряна: её уже может держать другой экземпляр.
+	for _, shard := range sortedKeys(m.owned) {
+		if !held[shard] {
+			log.Printf("[Leases] Lease of shard %d lost", shard)
+			m.releaseLocal(ctx, shard)
+		}
+	}
+	for _, shard := range renewed {
+		if !m.owned[shard] {
+			m.acquireLocal(ctx, shard)
+		}
+	}
+
+	leases, err := m.repo.List(ctx)
+	if err != nil {
+		return fmt.Errorf("чтение аренд: %w", err)
+	}
+
+	// Экземпляр, который ещё ничего не арендовал, виден только по отметке в cluster_members.
+	owners := map[string]bool{m.cfg.InstanceID: true}
+	for _, member := range members {
+		owners[member] = true
+	}
+	taken := make(map[int]bool, len(leases))
+	for _, lease := range leases {
+		owners[lease.OwnerID] = true
+		taken[lease.Shard] = true
+	}
+	target := (m.shards + len(owners) - 1) / len(owners)
+
+	switch {
+	case len(m.owned) < target:
+		var free []int
+		for shard := 0; shard < m.shards && len(free) < target-len(m.owned); shard++ {
+			if !taken[shard] {
+				free = append(free, shard)
+			}
+		}
+		acquired, err := m.repo.Acquire(ctx, m.cfg.InstanceID, m.cfg.Addr, free, m.cfg.TTL)
+		if err != nil {
+			return fmt.Errorf("захват шардов: %w", err)
+		}
+		for _, shard := range acquired {
+			m.acquireLocal(ctx, shard)
+		}
+		if len(acquired) > 0 {
+			log.Printf("[Leases] Acquired %d shards, owning %d of target %d", len(acquired), len(m.owned), target)
+		}
+	case len(m.owned) > target:
+		// Отдаём лишние шарды, чтобы их забрали новые экземпляры.
+		excess := sortedKeys(m.owned)[target:]
+		var released []int
+		for _, shard := range excess {
+			if _, err := m.owner.ReleaseShard(ctx, shard); err != nil {
+				// Не всё записано в БД: шард остаётся за нами до следующей попытки.
+				log.Printf("[Leases] Shard %d not handed over: %v", shard, err)
+				m.acquireLocal(ctx, shard)
+				continue
+			}
+			delete(m.owned, shard)
+			released = append(released, shard)
+		}
+		if err := m.repo.Release(ctx, m.cfg.InstanceID, released); err != nil {
+			return fmt.Errorf("освобождение шардов: %w", err)
+		}
+		if len(released) > 0 {
+			log.Printf("[Leases] Released %d shards, owning %d of target %d", len(released), len(m.owned), target)
+		}
+	}
+
+	m.setRoutes(leases)
+	return nil
+}
+
+func (m *Manager) acquireLocal(ctx context.Context, shard int) {
+	if err := m.owner.AcquireShard(ctx, shard); err != nil {
+		// Без сверки кэша шард не обслуживаем; аренда истечёт или будет отдана.
+		log.Printf("[Leases] Shard %d not taken over: %v", shard, err)
+		return
+	}
+	m.owned[shard] = true
+}
+
+// releaseLocal перестаёт обслуживать шард и возвращает, сколько его кошельков не записано в БД.
+func (m *Manager) releaseLocal(ctx context.Context, shard int) int {
+	unpersisted, err := m.owner.ReleaseShard(ctx, shard)
+	if err != nil {
+		log.Printf("[Leases] Shard %d released with unflushed changes: %v", shard, err)
+	}
+	delete(m.owned, shard)
+	return unpersisted
+}
+
+// setRoutes обновляет таблицу маршрутизации. Свои шарды берутся из owned:
+// в прочитанных арендах могут быть шарды, которые мы только что отдали.
+func (m *Manager) setRoutes(leases []models.ShardLease) {
+	routes := make(map[int]models.ShardLease, len(leases))
+	for _, lease := range leases {
+		if lease.OwnerID == m.cfg.InstanceID && !m.owned[lease.Shard] {
+			continue
+		}
+		routes[lease.Shard] = lease
+	}
+	for shard := range m.owned {
+		routes[shard] = models.ShardLease{Shard: shard, OwnerID: m.cfg.InstanceID, OwnerAddr: m.cfg.Addr}
+	}
+
+	m.routesMu.Lock()
+	m.routes = routes
+	m.routesMu.Unlock()
+}
+
+func sortedKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,254 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testShards = 8
+
+// fakeLeases — таблица аренд в памяти; аренды не истекают, пока их не снимет тест.
+type fakeLeases struct {
+	mu      sync.Mutex
+	leases  map[int]models.ShardLease
+	members map[string]bool
+	// renewErr — ошибка, которую вернёт продление аренд.
+	renewErr error
+}
+
+func newFakeLeases() *fakeLeases {
+	return &fakeLeases{leases: make(map[int]models.ShardLease), members: make(map[string]bool)}
+}
+
+func (f *fakeLeases) Heartbeat(ctx context.Context, ownerID, ownerAddr string, ttl time.Duration) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members[ownerID] = true
+	members := make([]string, 0, len(f.members))
+	for member := range f.members {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (f *fakeLeases) Leave(ctx context.Context, ownerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.members, ownerID)
+	return nil
+}
+
+func (f *fakeLeases) Acquire(ctx context.Context, ownerID, ownerAddr string, shards []int, ttl time.Duration) ([]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var acquired []int
+	for _, shard := range shards {
+		if lease, ok := f.leases[shard]; ok && lease.OwnerID != ownerID {
+			continue
+		}
+		f.leases[shard] = models.ShardLease{Shard: shard, OwnerID: ownerID, OwnerAddr: ownerAddr}
+		acquired = append(acquired, shard)
+	}
+	return acquired, nil
+}
+
+func (f *fakeLeases) Renew(ctx context.Context, ownerID, ownerAddr string, ttl time.Duration) ([]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.renewErr != nil {
+		return nil, f.renewErr
+	}
+	var renewed []int
+	for shard, lease := range f.leases {
+		if lease.OwnerID == ownerID {
+			renewed = append(renewed, shard)
+		}
+	}
+	return renewed, nil
+}
+
+func (f *fakeLeases) Release(ctx context.Context, ownerID string, shards []int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, shard := range shards {
+		if f.leases[shard].OwnerID == ownerID {
+			delete(f.leases, shard)
+		}
+	}
+	return nil
+}
+
+func (f *fakeLeases) List(ctx context.Context) ([]models.ShardLease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	leases := make([]models.ShardLease, 0, len(f.leases))
+	for _, lease := range f.leases {
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+func (f *fakeLeases) ownedBy(ownerID string) map[int]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	owned := make(map[int]bool)
+	for shard, lease := range f.leases {
+		if lease.OwnerID == ownerID {
+			owned[shard] = true
+		}
+	}
+	return owned
+}
+
+// fakeOwner запоминает, какие шарды ему выданы.
+type fakeOwner struct {
+	owned      map[int]bool
+	releaseErr error
+	// releaseCtxErr — ошибка контекста, с которым отпущен последний шард.
+	releaseCtxErr error
+}
+
+func newFakeOwner() *fakeOwner {
+	return &fakeOwner{owned: make(map[int]bool)}
+}
+
+func (o *fakeOwner) AcquireShard(ctx context.Context, index int) error {
+	o.owned[index] = true
+	return nil
+}
+
+func (o *fakeOwner) ReleaseShard(ctx context.Context, index int) (int, error) {
+	delete(o.owned, index)
+	o.releaseCtxErr = ctx.Err()
+	if o.releaseErr != nil {
+		return 1, o.releaseErr
+	}
+	return 0, nil
+}
+
+func shardOf(id uuid.UUID) int {
+	return int(id[0]) % testShards
+}
+
+func walletInShard(shard int) uuid.UUID {
+	for {
+		id := uuid.New()
+		if shardOf(id) == shard {
+			return id
+		}
+	}
+}
+
+func TestManager_rebalance(t *testing.T) {
+	ctx := context.Background()
+
+	newManager := func(repo *fakeLeases, owner *fakeOwner, id string) *Manager {
+		return NewManager(repo, owner, testShards, shardOf, Config{InstanceID: id, Addr: "http://" + id, TTL: time.Second})
+	}
+
+	t.Run("Single instance takes all shards", func(t *testing.T) {
+		repo, owner := newFakeLeases(), newFakeOwner()
+		m := newManager(repo, owner, "a")
+
+		require.NoError(t, m.rebalance(ctx))
+		assert.Len(t, owner.owned, testShards)
+		assert.Len(t, repo.ownedBy("a"), testShards)
+
+		_, local := m.OwnerOf(walletInShard(3))
+		assert.True(t, local)
+	})
+
+	t.Run("Shards are split with a new instance", func(t *testing.T) {
+		repo := newFakeLeases()
+		ownerA, ownerB := newFakeOwner(), newFakeOwner()
+		a, b := newManager(repo, ownerA, "a"), newManager(repo, ownerB, "b")
+
+		require.NoError(t, a.rebalance(ctx))
+		require.NoError(t, b.rebalance(ctx))
+		assert.Empty(t, ownerB.owned, "all shards are still leased by a")
+
+		// a видит второго владельца и отдаёт лишнее, b забирает освободившееся.
+		require.NoError(t, a.rebalance(ctx))
+		require.NoError(t, b.rebalance(ctx))
+		require.NoError(t, a.rebalance(ctx))
+
+		assert.Len(t, ownerA.owned, testShards/2)
+		assert.Len(t, ownerB.owned, testShards/2)
+		assert.Equal(t, ownerA.owned, repo.ownedBy("a"))
+		assert.Equal(t, ownerB.owned, repo.ownedBy("b"))
+
+		for shard := range ownerB.owned {
+			addr, local := a.OwnerOf(walletInShard(shard))
+			assert.False(t, local)
+			assert.Equal(t, "http://b", addr)
+		}
+	})
+
+	t.Run("Lost lease is released locally", func(t *testing.T) {
+		repo, owner := newFakeLeases(), newFakeOwner()
+		m := newManager(repo, owner, "a")
+		require.NoError(t, m.rebalance(ctx))
+
+		// Аренда истекла, и шард забрал другой экземпляр.
+		repo.mu.Lock()
+		repo.leases[5] = models.ShardLease{Shard: 5, OwnerID: "b", OwnerAddr: "http://b"}
+		repo.mu.Unlock()
+
+		require.NoError(t, m.rebalance(ctx))
+		assert.False(t, owner.owned[5])
+		addr, local := m.OwnerOf(walletInShard(5))
+		assert.False(t, local)
+		assert.Equal(t, "http://b", addr)
+	})
+
+	t.Run("Shard with unflushed changes is not handed over", func(t *testing.T) {
+		repo := newFakeLeases()
+		ownerA := newFakeOwner()
+		a := newManager(repo, ownerA, "a")
+		require.NoError(t, a.rebalance(ctx))
+
+		ownerA.releaseErr = errors.New("db is down")
+		b := newManager(repo, newFakeOwner(), "b")
+		require.NoError(t, b.rebalance(ctx))
+		require.NoError(t, a.rebalance(ctx))
+
+		assert.Len(t, ownerA.owned, testShards)
+		assert.Len(t, repo.ownedBy("a"), testShards)
+	})
+
+	t.Run("Shards are released with a fresh context when renewal fails", func(t *testing.T) {
+		repo, owner := newFakeLeases(), newFakeOwner()
+		m := newManager(repo, owner, "a")
+		require.NoError(t, m.rebalance(ctx))
+
+		repo.renewErr = errors.New("db is down")
+		m.lastRenewal = time.Now().Add(-time.Second)
+		// Срок раунда истёк, пока продление ждало БД.
+		expired, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.Error(t, m.rebalance(expired))
+
+		assert.Empty(t, owner.owned)
+		assert.Empty(t, m.owned)
+		assert.NoError(t, owner.releaseCtxErr)
+	})
+
+	t.Run("Stop releases all leases", func(t *testing.T) {
+		repo, owner := newFakeLeases(), newFakeOwner()
+		m := newManager(repo, owner, "a")
+		require.NoError(t, m.Start(ctx))
+
+		require.NoError(t, m.Stop(ctx))
+		assert.Empty(t, repo.ownedBy("a"))
+		assert.Empty(t, repo.members)
+	})
+}
@@ -3,14 +3,24 @@ package config
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	HTTPPort string `envconfig:"APP_PORT" default:"8080"`
-	DB       DBConfig
+	HTTPPort   string `envconfig:"APP_PORT" default:"8080"`
+	DB         DBConfig
+	Journal    JournalConfig
+	DeadLetter DeadLetterConfig
+	Wallet     WalletConfig
+	Cluster    ClusterConfig
+	Health     HealthConfig
+	Tracing    TracingConfig
+	Auth       AuthConfig
+	RateLimit  RateLimitConfig
+	Request    RequestConfig
 }
 
 type DBConfig struct {
@@ -22,6 +32,104 @@ type DBConfig struct {
 	SSLMode  string `envconfig:"POSTGRES_SSLMODE"  default:"disable"`
 }
 
+type JournalConfig struct {
+	Enabled      bool          `envconfig:"JOURNAL_ENABLED"       default:"true"`
+	Dir          string        `envconfig:"JOURNAL_DIR"           default:"data/journal"`
+	SegmentSize  int64         `envconfig:"JOURNAL_SEGMENT_SIZE"  default:"67108864"`
+	SyncInterval time.Duration `envconfig:"JOURNAL_SYNC_INTERVAL" default:"2ms"`
+}
+
+type DeadLetterConfig struct {
+	Enabled bool   `envconfig:"DEADLETTER_ENABLED" default:"true"`
+	Dir     string `envconfig:"DEADLETTER_DIR"     default:"data/deadletter"`
+}
+
+type WalletConfig struct {
+	IdempotencyWindow  time.Duration `envconfig:"WALLET_IDEMPOTENCY_WINDOW"   default:"10m"`
+	ConsistencyMode    string        `envconfig:"WALLET_CONSISTENCY_MODE"     default:"cache"`
+	SyncMaxRetries     int           `envconfig:"WALLET_SYNC_MAX_RETRIES"     default:"5"`
+	CacheCoherence     bool          `envconfig:"WALLET_CACHE_COHERENCE"      default:"true"`
+	CacheMaxWallets    int           `envconfig:"WALLET_CACHE_MAX_WALLETS"    default:"1000000"`
+	CacheIdleTTL       time.Duration `envconfig:"WALLET_CACHE_IDLE_TTL"       default:"30m"`
+	NegativeCacheTTL   time.Duration `envconfig:"WALLET_NEGATIVE_CACHE_TTL"   default:"5s"`
+	HoldExpiryInterval time.Duration `envconfig:"WALLET_HOLD_EXPIRY_INTERVAL" default:"1s"`
+	Backpressure       BackpressureConfig
+}
+
+// BackpressureConfig — пороги отставания flush'а, с которых изменения баланса
+// замедляются (SOFT) и отклоняются с 429 (HARD). 0 в HARD снимает ограничение.
+type BackpressureConfig struct {
+	Enabled          bool          `envconfig:"WALLET_BACKPRESSURE_ENABLED"            default:"true"`
+	SoftPendingOps   int64         `envconfig:"WALLET_BACKPRESSURE_SOFT_PENDING_OPS"   default:"200000"`
+	HardPendingOps   int64         `envconfig:"WALLET_BACKPRESSURE_HARD_PENDING_OPS"   default:"1000000"`
+	SoftDirtyWallets int64         `envconfig:"WALLET_BACKPRESSURE_SOFT_DIRTY_WALLETS" default:"50000"`
+	HardDirtyWallets int64         `envconfig:"WALLET_BACKPRESSURE_HARD_DIRTY_WALLETS" default:"200000"`
+	SoftRetryQueue   int64         `envconfig:"WALLET_BACKPRESSURE_SOFT_RETRY_QUEUE"   default:"1000"`
+	HardRetryQueue   int64         `envconfig:"WALLET_BACKPRESSURE_HARD_RETRY_QUEUE"   default:"10000"`
+	MaxDelay         time.Duration `envconfig:"WALLET_BACKPRESSURE_MAX_DELAY"          default:"200ms"`
+	RetryAfter       time.Duration `envconfig:"WALLET_BACKPRESSURE_RETRY_AFTER"        default:"1s"`
+}
+
+type ClusterConfig struct {
+	Enabled bool `envconfig:"CLUSTER_ENABLED" default:"false"`
+	// InstanceID по умолчанию — имя хоста.
+	InstanceID    string        `envconfig:"CLUSTER_INSTANCE_ID"`
+	AdvertiseAddr string        `envconfig:"CLUSTER_ADVERTISE_ADDR"`
+	LeaseTTL      time.Duration `envconfig:"CLUSTER_LEASE_TTL" default:"10s"`
+	// Secret — общий ключ экземпляров, которым подписываются перенаправленные запросы.
+	Secret string `envconfig:"CLUSTER_SECRET"`
+}
+
+// HealthConfig — пороги проверки готовности (/readyz).
+type HealthConfig struct {
+	CheckTimeout      time.Duration `envconfig:"HEALTH_CHECK_TIMEOUT"        default:"2s"`
+	FlushStaleAfter   time.Duration `envconfig:"HEALTH_FLUSH_STALE_AFTER"    default:"1m"`
+	RetryQueueMaxFill float64       `envconfig:"HEALTH_RETRY_QUEUE_MAX_FILL" default:"0.9"`
+	// ShutdownDelay — сколько /readyz отвечает 503 перед остановкой сервера,
+	// чтобы балансировщик успел убрать экземпляр.
+	ShutdownDelay time.Duration `envconfig:"HEALTH_SHUTDOWN_DELAY" default:"5s"`
+}
+
+// TracingConfig — экспорт трейсов OpenTelemetry: none, stdout или otlp-file.
+type TracingConfig struct {
+	Exporter    string  `envconfig:"TRACING_EXPORTER"     default:"none"`
+	File        string  `envconfig:"TRACING_FILE"         default:"data/traces.jsonl"`
+	SampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+}
+
+// AuthConfig — проверка API-ключей и JWT. Без AUTH_JWKS_FILE принимаются только API-ключи.
+type AuthConfig struct {
+	Enabled     bool          `envconfig:"AUTH_ENABLED"       default:"true"`
+	KeyCacheTTL time.Duration `envconfig:"AUTH_KEY_CACHE_TTL" default:"30s"`
+	// BootstrapKey — ключ с правом admin, который создаётся при запуске, чтобы
+	// через него выдать остальные ключи.
+	BootstrapKey string        `envconfig:"AUTH_BOOTSTRAP_KEY"`
+	JWKSFile     string        `envconfig:"AUTH_JWKS_FILE"`
+	JWTIssuer    string        `envconfig:"AUTH_JWT_ISSUER"`
+	JWTAudience  string        `envconfig:"AUTH_JWT_AUDIENCE"`
+	JWTLeeway    time.Duration `envconfig:"AUTH_JWT_LEEWAY" default:"30s"`
+}
+
+// RateLimitConfig — token bucket: RATE запросов в секунду и запас в BURST
+// запросов. 0 снимает ограничение. Запас на кошелек рассчитан на 1000 RPS по
+// одному кошельку.
+type RateLimitConfig struct {
+	Enabled     bool    `envconfig:"RATE_LIMIT_ENABLED"      default:"true"`
+	KeyRate     float64 `envconfig:"RATE_LIMIT_KEY_RATE"     default:"2000"`
+	KeyBurst    int     `envconfig:"RATE_LIMIT_KEY_BURST"    default:"2000"`
+	IPRate      float64 `envconfig:"RATE_LIMIT_IP_RATE"      default:"2000"`
+	IPBurst     int     `envconfig:"RATE_LIMIT_IP_BURST"     default:"2000"`
+	WalletRate  float64 `envconfig:"RATE_LIMIT_WALLET_RATE"  default:"1500"`
+	WalletBurst int     `envconfig:"RATE_LIMIT_WALLET_BURST" default:"1500"`
+}
+
+// RequestConfig — разбор JSON-тел запросов. FieldAliases — устаревшие имена
+// полей, которые ещё принимаются, в виде старое:текущее через запятую.
+type RequestConfig struct {
+	MaxBodyBytes int64             `envconfig:"REQUEST_MAX_BODY_BYTES" default:"65536"`
+	FieldAliases map[string]string `envconfig:"REQUEST_FIELD_ALIASES"  default:"valletId:walletId"`
+}
+
 func NewConfig() (*Config, error) {
 	envFile := "config.env"
 
@@ -1,6 +1,12 @@
 package custom_err
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 var (
 	ErrNotFound           = errors.New("запись не найдена")
@@ -8,4 +14,47 @@ var (
 	ErrDuplicateRequest   = errors.New("повторяющийся запрос")
 	ErrMaxRetriesExceeded = errors.New("превышено максимальное число повторных попыток")
 	ErrConflict           = errors.New("конфликт оптимистической блокировки")
+
+	ErrAlreadyExists           = errors.New("запись уже существует")
+	ErrWalletFrozen            = errors.New("кошелек заморожен")
+	ErrWalletClosed            = errors.New("кошелек закрыт")
+	ErrInvalidStatusTransition = errors.New("недопустимая смена статуса кошелька")
+	ErrSameWallet              = errors.New("перевод на тот же кошелек")
+	ErrInvalidCursor           = errors.New("невалидный курсор")
+	ErrServiceStopping         = errors.New("сервис останавливается")
+	ErrNotOwner                = errors.New("кошелек обслуживает другой экземпляр")
+	ErrOverloaded              = errors.New("сервис перегружен: изменения не успевают записываться в БД")
+	ErrHoldNotActive           = errors.New("холд уже списан, отменён или истёк")
+	ErrHoldExpired             = errors.New("срок холда истёк")
+	ErrCaptureExceedsHold      = errors.New("сумма списания больше суммы холда")
+
+	ErrUnauthorized = errors.New("не удалось проверить подлинность запроса")
 )
+
+// RetryAfterError — ошибка запроса, который стоит повторить не раньше чем через After.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError — строки кошельков WalletIDs изменились после версии, от которой
+// считал пишущий, и запись отменена.
+type ConflictError struct {
+	WalletIDs []uuid.UUID
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %d wallets", ErrConflict, len(e.WalletIDs))
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
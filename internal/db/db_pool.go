@@ -22,6 +22,7 @@ func NewPool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 	conf.MaxConns = DefaultMaxConns
 	conf.MinConns = defaultMinConns
 	conf.HealthCheckPeriod = 1 * time.Minute
+	conf.ConnConfig.Tracer = NewQueryTracer()
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector отдаёт в Prometheus статистику пула соединений.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns   *prometheus.Desc
+	idleConns       *prometheus.Desc
+	totalConns      *prometheus.Desc
+	maxConns        *prometheus.Desc
+	acquires        *prometheus.Desc
+	emptyAcquires   *prometheus.Desc
+	canceledAcquire *prometheus.Desc
+	acquireDuration *prometheus.Desc
+}
+
+// NewPoolCollector возвращает prometheus.Collector, читающий pool.Stat() при каждом опросе.
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("pgxpool", "", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool:            pool,
+		acquiredConns:   desc("acquired_conns", "Соединения, занятые запросами."),
+		idleConns:       desc("idle_conns", "Свободные соединения."),
+		totalConns:      desc("total_conns", "Все соединения пула."),
+		maxConns:        desc("max_conns", "Максимальный размер пула."),
+		acquires:        desc("acquires_total", "Успешные получения соединения из пула."),
+		emptyAcquires:   desc("empty_acquires_total", "Получения соединения, которым пришлось ждать."),
+		canceledAcquire: desc("canceled_acquires_total", "Получения соединения, прерванные отменой контекста."),
+		acquireDuration: desc("acquire_duration_seconds_total", "Суммарное время получения соединений."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.acquiredConns, c.idleConns, c.totalConns, c.maxConns,
+		c.acquires, c.emptyAcquires, c.canceledAcquire, c.acquireDuration,
+	} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stat()
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+
+	gauge(c.acquiredConns, float64(stats.AcquiredConns()))
+	gauge(c.idleConns, float64(stats.IdleConns()))
+	gauge(c.totalConns, float64(stats.TotalConns()))
+	gauge(c.maxConns, float64(stats.MaxConns()))
+	counter(c.acquires, float64(stats.AcquireCount()))
+	counter(c.emptyAcquires, float64(stats.EmptyAcquireCount()))
+	counter(c.canceledAcquire, float64(stats.CanceledAcquireCount()))
+	counter(c.acquireDuration, stats.AcquireDuration().Seconds())
+}
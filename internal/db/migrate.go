@@ -1,38 +1,60 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func RunMigrations(dsn string, migrationsPath string) error {
+// RunMigrations применяет миграции и возвращает версию схемы после них.
+func RunMigrations(dsn string, migrationsPath string) (uint, error) {
 	if dsn == "" {
-		return errors.New("DSN для миграций не может быть пустым")
+		return 0, errors.New("DSN для миграций не может быть пустым")
 	}
 	if migrationsPath == "" {
-		return errors.New("путь к файлам миграций не может быть пустым")
+		return 0, errors.New("путь к файлам миграций не может быть пустым")
 	}
 
 	m, err := migrate.New("file://"+migrationsPath, dsn)
 	if err != nil {
-		return fmt.Errorf("не удалось создать экземпляр мигратора: %w", err)
+		return 0, fmt.Errorf("не удалось создать экземпляр мигратора: %w", err)
 	}
 
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("ошибка при выполнении миграций: %w", err)
+		return 0, fmt.Errorf("ошибка при выполнении миграций: %w", err)
 	}
 
 	version, dirty, err := m.Version()
 	if err != nil {
-		return fmt.Errorf("ошибка при проверке версии миграций: %w", err)
+		return 0, fmt.Errorf("ошибка при проверке версии миграций: %w", err)
 	}
 	if dirty {
-		return fmt.Errorf("обнаружена 'грязная' миграция версии %d. Исправьте вручную", version)
+		return 0, fmt.Errorf("обнаружена 'грязная' миграция версии %d. Исправьте вручную", version)
 	}
 
-	return nil
+	return version, nil
+}
+
+// SchemaVersion читает версию схемы, записанную golang-migrate.
+type SchemaVersion struct {
+	pool *pgxpool.Pool
+}
+
+func NewSchemaVersion(pool *pgxpool.Pool) *SchemaVersion {
+	return &SchemaVersion{pool: pool}
+}
+
+// MigrationVersion возвращает текущую версию схемы и признак незавершённой миграции.
+func (v *SchemaVersion) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	if err := v.pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty); err != nil {
+		return 0, false, fmt.Errorf("ошибка чтения версии схемы: %w", err)
+	}
+	return uint(version), dirty, nil
 }
@@ -0,0 +1,162 @@
+// Package deadletter хранит на локальном диске снимки операций, которые flush
+// не смог записать в БД. Хранилище не зависит от БД: оно нужно как раз тогда,
+// когда БД недоступна.
+package deadletter
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const fileExt = ".json"
+
+// FileStore хранит каждое письмо в отдельном файле каталога. Файл пишется во
+// временный, сбрасывается на диск и переименовывается, поэтому после сбоя
+// письмо либо есть целиком, либо его нет.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Open открывает каталог хранилища, создавая его при необходимости, и удаляет
+// временные файлы, оставшиеся от оборванных записей.
+func Open(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("каталог dead letter не может быть пустым")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("не удалось создать каталог dead letter: %w", err)
+	}
+	tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range tmp {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("не удалось удалить %s: %w", path, err)
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Put сохраняет письмо и возвращается после того, как оно на диске.
+func (s *FileStore) Put(ctx context.Context, letter models.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("не удалось закодировать dead letter: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(letter.ID)
+	tmp := path + ".tmp"
+	if err := writeFile(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("не удалось записать dead letter: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("не удалось записать dead letter: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+// Get возвращает письмо или custom_err.ErrNotFound.
+func (s *FileStore) Get(ctx context.Context, id uuid.UUID) (*models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(s.path(id))
+}
+
+// List возвращает все письма, старые первыми.
+func (s *FileStore) List(ctx context.Context) ([]models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать каталог dead letter: %w", err)
+	}
+	letters := make([]models.DeadLetter, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fileExt) {
+			continue
+		}
+		letter, err := s.read(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].CreatedAt.Before(letters[j].CreatedAt)
+	})
+	return letters, nil
+}
+
+// Delete удаляет письмо. Удаление отсутствующего письма не ошибка.
+func (s *FileStore) Delete(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("не удалось удалить dead letter: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+func (s *FileStore) path(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String()+fileExt)
+}
+
+func (s *FileStore) read(path string) (*models.DeadLetter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, custom_err.ErrNotFound
+		}
+		return nil, fmt.Errorf("не удалось прочитать dead letter: %w", err)
+	}
+	var letter models.DeadLetter
+	if err := json.Unmarshal(data, &letter); err != nil {
+		return nil, fmt.Errorf("dead letter %s повреждён: %w", path, err)
+	}
+	return &letter, nil
+}
+
+func writeFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,64 @@
+package deadletter
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := Open(dir)
+	require.NoError(t, err)
+
+	walletID := uuid.New()
+	older := models.DeadLetter{
+		ID:         uuid.New(),
+		WalletIDs:  []uuid.UUID{walletID},
+		Operations: []models.Operation{{ID: uuid.New(), WalletID: walletID, Type: models.DepositOperation, Amount: 10}},
+		Attempts:   3,
+		LastError:  "connection refused",
+		CreatedAt:  time.Now().UTC().Add(-time.Minute),
+	}
+	newer := older
+	newer.ID = uuid.New()
+	newer.CreatedAt = time.Now().UTC()
+
+	require.NoError(t, store.Put(ctx, newer))
+	require.NoError(t, store.Put(ctx, older))
+
+	// Письма переживают переоткрытие хранилища, оборванная запись удаляется.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, uuid.NewString()+fileExt+".tmp"), []byte("{"), 0o644))
+	store, err = Open(dir)
+	require.NoError(t, err)
+
+	letters, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, older.ID, letters[0].ID, "older letters first")
+	assert.Equal(t, newer.ID, letters[1].ID)
+	assert.Equal(t, older.Operations[0].ID, letters[0].Operations[0].ID)
+
+	letter, err := store.Get(ctx, older.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "connection refused", letter.LastError)
+
+	require.NoError(t, store.Delete(ctx, older.ID))
+	require.NoError(t, store.Delete(ctx, older.ID), "deleting twice is not an error")
+	_, err = store.Get(ctx, older.ID)
+	assert.ErrorIs(t, err, custom_err.ErrNotFound)
+
+	letters, err = store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, letters, 1)
+}
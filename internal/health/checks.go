@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"api_wallet/internal/service"
+)
+
+// Pinger — зависимость, доступность которой проверяется запросом, например pgxpool.Pool.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping проверяет, что зависимость отвечает.
+func Ping(p Pinger) Check {
+	return func(ctx context.Context) (any, error) {
+		return nil, p.Ping(ctx)
+	}
+}
+
+// FlushSource — сервис, воркеры flush'а и очередь повторов которого проверяются.
+type FlushSource interface {
+	FlushWorkers() []service.FlushWorkerHealth
+	RetryQueue() service.RetryQueueHealth
+}
+
+// FlushWorkerDetails — состояние воркера flush'а в отчёте.
+type FlushWorkerDetails struct {
+	Worker           int       `json:"worker"`
+	LastSuccess      time.Time `json:"lastSuccess"`
+	SinceLastSuccess string    `json:"sinceLastSuccess"`
+}
+
+// Flushers проверяет, что каждый воркер flush'а успешно отработал не позже
+// чем staleAfter назад.
+func Flushers(src FlushSource, staleAfter time.Duration) Check {
+	return func(ctx context.Context) (any, error) {
+		now := time.Now()
+		workers := src.FlushWorkers()
+		details := make([]FlushWorkerDetails, 0, len(workers))
+		var stale []int
+		for _, w := range workers {
+			since := now.Sub(w.LastSuccess)
+			d := FlushWorkerDetails{Worker: w.Worker, LastSuccess: w.LastSuccess}
+			if w.LastSuccess.IsZero() {
+				d.SinceLastSuccess = "never"
+			} else {
+				d.SinceLastSuccess = since.Round(time.Millisecond).String()
+			}
+			details = append(details, d)
+			if w.LastSuccess.IsZero() || since > staleAfter {
+				stale = append(stale, w.Worker)
+			}
+		}
+		if len(stale) > 0 {
+			return details, fmt.Errorf("воркеры %v не записывали в БД дольше %s", stale, staleAfter)
+		}
+		return details, nil
+	}
+}
+
+// RetryQueueDetails — заполненность очереди повторов в отчёте.
+type RetryQueueDetails struct {
+	Length   int     `json:"length"`
+	Capacity int     `json:"capacity"`
+	Fill     float64 `json:"fill"`
+}
+
+// RetryQueue проверяет, что очередь повторов заполнена меньше чем на maxFill (от 0 до 1).
+func RetryQueue(src FlushSource, maxFill float64) Check {
+	return func(ctx context.Context) (any, error) {
+		q := src.RetryQueue()
+		details := RetryQueueDetails{Length: q.Length, Capacity: q.Capacity}
+		if q.Capacity > 0 {
+			details.Fill = float64(q.Length) / float64(q.Capacity)
+		}
+		if details.Fill >= maxFill {
+			return details, fmt.Errorf("очередь повторов заполнена на %.0f%%", details.Fill*100)
+		}
+		return details, nil
+	}
+}
+
+// MigrationSource читает версию схемы БД.
+type MigrationSource interface {
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// MigrationDetails — версия схемы в отчёте.
+type MigrationDetails struct {
+	Version  uint `json:"version"`
+	Expected uint `json:"expected"`
+	Dirty    bool `json:"dirty"`
+}
+
+// Migrations проверяет, что схема БД не ниже expected и последняя миграция
+// применена полностью. Более новая схема допустима: миграции только добавляют,
+// и её мог применить обновлённый экземпляр.
+func Migrations(src MigrationSource, expected uint) Check {
+	return func(ctx context.Context) (any, error) {
+		version, dirty, err := src.MigrationVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		details := MigrationDetails{Version: version, Expected: expected, Dirty: dirty}
+		switch {
+		case dirty:
+			return details, fmt.Errorf("миграция %d применена не полностью", version)
+		case version < expected:
+			return details, fmt.Errorf("схема БД версии %d, ожидается %d", version, expected)
+		}
+		return details, nil
+	}
+}
+
+// ErrShuttingDown — экземпляр останавливается и новые запросы направлять ему не нужно.
+var ErrShuttingDown = errors.New("экземпляр останавливается")
+
+// Running проверяет, что экземпляр не останавливается.
+func Running(stopping func() bool) Check {
+	return func(ctx context.Context) (any, error) {
+		if stopping() {
+			return nil, ErrShuttingDown
+		}
+		return nil, nil
+	}
+}
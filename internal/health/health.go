@@ -0,0 +1,99 @@
+// Package health собирает проверки готовности сервиса в один отчёт.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check проверяет одну зависимость. details попадают в отчёт как есть, в том
+// числе при ошибке.
+type Check func(ctx context.Context) (details any, err error)
+
+// Result — результат одной проверки.
+type Result struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Report — результаты всех проверок. Status — ok, только если ok все проверки.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Ready сообщает, прошли ли все проверки.
+func (r Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// Checker выполняет набор именованных проверок.
+type Checker struct {
+	timeout time.Duration
+	checks  map[string]Check
+}
+
+// NewChecker создаёт набор проверок; каждая проверка ограничена timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add добавляет проверку. Вызывается до первого Run.
+func (c *Checker) Add(name string, check Check) {
+	c.checks[name] = check
+}
+
+// Run выполняет проверки параллельно. Проверка, не уложившаяся в таймаут,
+// считается проваленной.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := run(ctx, check)
+			mu.Lock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func run(ctx context.Context, check Check) Result {
+	type outcome struct {
+		details any
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := check(ctx)
+		done <- outcome{details, err}
+	}()
+
+	select {
+	case out := <-done:
+		if out.err != nil {
+			return Result{Status: StatusFail, Error: out.err.Error(), Details: out.details}
+		}
+		return Result{Status: StatusOK, Details: out.details}
+	case <-ctx.Done():
+		return Result{Status: StatusFail, Error: ctx.Err().Error()}
+	}
+}
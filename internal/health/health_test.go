@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"api_wallet/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFlushSource struct {
+	workers []service.FlushWorkerHealth
+	queue   service.RetryQueueHealth
+}
+
+func (f fakeFlushSource) FlushWorkers() []service.FlushWorkerHealth { return f.workers }
+func (f fakeFlushSource) RetryQueue() service.RetryQueueHealth      { return f.queue }
+
+type fakeMigrations struct {
+	version uint
+	dirty   bool
+	err     error
+}
+
+func (f fakeMigrations) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	return f.version, f.dirty, f.err
+}
+
+func TestChecker_Run(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("All checks pass", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("a", func(ctx context.Context) (any, error) { return "details", nil })
+		checker.Add("b", func(ctx context.Context) (any, error) { return nil, nil })
+
+		report := checker.Run(ctx)
+		assert.True(t, report.Ready())
+		assert.Equal(t, Result{Status: StatusOK, Details: "details"}, report.Checks["a"])
+		assert.Equal(t, Result{Status: StatusOK}, report.Checks["b"])
+	})
+
+	t.Run("One failed check fails the report", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("ok", func(ctx context.Context) (any, error) { return nil, nil })
+		checker.Add("broken", func(ctx context.Context) (any, error) { return 42, errors.New("boom") })
+
+		report := checker.Run(ctx)
+		assert.False(t, report.Ready())
+		assert.Equal(t, StatusOK, report.Checks["ok"].Status)
+		assert.Equal(t, Result{Status: StatusFail, Error: "boom", Details: 42}, report.Checks["broken"])
+	})
+
+	t.Run("Hanging check times out", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		checker := NewChecker(20 * time.Millisecond)
+		checker.Add("hang", func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+
+		start := time.Now()
+		report := checker.Run(ctx)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.False(t, report.Ready())
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["hang"].Error)
+	})
+}
+
+func TestChecks(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	testCases := []struct {
+		name        string
+		check       Check
+		expectedErr bool
+	}{
+		{
+			name: "Fresh flush workers",
+			check: Flushers(fakeFlushSource{workers: []service.FlushWorkerHealth{
+				{Worker: 0, LastSuccess: now.Add(-time.Second)},
+				{Worker: 1, LastSuccess: now},
+			}}, time.Minute),
+		},
+		{
+			name: "Stale flush worker",
+			check: Flushers(fakeFlushSource{workers: []service.FlushWorkerHealth{
+				{Worker: 0, LastSuccess: now},
+				{Worker: 1, LastSuccess: now.Add(-2 * time.Minute)},
+			}}, time.Minute),
+			expectedErr: true,
+		},
+		{
+			name:        "Flush worker never ran",
+			check:       Flushers(fakeFlushSource{workers: []service.FlushWorkerHealth{{Worker: 0}}}, time.Minute),
+			expectedErr: true,
+		},
+		{
+			name:  "Retry queue below limit",
+			check: RetryQueue(fakeFlushSource{queue: service.RetryQueueHealth{Length: 10, Capacity: 100}}, 0.9),
+		},
+		{
+			name:        "Retry queue saturated",
+			check:       RetryQueue(fakeFlushSource{queue: service.RetryQueueHealth{Length: 95, Capacity: 100}}, 0.9),
+			expectedErr: true,
+		},
+		{
+			name:  "Schema up to date",
+			check: Migrations(fakeMigrations{version: 10}, 10),
+		},
+		{
+			name:  "Schema migrated by a newer instance",
+			check: Migrations(fakeMigrations{version: 11}, 10),
+		},
+		{
+			name:        "Schema behind",
+			check:       Migrations(fakeMigrations{version: 9}, 10),
+			expectedErr: true,
+		},
+		{
+			name:        "Dirty migration",
+			check:       Migrations(fakeMigrations{version: 10, dirty: true}, 10),
+			expectedErr: true,
+		},
+		{
+			name:        "Schema version unavailable",
+			check:       Migrations(fakeMigrations{err: errors.New("connection refused")}, 10),
+			expectedErr: true,
+		},
+		{
+			name:  "Running",
+			check: Running(func() bool { return false }),
+		},
+		{
+			name:        "Shutting down",
+			check:       Running(func() bool { return true }),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.check(ctx)
+			if tc.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+// Package journal реализует локальный append-only журнал принятых операций.
+//
+// Каждая запись фиксирует итоговые балансы затронутых кошельков, поэтому при
+// восстановлении достаточно взять последнюю запись по каждому кошельку.
+// Записи пишутся группами: один fsync подтверждает всех, кто успел встать в
+// очередь, пока шёл предыдущий fsync. Файл журнала разбит на сегменты, и
+// сегменты, все записи которых уже попали в БД, удаляются через Truncate.
+package journal
+
+import (
+	"api_wallet/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrClosed = errors.New("журнал закрыт")
+
+// Entry — итоговый баланс кошелька после операции и сама операция для истории.
+type Entry struct {
+	WalletID  uuid.UUID         `json:"walletId"`
+	Balance   int64             `json:"balance"`
+	Operation *models.Operation `json:"operation,omitempty"`
+}
+
+// Record — одна принятая операция.
+type Record struct {
+	Seq     uint64  `json:"seq"`
+	Entries []Entry `json:"entries"`
+}
+
+type Config struct {
+	Dir string
+	// SegmentSize — размер, после которого активный сегмент закрывается и начинается новый.
+	SegmentSize int64
+	// SyncInterval — сколько syncer ждёт новых записей перед fsync, чтобы собрать пачку побольше.
+	SyncInterval time.Duration
+}
+
+// Ticket позволяет дождаться, пока запись окажется на диске.
+type Ticket struct {
+	Seq   uint64
+	batch *batch
+}
+
+// Wait блокируется до fsync пачки, в которую попала запись.
+func (t *Ticket) Wait() error {
+	<-t.batch.done
+	return t.batch.err
+}
+
+type batch struct {
+	buf      []byte
+	firstSeq uint64
+	lastSeq  uint64
+	done     chan struct{}
+	err      error
+}
+
+func newBatch() *batch {
+	return &batch{done: make(chan struct{})}
+}
+
+type Journal struct {
+	cfg Config
+
+	mu      sync.Mutex
+	nextSeq uint64
+	pending *batch
+	err     error
+	closed  bool
+
+	// segMu защищает файлы сегментов; их трогают только syncer, Truncate и Close.
+	segMu     sync.Mutex
+	active    *os.File
+	activeSeg *segment
+	sealed    []*segment
+	recovered []*segment
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Open открывает каталог журнала: проверяет существующие сегменты, отрезает
+// оборванный хвост последнего из них и начинает новый активный сегмент.
+// Записи старых сегментов можно прочитать через Replay.
+func Open(cfg Config) (*Journal, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("каталог журнала не может быть пустым")
+	}
+	if cfg.SegmentSize <= 0 {
+		return nil, errors.New("размер сегмента журнала должен быть положительным")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("не удалось создать каталог журнала: %w", err)
+	}
+
+	segments, err := listSegments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		cfg:     cfg,
+		nextSeq: 1,
+		pending: newBatch(),
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	for i, seg := range segments {
+		offset, scanned, err := scanSegment(seg.path, nil)
+		if err != nil {
+			if i != len(segments)-1 || (!errors.Is(err, errTornRecord) && !errors.Is(err, errCorruptRecord)) {
+				return nil, fmt.Errorf("сегмент %s: %w", seg.path, err)
+			}
+			log.Printf("[Journal] Truncating torn tail of %s at offset %d: %v", seg.path, offset, err)
+			if err := os.Truncate(seg.path, offset); err != nil {
+				return nil, fmt.Errorf("не удалось отрезать хвост сегмента %s: %w", seg.path, err)
+			}
+		}
+		if scanned.lastSeq == 0 {
+			if err := os.Remove(seg.path); err != nil {
+				return nil, fmt.Errorf("не удалось удалить пустой сегмент %s: %w", seg.path, err)
+			}
+			continue
+		}
+		if scanned.lastSeq >= j.nextSeq {
+			j.nextSeq = scanned.lastSeq + 1
+		}
+		j.recovered = append(j.recovered, scanned)
+	}
+	j.sealed = append(j.sealed, j.recovered...)
+
+	if err := j.openSegment(j.nextSeq); err != nil {
+		return nil, err
+	}
+
+	go j.syncer()
+	return j, nil
+}
+
+// Replay вызывает fn для каждой записи, пережившей предыдущий запуск, в порядке seq.
+// Вызывается до первого Append.
+func (j *Journal) Replay(fn func(Record) error) error {
+	for _, seg := range j.recovered {
+		if _, _, err := scanSegment(seg.path, fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("ошибка чтения сегмента %s: %w", seg.path, err)
+		}
+	}
+	return nil
+}
+
+// NextSeq возвращает seq, который получит следующая запись.
+func (j *Journal) NextSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.nextSeq
+}
+
+// Append ставит запись в текущую пачку и сразу возвращается. Дождаться
+// попадания записи на диск можно через Ticket.Wait.
+func (j *Journal) Append(entries ...Entry) (*Ticket, error) {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if j.err != nil {
+		err := j.err
+		j.mu.Unlock()
+		return nil, err
+	}
+
+	rec := Record{Seq: j.nextSeq, Entries: entries}
+	buf, err := encodeRecord(j.pending.buf, rec)
+	if err != nil {
+		j.mu.Unlock()
+		return nil, err
+	}
+	if len(j.pending.buf) == 0 {
+		j.pending.firstSeq = rec.Seq
+	}
+	j.pending.buf = buf
+	j.pending.lastSeq = rec.Seq
+	j.nextSeq++
+	t := &Ticket{Seq: rec.Seq, batch: j.pending}
+	j.mu.Unlock()
+
+	select {
+	case j.notify <- struct{}{}:
+	default:
+	}
+	return t, nil
+}
+
+// Truncate удаляет закрытые сегменты, все записи которых имеют seq меньше watermark.
+func (j *Journal) Truncate(watermark uint64) error {
+	j.segMu.Lock()
+	defer j.segMu.Unlock()
+
+	kept := j.sealed[:0]
+	var firstErr error
+	for _, seg := range j.sealed {
+		if seg.lastSeq >= watermark {
+			kept = append(kept, seg)
+			continue
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("не удалось удалить сегмент %s: %w", seg.path, err)
+			}
+			kept = append(kept, seg)
+		}
+	}
+	j.sealed = kept
+	return firstErr
+}
+
+// Close дописывает накопленные записи, делает fsync и закрывает активный сегмент.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	j.mu.Unlock()
+
+	close(j.stop)
+	<-j.done
+
+	j.segMu.Lock()
+	defer j.segMu.Unlock()
+	if j.active == nil {
+		return nil
+	}
+	err := j.active.Close()
+	j.active = nil
+	return err
+}
+
+func (j *Journal) syncer() {
+	defer close(j.done)
+
+	for {
+		select {
+		case <-j.notify:
+		case <-j.stop:
+			j.syncPending()
+			return
+		}
+
+		if j.cfg.SyncInterval > 0 {
+			select {
+			case <-time.After(j.cfg.SyncInterval):
+			case <-j.stop:
+			}
+		}
+		j.syncPending()
+	}
+}
+
+func (j *Journal) syncPending() {
+	j.mu.Lock()
+	b := j.pending
+	if len(b.buf) == 0 {
+		j.mu.Unlock()
+		return
+	}
+	j.pending = newBatch()
+	j.mu.Unlock()
+
+	b.err = j.write(b)
+	close(b.done)
+
+	if b.err != nil {
+		log.Printf("[Journal] Write failed, journal is now read-only: %v", b.err)
+		j.mu.Lock()
+		if j.err == nil {
+			j.err = b.err
+		}
+		j.mu.Unlock()
+	}
+}
+
+func (j *Journal) write(b *batch) error {
+	j.segMu.Lock()
+	defer j.segMu.Unlock()
+
+	if j.activeSeg.size > 0 && j.activeSeg.size+int64(len(b.buf)) > j.cfg.SegmentSize {
+		if err := j.sealActive(); err != nil {
+			return err
+		}
+		if err := j.openSegment(b.firstSeq); err != nil {
+			return err
+		}
+	}
+
+	if _, err := j.active.Write(b.buf); err != nil {
+		return fmt.Errorf("ошибка записи в журнал: %w", err)
+	}
+	if err := j.active.Sync(); err != nil {
+		return fmt.Errorf("ошибка fsync журнала: %w", err)
+	}
+
+	if j.activeSeg.firstSeq == 0 {
+		j.activeSeg.firstSeq = b.firstSeq
+	}
+	j.activeSeg.size += int64(len(b.buf))
+	j.activeSeg.lastSeq = b.lastSeq
+	return nil
+}
+
+func (j *Journal) sealActive() error {
+	if err := j.active.Close(); err != nil {
+		return fmt.Errorf("ошибка закрытия сегмента журнала: %w", err)
+	}
+	j.sealed = append(j.sealed, j.activeSeg)
+	j.active = nil
+	j.activeSeg = nil
+	return nil
+}
+
+func (j *Journal) openSegment(firstSeq uint64) error {
+	path := filepath.Join(j.cfg.Dir, segmentName(firstSeq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("не удалось создать сегмент журнала: %w", err)
+	}
+	if err := syncDir(j.cfg.Dir); err != nil {
+		f.Close()
+		return fmt.Errorf("ошибка fsync каталога журнала: %w", err)
+	}
+	j.active = f
+	j.activeSeg = &segment{path: path}
+	return nil
+}
@@ -0,0 +1,177 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestJournal(t *testing.T, dir string, segmentSize int64) *Journal {
+	j, err := Open(Config{Dir: dir, SegmentSize: segmentSize})
+	require.NoError(t, err)
+	return j
+}
+
+func replayAll(t *testing.T, j *Journal) []Record {
+	var records []Record
+	require.NoError(t, j.Replay(func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	}))
+	return records
+}
+
+func TestJournal_AppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	walletID := uuid.New()
+
+	j := openTestJournal(t, dir, 1<<20)
+	for i := int64(1); i <= 3; i++ {
+		ticket, err := j.Append(Entry{WalletID: walletID, Balance: i * 100})
+		require.NoError(t, err)
+		require.NoError(t, ticket.Wait())
+		assert.Equal(t, uint64(i), ticket.Seq)
+	}
+	require.NoError(t, j.Close())
+
+	reopened := openTestJournal(t, dir, 1<<20)
+	defer reopened.Close()
+
+	records := replayAll(t, reopened)
+	require.Len(t, records, 3)
+	assert.Equal(t, uint64(3), records[2].Seq)
+	assert.Equal(t, int64(300), records[2].Entries[0].Balance)
+	assert.Equal(t, uint64(4), reopened.NextSeq(), "seq must continue after the replayed records")
+}
+
+func TestJournal_ConcurrentAppends(t *testing.T) {
+	j := openTestJournal(t, t.TempDir(), 1<<20)
+	defer j.Close()
+
+	const writers = 50
+	var wg sync.WaitGroup
+	seqs := make(chan uint64, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticket, err := j.Append(Entry{WalletID: uuid.New(), Balance: 1})
+			if assert.NoError(t, err) && assert.NoError(t, ticket.Wait()) {
+				seqs <- ticket.Seq
+			}
+		}()
+	}
+	wg.Wait()
+	close(seqs)
+
+	seen := make(map[uint64]bool)
+	for seq := range seqs {
+		assert.False(t, seen[seq], "seq %d issued twice", seq)
+		seen[seq] = true
+	}
+	assert.Len(t, seen, writers)
+}
+
+func TestJournal_RotateAndTruncate(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir, 128)
+	defer j.Close()
+
+	walletID := uuid.New()
+	for i := 0; i < 10; i++ {
+		ticket, err := j.Append(Entry{WalletID: walletID, Balance: int64(i)})
+		require.NoError(t, err)
+		require.NoError(t, ticket.Wait())
+	}
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 2, "small segment size should force rotation")
+
+	require.NoError(t, j.Truncate(j.NextSeq()))
+
+	segments, err = listSegments(dir)
+	require.NoError(t, err)
+	assert.Len(t, segments, 1, "only the active segment should survive a full truncate")
+}
+
+func TestJournal_TruncateKeepsUnflushedSegments(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir, 128)
+
+	walletID := uuid.New()
+	for i := 0; i < 10; i++ {
+		ticket, err := j.Append(Entry{WalletID: walletID, Balance: int64(i)})
+		require.NoError(t, err)
+		require.NoError(t, ticket.Wait())
+	}
+	require.NoError(t, j.Truncate(5))
+	require.NoError(t, j.Close())
+
+	reopened := openTestJournal(t, dir, 128)
+	defer reopened.Close()
+
+	records := replayAll(t, reopened)
+	require.NotEmpty(t, records)
+	assert.LessOrEqual(t, records[0].Seq, uint64(5), "record 5 is not flushed yet and must survive")
+	assert.Equal(t, uint64(10), records[len(records)-1].Seq)
+}
+
+func TestJournal_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	walletID := uuid.New()
+
+	j := openTestJournal(t, dir, 1<<20)
+	for i := 0; i < 2; i++ {
+		ticket, err := j.Append(Entry{WalletID: walletID, Balance: int64(i)})
+		require.NoError(t, err)
+		require.NoError(t, ticket.Wait())
+	}
+	require.NoError(t, j.Close())
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	last := segments[len(segments)-1].path
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x20, 0x00, 0x00, 0x00, 0x01})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened := openTestJournal(t, dir, 1<<20)
+	defer reopened.Close()
+
+	records := replayAll(t, reopened)
+	assert.Len(t, records, 2, "torn tail must be dropped, complete records kept")
+	assert.Equal(t, uint64(3), reopened.NextSeq())
+}
+
+func TestJournal_CorruptSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		j := openTestJournal(t, dir, 1<<20)
+		ticket, err := j.Append(Entry{WalletID: uuid.New(), Balance: int64(i)})
+		require.NoError(t, err)
+		require.NoError(t, ticket.Wait())
+		require.NoError(t, j.Close())
+	}
+
+	// Первый сегмент уже не последний, поэтому порча в нём — не оборванный хвост.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, segmentName(1)), []byte("garbage-garbage"), 0o644))
+
+	_, err := Open(Config{Dir: dir, SegmentSize: 1 << 20})
+	assert.Error(t, err, "corruption in the middle of the journal must not be silently ignored")
+}
+
+func TestJournal_AppendAfterClose(t *testing.T) {
+	j := openTestJournal(t, t.TempDir(), 1<<20)
+	require.NoError(t, j.Close())
+
+	_, err := j.Append(Entry{WalletID: uuid.New(), Balance: 1})
+	assert.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,151 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentExt = ".wal"
+	// headerSize — длина полезной нагрузки (uint32) + crc32 полезной нагрузки (uint32).
+	headerSize = 8
+	// maxPayloadSize защищает от гигантских аллокаций при чтении мусора вместо заголовка.
+	maxPayloadSize = 1 << 20
+)
+
+var (
+	errTornRecord    = errors.New("оборванная запись журнала")
+	errCorruptRecord = errors.New("повреждённая запись журнала")
+	crcTable         = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type segment struct {
+	path     string
+	firstSeq uint64
+	lastSeq  uint64
+	size     int64
+}
+
+func segmentName(firstSeq uint64) string {
+	return fmt.Sprintf("%020d%s", firstSeq, segmentExt)
+}
+
+// listSegments возвращает сегменты каталога в порядке возрастания первого seq.
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать каталог журнала: %w", err)
+	}
+
+	var segments []*segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{path: filepath.Join(dir, name), firstSeq: firstSeq})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].firstSeq < segments[j].firstSeq })
+	return segments, nil
+}
+
+func encodeRecord(dst []byte, rec Record) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return dst, fmt.Errorf("ошибка кодирования записи журнала: %w", err)
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	dst = append(dst, header[:]...)
+	return append(dst, payload...), nil
+}
+
+// readRecord читает одну запись. io.EOF возвращается только на чистой границе записи.
+func readRecord(r *bufio.Reader) (Record, int64, error) {
+	var header [headerSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if err != nil {
+		if errors.Is(err, io.EOF) && n == 0 {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, errTornRecord
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size == 0 || size > maxPayloadSize {
+		return Record{}, 0, errCorruptRecord
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Record{}, 0, errTornRecord
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return Record{}, 0, errCorruptRecord
+	}
+
+	var rec Record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return Record{}, 0, errCorruptRecord
+	}
+	return rec, int64(headerSize + size), nil
+}
+
+// scanSegment проходит по всем записям сегмента и возвращает смещение конца
+// последней целой записи. Ошибка errTornRecord/errCorruptRecord означает, что
+// после этого смещения лежит мусор.
+func scanSegment(path string, fn func(Record) error) (int64, *segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("не удалось открыть сегмент %s: %w", path, err)
+	}
+	defer f.Close()
+
+	seg := &segment{path: path}
+	r := bufio.NewReaderSize(f, 64*1024)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			seg.size = offset
+			return offset, seg, nil
+		}
+		if err != nil {
+			seg.size = offset
+			return offset, seg, err
+		}
+		if seg.firstSeq == 0 {
+			seg.firstSeq = rec.Seq
+		}
+		seg.lastSeq = rec.Seq
+		offset += n
+		if fn != nil {
+			if err := fn(rec); err != nil {
+				return offset, seg, err
+			}
+		}
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope — право, которое выдаётся API-ключу или токену.
+type Scope string
+
+const (
+	ScopeWalletsRead  Scope = "wallets:read"
+	ScopeWalletsWrite Scope = "wallets:write"
+	// ScopeAdmin включает все остальные права.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) IsValid() bool {
+	switch s {
+	case ScopeWalletsRead, ScopeWalletsWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// Principal — тот, от чьего имени выполняется запрос.
+type Principal struct {
+	// Subject — id API-ключа или sub токена.
+	Subject string
+	Scopes  []Scope
+	// WalletIDs ограничивает доступ перечисленными кошельками. nil — доступны все.
+	WalletIDs []uuid.UUID
+}
+
+// HasScope сообщает, есть ли у принципала право scope. admin даёт любое право.
+func (p *Principal) HasScope(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// Restricted сообщает, ограничен ли принципал набором кошельков.
+func (p *Principal) Restricted() bool {
+	return p.WalletIDs != nil
+}
+
+// CanAccessWallet сообщает, можно ли принципалу работать с кошельком id.
+func (p *Principal) CanAccessWallet(id uuid.UUID) bool {
+	return !p.Restricted() || slices.Contains(p.WalletIDs, id)
+}
+
+// APIKey — выданный API-ключ. Сам ключ не хранится, только его хэш.
+type APIKey struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// Prefix — начало ключа, по которому его можно узнать в списке.
+	Prefix    string      `json:"prefix"`
+	Scopes    []Scope     `json:"scopes"`
+	WalletIDs []uuid.UUID `json:"walletIds"`
+	CreatedAt time.Time   `json:"createdAt"`
+	RevokedAt *time.Time  `json:"revokedAt,omitempty"`
+}
+
+// Principal возвращает принципала, от имени которого действует ключ.
+func (k *APIKey) Principal() *Principal {
+	return &Principal{Subject: k.ID.String(), Scopes: k.Scopes, WalletIDs: k.WalletIDs}
+}
+
+// CreateAPIKeyRequest — тело POST /api/v1/admin/api-keys. Без walletIds ключ
+// получает доступ ко всем кошелькам.
+type CreateAPIKeyRequest struct {
+	Name      string      `json:"name"`
+	Scopes    []Scope     `json:"scopes"`
+	WalletIDs []uuid.UUID `json:"walletIds"`
+}
+
+// CreatedAPIKey — ответ на создание ключа. Key показывается только один раз.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter — снимок операций, который flush так и не смог записать в БД.
+// Операции одного письма пишутся одной транзакцией, как и исходный снимок.
+type DeadLetter struct {
+	ID         uuid.UUID   `json:"id"`
+	WalletIDs  []uuid.UUID `json:"walletIds"`
+	Operations []Operation `json:"operations"`
+	Attempts   int         `json:"attempts"`
+	LastError  string      `json:"lastError"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
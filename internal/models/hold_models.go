@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxHoldExpiresIn — наибольший срок холда в секундах (30 дней).
+const MaxHoldExpiresIn = 30 * 24 * 60 * 60
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "active"
+	HoldCaptured HoldStatus = "captured"
+	HoldVoided   HoldStatus = "voided"
+	HoldExpired  HoldStatus = "expired"
+)
+
+// Hold — резерв средств на кошельке. Пока холд активен, его Amount входит в
+// Reserved кошелька. Списание (capture) оставляет операцию OperationID на
+// CapturedAmount, остаток резерва освобождается.
+type Hold struct {
+	ID             uuid.UUID  `json:"id"`
+	WalletID       uuid.UUID  `json:"walletId"`
+	Amount         int64      `json:"amount"`
+	CapturedAmount int64      `json:"capturedAmount"`
+	Status         HoldStatus `json:"status"`
+	RequestID      uuid.UUID  `json:"requestId"`
+	OperationID    *uuid.UUID `json:"operationId,omitempty"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// CreateHoldRequest — тело POST /api/v1/wallets/{walletID}/holds.
+type CreateHoldRequest struct {
+	Amount int64 `json:"amount"`
+	// ExpiresIn — срок холда в секундах, по истечении резерв освобождается.
+	ExpiresIn int64     `json:"expiresInSeconds"`
+	RequestID uuid.UUID `json:"requestId"`
+}
+
+// Matches сообщает, что холд создан запросом с теми же параметрами, что req.
+// Повтор RequestID с другими параметрами — другой запрос, а не повтор.
+func (h *Hold) Matches(req CreateHoldRequest) bool {
+	return h.Amount == req.Amount && h.ExpiresAt.Sub(h.CreatedAt) == time.Duration(req.ExpiresIn)*time.Second
+}
+
+// CaptureHoldRequest — тело POST .../holds/{holdID}/capture. Без amount
+// списывается весь холд.
+type CaptureHoldRequest struct {
+	Amount *int64 `json:"amount"`
+}
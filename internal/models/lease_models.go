@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// ShardLease — аренда шарда кошельков экземпляром сервиса.
+type ShardLease struct {
+	Shard     int
+	OwnerID   string
+	OwnerAddr string
+	ExpiresAt time.Time
+}
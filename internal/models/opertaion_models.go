@@ -1,6 +1,14 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"api_wallet/internal/custom_err"
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type OperationType string
 
@@ -23,3 +31,143 @@ type WalletOperationRequest struct {
 	Amount        int64         `json:"amount"`
 	RequestID     uuid.UUID     `json:"requestId"`
 }
+
+// Operation — запись истории изменения баланса. Amount знаковый: списания отрицательные.
+// У проводок перевода заполнены TransferID (общий для обеих) и CounterpartyID.
+type Operation struct {
+	ID             uuid.UUID     `json:"id"`
+	WalletID       uuid.UUID     `json:"walletId"`
+	Type           OperationType `json:"operationType"`
+	Amount         int64         `json:"amount"`
+	RequestID      uuid.UUID     `json:"requestId"`
+	TransferID     *uuid.UUID    `json:"transferId,omitempty"`
+	CounterpartyID *uuid.UUID    `json:"counterpartyWalletId,omitempty"`
+	CreatedAt      time.Time     `json:"createdAt"`
+}
+
+// OperationReceipt — результат изменения баланса. Amount положителен, направление
+// задаёт Type. Version — версия строки кошелька в БД, от которой отсчитан
+// BalanceAfter: записанная в БД операция входит в неё, ещё не записанная попадёт
+// в одну из следующих. Persisted — операция записана в БД или в журнал на диске
+// и переживёт перезапуск. BalanceAfter и Version не заполнены у повтора запроса,
+// исход которого найден в истории операций: баланс после операции там не хранится.
+type OperationReceipt struct {
+	OperationID  uuid.UUID     `json:"operationId"`
+	WalletID     uuid.UUID     `json:"walletId"`
+	Type         OperationType `json:"operationType"`
+	Amount       int64         `json:"amount"`
+	BalanceAfter *int64        `json:"balanceAfter,omitempty"`
+	Version      *int64        `json:"version,omitempty"`
+	AcceptedAt   time.Time     `json:"acceptedAt"`
+	Persisted    bool          `json:"persisted"`
+}
+
+type TransferRequest struct {
+	FromWalletID uuid.UUID `json:"fromWalletId"`
+	ToWalletID   uuid.UUID `json:"toWalletId"`
+	Amount       int64     `json:"amount"`
+	RequestID    uuid.UUID `json:"requestId"`
+}
+
+// Transfer — результат перевода. В истории он представлен двумя операциями:
+// WITHDRAW на кошельке-источнике и DEPOSIT на кошельке-получателе.
+type Transfer struct {
+	ID           uuid.UUID `json:"id"`
+	FromWalletID uuid.UUID `json:"fromWalletId"`
+	ToWalletID   uuid.UUID `json:"toWalletId"`
+	Amount       int64     `json:"amount"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// OperationCursor — позиция в истории операций. История отсортирована по
+// (CreatedAt, ID) по убыванию, курсор указывает на последнюю отданную операцию.
+type OperationCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (c OperationCursor) String() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseOperationCursor(s string) (OperationCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return OperationCursor{}, custom_err.ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return OperationCursor{}, custom_err.ErrInvalidCursor
+	}
+	var c OperationCursor
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return OperationCursor{}, custom_err.ErrInvalidCursor
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return OperationCursor{}, custom_err.ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// After сообщает, что операция идёт в истории после курсора (то есть старше него).
+func (c OperationCursor) After(op Operation) bool {
+	if !op.CreatedAt.Equal(c.CreatedAt) {
+		return op.CreatedAt.Before(c.CreatedAt)
+	}
+	return bytes.Compare(op.ID[:], c.ID[:]) < 0
+}
+
+// OperationFilter — условия выборки истории. Суммы сравниваются по модулю,
+// окно времени — полуинтервал [From, To).
+type OperationFilter struct {
+	Types     []OperationType
+	MinAmount *int64
+	MaxAmount *int64
+	From      *time.Time
+	To        *time.Time
+	Cursor    *OperationCursor
+	Limit     int
+}
+
+// Matches проверяет операцию теми же условиями, что и запрос к БД.
+func (f OperationFilter) Matches(op Operation) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if op.Type == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	amount := op.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	if f.MinAmount != nil && amount < *f.MinAmount {
+		return false
+	}
+	if f.MaxAmount != nil && amount > *f.MaxAmount {
+		return false
+	}
+	if f.From != nil && op.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !op.CreatedAt.Before(*f.To) {
+		return false
+	}
+	if f.Cursor != nil && !f.Cursor.After(op) {
+		return false
+	}
+	return true
+}
+
+// OperationPage — страница истории. NextCursor пуст, если страница последняя.
+type OperationPage struct {
+	Items      []Operation `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
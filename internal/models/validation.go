@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Коды нарушений в полях запроса.
+const (
+	ViolationRequired = "required"
+	// ViolationInvalid — значение не разбирается: неверный тип или формат.
+	ViolationInvalid     = "invalid"
+	ViolationNotAllowed  = "not_allowed"
+	ViolationOutOfRange  = "out_of_range"
+	ViolationNotPositive = "not_positive"
+	// ViolationUnknownField — в теле поле, которого нет в запросе.
+	ViolationUnknownField = "unknown_field"
+	ViolationDuplicate    = "duplicate"
+)
+
+// Position — место в теле запроса: смещение в байтах с нуля, строка и столбец с единицы.
+type Position struct {
+	Offset int64 `json:"offset"`
+	Line   int   `json:"line"`
+	Column int   `json:"column"`
+}
+
+// Violation — нарушение правила в одном поле запроса.
+type Violation struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+	// Position заполняется для нарушений, найденных при разборе тела.
+	Position *Position `json:"position,omitempty"`
+}
+
+// Violations собирает все нарушения запроса, а не только первое.
+type Violations []Violation
+
+func (v *Violations) Add(field, code, detail string) {
+	*v = append(*v, Violation{Field: field, Code: code, Detail: detail})
+}
+
+// Merge добавляет нарушения other в поля, где нарушений ещё нет: поле, которое
+// не разобралось, не должно вдобавок считаться незаполненным.
+func (v *Violations) Merge(other Violations) {
+	for _, o := range other {
+		if !v.Has(o.Field) {
+			*v = append(*v, o)
+		}
+	}
+}
+
+func (v Violations) Has(field string) bool {
+	return slices.ContainsFunc(v, func(x Violation) bool { return x.Field == field })
+}
+
+func (v *Violations) requirePositive(field string, value int64) {
+	if value <= 0 {
+		v.Add(field, ViolationNotPositive, field+" must be positive")
+	}
+}
+
+func (v *Violations) requireOneOf(field string, valid bool, value string, allowed ...string) {
+	switch {
+	case value == "":
+		v.Add(field, ViolationRequired, field+" is required")
+	case !valid:
+		v.Add(field, ViolationNotAllowed, fmt.Sprintf("%s must be one of %v", field, allowed))
+	}
+}
+
+// Validate проверяет запрос изменения баланса. requestId необязателен.
+func (r WalletOperationRequest) Validate() Violations {
+	var v Violations
+	if r.WalletID == uuid.Nil {
+		v.Add("walletId", ViolationRequired, "walletId is required")
+	}
+	v.requireOneOf("operationType", r.OperationType.IsValid(), string(r.OperationType), string(DepositOperation), string(WithdrawOperation))
+	v.requirePositive("amount", r.Amount)
+	return v
+}
+
+// Validate проверяет запрос перевода. Перевод на тот же кошелек отклоняет сервис.
+func (r TransferRequest) Validate() Violations {
+	var v Violations
+	if r.FromWalletID == uuid.Nil {
+		v.Add("fromWalletId", ViolationRequired, "fromWalletId is required")
+	}
+	if r.ToWalletID == uuid.Nil {
+		v.Add("toWalletId", ViolationRequired, "toWalletId is required")
+	}
+	v.requirePositive("amount", r.Amount)
+	return v
+}
+
+// Validate проверяет запрос холда. requestId необязателен.
+func (r CreateHoldRequest) Validate() Violations {
+	var v Violations
+	v.requirePositive("amount", r.Amount)
+	switch {
+	case r.ExpiresIn == 0:
+		v.Add("expiresInSeconds", ViolationRequired, "expiresInSeconds is required")
+	case r.ExpiresIn < 0 || r.ExpiresIn > MaxHoldExpiresIn:
+		v.Add("expiresInSeconds", ViolationOutOfRange, fmt.Sprintf("expiresInSeconds must be between 1 and %d", MaxHoldExpiresIn))
+	}
+	return v
+}
+
+// Validate проверяет запрос списания холда. Сумму больше холда отклоняет сервис.
+func (r CaptureHoldRequest) Validate() Violations {
+	var v Violations
+	if r.Amount != nil {
+		v.requirePositive("amount", *r.Amount)
+	}
+	return v
+}
+
+func (r UpdateWalletStatusRequest) Validate() Violations {
+	var v Violations
+	v.requireOneOf("status", r.Status.IsValid(), string(r.Status), string(WalletActive), string(WalletFrozen), string(WalletClosed))
+	return v
+}
+
+// Validate проверяет запрос ключа. Пустой walletIds означал бы ключ без доступа
+// к кошелькам, а не без ограничений, поэтому он отклоняется.
+func (r CreateAPIKeyRequest) Validate() Violations {
+	var v Violations
+	if r.Name == "" {
+		v.Add("name", ViolationRequired, "name is required")
+	}
+	if len(r.Scopes) == 0 {
+		v.Add("scopes", ViolationRequired, "scopes must not be empty")
+	}
+	for _, scope := range r.Scopes {
+		if !scope.IsValid() {
+			v.Add("scopes", ViolationNotAllowed, fmt.Sprintf("unknown scope %q", scope))
+			break
+		}
+	}
+	if r.WalletIDs != nil && len(r.WalletIDs) == 0 {
+		v.Add("walletIds", ViolationRequired, "walletIds must not be empty, omit it to allow all wallets")
+	}
+	for _, id := range r.WalletIDs {
+		if id == uuid.Nil {
+			v.Add("walletIds", ViolationInvalid, "walletIds must not contain a nil UUID")
+			break
+		}
+	}
+	return v
+}
@@ -6,9 +6,65 @@ import (
 	"github.com/google/uuid"
 )
 
+type WalletStatus string
+
+const (
+	WalletActive WalletStatus = "active"
+	WalletFrozen WalletStatus = "frozen"
+	WalletClosed WalletStatus = "closed"
+)
+
+func (ws WalletStatus) IsValid() bool {
+	switch ws {
+	case WalletActive, WalletFrozen, WalletClosed:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo описывает жизненный цикл кошелька: active и frozen переходят
+// друг в друга и в closed, closed — конечный статус.
+func (ws WalletStatus) CanTransitionTo(next WalletStatus) bool {
+	if ws == next {
+		return true
+	}
+	switch ws {
+	case WalletActive:
+		return next == WalletFrozen || next == WalletClosed
+	case WalletFrozen:
+		return next == WalletActive || next == WalletClosed
+	}
+	return false
+}
+
+// Wallet — кошелек. Balance — весь баланс, включая Reserved — сумму активных
+// холдов; тратить можно только Available = Balance - Reserved.
 type Wallet struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	Balance   int64     `json:"balance" db:"balance"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID        uuid.UUID    `json:"id" db:"id"`
+	Balance   int64        `json:"balance" db:"balance"`
+	Reserved  int64        `json:"reserved" db:"reserved"`
+	Available int64        `json:"available" db:"-"`
+	Status    WalletStatus `json:"status,omitempty" db:"status"`
+	Version   int64        `json:"-" db:"version"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// WalletChange — состояние строки кошелька после изменения в БД. Его же
+// рассылает уведомление wallet_changes, поэтому теги совпадают с полями payload.
+type WalletChange struct {
+	ID       uuid.UUID    `json:"id"`
+	Balance  int64        `json:"balance"`
+	Reserved int64        `json:"reserved"`
+	Version  int64        `json:"version"`
+	Status   WalletStatus `json:"status"`
+}
+
+// CreateWalletRequest — тело POST /api/v1/wallets. Если id не передан, его генерирует сервер.
+type CreateWalletRequest struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type UpdateWalletStatusRequest struct {
+	Status WalletStatus `json:"status"`
 }
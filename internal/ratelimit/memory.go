@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const (
+	memoryShards = 64
+	// sweepInterval — как часто шард удаляет полные вёдра: они ничем не
+	// отличаются от новых.
+	sweepInterval = time.Minute
+)
+
+// MemoryStore хранит вёдра в памяти процесса, поэтому каждый экземпляр
+// считает свои запросы сам.
+type MemoryStore struct {
+	seed   maphash.Seed
+	shards [memoryShards]memoryShard
+	now    func() time.Time
+}
+
+type memoryShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{seed: maphash.MakeSeed(), now: time.Now}
+	for i := range s.shards {
+		s.shards[i].buckets = make(map[string]*bucket)
+	}
+	return s
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	shard := &s.shards[maphash.String(s.seed, key)%memoryShards]
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if now.Sub(shard.lastSweep) >= sweepInterval {
+		shard.sweep(now)
+	}
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = newBucket(limit, now)
+		shard.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+func (sh *memoryShard) sweep(now time.Time) {
+	for key, b := range sh.buckets {
+		if !now.Before(b.full) {
+			delete(sh.buckets, key)
+		}
+	}
+	sh.lastSweep = now
+}
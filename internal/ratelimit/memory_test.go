@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock — часы, которые двигает тест.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func buckets(s *MemoryStore) int {
+	n := 0
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		n += len(s.shards[i].buckets)
+		s.shards[i].mu.Unlock()
+	}
+	return n
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Burst: 3}
+
+	t.Run("Burst Then Reject", func(t *testing.T) {
+		store, _ := newTestStore()
+		for i := range 3 {
+			res, err := store.Take(ctx, "k", limit)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 3, res.Limit)
+			assert.Equal(t, 2-i, res.Remaining)
+		}
+
+		res, err := store.Take(ctx, "k", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+		assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+		assert.Equal(t, 300*time.Millisecond, res.Reset)
+	})
+
+	t.Run("Tokens Refill Over Time", func(t *testing.T) {
+		store, clock := newTestStore()
+		for range 3 {
+			_, err := store.Take(ctx, "k", limit)
+			require.NoError(t, err)
+		}
+
+		clock.Advance(150 * time.Millisecond)
+		res, err := store.Take(ctx, "k", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed, "one token must be refilled after 100ms")
+		res, err = store.Take(ctx, "k", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 50*time.Millisecond, res.RetryAfter)
+
+		// Ведро не наполняется больше Burst.
+		clock.Advance(time.Hour)
+		res, err = store.Take(ctx, "k", limit)
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.Remaining)
+	})
+
+	t.Run("Keys Are Independent", func(t *testing.T) {
+		store, _ := newTestStore()
+		for range 3 {
+			_, err := store.Take(ctx, "a", limit)
+			require.NoError(t, err)
+		}
+		res, err := store.Take(ctx, "b", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	})
+
+	t.Run("Full Buckets Are Swept", func(t *testing.T) {
+		store, clock := newTestStore()
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := store.Take(ctx, key, limit)
+			require.NoError(t, err)
+		}
+		// Меньше sweepInterval: шарды не чистятся сами, пока тест их считает.
+		clock.Advance(time.Second)
+		// Ведро, из которого только что взяли токен, ещё не полное и остаётся.
+		_, err := store.Take(ctx, "d", limit)
+		require.NoError(t, err)
+		require.Equal(t, 4, buckets(store))
+
+		for i := range store.shards {
+			store.shards[i].mu.Lock()
+			store.shards[i].sweep(clock.Now())
+			store.shards[i].mu.Unlock()
+		}
+		assert.Equal(t, 1, buckets(store))
+	})
+
+	t.Run("Disabled Limit", func(t *testing.T) {
+		assert.False(t, Limit{}.Enabled())
+		assert.False(t, Limit{Rate: 10}.Enabled())
+		assert.True(t, limit.Enabled())
+		assert.Equal(t, 300*time.Millisecond, limit.Window())
+	})
+}
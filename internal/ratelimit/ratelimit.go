@@ -0,0 +1,80 @@
+// Package ratelimit ограничивает частоту запросов алгоритмом token bucket.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit — ведро на Burst токенов, которое пополняется со скоростью Rate токенов
+// в секунду. Каждый запрос забирает один токен.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled сообщает, ограничивает ли что-то Limit. Нулевой Limit пропускает все запросы.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Window — за сколько пустое ведро наполняется целиком.
+func (l Limit) Window() time.Duration {
+	return seconds(float64(l.Burst) / l.Rate)
+}
+
+// Result — состояние ведра после попытки забрать токен.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset — через сколько ведро наполнится целиком.
+	Reset time.Duration
+	// RetryAfter — через сколько появится токен для отклонённого запроса.
+	RetryAfter time.Duration
+}
+
+// Store хранит вёдра по ключам. MemoryStore держит их в памяти процесса;
+// хранилище, общее для экземпляров, реализует тот же интерфейс.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket — ведро token bucket. Токены пересчитываются при обращении.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full — когда ведро наполнится, если к нему больше не обращаться.
+	full time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	return &bucket{tokens: float64(limit.Burst), updated: now, full: now}
+}
+
+// take пополняет ведро за прошедшее время и забирает токен, если он есть.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	burst := float64(limit.Burst)
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed*limit.Rate)
+		b.updated = now
+	}
+	// Лимит могли уменьшить, пока ведро было полным.
+	b.tokens = min(burst, b.tokens)
+
+	res := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / limit.Rate)
+	b.full = now.Add(res.Reset)
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type APIKeys interface {
+	// Create сохраняет ключ с хэшем hash. Если ключ с таким хэшем уже есть,
+	// возвращает custom_err.ErrAlreadyExists.
+	Create(ctx context.Context, key *models.APIKey, hash []byte) error
+	// GetByHash возвращает действующий ключ или custom_err.ErrNotFound.
+	GetByHash(ctx context.Context, hash []byte) (*models.APIKey, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+	// Revoke отзывает ключ. Отзыв отозванного ключа ничего не меняет.
+	Revoke(ctx context.Context, id uuid.UUID) error
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"api_wallet/internal/models"
+)
+
+type Leases interface {
+	// Acquire берёт свободные или просроченные шарды и продлевает свои.
+	// Возвращает шарды, которые теперь принадлежат ownerID.
+	Acquire(ctx context.Context, ownerID, ownerAddr string, shards []int, ttl time.Duration) ([]int, error)
+	// Renew продлевает все аренды ownerID и возвращает продлённые шарды.
+	Renew(ctx context.Context, ownerID, ownerAddr string, ttl time.Duration) ([]int, error)
+	Release(ctx context.Context, ownerID string, shards []int) error
+	// List возвращает действующие аренды.
+	List(ctx context.Context) ([]models.ShardLease, error)
+	// Heartbeat отмечает ownerID живым на ttl и возвращает все живые экземпляры.
+	Heartbeat(ctx context.Context, ownerID, ownerAddr string, ttl time.Duration) ([]string, error)
+	// Leave снимает отметку ownerID до истечения срока.
+	Leave(ctx context.Context, ownerID string) error
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(db *pgxpool.Pool) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *models.APIKey, hash []byte) error {
+	const op = "repository.CreateAPIKey"
+	scopes := make([]string, len(key.Scopes))
+	for i, s := range key.Scopes {
+		scopes[i] = string(s)
+	}
+	err := r.db.QueryRow(ctx, repository.CreateAPIKeyQuery,
+		key.ID, key.Name, key.Prefix, hash, scopes, key.WalletIDs,
+	).Scan(&key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return custom_err.ErrAlreadyExists
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) GetByHash(ctx context.Context, hash []byte) (*models.APIKey, error) {
+	const op = "repository.GetAPIKeyByHash"
+	key, err := scanAPIKey(r.db.QueryRow(ctx, repository.GetAPIKeyByHashQuery, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, custom_err.ErrNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	const op = "repository.ListAPIKeys"
+	rows, err := r.db.Query(ctx, repository.ListAPIKeysQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.APIKey, error) {
+		return scanAPIKey(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return keys, nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	const op = "repository.RevokeAPIKey"
+	tag, err := r.db.Exec(ctx, repository.RevokeAPIKeyQuery, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return custom_err.ErrNotFound
+	}
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes []string
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.WalletIDs, &key.CreatedAt, &key.RevokedAt); err != nil {
+		return key, err
+	}
+	key.Scopes = make([]models.Scope, len(scopes))
+	for i, s := range scopes {
+		key.Scopes[i] = models.Scope(s)
+	}
+	return key, nil
+}
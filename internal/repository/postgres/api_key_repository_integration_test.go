@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, "TRUNCATE TABLE api_keys")
+	require.NoError(t, err)
+
+	repo := NewAPIKeyRepository(pool)
+
+	walletID := uuid.New()
+	restricted := &models.APIKey{
+		ID:        uuid.New(),
+		Name:      "shop",
+		Prefix:    "wk_abcd",
+		Scopes:    []models.Scope{models.ScopeWalletsRead, models.ScopeWalletsWrite},
+		WalletIDs: []uuid.UUID{walletID},
+	}
+	restrictedHash := sha256.Sum256([]byte("restricted"))
+	require.NoError(t, repo.Create(ctx, restricted, restrictedHash[:]))
+	assert.False(t, restricted.CreatedAt.IsZero())
+
+	admin := &models.APIKey{ID: uuid.New(), Name: "admin", Prefix: "wk_efgh", Scopes: []models.Scope{models.ScopeAdmin}}
+	adminHash := sha256.Sum256([]byte("admin"))
+	require.NoError(t, repo.Create(ctx, admin, adminHash[:]))
+
+	// Повторное создание с тем же ключом не заменяет существующий.
+	err = repo.Create(ctx, &models.APIKey{ID: uuid.New(), Name: "copy", Scopes: []models.Scope{models.ScopeAdmin}}, adminHash[:])
+	assert.ErrorIs(t, err, custom_err.ErrAlreadyExists)
+
+	got, err := repo.GetByHash(ctx, restrictedHash[:])
+	require.NoError(t, err)
+	assert.Equal(t, restricted.ID, got.ID)
+	assert.Equal(t, restricted.Scopes, got.Scopes)
+	assert.Equal(t, []uuid.UUID{walletID}, got.WalletIDs)
+
+	got, err = repo.GetByHash(ctx, adminHash[:])
+	require.NoError(t, err)
+	assert.Nil(t, got.WalletIDs, "key without wallet list must stay unrestricted")
+
+	require.NoError(t, repo.Revoke(ctx, restricted.ID))
+	_, err = repo.GetByHash(ctx, restrictedHash[:])
+	assert.ErrorIs(t, err, custom_err.ErrNotFound)
+	assert.ErrorIs(t, repo.Revoke(ctx, uuid.New()), custom_err.ErrNotFound)
+
+	keys, err := repo.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, restricted.ID, keys[0].ID)
+	assert.NotNil(t, keys[0].RevokedAt)
+	assert.Nil(t, keys[1].RevokedAt)
+}
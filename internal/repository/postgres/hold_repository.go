@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (r *WalletRepository) CreateHoldTx(ctx context.Context, tx pgx.Tx, hold models.Hold) error {
+	_, err := tx.Exec(ctx, repository.CreateHoldQuery,
+		hold.ID, hold.WalletID, hold.Amount, string(hold.Status), nullUUID(hold.RequestID), hold.ExpiresAt, hold.CreatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return custom_err.ErrDuplicateRequest
+		}
+		return fmt.Errorf("ошибка сохранения холда: %w", err)
+	}
+	return nil
+}
+
+func (r *WalletRepository) GetHoldForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.Hold, error) {
+	hold, err := scanHold(tx.QueryRow(ctx, repository.GetHoldForUpdateQuery, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, custom_err.ErrNotFound
+		}
+		return nil, fmt.Errorf("ошибка чтения холда: %w", err)
+	}
+	return hold, nil
+}
+
+func (r *WalletRepository) UpdateHoldTx(ctx context.Context, tx pgx.Tx, hold models.Hold) error {
+	cmdTag, err := tx.Exec(ctx, repository.UpdateHoldQuery,
+		hold.ID, string(hold.Status), hold.CapturedAmount, hold.OperationID, hold.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления холда: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return custom_err.ErrNotFound
+	}
+	return nil
+}
+
+func (r *WalletRepository) GetHoldByRequestID(ctx context.Context, walletID, requestID uuid.UUID) (*models.Hold, error) {
+	const op = "repository.GetHoldByRequestID"
+	hold, err := scanHold(r.db.QueryRow(ctx, repository.GetHoldByRequestIDQuery, walletID, requestID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, custom_err.ErrNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return hold, nil
+}
+
+func (r *WalletRepository) ListExpiredHolds(ctx context.Context, now time.Time, limit int) ([]models.Hold, error) {
+	const op = "repository.ListExpiredHolds"
+	rows, err := r.db.Query(ctx, repository.ListExpiredHoldsQuery, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var holds []models.Hold
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		holds = append(holds, *hold)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return holds, nil
+}
+
+// nullUUID переводит необязательный UUID в значение колонки: uuid.Nil — NULL.
+func nullUUID(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
+
+func scanHold(row pgx.Row) (*models.Hold, error) {
+	var hold models.Hold
+	var status string
+	var requestID *uuid.UUID
+	if err := row.Scan(
+		&hold.ID, &hold.WalletID, &hold.Amount, &hold.CapturedAmount, &status, &requestID,
+		&hold.OperationID, &hold.ExpiresAt, &hold.CreatedAt, &hold.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	hold.Status = models.HoldStatus(status)
+	if requestID != nil {
+		hold.RequestID = *requestID
+	}
+	hold.ExpiresAt = hold.ExpiresAt.UTC()
+	hold.CreatedAt = hold.CreatedAt.UTC()
+	hold.UpdatedAt = hold.UpdatedAt.UTC()
+	return &hold, nil
+}
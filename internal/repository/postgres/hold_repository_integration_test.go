@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletRepository_Holds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance, version) VALUES ($1, 100, 1)", walletID)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	hold := models.Hold{
+		ID:        uuid.New(),
+		WalletID:  walletID,
+		Amount:    70,
+		Status:    models.HoldActive,
+		RequestID: uuid.New(),
+		ExpiresAt: now.Add(time.Minute),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	t.Run("Create and reserve", func(t *testing.T) {
+		tx, err := pool.Begin(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback(ctx)
+
+		require.NoError(t, repo.CreateHoldTx(ctx, tx, hold))
+		require.NoError(t, repo.UpdateFundsWithOptimisticLockTx(ctx, tx, walletID, 100, 70, 1))
+		assert.ErrorIs(t, repo.UpdateFundsWithOptimisticLockTx(ctx, tx, walletID, 100, 70, 1), custom_err.ErrConflict)
+
+		state, err := repo.GetWalletStateTx(ctx, tx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, models.WalletChange{ID: walletID, Balance: 100, Reserved: 70, Version: 2, Status: models.WalletActive}, state)
+		require.NoError(t, tx.Commit(ctx))
+
+		wallet, err := repo.GetByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(70), wallet.Reserved)
+		assert.Equal(t, int64(30), wallet.Available)
+	})
+
+	t.Run("Request ID is unique per wallet", func(t *testing.T) {
+		found, err := repo.GetHoldByRequestID(ctx, walletID, hold.RequestID)
+		require.NoError(t, err)
+		assert.Equal(t, hold, *found)
+
+		tx, err := pool.Begin(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback(ctx)
+
+		duplicate := hold
+		duplicate.ID = uuid.New()
+		assert.ErrorIs(t, repo.CreateHoldTx(ctx, tx, duplicate), custom_err.ErrDuplicateRequest)
+	})
+
+	t.Run("List expired", func(t *testing.T) {
+		expired, err := repo.ListExpiredHolds(ctx, now, 10)
+		require.NoError(t, err)
+		assert.Empty(t, expired)
+
+		expired, err = repo.ListExpiredHolds(ctx, now.Add(time.Minute), 10)
+		require.NoError(t, err)
+		require.Len(t, expired, 1)
+		assert.Equal(t, hold.ID, expired[0].ID)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		tx, err := pool.Begin(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback(ctx)
+
+		locked, err := repo.GetHoldForUpdateTx(ctx, tx, hold.ID)
+		require.NoError(t, err)
+		locked.Status = models.HoldVoided
+		locked.UpdatedAt = now.Add(time.Second)
+		require.NoError(t, repo.UpdateHoldTx(ctx, tx, *locked))
+		require.NoError(t, tx.Commit(ctx))
+
+		expired, err := repo.ListExpiredHolds(ctx, now.Add(time.Minute), 10)
+		require.NoError(t, err)
+		assert.Empty(t, expired, "only active holds expire")
+
+		tx, err = pool.Begin(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback(ctx)
+		_, err = repo.GetHoldForUpdateTx(ctx, tx, uuid.New())
+		assert.ErrorIs(t, err, custom_err.ErrNotFound)
+	})
+}
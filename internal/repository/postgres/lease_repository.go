@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type LeaseRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLeaseRepository(db *pgxpool.Pool) *LeaseRepository {
+	return &LeaseRepository{db: db}
+}
+
+func (r *LeaseRepository) Acquire(ctx context.Context, ownerID, ownerAddr string, shards []int, ttl time.Duration) ([]int, error) {
+	const op = "repository.AcquireLeases"
+	if len(shards) == 0 {
+		return nil, nil
+	}
+	rows, err := r.db.Query(ctx, repository.AcquireShardLeasesQuery, ownerID, ownerAddr, ttl.Milliseconds(), shards)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	acquired, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return acquired, nil
+}
+
+func (r *LeaseRepository) Renew(ctx context.Context, ownerID, ownerAddr string, ttl time.Duration) ([]int, error) {
+	const op = "repository.RenewLeases"
+	rows, err := r.db.Query(ctx, repository.RenewShardLeasesQuery, ownerID, ownerAddr, ttl.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	renewed, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return renewed, nil
+}
+
+func (r *LeaseRepository) Release(ctx context.Context, ownerID string, shards []int) error {
+	const op = "repository.ReleaseLeases"
+	if len(shards) == 0 {
+		return nil
+	}
+	if _, err := r.db.Exec(ctx, repository.ReleaseShardLeasesQuery, ownerID, shards); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (r *LeaseRepository) List(ctx context.Context) ([]models.ShardLease, error) {
+	const op = "repository.ListLeases"
+	rows, err := r.db.Query(ctx, repository.ListShardLeasesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	leases, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ShardLease, error) {
+		var lease models.ShardLease
+		err := row.Scan(&lease.Shard, &lease.OwnerID, &lease.OwnerAddr, &lease.ExpiresAt)
+		return lease, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return leases, nil
+}
+
+func (r *LeaseRepository) Heartbeat(ctx context.Context, ownerID, ownerAddr string, ttl time.Duration) ([]string, error) {
+	const op = "repository.Heartbeat"
+	if _, err := r.db.Exec(ctx, repository.HeartbeatMemberQuery, ownerID, ownerAddr, ttl.Milliseconds()); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	rows, err := r.db.Query(ctx, repository.ListMembersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	members, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return members, nil
+}
+
+func (r *LeaseRepository) Leave(ctx context.Context, ownerID string) error {
+	const op = "repository.Leave"
+	if _, err := r.db.Exec(ctx, repository.DeleteMemberQuery, ownerID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, "TRUNCATE TABLE shard_leases, cluster_members")
+	require.NoError(t, err)
+
+	repo := NewLeaseRepository(pool)
+
+	acquired, err := repo.Acquire(ctx, "a", "http://a", []int{0, 1, 2}, time.Minute)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{0, 1, 2}, acquired)
+
+	// Действующие чужие аренды не перехватываются.
+	acquired, err = repo.Acquire(ctx, "b", "http://b", []int{1, 2, 3}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, acquired)
+
+	renewed, err := repo.Renew(ctx, "a", "http://a", time.Minute)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{0, 1, 2}, renewed)
+
+	// Просроченная аренда достаётся первому, кто её запросит.
+	_, err = pool.Exec(ctx, "UPDATE shard_leases SET expires_at = NOW() - interval '1 second' WHERE shard = 2")
+	require.NoError(t, err)
+	acquired, err = repo.Acquire(ctx, "b", "http://b", []int{2}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, acquired)
+
+	require.NoError(t, repo.Release(ctx, "a", []int{0, 2}))
+	leases, err := repo.List(ctx)
+	require.NoError(t, err)
+	owners := make(map[int]string, len(leases))
+	for _, lease := range leases {
+		owners[lease.Shard] = lease.OwnerID
+	}
+	assert.Equal(t, map[int]string{1: "a", 2: "b", 3: "b"}, owners, "release must not drop leases of other owners")
+
+	members, err := repo.Heartbeat(ctx, "a", "http://a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, members)
+	members, err = repo.Heartbeat(ctx, "b", "http://b", time.Minute)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, members)
+
+	require.NoError(t, repo.Leave(ctx, "a"))
+	members, err = repo.Heartbeat(ctx, "b", "http://b", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, members)
+}
@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (r *WalletRepository) GetOperationByRequestID(ctx context.Context, walletID, requestID uuid.UUID) (*models.Operation, error) {
+	const op = "repository.GetOperationByRequestID"
+
+	operation, err := scanOperation(r.db.QueryRow(ctx, repository.GetOperationByRequestIDQuery, walletID, requestID.String()))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, custom_err.ErrNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return operation, nil
+}
+
+// ListOperations возвращает до filter.Limit операций кошелька, новые первыми.
+func (r *WalletRepository) ListOperations(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) ([]models.Operation, error) {
+	const op = "repository.ListOperations"
+
+	query, args := buildListOperationsQuery(walletID, filter)
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	operations := make([]models.Operation, 0, filter.Limit)
+	for rows.Next() {
+		operation, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		operations = append(operations, *operation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return operations, nil
+}
+
+func buildListOperationsQuery(walletID uuid.UUID, filter models.OperationFilter) (string, []any) {
+	var sb strings.Builder
+	sb.WriteString(repository.ListOperationsQuery)
+	args := []any{walletID}
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Types) > 0 {
+		types := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			types[i] = string(t)
+		}
+		sb.WriteString(" AND operation_type = ANY(" + arg(types) + ")")
+	}
+	if filter.MinAmount != nil {
+		sb.WriteString(" AND abs(amount) >= " + arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		sb.WriteString(" AND abs(amount) <= " + arg(*filter.MaxAmount))
+	}
+	if filter.From != nil {
+		sb.WriteString(" AND created_at >= " + arg(*filter.From))
+	}
+	if filter.To != nil {
+		sb.WriteString(" AND created_at < " + arg(*filter.To))
+	}
+	if filter.Cursor != nil {
+		sb.WriteString(" AND (created_at, id) < (" + arg(filter.Cursor.CreatedAt) + ", " + arg(filter.Cursor.ID) + ")")
+	}
+	sb.WriteString(" ORDER BY created_at DESC, id DESC LIMIT " + arg(filter.Limit))
+	return sb.String(), args
+}
+
+// requestIDValue переводит requestId в значение колонки request_id (TEXT, NULL — без requestId).
+func requestIDValue(id uuid.UUID) any {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id.String()
+}
+
+func scanOperation(row pgx.Row) (*models.Operation, error) {
+	var operation models.Operation
+	var opType string
+	var requestID *string
+	if err := row.Scan(
+		&operation.ID, &operation.WalletID, &operation.Amount, &opType, &requestID,
+		&operation.TransferID, &operation.CounterpartyID, &operation.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	operation.Type = models.OperationType(opType)
+	if requestID != nil {
+		// request_id исторически TEXT, поэтому не-UUID значения просто игнорируем.
+		if parsed, err := uuid.Parse(*requestID); err == nil {
+			operation.RequestID = parsed
+		}
+	}
+	operation.CreatedAt = operation.CreatedAt.UTC()
+	return &operation, nil
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletRepository_GetOperationByRequestID(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance) VALUES ($1, 0)", walletID)
+	require.NoError(t, err)
+
+	operation := models.Operation{
+		ID:        uuid.New(),
+		WalletID:  walletID,
+		Type:      models.DepositOperation,
+		Amount:    250,
+		RequestID: uuid.New(),
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	_, err = repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, []models.Operation{operation}, nil)
+	require.NoError(t, err)
+
+	found, err := repo.GetOperationByRequestID(ctx, walletID, operation.RequestID)
+	require.NoError(t, err)
+	assert.Equal(t, operation, *found)
+
+	_, err = repo.GetOperationByRequestID(ctx, uuid.New(), operation.RequestID)
+	assert.ErrorIs(t, err, custom_err.ErrNotFound, "request IDs are scoped to a wallet")
+}
+
+func TestWalletRepository_ListOperations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance) VALUES ($1, 0)", walletID)
+	require.NoError(t, err)
+
+	base := time.Now().UTC().Truncate(time.Microsecond).Add(-time.Hour)
+	var ops []models.Operation
+	for i := 0; i < 6; i++ {
+		opType, amount := models.DepositOperation, int64(10*(i+1))
+		if i%2 == 1 {
+			opType, amount = models.WithdrawOperation, -amount
+		}
+		ops = append(ops, models.Operation{
+			ID: uuid.New(), WalletID: walletID, Type: opType, Amount: amount,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	_, err = repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, ops, nil)
+	require.NoError(t, err)
+
+	t.Run("Newest first with cursor", func(t *testing.T) {
+		first, err := repo.ListOperations(ctx, walletID, models.OperationFilter{Limit: 4})
+		require.NoError(t, err)
+		require.Len(t, first, 4)
+		assert.Equal(t, ops[5].ID, first[0].ID)
+
+		last := first[3]
+		cursor := models.OperationCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		second, err := repo.ListOperations(ctx, walletID, models.OperationFilter{Limit: 4, Cursor: &cursor})
+		require.NoError(t, err)
+		require.Len(t, second, 2)
+		assert.Equal(t, ops[1].ID, second[0].ID)
+		assert.Equal(t, ops[0].ID, second[1].ID)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		minAmount, maxAmount := int64(20), int64(50)
+		from, to := base.Add(time.Minute), base.Add(5*time.Minute)
+		found, err := repo.ListOperations(ctx, walletID, models.OperationFilter{
+			Types:     []models.OperationType{models.WithdrawOperation},
+			MinAmount: &minAmount,
+			MaxAmount: &maxAmount,
+			From:      &from,
+			To:        &to,
+			Limit:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, found, 2)
+		assert.Equal(t, ops[3].ID, found[0].ID)
+		assert.Equal(t, ops[1].ID, found[1].ID)
+	})
+}
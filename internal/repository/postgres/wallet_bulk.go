@@ -1,6 +1,9 @@
 package postgres
 
 import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
 	"context"
 	"fmt"
 	"log"
@@ -8,13 +11,36 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (r *WalletRepository) BulkUpdateBalances(ctx context.Context, wallets map[uuid.UUID]int64) error {
-	if len(wallets) == 0 {
-		return nil
+var tracer = otel.Tracer("api_wallet/internal/repository/postgres")
+
+// BulkApplyOperations в одной транзакции записывает операции и прибавляет к
+// балансам кошельков суммы тех из них, которых ещё не было в БД. Повторная
+// запись уже записанной операции (по id) баланс не меняет, поэтому повтор
+// после сбоя безопасен, а изменения других экземпляров сервиса не затираются.
+// Строки, версия которых разошлась с versions, не пишутся: транзакция
+// откатывается с *custom_err.ConflictError.
+// Возвращает баланс, версию и статус кошельков walletIDs после записи.
+func (r *WalletRepository) BulkApplyOperations(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) (_ []models.WalletChange, err error) {
+	if len(walletIDs) == 0 {
+		return nil, nil
 	}
 
+	ctx, span := tracer.Start(ctx, "WalletRepository.BulkApplyOperations", trace.WithAttributes(
+		attribute.Int("db.wallets", len(walletIDs)), attribute.Int("db.operations", len(ops))))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	stats := r.db.Stat()
 	log.Printf("[BulkUpdate] Pool stats: Acquired=%d Idle=%d Total=%d Max=%d",
 		stats.AcquiredConns(), stats.IdleConns(), stats.TotalConns(), stats.MaxConns())
@@ -23,66 +49,170 @@ func (r *WalletRepository) BulkUpdateBalances(ctx context.Context, wallets map[u
 
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, `
-        CREATE TEMP TABLE wallet_updates_tmp (
-            id UUID PRIMARY KEY,
-            balance BIGINT NOT NULL
-        ) ON COMMIT DROP
-    `)
+	if err := checkVersions(ctx, tx, walletIDs, versions); err != nil {
+		return nil, err
+	}
+
+	recorded, err := copyOperations(ctx, tx, ops)
 	if err != nil {
-		return fmt.Errorf("ошибка создания TEMP таблицы: %w", err)
+		return nil, err
 	}
 
-	rows := make([][]any, 0, len(wallets))
-	for id, balance := range wallets {
-		rows = append(rows, []any{id, balance})
+	var updatedCount int64
+	if len(recorded) > 0 {
+		ids := make([]uuid.UUID, 0, len(recorded))
+		deltas := make([]int64, 0, len(recorded))
+		expected := make([]int64, 0, len(recorded))
+		for id, delta := range recorded {
+			ids = append(ids, id)
+			deltas = append(deltas, delta)
+			expected = append(expected, versions[id])
+		}
+		cmdTag, err := tx.Exec(ctx, `
+            UPDATE wallets w
+            SET balance = w.balance + d.delta,
+                version = w.version + 1,
+                updated_at = NOW()
+            FROM unnest($1::uuid[], $2::bigint[], $3::bigint[]) AS d(id, delta, expected_version)
+            WHERE w.id = d.id
+              AND (d.expected_version = 0 OR w.version = d.expected_version)
+        `, ids, deltas, expected)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка обновления балансов: %w", err)
+		}
+		updatedCount = cmdTag.RowsAffected()
+		// Строки заблокированы и версии сверены, так что расхождение здесь значит,
+		// что строку изменили в обход блокировки.
+		if updatedCount != int64(len(ids)) {
+			return nil, &custom_err.ConflictError{WalletIDs: ids}
+		}
 	}
 
-	_, err = tx.CopyFrom(ctx,
-		pgx.Identifier{"wallet_updates_tmp"},
-		[]string{"id", "balance"},
-		pgx.CopyFromRows(rows),
-	)
+	rows, err := tx.Query(ctx, repository.GetWalletStatesQuery, walletIDs)
 	if err != nil {
-		return fmt.Errorf("ошибка COPY в TEMP таблицу: %w", err)
+		return nil, fmt.Errorf("ошибка чтения балансов: %w", err)
+	}
+	changes, err := scanWalletChanges(rows)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения балансов: %w", err)
+	}
+	if len(changes) != len(walletIDs) {
+		// Кошельки создаются только через API, поэтому отсутствующая строка означает,
+		// что её удалили в обход сервиса. Такие кошельки не воскрешаем.
+		log.Printf("[BulkUpdate] %d of %d wallets are missing in the database and were skipped",
+			len(walletIDs)-len(changes), len(walletIDs))
 	}
 
-	cmdTag, err := tx.Exec(ctx, `
-        UPDATE wallets w
-        SET balance = u.balance,
-            updated_at = NOW()
-        FROM wallet_updates_tmp u
-        WHERE w.id = u.id
-    `)
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("ошибка коммита транзакции: %w", err)
+	}
+
+	duration := time.Since(startTime)
+	log.Printf("[BulkUpdate] Success: %d wallets (updated=%d), %d operations in %v",
+		len(walletIDs), updatedCount, len(ops), duration)
+
+	return changes, nil
+}
+
+// checkVersions блокирует строки кошельков и сверяет их версии с versions.
+// Кошельки без ожидаемой версии и отсутствующие в БД не проверяются.
+func checkVersions(ctx context.Context, tx pgx.Tx, walletIDs []uuid.UUID, versions map[uuid.UUID]int64) error {
+	rows, err := tx.Query(ctx, repository.LockWalletsQuery, walletIDs)
 	if err != nil {
-		return fmt.Errorf("ошибка UPDATE из TEMP таблицы: %w", err)
+		return fmt.Errorf("ошибка блокировки кошельков: %w", err)
 	}
-	updatedCount := cmdTag.RowsAffected()
+	defer rows.Close()
 
-	cmdTag, err = tx.Exec(ctx, `
-        INSERT INTO wallets (id, balance, created_at, updated_at)
-        SELECT u.id, u.balance, NOW(), NOW()
-        FROM wallet_updates_tmp u
-        WHERE NOT EXISTS (
-            SELECT 1 FROM wallets w WHERE w.id = u.id
-        )
+	var conflicts []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		var version int64
+		if err := rows.Scan(&id, &version); err != nil {
+			return fmt.Errorf("ошибка блокировки кошельков: %w", err)
+		}
+		if expected := versions[id]; expected != 0 && expected != version {
+			conflicts = append(conflicts, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка блокировки кошельков: %w", err)
+	}
+	if len(conflicts) > 0 {
+		return &custom_err.ConflictError{WalletIDs: conflicts}
+	}
+	return nil
+}
+
+// copyOperations вставляет операции, которых ещё нет в БД, и возвращает сумму
+// вставленных операций по каждому кошельку.
+func copyOperations(ctx context.Context, tx pgx.Tx, ops []models.Operation) (map[uuid.UUID]int64, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	_, err := tx.Exec(ctx, `
+        CREATE TEMP TABLE operations_tmp (
+            id UUID NOT NULL,
+            wallet_id UUID NOT NULL,
+            amount BIGINT NOT NULL,
+            operation_type TEXT NOT NULL,
+            request_id TEXT NULL,
+            transfer_id UUID NULL,
+            counterparty_wallet_id UUID NULL,
+            created_at TIMESTAMP WITH TIME ZONE NOT NULL
+        ) ON COMMIT DROP
     `)
 	if err != nil {
-		return fmt.Errorf("ошибка INSERT из TEMP таблицы: %w", err)
+		return nil, fmt.Errorf("ошибка создания TEMP таблицы операций: %w", err)
 	}
-	insertedCount := cmdTag.RowsAffected()
 
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("ошибка коммита транзакции: %w", err)
+	copyRows := make([][]any, 0, len(ops))
+	for _, op := range ops {
+		copyRows = append(copyRows, []any{op.ID, op.WalletID, op.Amount, string(op.Type), requestIDValue(op.RequestID), op.TransferID, op.CounterpartyID, op.CreatedAt})
 	}
 
-	duration := time.Since(startTime)
-	log.Printf("[BulkUpdate] Success: %d wallets (updated=%d, inserted=%d) in %v",
-		len(wallets), updatedCount, insertedCount, duration)
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"operations_tmp"},
+		[]string{"id", "wallet_id", "amount", "operation_type", "request_id", "transfer_id", "counterparty_wallet_id", "created_at"},
+		pgx.CopyFromRows(copyRows),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка COPY операций в TEMP таблицу: %w", err)
+	}
 
-	return nil
+	rows, err := tx.Query(ctx, `
+        WITH inserted AS (
+            INSERT INTO operations (id, wallet_id, amount, operation_type, request_id, transfer_id, counterparty_wallet_id, created_at)
+            SELECT o.id, o.wallet_id, o.amount, o.operation_type, o.request_id, o.transfer_id, o.counterparty_wallet_id, o.created_at
+            FROM operations_tmp o
+            JOIN wallets w ON w.id = o.wallet_id
+            ON CONFLICT DO NOTHING
+            RETURNING wallet_id, amount
+        )
+        SELECT wallet_id, sum(amount)::bigint
+        FROM inserted
+        GROUP BY wallet_id
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка INSERT операций из TEMP таблицы: %w", err)
+	}
+	defer rows.Close()
+
+	deltas := make(map[uuid.UUID]int64)
+	for rows.Next() {
+		var walletID uuid.UUID
+		var delta int64
+		if err := rows.Scan(&walletID, &delta); err != nil {
+			return nil, fmt.Errorf("ошибка чтения вставленных операций: %w", err)
+		}
+		deltas[walletID] = delta
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка INSERT операций из TEMP таблицы: %w", err)
+	}
+	return deltas, nil
 }
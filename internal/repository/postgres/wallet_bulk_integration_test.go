@@ -3,13 +3,17 @@ package postgres
 import (
 	"context"
 	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestWalletRepository_BulkUpdateBalances(t *testing.T) {
+func TestWalletRepository_BulkApplyOperations(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration tests in short mode")
 	}
@@ -25,22 +29,176 @@ func TestWalletRepository_BulkUpdateBalances(t *testing.T) {
 	require.NoError(t, err)
 
 	newWalletID := uuid.New()
-
-	walletsToUpdate := map[uuid.UUID]int64{
-		existingWalletID: 250,
-		newWalletID:      500,
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	ops := []models.Operation{
+		{ID: uuid.New(), WalletID: existingWalletID, Type: models.WithdrawOperation, Amount: -750, CreatedAt: now},
+		{ID: uuid.New(), WalletID: newWalletID, Type: models.DepositOperation, Amount: 500, CreatedAt: now},
 	}
 
-	err = repo.BulkUpdateBalances(ctx, walletsToUpdate)
+	changes, err := repo.BulkApplyOperations(ctx, []uuid.UUID{existingWalletID, newWalletID}, ops, nil)
 	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, existingWalletID, changes[0].ID)
+	assert.Equal(t, int64(250), changes[0].Balance)
+	assert.Equal(t, int64(2), changes[0].Version, "every balance change bumps the row version")
 
 	var existingBalance int64
 	err = pool.QueryRow(ctx, "SELECT balance FROM wallets WHERE id = $1", existingWalletID).Scan(&existingBalance)
 	require.NoError(t, err)
 	assert.Equal(t, int64(250), existingBalance, "Balance of existing wallet should be updated")
 
-	var newBalance int64
-	err = pool.QueryRow(ctx, "SELECT balance FROM wallets WHERE id = $1", newWalletID).Scan(&newBalance)
+	var exists bool
+	err = pool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM wallets WHERE id = $1)", newWalletID).Scan(&exists)
+	require.NoError(t, err)
+	assert.False(t, exists, "Unknown wallet must not be created by a flush")
+}
+
+func TestWalletRepository_BulkApplyOperationsKeepsConcurrentChanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance) VALUES ($1, $2)", walletID, 1000)
+	require.NoError(t, err)
+
+	// Другой экземпляр успел записать своё изменение.
+	_, err = pool.Exec(ctx, "UPDATE wallets SET balance = balance + 50, version = version + 1 WHERE id = $1", walletID)
+	require.NoError(t, err)
+
+	ops := []models.Operation{{ID: uuid.New(), WalletID: walletID, Type: models.WithdrawOperation, Amount: -100, CreatedAt: time.Now().UTC()}}
+	changes, err := repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, ops, nil)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, int64(950), changes[0].Balance)
+}
+
+func TestWalletRepository_BulkApplyOperationsVersionConflict(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance, version) VALUES ($1, 1000, 1)", walletID)
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "UPDATE wallets SET balance = balance + 50, version = version + 1 WHERE id = $1", walletID)
+	require.NoError(t, err)
+
+	ops := []models.Operation{{ID: uuid.New(), WalletID: walletID, Type: models.WithdrawOperation, Amount: -100, CreatedAt: time.Now().UTC()}}
+	_, err = repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, ops, map[uuid.UUID]int64{walletID: 1})
+	var conflict *custom_err.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.ErrorIs(t, err, custom_err.ErrConflict)
+	assert.Equal(t, []uuid.UUID{walletID}, conflict.WalletIDs)
+
+	var count int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT count(*) FROM operations WHERE wallet_id = $1", walletID).Scan(&count))
+	assert.Zero(t, count, "nothing is written on conflict")
+
+	changes, err := repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, ops, map[uuid.UUID]int64{walletID: 2})
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, int64(950), changes[0].Balance)
+	assert.Equal(t, int64(3), changes[0].Version)
+}
+
+func TestWalletRepository_BulkApplyOperationsWithOperations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance) VALUES ($1, $2)", walletID, 1000)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	ops := []models.Operation{
+		{ID: uuid.New(), WalletID: walletID, Type: models.DepositOperation, Amount: 300, RequestID: uuid.New(), CreatedAt: now},
+		{ID: uuid.New(), WalletID: walletID, Type: models.WithdrawOperation, Amount: -100, CreatedAt: now},
+	}
+
+	_, err = repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, ops, nil)
+	require.NoError(t, err)
+
+	var count int
+	var sum int64
+	err = pool.QueryRow(ctx, "SELECT count(*), coalesce(sum(amount), 0) FROM operations WHERE wallet_id = $1", walletID).Scan(&count, &sum)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(200), sum)
+
+	var opType string
+	var createdAt time.Time
+	err = pool.QueryRow(ctx, "SELECT operation_type, created_at FROM operations WHERE id = $1", ops[1].ID).Scan(&opType, &createdAt)
+	require.NoError(t, err)
+	assert.Equal(t, string(models.WithdrawOperation), opType)
+	assert.True(t, now.Equal(createdAt), "operation time must be the time it was accepted, not flushed")
+
+	// Повторная запись тех же операций (например, после восстановления из журнала)
+	// не дублирует историю и не меняет баланс второй раз.
+	changes, err := repo.BulkApplyOperations(ctx, []uuid.UUID{walletID}, ops, nil)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, int64(1200), changes[0].Balance)
+
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM operations WHERE wallet_id = $1", walletID).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestWalletRepository_BulkApplyOperationsWithTransfer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	fromID, toID := uuid.New(), uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance) VALUES ($1, 1000), ($2, 0)", fromID, toID)
+	require.NoError(t, err)
+
+	transferID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	ops := []models.Operation{
+		{ID: uuid.New(), WalletID: fromID, Type: models.WithdrawOperation, Amount: -250, RequestID: uuid.New(), TransferID: &transferID, CounterpartyID: &toID, CreatedAt: now},
+		{ID: uuid.New(), WalletID: toID, Type: models.DepositOperation, Amount: 250, TransferID: &transferID, CounterpartyID: &fromID, CreatedAt: now},
+	}
+
+	_, err = repo.BulkApplyOperations(ctx, []uuid.UUID{fromID, toID}, ops, nil)
+	require.NoError(t, err)
+
+	debit, err := repo.GetOperationByRequestID(ctx, fromID, ops[0].RequestID)
+	require.NoError(t, err)
+	require.NotNil(t, debit.TransferID)
+	assert.Equal(t, transferID, *debit.TransferID)
+	assert.Equal(t, toID, *debit.CounterpartyID)
+
+	var legs int
+	var sum int64
+	err = pool.QueryRow(ctx, "SELECT count(*), coalesce(sum(amount), 0) FROM operations WHERE transfer_id = $1", transferID).Scan(&legs, &sum)
 	require.NoError(t, err)
-	assert.Equal(t, int64(500), newBalance, "New wallet should be created with correct balance")
+	assert.Equal(t, 2, legs)
+	assert.Zero(t, sum, "transfer legs must cancel out")
 }
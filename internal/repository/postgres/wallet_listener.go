@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"api_wallet/internal/models"
+	"api_wallet/internal/repository"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const listenerReconnectInterval = time.Second
+
+// WalletChangeListener слушает канал wallet_changes, в который триггер на
+// wallets публикует каждое изменение строки кошелька.
+type WalletChangeListener struct {
+	db *pgxpool.Pool
+}
+
+func NewWalletChangeListener(db *pgxpool.Pool) *WalletChangeListener {
+	return &WalletChangeListener{db: db}
+}
+
+// Listen держит отдельное соединение с LISTEN и передаёт изменения в onChange,
+// пока не отменён ctx. После каждого (пере)подключения вызывается onConnect:
+// уведомления, отправленные без подписки, потеряны, и их нужно восполнить.
+func (l *WalletChangeListener) Listen(ctx context.Context, onConnect func(ctx context.Context) error, onChange func(models.WalletChange)) error {
+	for {
+		err := l.listen(ctx, onConnect, onChange)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("[Listener] Connection lost: %v, reconnecting in %v", err, listenerReconnectInterval)
+
+		select {
+		case <-time.After(listenerReconnectInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (l *WalletChangeListener) listen(ctx context.Context, onConnect func(ctx context.Context) error, onChange func(models.WalletChange)) error {
+	pooled, err := l.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения: %w", err)
+	}
+	// Соединение с подпиской не возвращаем в пул, чтобы уведомления не копились в чужих запросах.
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{repository.WalletChangesChannel}.Sanitize()); err != nil {
+		return fmt.Errorf("ошибка подписки на %s: %w", repository.WalletChangesChannel, err)
+	}
+	if err := onConnect(ctx); err != nil {
+		return fmt.Errorf("ошибка сверки после подключения: %w", err)
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var change models.WalletChange
+		if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			log.Printf("[Listener] Malformed notification %q: %v", notification.Payload, err)
+			continue
+		}
+		onChange(change)
+	}
+}
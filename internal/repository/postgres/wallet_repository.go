@@ -10,6 +10,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -23,24 +24,78 @@ func NewWalletRepository(db *pgxpool.Pool) *WalletRepository {
 
 func (r *WalletRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
 	const op = "repository.GetByID"
-	var wallet models.Wallet
-	err := r.db.QueryRow(ctx, repository.GetWalletByIDQuery, id).Scan(
-		&wallet.ID, &wallet.Balance, &wallet.CreatedAt, &wallet.UpdatedAt,
-	)
+	wallet, err := scanWallet(r.db.QueryRow(ctx, repository.GetWalletByIDQuery, id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, custom_err.ErrNotFound
 		}
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	return wallet, nil
+}
+
+func (r *WalletRepository) Create(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	const op = "repository.Create"
+	wallet, err := scanWallet(r.db.QueryRow(ctx, repository.CreateWalletQuery, id))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, custom_err.ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return wallet, nil
+}
+
+func (r *WalletRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error) {
+	const op = "repository.UpdateStatus"
+	wallet, err := scanWallet(r.db.QueryRow(ctx, repository.UpdateWalletStatusQuery, id, string(status)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, custom_err.ErrNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return wallet, nil
+}
+
+func scanWallet(row pgx.Row) (*models.Wallet, error) {
+	var wallet models.Wallet
+	var status string
+	if err := row.Scan(&wallet.ID, &wallet.Balance, &wallet.Reserved, &status, &wallet.Version, &wallet.CreatedAt, &wallet.UpdatedAt); err != nil {
+		return nil, err
+	}
+	wallet.Available = wallet.Balance - wallet.Reserved
+	wallet.Status = models.WalletStatus(status)
 	return &wallet, nil
 }
 
-func (r *WalletRepository) UpsertWalletBalance(ctx context.Context, id uuid.UUID, balance int64) error {
-	const op = "repository.UpsertWalletBalance"
-	_, err := r.db.Exec(ctx, repository.UpsertWalletBalanceQuery, id, balance)
+// GetWalletStates возвращает баланс, резерв, версию и статус перечисленных кошельков.
+// Отсутствующие в БД кошельки в результат не попадают.
+func (r *WalletRepository) GetWalletStates(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+	const op = "repository.GetWalletStates"
+	rows, err := r.db.Query(ctx, repository.GetWalletStatesQuery, ids)
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	states, err := scanWalletChanges(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return states, nil
+}
+
+func scanWalletChanges(rows pgx.Rows) ([]models.WalletChange, error) {
+	defer rows.Close()
+	var changes []models.WalletChange
+	for rows.Next() {
+		var change models.WalletChange
+		var status string
+		if err := rows.Scan(&change.ID, &change.Balance, &change.Reserved, &change.Version, &status); err != nil {
+			return nil, err
+		}
+		change.Status = models.WalletStatus(status)
+		changes = append(changes, change)
 	}
-	return nil
+	return changes, rows.Err()
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -34,3 +35,36 @@ func TestWalletRepository_GetByID(t *testing.T) {
 	_, err = repo.GetByID(ctx, uuid.New())
 	assert.ErrorIs(t, err, custom_err.ErrNotFound)
 }
+
+func TestWalletRepository_CreateAndUpdateStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	pool, cleanup := setupRepoTest(t)
+	defer cleanup()
+
+	repo := NewWalletRepository(pool)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	wallet, err := repo.Create(ctx, walletID)
+	require.NoError(t, err)
+	assert.Equal(t, walletID, wallet.ID)
+	assert.Equal(t, int64(0), wallet.Balance)
+	assert.Equal(t, models.WalletActive, wallet.Status)
+
+	_, err = repo.Create(ctx, walletID)
+	assert.ErrorIs(t, err, custom_err.ErrAlreadyExists)
+
+	wallet, err = repo.UpdateStatus(ctx, walletID, models.WalletFrozen)
+	require.NoError(t, err)
+	assert.Equal(t, models.WalletFrozen, wallet.Status)
+
+	stored, err := repo.GetByID(ctx, walletID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WalletFrozen, stored.Status)
+
+	_, err = repo.UpdateStatus(ctx, uuid.New(), models.WalletClosed)
+	assert.ErrorIs(t, err, custom_err.ErrNotFound)
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
 	"api_wallet/internal/repository"
 	"context"
 	"errors"
@@ -12,15 +13,19 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 )
 
-func (r *WalletRepository) GetWalletStateTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (balance int64, version int64, err error) {
-	err = tx.QueryRow(ctx, repository.GetWalletStateQuery, walletID).Scan(&balance, &version)
+// GetWalletStateTx читает строку кошелька и блокирует её до конца транзакции.
+func (r *WalletRepository) GetWalletStateTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error) {
+	var state models.WalletChange
+	var status string
+	err := tx.QueryRow(ctx, repository.GetWalletStateQuery, walletID).Scan(&state.ID, &state.Balance, &state.Reserved, &state.Version, &status)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, 0, custom_err.ErrNotFound
+			return models.WalletChange{}, custom_err.ErrNotFound
 		}
-		return 0, 0, fmt.Errorf("ошибка чтения состояния кошелька: %w", err)
+		return models.WalletChange{}, fmt.Errorf("ошибка чтения состояния кошелька: %w", err)
 	}
-	return balance, version, nil
+	state.Status = models.WalletStatus(status)
+	return state, nil
 }
 func (r *WalletRepository) UpdateBalanceWithOptimisticLockTx(
 	ctx context.Context,
@@ -40,17 +45,33 @@ func (r *WalletRepository) UpdateBalanceWithOptimisticLockTx(
 
 	return nil
 }
-func (r *WalletRepository) CheckOperationExistsTx(ctx context.Context, tx pgx.Tx, requestID uuid.UUID) (bool, error) {
+
+// UpdateFundsWithOptimisticLockTx записывает баланс и зарезервированную сумму кошелька.
+func (r *WalletRepository) UpdateFundsWithOptimisticLockTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance, newReserved, expectedVersion int64) error {
+	cmdTag, err := tx.Exec(ctx, repository.UpdateWalletFundsWithLockQuery, newBalance, newReserved, expectedVersion, walletID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления резерва кошелька: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return custom_err.ErrConflict
+	}
+	return nil
+}
+
+func (r *WalletRepository) CheckOperationExistsTx(ctx context.Context, tx pgx.Tx, walletID, requestID uuid.UUID) (bool, error) {
 	var exists bool
 
-	if err := tx.QueryRow(ctx, repository.CheckOperationExistsQuery, requestID).Scan(&exists); err != nil {
+	if err := tx.QueryRow(ctx, repository.CheckOperationExistsQuery, walletID, requestID.String()).Scan(&exists); err != nil {
 		return false, fmt.Errorf("ошибка проверки идемпотентности: %w", err)
 	}
 	return exists, nil
 }
 
-func (r *WalletRepository) CreateOperationTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, amount int64, requestID uuid.UUID) error {
-	_, err := tx.Exec(ctx, repository.CreateOperationQuery, walletID, amount, requestID)
+func (r *WalletRepository) CreateOperationTx(ctx context.Context, tx pgx.Tx, operation models.Operation) error {
+	_, err := tx.Exec(ctx, repository.CreateOperationQuery,
+		operation.ID, operation.WalletID, operation.Amount, string(operation.Type),
+		requestIDValue(operation.RequestID), operation.TransferID, operation.CounterpartyID, operation.CreatedAt,
+	)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -55,12 +57,11 @@ func TestWalletRepository_OptimisticLockingTransactions(t *testing.T) {
 		require.NoError(t, err)
 		defer tx.Rollback(ctx)
 
-		balance, version, err := repo.GetWalletStateTx(ctx, tx, walletID)
+		state, err := repo.GetWalletStateTx(ctx, tx, walletID)
 		require.NoError(t, err)
-		assert.Equal(t, int64(100), balance)
-		assert.Equal(t, int64(1), version)
+		assert.Equal(t, models.WalletChange{ID: walletID, Balance: 100, Version: 1, Status: models.WalletActive}, state)
 
-		_, _, err = repo.GetWalletStateTx(ctx, tx, uuid.New())
+		_, err = repo.GetWalletStateTx(ctx, tx, uuid.New())
 		assert.ErrorIs(t, err, custom_err.ErrNotFound)
 	})
 
@@ -83,18 +84,27 @@ func TestWalletRepository_OptimisticLockingTransactions(t *testing.T) {
 
 		requestID := uuid.New()
 
-		exists, err := repo.CheckOperationExistsTx(ctx, tx, requestID)
+		exists, err := repo.CheckOperationExistsTx(ctx, tx, walletID, requestID)
 		require.NoError(t, err)
 		assert.False(t, exists)
 
-		err = repo.CreateOperationTx(ctx, tx, walletID, 50, requestID)
+		operation := models.Operation{
+			ID: uuid.New(), WalletID: walletID, Type: models.DepositOperation, Amount: 50,
+			RequestID: requestID, CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		}
+		err = repo.CreateOperationTx(ctx, tx, operation)
 		require.NoError(t, err)
 
-		exists, err = repo.CheckOperationExistsTx(ctx, tx, requestID)
+		exists, err = repo.CheckOperationExistsTx(ctx, tx, walletID, requestID)
 		require.NoError(t, err)
 		assert.True(t, exists)
 
-		err = repo.CreateOperationTx(ctx, tx, walletID, 50, requestID)
+		exists, err = repo.CheckOperationExistsTx(ctx, tx, uuid.New(), requestID)
+		require.NoError(t, err)
+		assert.False(t, exists, "request IDs are scoped to a wallet")
+
+		operation.ID = uuid.New()
+		err = repo.CreateOperationTx(ctx, tx, operation)
 		assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
 	})
 }
@@ -2,38 +2,78 @@ package repository
 
 const (
 	GetWalletByIDQuery = `
-        SELECT id, balance, created_at, updated_at
+        SELECT id, balance, reserved, status, version, created_at, updated_at
         FROM wallets
         WHERE id = $1
     `
 
+	CreateWalletQuery = `
+        INSERT INTO wallets (id, balance, status)
+        VALUES ($1, 0, 'active')
+        RETURNING id, balance, reserved, status, version, created_at, updated_at
+    `
+
+	UpdateWalletStatusQuery = `
+        UPDATE wallets
+        SET status = $2,
+            version = version + 1
+        WHERE id = $1
+        RETURNING id, balance, reserved, status, version, created_at, updated_at
+    `
+
+	// GetWalletStatesQuery читает текущие версии строк кошельков для сверки кэша.
+	GetWalletStatesQuery = `
+        SELECT id, balance, reserved, version, status
+        FROM wallets
+        WHERE id = ANY($1)
+    `
+
+	// LockWalletsQuery блокирует строки кошельков в порядке id, чтобы
+	// параллельные flush'и разных экземпляров не взаимоблокировались, и
+	// возвращает их версии.
+	LockWalletsQuery = `
+        SELECT id, version
+        FROM wallets
+        WHERE id = ANY($1)
+        ORDER BY id
+        FOR UPDATE
+    `
+
+	// WalletChangesChannel — канал LISTEN/NOTIFY, в который триггер на wallets
+	// публикует каждое изменение версии строки.
+	WalletChangesChannel = "wallet_changes"
+
 	GetWalletStateQuery = `
-    SELECT balance, version 
+    SELECT id, balance, reserved, version, status
     FROM wallets
     WHERE id = $1 
     FOR UPDATE 
 	`
 
 	CreateOperationQuery = `
-		INSERT INTO operations (wallet_id, amount, request_id) 
-		VALUES ($1, $2, $3)
+		INSERT INTO operations (id, wallet_id, amount, operation_type, request_id, transfer_id, counterparty_wallet_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	GetOperationByRequestIDQuery = `
+	SELECT id, wallet_id, amount, operation_type, request_id, transfer_id, counterparty_wallet_id, created_at
+	FROM operations
+	WHERE wallet_id = $1 AND request_id = $2
 	`
 
+	// ListOperationsQuery — основа выборки истории; условия фильтра, ORDER BY и LIMIT
+	// добавляет репозиторий.
+	ListOperationsQuery = `
+	SELECT id, wallet_id, amount, operation_type, request_id, transfer_id, counterparty_wallet_id, created_at
+	FROM operations
+	WHERE wallet_id = $1`
+
 	CheckOperationExistsQuery = `
 	SELECT 
 	EXISTS(SELECT 1 FROM operations 
-	WHERE request_id = $1)
+	WHERE wallet_id = $1 AND request_id = $2)
 	`
 
-	UpsertWalletBalanceQuery = `
-       INSERT INTO wallets (id, balance, version, created_at, updated_at)
-       VALUES ($1, $2, 1, NOW(), NOW())
-       ON CONFLICT (id) DO UPDATE 
-       SET 
-           balance = EXCLUDED.balance,
-           updated_at = NOW()
-   `
-
 	UpdateWalletBalanceWithLockQuery = `
     UPDATE wallets 
     SET 
@@ -44,4 +84,135 @@ const (
       AND version = $2    
     RETURNING version
 	`
+
+	// UpdateWalletFundsWithLockQuery меняет баланс и зарезервированную сумму вместе:
+	// так их меняют операции с холдами.
+	UpdateWalletFundsWithLockQuery = `
+    UPDATE wallets
+    SET
+        balance = $1,
+        reserved = $2,
+        version = $3 + 1,
+        updated_at = NOW()
+    WHERE id = $4
+      AND version = $3
+	`
+
+	CreateHoldQuery = `
+        INSERT INTO holds (id, wallet_id, amount, status, request_id, expires_at, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+    `
+
+	// GetHoldForUpdateQuery блокирует холд до конца транзакции, чтобы его не
+	// списали и не отменили одновременно.
+	GetHoldForUpdateQuery = `
+        SELECT id, wallet_id, amount, captured_amount, status, request_id, operation_id, expires_at, created_at, updated_at
+        FROM holds
+        WHERE id = $1
+        FOR UPDATE
+    `
+
+	GetHoldByRequestIDQuery = `
+        SELECT id, wallet_id, amount, captured_amount, status, request_id, operation_id, expires_at, created_at, updated_at
+        FROM holds
+        WHERE wallet_id = $1 AND request_id = $2
+    `
+
+	UpdateHoldQuery = `
+        UPDATE holds
+        SET status = $2,
+            captured_amount = $3,
+            operation_id = $4,
+            updated_at = $5
+        WHERE id = $1
+    `
+
+	// ListExpiredHoldsQuery выбирает активные холды с истёкшим сроком, самые старые первыми.
+	ListExpiredHoldsQuery = `
+        SELECT id, wallet_id, amount, captured_amount, status, request_id, operation_id, expires_at, created_at, updated_at
+        FROM holds
+        WHERE status = 'active' AND expires_at <= $1
+        ORDER BY expires_at
+        LIMIT $2
+    `
+
+	// Время аренды считается по часам БД, чтобы расхождение часов экземпляров не
+	// давало двум владельцам одного шарда.
+
+	AcquireShardLeasesQuery = `
+        INSERT INTO shard_leases (shard, owner_id, owner_addr, expires_at)
+        SELECT s, $1, $2, NOW() + $3 * interval '1 millisecond'
+        FROM unnest($4::int[]) AS s
+        ON CONFLICT (shard) DO UPDATE
+        SET owner_id = EXCLUDED.owner_id,
+            owner_addr = EXCLUDED.owner_addr,
+            expires_at = EXCLUDED.expires_at
+        WHERE shard_leases.expires_at < NOW()
+           OR shard_leases.owner_id = EXCLUDED.owner_id
+        RETURNING shard
+    `
+
+	RenewShardLeasesQuery = `
+        UPDATE shard_leases
+        SET owner_addr = $2,
+            expires_at = NOW() + $3 * interval '1 millisecond'
+        WHERE owner_id = $1
+        RETURNING shard
+    `
+
+	ReleaseShardLeasesQuery = `
+        DELETE FROM shard_leases
+        WHERE owner_id = $1 AND shard = ANY($2)
+    `
+
+	ListShardLeasesQuery = `
+        SELECT shard, owner_id, owner_addr, expires_at
+        FROM shard_leases
+        WHERE expires_at > NOW()
+    `
+
+	HeartbeatMemberQuery = `
+        INSERT INTO cluster_members (instance_id, addr, expires_at)
+        VALUES ($1, $2, NOW() + $3 * interval '1 millisecond')
+        ON CONFLICT (instance_id) DO UPDATE
+        SET addr = EXCLUDED.addr,
+            expires_at = EXCLUDED.expires_at
+    `
+
+	ListMembersQuery = `
+        SELECT instance_id
+        FROM cluster_members
+        WHERE expires_at > NOW()
+    `
+
+	DeleteMemberQuery = `
+        DELETE FROM cluster_members
+        WHERE instance_id = $1
+    `
+
+	// ON CONFLICT нужен ключу, который задан в конфигурации и создаётся при каждом запуске.
+	CreateAPIKeyQuery = `
+        INSERT INTO api_keys (id, name, key_prefix, key_hash, scopes, wallet_ids)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (key_hash) DO NOTHING
+        RETURNING created_at
+    `
+
+	GetAPIKeyByHashQuery = `
+        SELECT id, name, key_prefix, scopes, wallet_ids, created_at, revoked_at
+        FROM api_keys
+        WHERE key_hash = $1 AND revoked_at IS NULL
+    `
+
+	ListAPIKeysQuery = `
+        SELECT id, name, key_prefix, scopes, wallet_ids, created_at, revoked_at
+        FROM api_keys
+        ORDER BY created_at, id
+    `
+
+	RevokeAPIKeyQuery = `
+        UPDATE api_keys
+        SET revoked_at = COALESCE(revoked_at, NOW())
+        WHERE id = $1
+    `
 )
@@ -2,14 +2,41 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"api_wallet/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type Wallet interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
-	BulkUpdateBalances(ctx context.Context, wallets map[uuid.UUID]int64) error
-	UpsertWalletBalance(ctx context.Context, id uuid.UUID, balance int64) error
+	Create(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	UpdateStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error)
+	// BulkApplyOperations записывает операции и прибавляет их суммы к балансам
+	// кошельков одной транзакцией. versions — версии строк, от которых считал
+	// пишущий (0 или отсутствие — без проверки); при расхождении ничего не
+	// пишется и возвращается *custom_err.ConflictError. Возвращает состояние
+	// строк walletIDs после записи.
+	BulkApplyOperations(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error)
+	GetWalletStates(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error)
+	GetOperationByRequestID(ctx context.Context, walletID, requestID uuid.UUID) (*models.Operation, error)
+	ListOperations(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) ([]models.Operation, error)
+
+	// Методы для работы внутри транзакции, которую открывает вызывающий.
+	GetWalletStateTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error)
+	UpdateBalanceWithOptimisticLockTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance int64, expectedVersion int64) error
+	UpdateFundsWithOptimisticLockTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance, newReserved, expectedVersion int64) error
+	CheckOperationExistsTx(ctx context.Context, tx pgx.Tx, walletID, requestID uuid.UUID) (bool, error)
+	CreateOperationTx(ctx context.Context, tx pgx.Tx, operation models.Operation) error
+
+	// Холды. Резерв кошелька меняется вызывающим в той же транзакции.
+	CreateHoldTx(ctx context.Context, tx pgx.Tx, hold models.Hold) error
+	// GetHoldForUpdateTx возвращает холд, заблокировав его, или custom_err.ErrNotFound.
+	GetHoldForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.Hold, error)
+	UpdateHoldTx(ctx context.Context, tx pgx.Tx, hold models.Hold) error
+	GetHoldByRequestID(ctx context.Context, walletID, requestID uuid.UUID) (*models.Hold, error)
+	// ListExpiredHolds возвращает до limit активных холдов, истёкших к моменту now.
+	ListExpiredHolds(ctx context.Context, now time.Time, limit int) ([]models.Hold, error)
 }
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"context"
+	"time"
+)
+
+// backlogSampleInterval — как часто пересчитывается число грязных кошельков.
+const backlogSampleInterval = 200 * time.Millisecond
+
+// Backlog — изменения, принятые сервисом, но ещё не записанные в БД.
+type Backlog struct {
+	DirtyWallets int64
+	PendingOps   int64
+	RetryQueue   int64
+}
+
+// Backpressure — пороги admission control для изменений баланса. Пока
+// отставание по какой-либо величине между Soft и Hard, запись задерживается
+// пропорционально, до MaxDelay; с Hard — отклоняется. Величина с нулевым Hard
+// не ограничивается.
+type Backpressure struct {
+	Soft Backlog
+	Hard Backlog
+	// MaxDelay — задержка записи у самого порога Hard.
+	MaxDelay time.Duration
+	// RetryAfter — через сколько клиенту предлагается повторить отклонённую запись.
+	RetryAfter time.Duration
+}
+
+// WithBackpressure включает admission control: UpdateBalance и Transfer
+// замедляются и отклоняются с custom_err.ErrOverloaded, когда flush не
+// успевает за приёмом изменений. Чтение не ограничивается.
+func WithBackpressure(cfg Backpressure) Option {
+	return func(s *WalletService) {
+		s.backpressure = &cfg
+	}
+}
+
+// Backlog возвращает текущее отставание flush'а. Число грязных кошельков
+// пересчитывается периодически и может отставать на backlogSampleInterval.
+func (s *WalletService) Backlog() Backlog {
+	return Backlog{
+		DirtyWallets: s.metrics.dirtyWallets.Load(),
+		PendingOps:   s.metrics.pendingOps.Load(),
+		RetryQueue:   int64(len(s.retryQueue)),
+	}
+}
+
+// admit пропускает изменение баланса с учётом отставания flush'а: задерживает
+// его или отклоняет с custom_err.RetryAfterError.
+func (s *WalletService) admit(ctx context.Context) error {
+	if s.backpressure == nil {
+		return nil
+	}
+	pressure := s.backpressure.pressure(s.Backlog())
+	if pressure >= 1 {
+		s.metrics.writesRejected.Add(1)
+		return &custom_err.RetryAfterError{Err: custom_err.ErrOverloaded, After: s.backpressure.RetryAfter}
+	}
+	if pressure <= 0 || s.backpressure.MaxDelay <= 0 {
+		return nil
+	}
+
+	s.metrics.writesDelayed.Add(1)
+	timer := time.NewTimer(time.Duration(pressure * float64(s.backpressure.MaxDelay)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pressure возвращает загрузку от 0 (ниже Soft) до 1 (Hard достигнут) по самой
+// отстающей величине.
+func (b *Backpressure) pressure(backlog Backlog) float64 {
+	return max(
+		pressureOf(backlog.DirtyWallets, b.Soft.DirtyWallets, b.Hard.DirtyWallets),
+		pressureOf(backlog.PendingOps, b.Soft.PendingOps, b.Hard.PendingOps),
+		pressureOf(backlog.RetryQueue, b.Soft.RetryQueue, b.Hard.RetryQueue),
+	)
+}
+
+func pressureOf(value, soft, hard int64) float64 {
+	switch {
+	case hard <= 0:
+		return 0
+	case value >= hard:
+		return 1
+	case value <= soft:
+		return 0
+	}
+	return float64(value-soft) / float64(hard-soft)
+}
+
+// backlogSampler периодически пересчитывает грязные кошельки для admission control.
+func (s *WalletService) backlogSampler() {
+	ticker := time.NewTicker(backlogSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.metrics.dirtyWallets.Store(s.countDirty())
+	}
+}
+
+func (s *WalletService) countDirty() int64 {
+	var dirty int64
+	for _, shard := range s.shards {
+		dirty += int64(shard.dirtyCount())
+	}
+	return dirty
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPressureOf(t *testing.T) {
+	testCases := []struct {
+		name              string
+		value, soft, hard int64
+		expectedPressure  float64
+	}{
+		{name: "Below soft", value: 10, soft: 100, hard: 200, expectedPressure: 0},
+		{name: "Between soft and hard", value: 150, soft: 100, hard: 200, expectedPressure: 0.5},
+		{name: "At hard", value: 200, soft: 100, hard: 200, expectedPressure: 1},
+		{name: "No hard limit", value: 1000, soft: 100, hard: 0, expectedPressure: 0},
+		{name: "Soft above hard", value: 150, soft: 300, hard: 200, expectedPressure: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.expectedPressure, pressureOf(tc.value, tc.soft, tc.hard), 1e-9)
+		})
+	}
+}
+
+func TestWalletService_Backpressure(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func(bp Backpressure, bulkErr error) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+			},
+			BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+				return nil, bulkErr
+			},
+		}, nil, WithBackpressure(bp))
+	}
+	deposit := func(walletID uuid.UUID) models.WalletOperationRequest {
+		return models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10}
+	}
+
+	t.Run("Pending operations follow accepted and flushed writes", func(t *testing.T) {
+		service := newService(Backpressure{}, nil)
+		from, to := walletsInDifferentShards()
+
+		_, err := service.UpdateBalance(ctx, deposit(from))
+		require.NoError(t, err)
+		_, err = service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 5})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), service.Backlog().PendingOps)
+
+		_, err = service.FlushAll(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, service.Backlog().PendingOps)
+	})
+
+	t.Run("Failed flush keeps operations pending", func(t *testing.T) {
+		service := newService(Backpressure{}, errors.New("connection refused"))
+		walletID := uuid.New()
+		_, err := service.UpdateBalance(ctx, deposit(walletID))
+		require.NoError(t, err)
+
+		_, err = service.FlushAll(ctx)
+		require.Error(t, err)
+		assert.Equal(t, int64(1), service.Backlog().PendingOps)
+	})
+
+	t.Run("Writes over the hard limit are rejected, reads keep working", func(t *testing.T) {
+		service := newService(Backpressure{
+			Soft:       Backlog{PendingOps: 1},
+			Hard:       Backlog{PendingOps: 2},
+			RetryAfter: 3 * time.Second,
+		}, nil)
+		walletID := uuid.New()
+		_, err := service.UpdateBalance(ctx, deposit(walletID))
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, deposit(walletID))
+		require.NoError(t, err)
+
+		_, err = service.UpdateBalance(ctx, deposit(walletID))
+		require.ErrorIs(t, err, custom_err.ErrOverloaded)
+		var retry *custom_err.RetryAfterError
+		require.ErrorAs(t, err, &retry)
+		assert.Equal(t, 3*time.Second, retry.After)
+
+		_, err = service.Transfer(ctx, models.TransferRequest{FromWalletID: walletID, ToWalletID: uuid.New(), Amount: 1})
+		assert.ErrorIs(t, err, custom_err.ErrOverloaded)
+		assert.Equal(t, int64(2), service.metrics.writesRejected.Load())
+
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(120), wallet.Balance)
+
+		// После flush'а запись снова принимается.
+		_, err = service.FlushAll(ctx)
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, deposit(walletID))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Writes between soft and hard limits are delayed", func(t *testing.T) {
+		service := newService(Backpressure{
+			Soft:     Backlog{RetryQueue: 0},
+			Hard:     Backlog{RetryQueue: 2},
+			MaxDelay: 40 * time.Millisecond,
+		}, nil)
+		service.retryQueue <- retryItem{}
+
+		start := time.Now()
+		_, err := service.UpdateBalance(ctx, deposit(uuid.New()))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		assert.Equal(t, int64(1), service.metrics.writesDelayed.Load())
+	})
+
+	t.Run("Delayed write gives up when the request is cancelled", func(t *testing.T) {
+		service := newService(Backpressure{
+			Hard:     Backlog{DirtyWallets: 10},
+			MaxDelay: time.Hour,
+		}, nil)
+		service.metrics.dirtyWallets.Store(5)
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := service.UpdateBalance(ctx, deposit(uuid.New()))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
 	"api_wallet/internal/repository"
 	"api_wallet/internal/repository/postgres"
 	"context"
@@ -9,35 +10,427 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 type WalletState struct {
 	balance atomic.Int64
-	dirty   atomic.Bool
+	// reserved — сумма активных холдов, входящая в balance. Холды сначала пишутся
+	// в БД, поэтому reserved всегда берётся из строки кошелька. Меняется под mu.
+	reserved atomic.Int64
+	dirty    atomic.Bool
+
+	// mu сериализует изменение баланса вместе с записью в журнал, чтобы порядок
+	// записей одного кошелька в журнале совпадал с порядком изменений.
+	mu sync.Mutex
+	// journalSeq — seq последней записи журнала по кошельку.
+	journalSeq atomic.Uint64
+	// pendingSeq — нижняя граница seq первой записи, ещё не сброшенной в БД (0 — таких нет).
+	pendingSeq atomic.Uint64
+	// parkedSeq — то же для операций parked: журнал хранит их, пока replay не
+	// запишет их в БД (0 — таких нет или журнал выключен).
+	parkedSeq atomic.Uint64
+	// ops — операции, которые уже учтены в balance, но ещё не записаны в БД. Защищены mu.
+	ops []models.Operation
+	// inflight — операции снимка, который сейчас пишется в БД. Защищены mu.
+	inflight []models.Operation
+	// parked — операции, отправленные в dead letter: учтены в balance, но не
+	// записаны в БД, и flusher их больше не берёт. Защищены mu.
+	parked []models.Operation
+	// flushing выставляется тем, кто сейчас пишет снимок кошелька в БД (flusher или retryWorker).
+	flushing atomic.Bool
+	// status меняется только под mu, читается без блокировки.
+	status atomic.Value
+	// version — версия строки кошелька в БД, от которой отсчитан balance:
+	// balance равен балансу этой версии плюс суммы parked, inflight и ops. Защищена mu.
+	version int64
+	// pending — самая новая строка кошелька, полученная, пока снимок пишется в БД.
+	// По ней нельзя понять, есть ли в ней операции inflight, поэтому balance по
+	// ней пересчитывается, только когда запись снимка завершится. Защищена mu.
+	pending *models.WalletChange
+	// holds — число операций с холдами, чья транзакция идёт без mu (см.
+	// changeFunds); reserving — сумма, которую они резервируют: она уже в
+	// reserved, но ещё не в строке БД. Пока holds не 0, кошелек не вытесняется.
+	// Защищены mu.
+	holds     int
+	reserving int64
+	// evicted выставляется под mu, когда состояние выброшено из кэша. Пишущий,
+	// увидевший его, загружает кошелек заново, иначе изменение пропадёт вместе с состоянием.
+	evicted bool
+	// lastAccess — время последнего обращения в UnixNano, для LRU и TTL.
+	lastAccess atomic.Int64
+
+	// id и shard заполняются при добавлении в шард, см. Shard.insert.
+	id    uuid.UUID
+	shard *Shard
 }
 type Shard struct {
 	mu      sync.RWMutex
 	wallets map[uuid.UUID]*WalletState
+	// limit — максимум кошельков в шарде, 0 — без ограничения. См. WithCacheLimit.
+	limit   int
+	metrics *Metrics
+
+	// lease удерживается на чтение каждым обращением к кошелькам шарда, когда
+	// включено владение шардами; ReleaseShard берёт его на запись. owned защищён lease.
+	lease sync.RWMutex
+	owned bool
+
+	// dirty — кошельки шарда, которые стали грязными, чтобы flusher не обходил
+	// весь шард. Кошелек попадает сюда при переходе dirty из false в true и
+	// удаляется при обходе, когда оказывается чистым, поэтому в наборе бывают
+	// уже записанные кошельки, но нет грязных, которых в нём нет. Защищён dirtyMu.
+	dirtyMu sync.Mutex
+	dirty   map[uuid.UUID]*WalletState
+	// parked — кошельки шарда с операциями в dead letter. Они не грязные, но
+	// до replay считаются в отставании и держат журнал. Защищён dirtyMu.
+	parked map[uuid.UUID]*WalletState
+
+	// loads склеивает одновременные загрузки одного кошелька из БД.
+	loads singleflight.Group
+	// missing — кошельки, которых нет в БД, со временем, до которого это
+	// считается верным. Защищён mu. См. WithNegativeCacheTTL.
+	missing    map[uuid.UUID]time.Time
+	missingTTL time.Duration
+	// missingGen растёт при каждой инвалидации missing: загрузка, начатая до
+	// неё, не запоминает «не найдено».
+	missingGen atomic.Uint64
+}
+
+func newShard(metrics *Metrics) *Shard {
+	return &Shard{
+		wallets: make(map[uuid.UUID]*WalletState),
+		dirty:   make(map[uuid.UUID]*WalletState),
+		owned:   true,
+		metrics: metrics,
+	}
+}
+
+// insert добавляет состояние кошелька в шард. Вызывается под s.mu на запись.
+func (s *Shard) insert(id uuid.UUID, state *WalletState) {
+	state.id = id
+	state.shard = s
+	s.wallets[id] = state
+	if state.dirty.Load() {
+		s.addDirty(state)
+	}
+}
+
+func (s *Shard) addDirty(state *WalletState) {
+	s.dirtyMu.Lock()
+	if s.dirty == nil {
+		s.dirty = make(map[uuid.UUID]*WalletState)
+	}
+	s.dirty[state.id] = state
+	s.dirtyMu.Unlock()
+}
+
+// forEachDirty вызывает fn для грязных кошельков шарда, пока fn возвращает
+// true, по пути убирая из набора уже чистые. fn вызывается под dirtyMu.
+func (s *Shard) forEachDirty(fn func(ref stateRef) bool) {
+	s.dirtyMu.Lock()
+	defer s.dirtyMu.Unlock()
+
+	for id, state := range s.dirty {
+		// dirty читается под dirtyMu: кошелек, ставший грязным после проверки,
+		// вернётся в набор в markDirty уже после удаления.
+		if !state.dirty.Load() {
+			delete(s.dirty, id)
+			continue
+		}
+		if !fn(stateRef{id: id, state: state}) {
+			return
+		}
+	}
+}
+
+// dirtyStates возвращает до limit грязных кошельков шарда, которые сейчас никто не пишет.
+func (s *Shard) dirtyStates(limit int) []stateRef {
+	var refs []stateRef
+	s.forEachDirty(func(ref stateRef) bool {
+		if !ref.state.flushing.Load() {
+			refs = append(refs, ref)
+		}
+		return len(refs) < limit
+	})
+	return refs
+}
+
+// dirtyCount возвращает число кошельков шарда с незаписанными операциями:
+// грязных и отложенных в dead letter.
+func (s *Shard) dirtyCount() int {
+	n := 0
+	s.forEachDirty(func(stateRef) bool {
+		n++
+		return true
+	})
+	s.forEachParked(func(ref stateRef) bool {
+		if !ref.state.dirty.Load() {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// setParked добавляет кошелек в набор кошельков с операциями в dead letter или убирает из него.
+func (s *Shard) setParked(state *WalletState, parked bool) {
+	s.dirtyMu.Lock()
+	defer s.dirtyMu.Unlock()
+	if !parked {
+		delete(s.parked, state.id)
+		return
+	}
+	if s.parked == nil {
+		s.parked = make(map[uuid.UUID]*WalletState)
+	}
+	s.parked[state.id] = state
+}
+
+// forEachParked вызывает fn для кошельков шарда с операциями в dead letter,
+// пока fn возвращает true. fn вызывается под dirtyMu.
+func (s *Shard) forEachParked(fn func(ref stateRef) bool) {
+	s.dirtyMu.Lock()
+	defer s.dirtyMu.Unlock()
+
+	for id, state := range s.parked {
+		if !fn(stateRef{id: id, state: state}) {
+			return
+		}
+	}
+}
+
+// markDirty отмечает кошелек грязным и добавляет его в набор грязных кошельков шарда.
+func (w *WalletState) markDirty() {
+	if w.dirty.Swap(true) || w.shard == nil {
+		return
+	}
+	w.shard.addDirty(w)
+}
+
+func newWalletState(wallet *models.Wallet) *WalletState {
+	state := &WalletState{version: wallet.Version}
+	state.balance.Store(wallet.Balance)
+	state.reserved.Store(wallet.Reserved)
+	state.setStatus(wallet.Status)
+	state.touch()
+	return state
+}
+
+// lock берёт mu, если состояние ещё в кэше.
+func (w *WalletState) lock() error {
+	w.mu.Lock()
+	if w.evicted {
+		w.mu.Unlock()
+		return errStateEvicted
+	}
+	return nil
+}
+
+// Status возвращает статус кошелька; состояние без статуса считается активным.
+func (w *WalletState) Status() models.WalletStatus {
+	if status, ok := w.status.Load().(models.WalletStatus); ok && status != "" {
+		return status
+	}
+	return models.WalletActive
+}
+
+func (w *WalletState) setStatus(status models.WalletStatus) {
+	if status == "" {
+		status = models.WalletActive
+	}
+	w.status.Store(status)
+}
+
+// checkStatus проверяет, что кошелек в текущем статусе принимает изменение на amount.
+func (w *WalletState) checkStatus(amount int64) error {
+	return checkStatus(w.Status(), amount)
+}
+
+// checkStatus проверяет, что кошелек в статусе status принимает изменение на amount.
+// Замороженный кошелек принимает только зачисления, закрытый — ничего.
+func checkStatus(status models.WalletStatus, amount int64) error {
+	switch status {
+	case models.WalletClosed:
+		return custom_err.ErrWalletClosed
+	case models.WalletFrozen:
+		if amount < 0 {
+			return custom_err.ErrWalletFrozen
+		}
+	}
+	return nil
 }
 
 func (w *WalletState) Add(amount int64) {
-	w.balance.Add(amount)
-	w.dirty.Store(true)
+	w.add(amount)
+}
+
+func (w *WalletState) add(amount int64) int64 {
+	balance := w.balance.Add(amount)
+	w.markDirty()
+	return balance
 }
 
 func (w *WalletState) Withdraw(amount int64) error {
+	_, err := w.withdraw(amount)
+	return err
+}
+
+// withdraw списывает amount, не трогая зарезервированное холдами.
+func (w *WalletState) withdraw(amount int64) (int64, error) {
+	reserved := w.reserved.Load()
 	for {
 		current := w.balance.Load()
-		if current < amount {
-			return custom_err.ErrInsufficientFunds
+		if current-reserved < amount {
+			return current, custom_err.ErrInsufficientFunds
 		}
 		if w.balance.CompareAndSwap(current, current-amount) {
-			w.dirty.Store(true)
-			return nil
+			w.markDirty()
+			return current - amount, nil
+		}
+	}
+}
+
+// snapshot забирает незаписанные операции кошелька. В БД они попадут дельтами,
+// поэтому абсолютный баланс в снимок не входит. Вызывается под mu.
+func (w *WalletState) snapshot() (seq uint64, ops []models.Operation) {
+	seq = w.journalSeq.Load()
+	ops = w.ops
+	w.ops = nil
+	w.inflight = ops
+	return seq, ops
+}
+
+// applyChange приводит кэш к строке кошелька версии change.Version, записанной
+// любым экземпляром сервиса: баланс становится балансом строки плюс операции,
+// которых в ней ещё нет. Устаревшие и уже учтённые версии игнорируются.
+// Пока снимок пишется в БД, строка откладывается в pending, см. markFlushed.
+// Вызывается под mu.
+func (w *WalletState) applyChange(change models.WalletChange) bool {
+	if change.Version <= w.version || (w.pending != nil && change.Version <= w.pending.Version) {
+		return false
+	}
+	if len(w.inflight) > 0 {
+		w.pending = &change
+		w.reserved.Store(change.Reserved + w.reserving)
+		w.setStatus(change.Status)
+		return true
+	}
+	w.setRow(change)
+	return true
+}
+
+// applyCommitted применяет строку, которую этот экземпляр сам записал в БД мимо
+// flush'а; delta — на сколько запись изменила баланс строки. Пока снимок
+// пишется, строка откладывается, а delta сразу попадает в balance. Вызывается под mu.
+func (w *WalletState) applyCommitted(change models.WalletChange, delta int64) {
+	if len(w.inflight) > 0 {
+		w.balance.Add(delta)
+	}
+	w.applyChange(change)
+}
+
+// setRow отсчитывает balance от строки change. Вызывается под mu без inflight.
+func (w *WalletState) setRow(change models.WalletChange) {
+	w.version = change.Version
+	w.balance.Store(change.Balance + sumAmounts(w.parked) + sumAmounts(w.ops))
+	w.reserved.Store(change.Reserved + w.reserving)
+	w.setStatus(change.Status)
+	w.pending = nil
+}
+
+// resolvePending применяет отложенную строку, когда снимок больше не пишется. Вызывается под mu.
+func (w *WalletState) resolvePending() {
+	if w.pending != nil && len(w.inflight) == 0 {
+		w.setRow(*w.pending)
+	}
+}
+
+func sumAmounts(ops []models.Operation) int64 {
+	var sum int64
+	for i := range ops {
+		sum += ops[i].Amount
+	}
+	return sum
+}
+
+// pendingOps возвращает операции, которые учтены в балансе, но могут ещё не быть в БД.
+func (w *WalletState) pendingOps() []models.Operation {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ops := make([]models.Operation, 0, len(w.parked)+len(w.inflight)+len(w.ops))
+	ops = append(ops, w.parked...)
+	ops = append(ops, w.inflight...)
+	return append(ops, w.ops...)
+}
+
+// unpersisted сообщает, что у кошелька есть изменения, которых ещё нет в БД. Вызывается под mu.
+func (w *WalletState) unpersisted() bool {
+	return w.dirty.Load() || w.flushing.Load() || len(w.ops) > 0 || len(w.inflight) > 0 || len(w.parked) > 0 || w.holds > 0
+}
+
+// restoreOps возвращает операции неудачного снимка в начало очереди кошелька.
+// Баланс их уже учитывает, поэтому следующий снимок снова будет согласован.
+func (w *WalletState) restoreOps(ops []models.Operation) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inflight = nil
+	if len(ops) > 0 {
+		w.ops = append(ops, w.ops...)
+	}
+	w.resolvePending()
+}
+
+// hasPendingOp сообщает, что операция ещё не забрана flusher'ом. Вызывается под mu.
+func (w *WalletState) hasPendingOp(id uuid.UUID) bool {
+	for i := range w.ops {
+		if w.ops[i].ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// removePendingOp убирает операцию, если её ещё не забрал flusher.
+func (w *WalletState) removePendingOp(id uuid.UUID) bool {
+	for i := range w.ops {
+		if w.ops[i].ID == id {
+			w.ops = append(w.ops[:i], w.ops[i+1:]...)
+			return true
 		}
 	}
+	return false
+}
+
+// markFlushed вызывается после успешной записи снимка с seq в БД. change —
+// строка кошелька сразу после записи, nil, если строки в БД нет. Баланс
+// пересчитывается от более новой из change и строки, отложенной во время записи:
+// обе уже содержат записанные операции.
+func (w *WalletState) markFlushed(seq uint64, change *models.WalletChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.inflight = nil
+	if change != nil && change.Version >= w.version && (w.pending == nil || w.pending.Version < change.Version) {
+		w.setRow(*change)
+	}
+	w.resolvePending()
+
+	if len(w.ops) == 0 {
+		w.dirty.Store(false)
+		w.pendingSeq.Store(0)
+		return
+	}
+	// Записи после seq ещё не в БД, точный seq следующей неизвестен — берём нижнюю границу.
+	if p := w.pendingSeq.Load(); p != 0 && p <= seq {
+		w.pendingSeq.Store(seq + 1)
+	}
 }
 
 func (s *Shard) getState(ctx context.Context, id uuid.UUID, repo *postgres.WalletRepository) (*WalletState, error) {
@@ -57,47 +450,109 @@ func (s *Shard) getState(ctx context.Context, id uuid.UUID, repo *postgres.Walle
 		}
 		return nil, fmt.Errorf("ошибка загрузки кошелька из БД: %w", err)
 	}
-	newState := &WalletState{}
-	newState.balance.Store(wallet.Balance)
+	newState := newWalletState(wallet)
 
 	s.mu.Lock()
 	if existing, exists := s.wallets[id]; exists {
 		s.mu.Unlock()
 		return existing, nil
 	}
-	s.wallets[id] = newState
+	s.insert(id, newState)
 	s.mu.Unlock()
 
 	return newState, nil
 }
 
+// loadStateIntoCacheIfExists возвращает состояние кошелька, загружая его из БД
+// при промахе. Одновременные промахи по одному кошельку делят одну загрузку;
+// недавно не найденные кошельки в БД не запрашиваются, см. WithNegativeCacheTTL.
 func (s *Shard) loadStateIntoCacheIfExists(ctx context.Context, id uuid.UUID, repo repository.Wallet) (*WalletState, error) {
 	s.mu.RLock()
 	state, ok := s.wallets[id]
+	missing := !ok && s.isMissing(id, time.Now())
 	s.mu.RUnlock()
 
+	span := trace.SpanFromContext(ctx)
+	if ok {
+		span.SetAttributes(cacheAttr("hit"))
+		state.touch()
+		return state, nil
+	}
+	if missing {
+		span.SetAttributes(cacheAttr("negative"))
+		if s.metrics != nil {
+			s.metrics.negativeHits.Add(1)
+		}
+		return nil, custom_err.ErrNotFound
+	}
+
+	result := s.loads.DoChan(id.String(), func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		return s.loadState(loadCtx, id, repo)
+	})
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		if res.Shared {
+			span.SetAttributes(cacheAttr("shared"))
+			if s.metrics != nil {
+				s.metrics.loadsShared.Add(1)
+			}
+		} else {
+			span.SetAttributes(cacheAttr("miss"))
+		}
+		state = res.Val.(*WalletState)
+		state.touch()
+		return state, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// loadState загружает кошелек из БД и кладёт его в кэш. Вызывается через s.loads.
+// Спан загрузки — дочерний к запросу, начавшему её; запросы, дождавшиеся чужой
+// загрузки, отмечены атрибутом wallet.cache=shared.
+func (s *Shard) loadState(ctx context.Context, id uuid.UUID, repo repository.Wallet) (_ *WalletState, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.loadWallet", trace.WithAttributes(walletAttr(id)))
+	defer func() {
+		// Отсутствие кошелька — ответ, а не сбой загрузки.
+		if errors.Is(err, custom_err.ErrNotFound) {
+			span.SetAttributes(attribute.Bool("wallet.found", false))
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	// Кошелек мог попасть в кэш, пока запрос ждал предыдущую загрузку.
+	s.mu.RLock()
+	state, ok := s.wallets[id]
+	s.mu.RUnlock()
 	if ok {
 		return state, nil
 	}
 
+	gen := s.missingGen.Load()
 	wallet, err := repo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, custom_err.ErrNotFound) {
+			s.rememberMissing(id, gen)
 			return nil, custom_err.ErrNotFound
 		}
 		return nil, fmt.Errorf("ошибка загрузки кошелька из БД: %w", err)
 	}
 
-	newState := &WalletState{}
-	newState.balance.Store(wallet.Balance)
+	newState := newWalletState(wallet)
 
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	if existing, exists := s.wallets[id]; exists {
-		s.mu.Unlock()
 		return existing, nil
 	}
-	s.wallets[id] = newState
-	s.mu.Unlock()
-
+	s.insert(id, newState)
+	s.trim()
 	return newState, nil
 }
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// loadTimeout ограничивает общую загрузку кошелька из БД: она не зависит от
+	// контекста запроса, начавшего её, потому что результат ждут и другие запросы.
+	loadTimeout = 5 * time.Second
+	// maxMissingPerShard ограничивает число запомненных отсутствующих кошельков в шарде.
+	maxMissingPerShard = 10000
+)
+
+// WithNegativeCacheTTL включает кэш отсутствующих кошельков: после ответа БД
+// «не найдено» повторные запросы того же id в течение ttl получают
+// custom_err.ErrNotFound без обращения к БД. 0 — не кэшировать.
+func WithNegativeCacheTTL(ttl time.Duration) Option {
+	return func(s *WalletService) {
+		for _, shard := range s.shards {
+			shard.missingTTL = ttl
+		}
+	}
+}
+
+// isMissing сообщает, что кошелек недавно не нашёлся в БД. Вызывается под s.mu.
+func (s *Shard) isMissing(id uuid.UUID, now time.Time) bool {
+	until, ok := s.missing[id]
+	return ok && now.Before(until)
+}
+
+// rememberMissing запоминает, что кошелька нет в БД. gen — missingGen на момент
+// запроса к БД: если с тех пор кэш инвалидировался, ответ мог устареть.
+// Переполненный кэш сначала чистится от устаревших записей; если места всё
+// равно нет, id не запоминается.
+func (s *Shard) rememberMissing(id uuid.UUID, gen uint64) {
+	if s.missingTTL <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.missingGen.Load() != gen {
+		return
+	}
+
+	now := time.Now()
+	if s.missing == nil {
+		s.missing = make(map[uuid.UUID]time.Time)
+	}
+	if len(s.missing) >= maxMissingPerShard {
+		for missingID, until := range s.missing {
+			if !now.Before(until) {
+				delete(s.missing, missingID)
+			}
+		}
+		if len(s.missing) >= maxMissingPerShard {
+			return
+		}
+	}
+	s.missing[id] = now.Add(s.missingTTL)
+}
+
+// forgetMissing убирает кошелек из кэша отсутствующих: он мог появиться в БД.
+func (s *Shard) forgetMissing(id uuid.UUID) {
+	s.missingGen.Add(1)
+
+	// Изменения приходят и по некэшированным кошелькам, поэтому сначала проверка
+	// под RLock: запись в missing редка.
+	s.mu.RLock()
+	_, ok := s.missing[id]
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	delete(s.missing, id)
+	s.mu.Unlock()
+}
+
+// clearMissing сбрасывает кэш отсутствующих кошельков шарда.
+func (s *Shard) clearMissing() {
+	s.missingGen.Add(1)
+	s.mu.Lock()
+	clear(s.missing)
+	s.mu.Unlock()
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_CacheMiss(t *testing.T) {
+	ctx := context.Background()
+
+	// newService возвращает сервис, в БД которого есть только кошельки из existing.
+	newService := func(existing *sync.Map, loads *atomic.Int64, ttl time.Duration) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				loads.Add(1)
+				time.Sleep(20 * time.Millisecond)
+				if _, ok := existing.Load(id); !ok {
+					return nil, custom_err.ErrNotFound
+				}
+				return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+			},
+			CreateFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				existing.Store(id, struct{}{})
+				return &models.Wallet{ID: id, Version: 1, Status: models.WalletActive}, nil
+			},
+		}, nil, WithNegativeCacheTTL(ttl))
+	}
+	// getConcurrently запрашивает кошелек n раз одновременно и возвращает ошибки.
+	getConcurrently := func(service *WalletService, id uuid.UUID, n int) []error {
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = service.GetWalletByID(ctx, id)
+			}(i)
+		}
+		wg.Wait()
+		return errs
+	}
+
+	t.Run("Concurrent misses share one load", func(t *testing.T) {
+		var existing sync.Map
+		var loads atomic.Int64
+		service := newService(&existing, &loads, time.Minute)
+		walletID := uuid.New()
+		existing.Store(walletID, struct{}{})
+
+		for _, err := range getConcurrently(service, walletID, 50) {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int64(1), loads.Load())
+		assert.Positive(t, service.metrics.loadsShared.Load())
+	})
+
+	t.Run("Missing wallet is not loaded again within TTL", func(t *testing.T) {
+		var existing sync.Map
+		var loads atomic.Int64
+		service := newService(&existing, &loads, time.Minute)
+		walletID := uuid.New()
+
+		for _, err := range getConcurrently(service, walletID, 50) {
+			require.ErrorIs(t, err, custom_err.ErrNotFound)
+		}
+		_, err := service.GetWalletByID(ctx, walletID)
+		require.ErrorIs(t, err, custom_err.ErrNotFound)
+		assert.Equal(t, int64(1), loads.Load())
+		assert.Equal(t, int64(1), service.metrics.negativeHits.Load())
+	})
+
+	t.Run("Missing wallet is loaded again after TTL", func(t *testing.T) {
+		var existing sync.Map
+		var loads atomic.Int64
+		service := newService(&existing, &loads, 10*time.Millisecond)
+		walletID := uuid.New()
+
+		_, err := service.GetWalletByID(ctx, walletID)
+		require.ErrorIs(t, err, custom_err.ErrNotFound)
+		time.Sleep(20 * time.Millisecond)
+		existing.Store(walletID, struct{}{})
+
+		_, err = service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), loads.Load())
+	})
+
+	t.Run("Disabled negative cache", func(t *testing.T) {
+		var existing sync.Map
+		var loads atomic.Int64
+		service := newService(&existing, &loads, 0)
+		walletID := uuid.New()
+
+		for i := 0; i < 2; i++ {
+			_, err := service.GetWalletByID(ctx, walletID)
+			require.ErrorIs(t, err, custom_err.ErrNotFound)
+		}
+		assert.Equal(t, int64(2), loads.Load())
+	})
+
+	t.Run("Created wallet is visible at once", func(t *testing.T) {
+		var existing sync.Map
+		var loads atomic.Int64
+		service := newService(&existing, &loads, time.Minute)
+		walletID := uuid.New()
+
+		_, err := service.GetWalletByID(ctx, walletID)
+		require.ErrorIs(t, err, custom_err.ErrNotFound)
+		_, err = service.CreateWallet(ctx, walletID)
+		require.NoError(t, err)
+
+		_, err = service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+	})
+
+	t.Run("Change feed forgets a wallet created elsewhere", func(t *testing.T) {
+		var existing sync.Map
+		var loads atomic.Int64
+		service := newService(&existing, &loads, time.Minute)
+		walletID := uuid.New()
+
+		_, err := service.GetWalletByID(ctx, walletID)
+		require.ErrorIs(t, err, custom_err.ErrNotFound)
+
+		// Кошелек создан другим экземпляром, уведомление пришло от триггера на INSERT.
+		existing.Store(walletID, struct{}{})
+		service.applyChange(models.WalletChange{ID: walletID, Version: 1, Status: models.WalletActive})
+
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), wallet.Balance)
+	})
+
+	t.Run("Load started before invalidation is not remembered", func(t *testing.T) {
+		shard := newShard(nil)
+		shard.missingTTL = time.Minute
+		walletID := uuid.New()
+
+		gen := shard.missingGen.Load()
+		shard.forgetMissing(walletID)
+		shard.rememberMissing(walletID, gen)
+
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		assert.False(t, shard.isMissing(walletID, time.Now()))
+	})
+}
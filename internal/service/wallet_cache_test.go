@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -146,9 +147,11 @@ func TestShard_loadStateIntoCacheIfExists(t *testing.T) {
 		assert.True(t, errors.Is(err, dbError), "Should return the original DB error")
 	})
 
-	t.Run("Concurrency - Double Check Locking Prevention", func(t *testing.T) {
+	t.Run("Concurrency - Single Load Per Miss", func(t *testing.T) {
+		var callCount atomic.Int64
 		mockRepo := &mockRepository{
 			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				callCount.Add(1)
 				time.Sleep(10 * time.Millisecond)
 				return &models.Wallet{ID: id, Balance: 100}, nil
 			},
@@ -156,24 +159,24 @@ func TestShard_loadStateIntoCacheIfExists(t *testing.T) {
 		shard := &Shard{wallets: make(map[uuid.UUID]*WalletState)}
 
 		var wg sync.WaitGroup
-		var firstState *WalletState
+		var firstState, secondState *WalletState
 
 		wg.Add(2)
 
 		go func() {
 			defer wg.Done()
-			state, _ := shard.loadStateIntoCacheIfExists(ctx, walletID, mockRepo)
-			firstState = state
+			firstState, _ = shard.loadStateIntoCacheIfExists(ctx, walletID, mockRepo)
 		}()
 
 		go func() {
 			defer wg.Done()
 			time.Sleep(5 * time.Millisecond)
-			state, _ := shard.loadStateIntoCacheIfExists(ctx, walletID, mockRepo)
-			assert.Same(t, firstState, state, "Concurrent access should return the same object due to double-check locking")
+			secondState, _ = shard.loadStateIntoCacheIfExists(ctx, walletID, mockRepo)
 		}()
 
 		wg.Wait()
+		assert.Same(t, firstState, secondState, "Concurrent misses should share one loaded state")
+		assert.Equal(t, int64(1), callCount.Load(), "GetByID should be called once")
 
 		shard.mu.RLock()
 		assert.Len(t, shard.wallets, 1)
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"api_wallet/internal/models"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// resyncBatchSize ограничивает число кошельков в одном запросе сверки кэша.
+const resyncBatchSize = 1000
+
+// ChangeFeed доставляет изменения строк wallets, сделанные любым экземпляром сервиса.
+type ChangeFeed interface {
+	// Listen блокируется до отмены ctx. onConnect вызывается после каждого
+	// (пере)подключения: изменения, сделанные без подписки, могли быть пропущены.
+	Listen(ctx context.Context, onConnect func(ctx context.Context) error, onChange func(models.WalletChange)) error
+}
+
+// WithChangeFeed включает согласование кэша между экземплярами: кэшированные
+// кошельки обновляются, когда их строку в БД меняет другой экземпляр.
+func WithChangeFeed(feed ChangeFeed) Option {
+	return func(s *WalletService) {
+		s.changes = feed
+	}
+}
+
+func (s *WalletService) changeListener() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := s.changes.Listen(ctx, s.resyncCache, s.applyChange); err != nil {
+		log.Printf("[Coherence] Listener stopped: %v", err)
+	}
+}
+
+// applyChange применяет к кэшу изменение строки кошелька. Некэшированные
+// кошельки пропускаются: при первом обращении они загрузятся из БД. Кошелек
+// при этом точно есть в БД, поэтому он убирается из кэша отсутствующих.
+func (s *WalletService) applyChange(change models.WalletChange) {
+	state := s.cachedState(change.ID)
+	if state == nil {
+		s.getShard(change.ID).forgetMissing(change.ID)
+		return
+	}
+	state.mu.Lock()
+	state.applyChange(change)
+	state.mu.Unlock()
+}
+
+// resyncCache сверяет все кэшированные кошельки с БД.
+func (s *WalletService) resyncCache(ctx context.Context) error {
+	const op = "service.resyncCache"
+
+	// Пока уведомления не доходили, кошельки могли быть созданы в БД.
+	var ids []uuid.UUID
+	for _, shard := range s.shards {
+		shard.clearMissing()
+		shard.mu.RLock()
+		for id := range shard.wallets {
+			ids = append(ids, id)
+		}
+		shard.mu.RUnlock()
+	}
+
+	for start := 0; start < len(ids); start += resyncBatchSize {
+		end := min(start+resyncBatchSize, len(ids))
+		changes, err := s.repo.GetWalletStates(ctx, ids[start:end])
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		for _, change := range changes {
+			s.applyChange(change)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFeed отдаёт изменения из канала, как подписка на wallet_changes.
+type fakeFeed struct {
+	changes chan models.WalletChange
+}
+
+func (f *fakeFeed) Listen(ctx context.Context, onConnect func(ctx context.Context) error, onChange func(models.WalletChange)) error {
+	if err := onConnect(ctx); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-f.changes:
+			onChange(change)
+		}
+	}
+}
+
+func TestWalletState_applyChange(t *testing.T) {
+	newState := func() *WalletState {
+		return newWalletState(&models.Wallet{Balance: 100, Version: 2, Status: models.WalletActive})
+	}
+
+	t.Run("Newer version replaces balance and keeps unflushed operations", func(t *testing.T) {
+		state := newState()
+		state.add(20)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 20}}
+
+		assert.True(t, state.applyChange(models.WalletChange{Balance: 500, Version: 3, Status: models.WalletFrozen}))
+		assert.Equal(t, int64(520), state.balance.Load())
+		assert.Equal(t, models.WalletFrozen, state.Status())
+	})
+
+	t.Run("Stale version is ignored", func(t *testing.T) {
+		state := newState()
+		assert.False(t, state.applyChange(models.WalletChange{Balance: 500, Version: 2}))
+		assert.Equal(t, int64(100), state.balance.Load())
+	})
+
+	t.Run("Newer version committed during flush already contains the snapshot", func(t *testing.T) {
+		state := newState()
+		state.add(30)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 30}}
+		seq, _ := state.snapshot()
+
+		// Наш flush получил версию 3, чужое изменение (+5) — версию 4, и его уведомление пришло раньше ответа.
+		state.applyChange(models.WalletChange{Balance: 135, Version: 4})
+		state.markFlushed(seq, &models.WalletChange{Balance: 130, Version: 3})
+
+		assert.Equal(t, int64(135), state.balance.Load())
+		assert.False(t, state.dirty.Load())
+	})
+
+	t.Run("Older version received during flush does not contain the snapshot", func(t *testing.T) {
+		state := newState()
+		state.add(30)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 30}}
+		seq, _ := state.snapshot()
+
+		// Чужое изменение (+5) закоммичено раньше нашего flush'а. До конца записи
+		// не известно, есть ли в строке снимок, поэтому баланс её ещё не учитывает.
+		state.applyChange(models.WalletChange{Balance: 105, Version: 3})
+		assert.Equal(t, int64(130), state.balance.Load())
+		state.markFlushed(seq, &models.WalletChange{Balance: 135, Version: 4})
+
+		assert.Equal(t, int64(135), state.balance.Load())
+	})
+
+	t.Run("Notification of our own flush received before markFlushed is not counted twice", func(t *testing.T) {
+		state := newState()
+		state.add(30)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 30}}
+		seq, _ := state.snapshot()
+
+		// Строка, записанная самим flush'ем, пришла через NOTIFY раньше ответа БД.
+		assert.True(t, state.applyChange(models.WalletChange{Balance: 130, Version: 3}))
+		assert.Equal(t, int64(130), state.balance.Load())
+		_, err := state.withdraw(131)
+		assert.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+
+		state.markFlushed(seq, &models.WalletChange{Balance: 130, Version: 3})
+		assert.Equal(t, int64(130), state.balance.Load())
+		assert.Equal(t, int64(3), state.version)
+		assert.Nil(t, state.pending)
+		assert.False(t, state.dirty.Load())
+	})
+
+	t.Run("Own write committed during flush is counted once", func(t *testing.T) {
+		state := newState()
+		state.add(30)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 30}}
+		seq, _ := state.snapshot()
+
+		// Перевод на -10 закоммичен мимо flush'а, пока снимок пишется.
+		state.applyCommitted(models.WalletChange{Balance: 90, Version: 3}, -10)
+		assert.Equal(t, int64(120), state.balance.Load())
+
+		state.markFlushed(seq, &models.WalletChange{Balance: 120, Version: 4})
+		assert.Equal(t, int64(120), state.balance.Load())
+	})
+
+	t.Run("Deferred change is applied when the flush fails", func(t *testing.T) {
+		state := newState()
+		state.add(30)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 30}}
+		_, ops := state.snapshot()
+
+		state.applyChange(models.WalletChange{Balance: 105, Version: 3})
+		state.restoreOps(ops)
+
+		assert.Equal(t, int64(135), state.balance.Load())
+		assert.Equal(t, int64(3), state.version)
+	})
+}
+
+func TestWalletService_ChangeFeed(t *testing.T) {
+	ctx := context.Background()
+	cached, other := walletsInDifferentShards()
+
+	var resynced []uuid.UUID
+	feed := &fakeFeed{changes: make(chan models.WalletChange)}
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100, Version: 1}, nil
+		},
+		GetWalletStatesFunc: func(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+			resynced = append(resynced, ids...)
+			return []models.WalletChange{{ID: cached, Balance: 200, Version: 2, Status: models.WalletActive}}, nil
+		},
+	}, nil, WithChangeFeed(feed))
+
+	_, err := service.GetWalletByID(ctx, cached)
+	require.NoError(t, err)
+
+	service.Start()
+	// Небуферизованный канал: отправка завершится, когда onConnect уже отработал.
+	feed.changes <- models.WalletChange{ID: other, Balance: 1, Version: 5}
+	assert.Equal(t, []uuid.UUID{cached}, resynced, "cached wallets are reconciled on connect")
+	assert.Nil(t, service.cachedState(other), "changes of uncached wallets are not loaded")
+
+	feed.changes <- models.WalletChange{ID: cached, Balance: 300, Version: 3, Status: models.WalletFrozen}
+	assert.Eventually(t, func() bool {
+		wallet, err := service.GetWalletByID(ctx, cached)
+		return err == nil && wallet.Balance == 300 && wallet.Status == models.WalletFrozen
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = service.Stop(ctx)
+	require.NoError(t, err)
+}
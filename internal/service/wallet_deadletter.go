@@ -0,0 +1,234 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxFlushAttempts — сколько раз retryWorker пишет снимок, прежде чем отправить его в dead letter.
+const maxFlushAttempts = 3
+
+// DeadLetterStore — долговременное хранилище снимков, которые не удалось записать в БД.
+type DeadLetterStore interface {
+	Put(ctx context.Context, letter models.DeadLetter) error
+	// Get возвращает custom_err.ErrNotFound, если письма нет.
+	Get(ctx context.Context, id uuid.UUID) (*models.DeadLetter, error)
+	List(ctx context.Context) ([]models.DeadLetter, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// DeadLetterServicer — администрирование снимков, отправленных в dead letter.
+type DeadLetterServicer interface {
+	ListDeadLetters(ctx context.Context) ([]models.DeadLetter, error)
+	ReplayDeadLetter(ctx context.Context, id uuid.UUID) error
+}
+
+var _ DeadLetterServicer = (*WalletService)(nil)
+
+// WithDeadLetters включает dead letter: снимок, который не записался за
+// maxFlushAttempts попыток или не поместился в очередь повторов, сохраняется в
+// store, а его операции остаются на кошельках незаписанными до replay.
+// Без store такие снимки возвращаются flusher'у и повторяются бесконечно.
+func WithDeadLetters(store DeadLetterStore) Option {
+	return func(s *WalletService) {
+		s.deadLetters = store
+	}
+}
+
+// deadLetter сохраняет снимки в хранилище dead letter и паркует их операции на
+// кошельках. Если хранилища нет или сохранить не удалось, снимки возвращаются flusher'у.
+func (s *WalletService) deadLetter(snapshots []walletSnapshot, attempts int, cause error) {
+	if s.deadLetters == nil {
+		s.releaseSnapshots(snapshots)
+		return
+	}
+
+	letter := models.DeadLetter{ID: uuid.New(), Attempts: attempts, CreatedAt: time.Now().UTC()}
+	if cause != nil {
+		letter.LastError = cause.Error()
+	}
+	for _, snap := range snapshots {
+		letter.WalletIDs = append(letter.WalletIDs, snap.id)
+		letter.Operations = append(letter.Operations, snap.ops...)
+	}
+	if len(letter.Operations) == 0 {
+		s.releaseSnapshots(snapshots)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.deadLetters.Put(ctx, letter); err != nil {
+		log.Printf("[DeadLetter] Failed to store %d wallets, returning them to flusher: %v", len(snapshots), err)
+		s.releaseSnapshots(snapshots)
+		return
+	}
+
+	for _, snap := range snapshots {
+		s.traces.forget(snap.ops)
+		snap.state.park(snap.ops)
+		snap.state.flushing.Store(false)
+	}
+	s.metrics.deadLetters.Add(1)
+	log.Printf("[DeadLetter] Stored %s: %d wallets, %d operations after %d attempts: %s",
+		letter.ID, len(letter.WalletIDs), len(letter.Operations), attempts, letter.LastError)
+}
+
+// ListDeadLetters возвращает снимки, ожидающие replay, старые первыми.
+func (s *WalletService) ListDeadLetters(ctx context.Context) ([]models.DeadLetter, error) {
+	const op = "service.ListDeadLetters"
+	if s.deadLetters == nil {
+		return []models.DeadLetter{}, nil
+	}
+	letters, err := s.deadLetters.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetter записывает операции письма в БД одной транзакцией и удаляет
+// письмо. Операции вставляются по ID, поэтому повторный replay ничего не меняет.
+// Кэш кошельков письма сверяется с записанными строками.
+func (s *WalletService) ReplayDeadLetter(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.ReplayDeadLetter", trace.WithAttributes(attribute.String("dead_letter.id", id.String())))
+	defer func() { endSpan(span, err) }()
+	const op = "service.ReplayDeadLetter"
+	if s.deadLetters == nil {
+		return custom_err.ErrNotFound
+	}
+	done, err := s.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	letter, err := s.deadLetters.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, custom_err.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Replay пишет от версий кэша, как flusher: строку, изменённую в обход
+	// кэша, нельзя затереть, иначе кэш и БД разойдутся.
+	versions := make(map[uuid.UUID]int64, len(letter.WalletIDs))
+	for _, walletID := range letter.WalletIDs {
+		if state := s.cachedState(walletID); state != nil {
+			state.mu.Lock()
+			if state.version != 0 {
+				versions[walletID] = state.version
+			}
+			state.mu.Unlock()
+		}
+	}
+	changes, err := s.bulkApply(ctx, letter.WalletIDs, letter.Operations, versions)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	replayed := make(map[uuid.UUID]bool, len(letter.Operations))
+	for _, operation := range letter.Operations {
+		replayed[operation.ID] = true
+	}
+	byID := make(map[uuid.UUID]*models.WalletChange, len(changes))
+	for i := range changes {
+		byID[changes[i].ID] = &changes[i]
+	}
+	for _, walletID := range letter.WalletIDs {
+		state := s.cachedState(walletID)
+		if state == nil {
+			continue
+		}
+		state.mu.Lock()
+		removed := state.markReplayed(replayed, byID[walletID])
+		state.mu.Unlock()
+		s.metrics.pendingOps.Add(-int64(removed))
+	}
+
+	if err := s.deadLetters.Delete(ctx, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Printf("[DeadLetter] Replayed %s: %d operations", id, len(letter.Operations))
+	return nil
+}
+
+// park откладывает операции снимка, отправленного в dead letter. Они остаются
+// в балансе, но flusher их больше не пишет. До replay кошелек остаётся в
+// отставании flush'а, а журнал хранит их записи.
+func (w *WalletState) park(ops []models.Operation) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inflight = nil
+	w.parked = append(w.parked, ops...)
+	if w.parkedSeq.Load() == 0 {
+		w.parkedSeq.Store(w.pendingSeq.Load())
+	}
+	// Кошелек попадает в набор до того, как перестанет быть грязным, чтобы
+	// journalCheckpointer видел его хотя бы в одном из наборов.
+	if w.shard != nil {
+		w.shard.setParked(w, true)
+	}
+	w.resolvePending()
+	if len(w.ops) == 0 {
+		w.dirty.Store(false)
+		w.pendingSeq.Store(0)
+	}
+}
+
+// markReplayed убирает из кошелька операции, записанные replay'ем; change —
+// строка кошелька после записи, nil, если строки в БД нет. Возвращает, сколько
+// операций убрано из отставания flush'а. Вызывается под mu.
+func (w *WalletState) markReplayed(replayed map[uuid.UUID]bool, change *models.WalletChange) (count int) {
+	var removed int64
+	keep := func(ops []models.Operation) []models.Operation {
+		kept := ops[:0]
+		for _, operation := range ops {
+			if replayed[operation.ID] {
+				removed += operation.Amount
+				count++
+				continue
+			}
+			kept = append(kept, operation)
+		}
+		return kept
+	}
+	w.parked = keep(w.parked)
+	if len(w.parked) == 0 {
+		w.parkedSeq.Store(0)
+		if w.shard != nil {
+			w.shard.setParked(w, false)
+		}
+	}
+	// Операции могли вернуться в очередь и из журнала после перезапуска.
+	w.ops = keep(w.ops)
+
+	switch {
+	case len(w.inflight) > 0:
+		// Записанные операции переехали из parked в строку БД, и balance от этого
+		// не меняется. Саму строку применит markFlushed.
+		if change != nil {
+			w.applyChange(*change)
+		}
+	case change != nil && change.Version > w.version:
+		w.setRow(*change)
+	default:
+		// Строка с записанными операциями уже принята через applyChange, и они посчитаны дважды.
+		w.balance.Add(-removed)
+	}
+	if len(w.ops) == 0 && len(w.inflight) == 0 {
+		w.dirty.Store(false)
+		w.pendingSeq.Store(0)
+	}
+	return count
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryDeadLetters — DeadLetterStore в памяти.
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	letters map[uuid.UUID]models.DeadLetter
+	putErr  error
+}
+
+func newMemoryDeadLetters() *memoryDeadLetters {
+	return &memoryDeadLetters{letters: make(map[uuid.UUID]models.DeadLetter)}
+}
+
+func (m *memoryDeadLetters) Put(ctx context.Context, letter models.DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.putErr != nil {
+		return m.putErr
+	}
+	m.letters[letter.ID] = letter
+	return nil
+}
+
+func (m *memoryDeadLetters) Get(ctx context.Context, id uuid.UUID) (*models.DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	letter, ok := m.letters[id]
+	if !ok {
+		return nil, custom_err.ErrNotFound
+	}
+	return &letter, nil
+}
+
+func (m *memoryDeadLetters) List(ctx context.Context) ([]models.DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	letters := make([]models.DeadLetter, 0, len(m.letters))
+	for _, letter := range m.letters {
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (m *memoryDeadLetters) Delete(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.letters, id)
+	return nil
+}
+
+func TestWalletService_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	errDB := errors.New("connection refused")
+
+	type setup struct {
+		service  *WalletService
+		store    *memoryDeadLetters
+		walletID uuid.UUID
+		// bulkErr — ошибка, которую вернёт следующая запись в БД.
+		bulkErr *error
+		applied *[]models.Operation
+	}
+	newSetup := func(t *testing.T, opts ...Option) setup {
+		var bulkErr error
+		var applied []models.Operation
+		store := newMemoryDeadLetters()
+		walletID := uuid.New()
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+			},
+			BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+				if bulkErr != nil {
+					return nil, bulkErr
+				}
+				applied = append(applied, ops...)
+				return []models.WalletChange{{ID: walletID, Balance: 100 + sumAmounts(applied), Version: 2, Status: models.WalletActive}}, nil
+			},
+		}, nil, append([]Option{WithDeadLetters(store)}, opts...)...)
+		return setup{service: service, store: store, walletID: walletID, bulkErr: &bulkErr, applied: &applied}
+	}
+	deposit := func(walletID uuid.UUID, amount int64) models.WalletOperationRequest {
+		return models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: amount}
+	}
+	// failFlush снимает снимок кошелька и отправляет его в dead letter, как retryWorker после последней попытки.
+	failFlush := func(t *testing.T, s setup) {
+		snapshots := flattenGroups(s.service.collectDirty(s.service.getShard(s.walletID), maxBatchSize))
+		require.Len(t, snapshots, 1)
+		s.service.deadLetter(snapshots, maxFlushAttempts+1, errDB)
+	}
+
+	t.Run("Failed snapshot is stored and stays unpersisted", func(t *testing.T) {
+		s := newSetup(t)
+		_, err := s.service.UpdateBalance(ctx, deposit(s.walletID, 50))
+		require.NoError(t, err)
+		failFlush(t, s)
+
+		letters, err := s.service.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, letters, 1)
+		assert.Equal(t, []uuid.UUID{s.walletID}, letters[0].WalletIDs)
+		require.Len(t, letters[0].Operations, 1)
+		assert.Equal(t, int64(50), letters[0].Operations[0].Amount)
+		assert.Equal(t, maxFlushAttempts+1, letters[0].Attempts)
+		assert.Equal(t, errDB.Error(), letters[0].LastError)
+		assert.Equal(t, int64(1), s.service.metrics.deadLetters.Load())
+		assert.Equal(t, int64(1), s.service.Backlog().PendingOps, "parked operations stay in flush backlog")
+		assert.Equal(t, int64(1), s.service.countDirty())
+
+		// Операции остаются в балансе, но flusher их больше не берёт.
+		wallet, err := s.service.GetWalletByID(ctx, s.walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(150), wallet.Balance)
+		assert.Empty(t, s.service.collectDirty(s.service.getShard(s.walletID), maxBatchSize))
+
+		// Кошелек не вытесняется и попадает в отчёт о незаписанных.
+		assert.Zero(t, s.service.evictIdle(time.Now().Add(time.Hour)))
+		unpersisted := s.service.unpersistedWallets()
+		require.Len(t, unpersisted, 1)
+		assert.Equal(t, UnpersistedWallet{ID: s.walletID, Balance: 150, PendingOps: 1}, unpersisted[0])
+	})
+
+	t.Run("Replay persists operations and releases the wallet", func(t *testing.T) {
+		s := newSetup(t)
+		_, err := s.service.UpdateBalance(ctx, deposit(s.walletID, 50))
+		require.NoError(t, err)
+		failFlush(t, s)
+		_, err = s.service.UpdateBalance(ctx, deposit(s.walletID, 7))
+		require.NoError(t, err)
+
+		letters, err := s.service.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, letters, 1)
+
+		*s.bulkErr = nil
+		require.NoError(t, s.service.ReplayDeadLetter(ctx, letters[0].ID))
+		assert.Empty(t, s.store.letters)
+		require.Len(t, *s.applied, 1)
+		assert.Equal(t, int64(50), (*s.applied)[0].Amount)
+
+		// Более поздняя операция ещё не записана и остаётся в балансе.
+		wallet, err := s.service.GetWalletByID(ctx, s.walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(157), wallet.Balance)
+		state := s.service.cachedState(s.walletID)
+		assert.Empty(t, state.parked)
+		assert.True(t, state.dirty.Load())
+		assert.Equal(t, int64(1), s.service.Backlog().PendingOps)
+
+		_, err = s.service.FlushAll(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, s.service.unpersistedWallets())
+
+		assert.ErrorIs(t, s.service.ReplayDeadLetter(ctx, letters[0].ID), custom_err.ErrNotFound)
+	})
+
+	t.Run("Parked operations hold the journal until replay", func(t *testing.T) {
+		j := openServiceJournal(t, t.TempDir())
+		defer j.Close()
+		s := newSetup(t, WithJournal(j))
+		_, err := s.service.UpdateBalance(ctx, deposit(s.walletID, 50))
+		require.NoError(t, err)
+		failFlush(t, s)
+
+		state := s.service.cachedState(s.walletID)
+		assert.False(t, state.dirty.Load())
+		require.NotZero(t, state.parkedSeq.Load())
+		assert.Equal(t, state.parkedSeq.Load(), s.service.journalWatermark(), "journal must keep parked records")
+
+		letters, err := s.service.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.NoError(t, s.service.ReplayDeadLetter(ctx, letters[0].ID))
+		assert.Zero(t, state.parkedSeq.Load())
+		assert.Equal(t, j.NextSeq(), s.service.journalWatermark())
+		assert.Zero(t, s.service.countDirty())
+		assert.Zero(t, s.service.Backlog().PendingOps)
+	})
+
+	t.Run("Replay writes from the cached row version", func(t *testing.T) {
+		store := newMemoryDeadLetters()
+		walletID := uuid.New()
+		var seen []map[uuid.UUID]int64
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+			},
+			GetWalletStatesFunc: func(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+				// Строку изменили в обход кэша: +20 и версия 3.
+				return []models.WalletChange{{ID: walletID, Balance: 120, Version: 3, Status: models.WalletActive}}, nil
+			},
+			BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+				seen = append(seen, maps.Clone(versions))
+				if versions[walletID] != 3 {
+					return nil, &custom_err.ConflictError{WalletIDs: []uuid.UUID{walletID}}
+				}
+				return []models.WalletChange{{ID: walletID, Balance: 120 + sumAmounts(ops), Version: 4, Status: models.WalletActive}}, nil
+			},
+		}, nil, WithDeadLetters(store))
+		_, err := service.UpdateBalance(ctx, deposit(walletID, 50))
+		require.NoError(t, err)
+		snapshots := flattenGroups(service.collectDirty(service.getShard(walletID), maxBatchSize))
+		require.Len(t, snapshots, 1)
+		service.deadLetter(snapshots, maxFlushAttempts+1, errDB)
+		letters, err := service.ListDeadLetters(ctx)
+		require.NoError(t, err)
+
+		require.NoError(t, service.ReplayDeadLetter(ctx, letters[0].ID))
+		assert.Equal(t, []map[uuid.UUID]int64{{walletID: 1}, {walletID: 3}}, seen)
+		assert.Equal(t, int64(1), service.metrics.flushConflicts.Load())
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(170), wallet.Balance)
+	})
+
+	t.Run("Failed replay keeps the letter", func(t *testing.T) {
+		s := newSetup(t)
+		_, err := s.service.UpdateBalance(ctx, deposit(s.walletID, 50))
+		require.NoError(t, err)
+		failFlush(t, s)
+		letters, err := s.service.ListDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, letters, 1)
+
+		*s.bulkErr = errDB
+		assert.ErrorIs(t, s.service.ReplayDeadLetter(ctx, letters[0].ID), errDB)
+		assert.Len(t, s.store.letters, 1)
+		assert.Len(t, s.service.unpersistedWallets(), 1)
+	})
+
+	t.Run("Replay does not double count a version applied by the change feed", func(t *testing.T) {
+		s := newSetup(t)
+		_, err := s.service.UpdateBalance(ctx, deposit(s.walletID, 50))
+		require.NoError(t, err)
+		failFlush(t, s)
+		letters, err := s.service.ListDeadLetters(ctx)
+		require.NoError(t, err)
+
+		// Уведомление о записи replay'я пришло раньше, чем replay обновил кэш.
+		s.service.applyChange(models.WalletChange{ID: s.walletID, Balance: 150, Version: 2, Status: models.WalletActive})
+		require.NoError(t, s.service.ReplayDeadLetter(ctx, letters[0].ID))
+
+		wallet, err := s.service.GetWalletByID(ctx, s.walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(150), wallet.Balance)
+	})
+
+	t.Run("Snapshot returns to flusher when the store fails", func(t *testing.T) {
+		s := newSetup(t)
+		s.store.putErr = errors.New("disk full")
+		_, err := s.service.UpdateBalance(ctx, deposit(s.walletID, 50))
+		require.NoError(t, err)
+		failFlush(t, s)
+
+		assert.Empty(t, s.store.letters)
+		state := s.service.cachedState(s.walletID)
+		assert.Empty(t, state.parked)
+		assert.Len(t, state.ops, 1)
+		assert.True(t, state.dirty.Load())
+	})
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxEvictInterval ограничивает период обхода кэша при большом idle TTL.
+	maxEvictInterval = time.Minute
+	// trimSlack — доля лимита шарда, освобождаемая за раз, чтобы не сортировать
+	// шард на каждой вставке сверх лимита.
+	trimSlack = 10
+)
+
+// errStateEvicted означает, что состояние кошелька выброшено из кэша, пока
+// вызывающий держал на него ссылку; его нужно загрузить заново.
+var errStateEvicted = errors.New("состояние кошелька выброшено из кэша")
+
+// WithCacheLimit ограничивает число кошельков в кэше. При превышении
+// выбрасываются давно не использованные кошельки, все изменения которых уже в БД;
+// если таких нет, кэш временно превышает лимит. 0 — без ограничения.
+func WithCacheLimit(maxWallets int) Option {
+	return func(s *WalletService) {
+		limit := 0
+		if maxWallets > 0 {
+			limit = (maxWallets + numShards - 1) / numShards
+		}
+		for _, shard := range s.shards {
+			shard.limit = limit
+		}
+	}
+}
+
+// WithCacheTTL включает фоновое удаление из кэша кошельков, к которым не
+// обращались дольше idle и изменения которых уже в БД. 0 — не удалять.
+func WithCacheTTL(idle time.Duration) Option {
+	return func(s *WalletService) {
+		s.cacheTTL = idle
+	}
+}
+
+// retryEvicted повторяет fn, если состояние кошелька выбросили из кэша между
+// загрузкой и блокировкой: до блокировки fn ничего не меняет.
+func retryEvicted[T any](fn func() (T, error)) (T, error) {
+	for {
+		result, err := fn()
+		if !errors.Is(err, errStateEvicted) {
+			return result, err
+		}
+	}
+}
+
+// touch отмечает обращение к кошельку для LRU и TTL.
+func (w *WalletState) touch() {
+	w.lastAccess.Store(time.Now().UnixNano())
+}
+
+// tryEvict выбрасывает кошелек из кэша, если все его изменения уже в БД.
+// Занятые кошельки пропускаются. Вызывается под s.mu на запись.
+func (s *Shard) tryEvict(id uuid.UUID, state *WalletState) bool {
+	if state.dirty.Load() || state.flushing.Load() {
+		return false
+	}
+	if !state.mu.TryLock() {
+		return false
+	}
+	defer state.mu.Unlock()
+	if state.unpersisted() {
+		return false
+	}
+	state.evicted = true
+	delete(s.wallets, id)
+	return true
+}
+
+// trim приводит шард к лимиту, выбрасывая давно не использованные кошельки.
+// Вызывается под s.mu на запись.
+func (s *Shard) trim() {
+	if s.limit <= 0 || len(s.wallets) <= s.limit {
+		return
+	}
+
+	type candidate struct {
+		id         uuid.UUID
+		state      *WalletState
+		lastAccess int64
+	}
+	candidates := make([]candidate, 0, len(s.wallets))
+	for id, state := range s.wallets {
+		candidates = append(candidates, candidate{id: id, state: state, lastAccess: state.lastAccess.Load()})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess < candidates[j].lastAccess
+	})
+
+	target := s.limit - s.limit/trimSlack
+	for _, c := range candidates {
+		if len(s.wallets) <= target {
+			break
+		}
+		if s.tryEvict(c.id, c.state) {
+			s.metrics.evictedLRU.Add(1)
+		}
+	}
+}
+
+// cacheEvictor периодически выбрасывает из кэша кошельки, простаивающие дольше cacheTTL.
+func (s *WalletService) cacheEvictor() {
+	interval := max(min(s.cacheTTL/2, maxEvictInterval), time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		if evicted := s.evictIdle(time.Now().Add(-s.cacheTTL)); evicted > 0 {
+			log.Printf("[Cache] Evicted %d idle wallets", evicted)
+		}
+	}
+}
+
+// evictIdle выбрасывает чистые кошельки, к которым не обращались с before.
+func (s *WalletService) evictIdle(before time.Time) int {
+	threshold := before.UnixNano()
+	evicted := 0
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		for id, state := range shard.wallets {
+			if state.lastAccess.Load() < threshold && shard.tryEvict(id, state) {
+				evicted++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	s.metrics.evictedTTL.Add(int64(evicted))
+	return evicted
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walletsInShard возвращает n кошельков одного шарда.
+func walletsInShard(index, n int) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, n)
+	for len(ids) < n {
+		if id := uuid.New(); shardIndex(id) == index {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestWalletService_Eviction(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func(opts ...Option) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Status: models.WalletActive}, nil
+			},
+		}, nil, opts...)
+	}
+	deposit := func(walletID uuid.UUID) models.WalletOperationRequest {
+		return models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10}
+	}
+
+	t.Run("Least recently used clean wallets are evicted over the limit", func(t *testing.T) {
+		// 10 кошельков на шард.
+		service := newService(WithCacheLimit(numShards * 10))
+		ids := walletsInShard(0, 11)
+
+		for _, id := range ids[:10] {
+			_, err := service.GetWalletByID(ctx, id)
+			require.NoError(t, err)
+		}
+		// Первый кошелек становится самым свежим и не должен быть вытеснен.
+		service.getShard(ids[0]).wallets[ids[0]].lastAccess.Store(time.Now().Add(time.Hour).UnixNano())
+		_, err := service.GetWalletByID(ctx, ids[10])
+		require.NoError(t, err)
+
+		shard := service.shards[0]
+		assert.LessOrEqual(t, len(shard.wallets), 10)
+		assert.Contains(t, shard.wallets, ids[0])
+		assert.Contains(t, shard.wallets, ids[10])
+		assert.NotContains(t, shard.wallets, ids[1])
+		assert.Equal(t, int64(11-len(shard.wallets)), service.metrics.evictedLRU.Load())
+	})
+
+	t.Run("Dirty wallets are never evicted", func(t *testing.T) {
+		service := newService(WithCacheLimit(numShards * 10))
+		ids := walletsInShard(0, 15)
+
+		for _, id := range ids[:10] {
+			_, err := service.UpdateBalance(ctx, deposit(id))
+			require.NoError(t, err)
+		}
+		for _, id := range ids[10:] {
+			_, err := service.GetWalletByID(ctx, id)
+			require.NoError(t, err)
+		}
+
+		shard := service.shards[0]
+		for _, id := range ids[:10] {
+			assert.Contains(t, shard.wallets, id)
+		}
+	})
+
+	t.Run("Idle clean wallets are evicted after TTL", func(t *testing.T) {
+		service := newService(WithCacheTTL(time.Minute))
+		clean, dirty := walletsInDifferentShards()
+		_, err := service.GetWalletByID(ctx, clean)
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, deposit(dirty))
+		require.NoError(t, err)
+
+		assert.Zero(t, service.evictIdle(time.Now().Add(-time.Minute)), "recently used wallets stay")
+		assert.Equal(t, 1, service.evictIdle(time.Now().Add(time.Second)))
+		assert.Nil(t, service.cachedState(clean))
+		assert.NotNil(t, service.cachedState(dirty))
+		assert.Equal(t, int64(1), service.metrics.evictedTTL.Load())
+	})
+
+	t.Run("Writer holding an evicted state reloads the wallet", func(t *testing.T) {
+		service := newService()
+		walletID := uuid.New()
+		_, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+
+		stale := service.cachedState(walletID)
+		require.Equal(t, 1, service.evictIdle(time.Now().Add(time.Second)))
+
+		_, err = service.applyOperation(stale, models.Operation{ID: uuid.New(), WalletID: walletID, Amount: 10})
+		assert.ErrorIs(t, err, errStateEvicted)
+		assert.Equal(t, int64(100), stale.balance.Load(), "evicted state must not be changed")
+
+		_, err = service.UpdateBalance(ctx, deposit(walletID))
+		require.NoError(t, err)
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(110), wallet.Balance)
+	})
+}
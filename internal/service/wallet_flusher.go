@@ -1,13 +1,29 @@
 package service
 
 import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// walletSnapshot — незаписанные операции кошелька, которые пишутся в БД одной транзакцией.
+type walletSnapshot struct {
+	id    uuid.UUID
+	state *WalletState
+	seq   uint64
+	ops   []models.Operation
+	// version — версия строки, от которой считан баланс кошелька; 0 — неизвестна.
+	version int64
+}
+
 func (s *WalletService) flusher(workerID int) {
 	ticker := time.NewTicker(flushInterval)
 	defer ticker.Stop()
@@ -16,111 +32,338 @@ func (s *WalletService) flusher(workerID int) {
 	startShard := workerID * shardsPerWorker
 	endShard := startShard + shardsPerWorker
 
-	for range ticker.C {
+	health := &s.flushWorkers[workerID]
+	health.lastSuccess.Store(time.Now().UnixNano())
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
 		totalFlushed := 0
+		failed := false
 
 		for i := startShard; i < endShard; i++ {
-			shard := s.shards[i]
-
-			shard.mu.RLock()
-			dirtyCount := 0
-			for _, state := range shard.wallets {
-				if state.dirty.Load() {
-					dirtyCount++
-				}
-			}
-
-			if dirtyCount == 0 {
-				shard.mu.RUnlock()
+			groups := s.collectDirty(s.shards[i], maxBatchSize)
+			if len(groups) == 0 {
 				continue
 			}
+			snapshots := flattenGroups(groups)
 
-			batchSize := min(dirtyCount, maxBatchSize)
-			dirtyWallets := make(map[uuid.UUID]int64, batchSize)
-			dirtyStates := make(map[uuid.UUID]*WalletState, batchSize)
+			changes, err := s.persistSnapshots(snapshots)
+			if err != nil {
+				failed = true
+				s.metrics.flushesFailed.Add(1)
+				log.Printf("[Worker %d] Flush failed: %v, queueing %d wallets for retry",
+					workerID, err, len(snapshots))
 
-			collected := 0
-			for id, state := range shard.wallets {
-				if collected >= batchSize {
-					break
-				}
-				if state.dirty.Load() {
-					dirtyWallets[id] = state.balance.Load()
-					dirtyStates[id] = state
-					collected++
+				for _, group := range groups {
+					select {
+					case s.retryQueue <- retryItem{snapshots: group, err: err}:
+						s.metrics.retriesTotal.Add(1)
+					default:
+						log.Printf("[Worker %d] Retry queue full, sending %d wallets to dead letter", workerID, len(group))
+						s.deadLetter(group, 1, err)
+					}
 				}
+				continue
 			}
-			shard.mu.RUnlock()
 
-			if len(dirtyWallets) == 0 {
+			s.completeSnapshots(snapshots, changes)
+			totalFlushed += len(snapshots)
+		}
+
+		if !failed {
+			health.lastSuccess.Store(time.Now().UnixNano())
+		}
+		if totalFlushed > 0 {
+			log.Printf("[Worker %d] Flushed %d wallets", workerID, totalFlushed)
+		}
+	}
+}
+
+// FlushAll синхронно записывает в БД все грязные кошельки и ждёт, пока допишутся
+// кошельки, которые в этот момент пишут фоновые воркеры. Возвращает число
+// записанных кошельков.
+func (s *WalletService) FlushAll(ctx context.Context) (int, error) {
+	flushed := 0
+	for _, shard := range s.shards {
+		n, err := s.flushShard(ctx, shard)
+		flushed += n
+		if err != nil {
+			return flushed, err
+		}
+	}
+	return flushed, nil
+}
+
+// flushShard синхронно записывает в БД все грязные кошельки шарда.
+func (s *WalletService) flushShard(ctx context.Context, shard *Shard) (int, error) {
+	flushed := 0
+	for s.hasDirty(shard) {
+		groups := s.collectDirty(shard, maxBatchSize)
+		if len(groups) == 0 {
+			// Остальные грязные кошельки сейчас пишет кто-то другой.
+			select {
+			case <-time.After(10 * time.Millisecond):
 				continue
+			case <-ctx.Done():
+				return flushed, ctx.Err()
 			}
+		}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			err := s.repo.BulkUpdateBalances(ctx, dirtyWallets)
-			cancel()
+		snapshots := flattenGroups(groups)
+		changes, err := s.persistSnapshots(snapshots)
+		if err != nil {
+			s.metrics.flushesFailed.Add(1)
+			s.releaseSnapshots(snapshots)
+			return flushed, err
+		}
+		s.completeSnapshots(snapshots, changes)
+		flushed += len(snapshots)
+	}
+	return flushed, nil
+}
 
-			s.metrics.flushesTotal.Add(1)
+// flushWallet синхронно записывает в БД незаписанные операции кошелька вместе
+// с группой связанных переводами кошельков. Если кошелек сейчас пишет кто-то
+// другой, ждёт немного и возвращается: вызывающий проверит кошелек снова.
+// Вызывается без mu.
+func (s *WalletService) flushWallet(ctx context.Context, ref stateRef) error {
+	snapshots, ok := s.snapshotGroup(ref)
+	if !ok {
+		select {
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	changes, err := s.persistSnapshots(snapshots)
+	if err != nil {
+		s.metrics.flushesFailed.Add(1)
+		s.releaseSnapshots(snapshots)
+		return err
+	}
+	s.completeSnapshots(snapshots, changes)
+	return nil
+}
 
-			if err != nil {
-				s.metrics.flushesFailed.Add(1)
-				log.Printf("[Worker %d] Flush failed: %v, queueing %d wallets for retry",
-					workerID, err, len(dirtyWallets))
+func (s *WalletService) hasDirty(shard *Shard) bool {
+	found := false
+	shard.forEachDirty(func(stateRef) bool {
+		found = true
+		return false
+	})
+	return found
+}
 
-				for id, balance := range dirtyWallets {
-					select {
-					case s.retryQueue <- retryItem{walletID: id, balance: balance}:
-						s.metrics.retriesTotal.Add(1)
-					default:
-						log.Printf("[Worker %d] Retry queue full!", workerID)
-					}
+// collectDirty захватывает до limit грязных кошельков шарда и снимает с них снимки.
+// Кошельки, связанные переводами, попадают в одну группу, чтобы обе проводки
+// перевода ушли в БД одной транзакцией. Кошельки, которые уже пишет кто-то
+// другой, пропускаются до следующего тика.
+func (s *WalletService) collectDirty(shard *Shard, limit int) [][]walletSnapshot {
+	var groups [][]walletSnapshot
+	for _, c := range shard.dirtyStates(limit) {
+		if group, ok := s.snapshotGroup(c); ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// snapshotGroup захватывает кошелёк root вместе со всеми кошельками, с которыми
+// его незаписанные операции связаны переводами, и снимает снимки со всей группы
+// под их mu. Если кого-то из группы уже пишут, группа отпускается целиком.
+func (s *WalletService) snapshotGroup(root stateRef) ([]walletSnapshot, bool) {
+	if !root.state.flushing.CompareAndSwap(false, true) {
+		return nil, false
+	}
+	group := []stateRef{root}
+	members := map[uuid.UUID]bool{root.id: true}
+
+	for {
+		unlock := lockStates(group)
+		var missing []uuid.UUID
+		for _, ref := range group {
+			for _, operation := range ref.state.ops {
+				if operation.CounterpartyID != nil && !members[*operation.CounterpartyID] {
+					members[*operation.CounterpartyID] = true
+					missing = append(missing, *operation.CounterpartyID)
 				}
-			} else {
-				for id, state := range dirtyStates {
-					if state.balance.Load() == dirtyWallets[id] {
-						state.dirty.Store(false)
-					}
+			}
+		}
+		// Группа не делится, каким бы ни был её размер: иначе проводки одного
+		// перевода попали бы в разные транзакции.
+		if len(missing) == 0 {
+			snapshots := make([]walletSnapshot, 0, len(group))
+			for _, ref := range group {
+				seq, ops := ref.state.snapshot()
+				snapshots = append(snapshots, walletSnapshot{id: ref.id, state: ref.state, seq: seq, ops: ops, version: ref.state.version})
+			}
+			unlock()
+			return snapshots, true
+		}
+		unlock()
+
+		for _, id := range missing {
+			state := s.cachedState(id)
+			if state == nil {
+				// Кошелька нет в кэше — его проводки уже в БД.
+				continue
+			}
+			if !state.flushing.CompareAndSwap(false, true) {
+				for _, ref := range group {
+					ref.state.flushing.Store(false)
 				}
-				totalFlushed += len(dirtyWallets)
+				return nil, false
 			}
+			group = append(group, stateRef{id: id, state: state})
 		}
+	}
+}
 
-		if totalFlushed > 0 {
-			log.Printf("[Worker %d] Flushed %d wallets", workerID, totalFlushed)
+func (s *WalletService) cachedState(id uuid.UUID) *WalletState {
+	shard := s.getShard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.wallets[id]
+}
+
+func flattenGroups(groups [][]walletSnapshot) []walletSnapshot {
+	var snapshots []walletSnapshot
+	for _, group := range groups {
+		snapshots = append(snapshots, group...)
+	}
+	return snapshots
+}
+
+// persistSnapshots записывает операции снимков в БД и возвращает строки
+// кошельков после записи. Спан записи начинает новый трейс и ссылается на
+// трейсы запросов, чьи операции записываются.
+func (s *WalletService) persistSnapshots(snapshots []walletSnapshot) (_ []models.WalletChange, err error) {
+	ids := make([]uuid.UUID, 0, len(snapshots))
+	versions := make(map[uuid.UUID]int64, len(snapshots))
+	var ops []models.Operation
+	for _, snap := range snapshots {
+		ids = append(ids, snap.id)
+		ops = append(ops, snap.ops...)
+		if snap.version != 0 {
+			versions[snap.id] = snap.version
 		}
 	}
+
+	ctx, span := tracer.Start(context.Background(), "WalletService.flush",
+		trace.WithNewRoot(),
+		trace.WithLinks(s.traces.links(snapshots)...),
+		trace.WithAttributes(attribute.Int("flush.wallets", len(ids)), attribute.Int("flush.operations", len(ops))))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	s.metrics.flushesTotal.Add(1)
+	start := time.Now()
+	changes, err := s.bulkApply(ctx, ids, ops, versions)
+	s.metrics.flushDuration.Observe(time.Since(start).Seconds())
+	return changes, err
+}
+
+// bulkApply записывает операции кошельков ids, если их строки всё ещё в версиях
+// versions. Если строки изменили в обход кэша, кэш сверяется с ними, и запись
+// повторяется один раз от новых версий; следующий конфликт возвращается вызывающему.
+func (s *WalletService) bulkApply(ctx context.Context, ids []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+	changes, err := s.repo.BulkApplyOperations(ctx, ids, ops, versions)
+	var conflict *custom_err.ConflictError
+	if errors.As(err, &conflict) {
+		if err = s.reconcileConflict(ctx, conflict.WalletIDs, versions); err == nil {
+			changes, err = s.repo.BulkApplyOperations(ctx, ids, ops, versions)
+		}
+	}
+	return changes, err
+}
+
+// reconcileConflict перечитывает строки кошельков ids, которые изменились после
+// версий из versions, приводит к ним кэш и запоминает их версии в versions.
+func (s *WalletService) reconcileConflict(ctx context.Context, ids []uuid.UUID, versions map[uuid.UUID]int64) error {
+	const op = "service.reconcileConflict"
+
+	s.metrics.flushConflicts.Add(int64(len(ids)))
+	rows, err := s.repo.GetWalletStates(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, row := range rows {
+		log.Printf("[Flush] Wallet %s: %v: row was changed outside the cache, reloaded version %d",
+			row.ID, custom_err.ErrConflict, row.Version)
+		versions[row.ID] = row.Version
+		s.applyChange(row)
+	}
+	return nil
+}
+
+// completeSnapshots отмечает записанные снимки и отпускает кошельки. Кэш
+// записанных кошельков приводится к вернувшимся строкам БД.
+func (s *WalletService) completeSnapshots(snapshots []walletSnapshot, changes []models.WalletChange) {
+	byID := make(map[uuid.UUID]*models.WalletChange, len(changes))
+	for i := range changes {
+		byID[changes[i].ID] = &changes[i]
+	}
+	for _, snap := range snapshots {
+		s.metrics.pendingOps.Add(-int64(len(snap.ops)))
+		s.traces.forget(snap.ops)
+		snap.state.markFlushed(snap.seq, byID[snap.id])
+		snap.state.flushing.Store(false)
+	}
+}
+
+// releaseSnapshots отдаёт кошельки обратно flusher'у: операции возвращаются в очередь,
+// кошельки остаются грязными и будут записаны на следующем тике.
+func (s *WalletService) releaseSnapshots(snapshots []walletSnapshot) {
+	for _, snap := range snapshots {
+		snap.state.restoreOps(snap.ops)
+		snap.state.markDirty()
+		snap.state.flushing.Store(false)
+	}
 }
 
 func (s *WalletService) retryWorker(workerID int) {
-	for item := range s.retryQueue {
-		if item.attempts >= 3 {
-			log.Printf("[Retry %d] Max attempts for wallet %s", workerID, item.walletID)
+	for {
+		var item retryItem
+		select {
+		case <-s.stop:
+			return
+		case item = <-s.retryQueue:
+		}
+
+		if item.attempts >= maxFlushAttempts {
+			log.Printf("[Retry %d] Max attempts for %d wallets, sending them to dead letter", workerID, len(item.snapshots))
+			s.deadLetter(item.snapshots, item.attempts+1, item.err)
 			continue
 		}
 
 		backoff := time.Duration(1<<item.attempts) * time.Second
-		time.Sleep(backoff)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		err := s.repo.UpsertWalletBalance(ctx, item.walletID, item.balance)
-		cancel()
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			// Снимок допишет финальный flush в Stop.
+			s.releaseSnapshots(item.snapshots)
+			return
+		}
 
+		changes, err := s.persistSnapshots(item.snapshots)
 		if err != nil {
 			item.attempts++
+			item.err = err
 			select {
 			case s.retryQueue <- item:
 			default:
-				log.Printf("[Retry %d] Queue full, dropping wallet %s", workerID, item.walletID)
+				log.Printf("[Retry %d] Queue full, sending %d wallets to dead letter", workerID, len(item.snapshots))
+				s.deadLetter(item.snapshots, item.attempts+1, item.err)
 			}
 		} else {
-			shard := s.getShard(item.walletID)
-			shard.mu.Lock()
-			if state, ok := shard.wallets[item.walletID]; ok {
-				if state.balance.Load() == item.balance {
-					state.dirty.Store(false)
-				}
-			}
-			shard.mu.Unlock()
+			s.completeSnapshots(item.snapshots, changes)
 		}
 	}
 }
@@ -129,35 +372,29 @@ func (s *WalletService) metricsLogger() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		var totalWallets int64
-		var dirtyWallets int64
-
-		for i := 0; i < numShards; i++ {
-			s.shards[i].mu.RLock()
-			totalWallets += int64(len(s.shards[i].wallets))
-			for _, state := range s.shards[i].wallets {
-				if state.dirty.Load() {
-					dirtyWallets++
-				}
-			}
-			s.shards[i].mu.RUnlock()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
 		}
 
-		log.Printf("[METRICS] Wallets=%d Dirty=%d Flushes=%d Failed=%d Retries=%d QueueLen=%d",
-			totalWallets,
-			dirtyWallets,
+		log.Printf("[METRICS] Wallets=%d Dirty=%d PendingOps=%d Flushes=%d Failed=%d Conflicts=%d Retries=%d QueueLen=%d DeadLetters=%d Delayed=%d Rejected=%d EvictedLRU=%d EvictedTTL=%d LoadsShared=%d NegativeHits=%d",
+			s.cachedWallets(),
+			s.countDirty(),
+			s.metrics.pendingOps.Load(),
 			s.metrics.flushesTotal.Load(),
 			s.metrics.flushesFailed.Load(),
+			s.metrics.flushConflicts.Load(),
 			s.metrics.retriesTotal.Load(),
 			len(s.retryQueue),
+			s.metrics.deadLetters.Load(),
+			s.metrics.writesDelayed.Load(),
+			s.metrics.writesRejected.Load(),
+			s.metrics.evictedLRU.Load(),
+			s.metrics.evictedTTL.Load(),
+			s.metrics.loadsShared.Load(),
+			s.metrics.negativeHits.Load(),
 		)
 	}
 }
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
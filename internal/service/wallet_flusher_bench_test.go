@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// benchmarkService возвращает сервис с cached кошельками в кэше, dirty из которых грязные.
+func benchmarkService(b *testing.B, cached, dirty int) *WalletService {
+	b.Helper()
+	ctx := context.Background()
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+		},
+	}, nil)
+
+	for i := 0; i < cached; i++ {
+		id := uuid.New()
+		if _, err := service.GetWalletByID(ctx, id); err != nil {
+			b.Fatal(err)
+		}
+		if i < dirty {
+			req := models.WalletOperationRequest{WalletID: id, OperationType: models.DepositOperation, Amount: 1}
+			if _, err := service.UpdateBalance(ctx, req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return service
+}
+
+// BenchmarkWalletService_flushTick — поиск грязных кошельков за один тик flusher'а
+// по всем шардам. Число грязных кошельков постоянно, меняется только размер кэша.
+func BenchmarkWalletService_flushTick(b *testing.B) {
+	for _, cached := range []int{10_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("cached=%d/dirty=100", cached), func(b *testing.B) {
+			service := benchmarkService(b, cached, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, shard := range service.shards {
+					if !service.hasDirty(shard) {
+						continue
+					}
+					// Снимки возвращаются, чтобы каждый тик находил те же грязные кошельки.
+					service.releaseSnapshots(flattenGroups(service.collectDirty(shard, maxBatchSize)))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWalletService_countDirty — подсчёт грязных кошельков для метрик и backpressure.
+func BenchmarkWalletService_countDirty(b *testing.B) {
+	for _, cached := range []int{10_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("cached=%d/dirty=100", cached), func(b *testing.B) {
+			service := benchmarkService(b, cached, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if n := service.countDirty(); n != 100 {
+					b.Fatalf("dirty wallets: %d", n)
+				}
+			}
+		})
+	}
+}
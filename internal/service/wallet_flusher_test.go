@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_flushSnapshots(t *testing.T) {
+	walletID := uuid.New()
+	ctx := context.Background()
+
+	newService := func(bulk func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error)) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1}, nil
+			},
+			BulkApplyOperationsFunc: bulk,
+		}, nil)
+	}
+
+	t.Run("Operations are persisted as deltas", func(t *testing.T) {
+		var gotIDs []uuid.UUID
+		var gotOps []models.Operation
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			gotIDs, gotOps = walletIDs, ops
+			return nil, nil
+		})
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 20})
+		require.NoError(t, err)
+
+		shard := service.getShard(walletID)
+		snapshots := flattenGroups(service.collectDirty(shard, maxBatchSize))
+		require.Len(t, snapshots, 1)
+		_, err = service.persistSnapshots(snapshots)
+		require.NoError(t, err)
+
+		assert.Equal(t, []uuid.UUID{walletID}, gotIDs)
+		require.Len(t, gotOps, 2)
+		assert.Equal(t, map[uuid.UUID]int64{walletID: 30}, sumByWallet(gotOps))
+	})
+
+	t.Run("Balance follows the database row after flush", func(t *testing.T) {
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			// Другой экземпляр успел прибавить 1000.
+			return []models.WalletChange{{ID: walletID, Balance: 1150, Version: 3, Status: models.WalletActive}}, nil
+		})
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		shard := service.getShard(walletID)
+		snapshots := flattenGroups(service.collectDirty(shard, maxBatchSize))
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 10})
+		require.NoError(t, err)
+
+		changes, err := service.persistSnapshots(snapshots)
+		require.NoError(t, err)
+		service.completeSnapshots(snapshots, changes)
+
+		state := shard.wallets[walletID]
+		assert.Equal(t, int64(1140), state.balance.Load(), "database balance plus the operation not flushed yet")
+		assert.True(t, state.dirty.Load())
+	})
+
+	t.Run("Version conflict reloads the wallet and retries once", func(t *testing.T) {
+		var calls []int64
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1}, nil
+			},
+			BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+				calls = append(calls, versions[walletID])
+				if versions[walletID] == 1 {
+					return nil, &custom_err.ConflictError{WalletIDs: []uuid.UUID{walletID}}
+				}
+				return []models.WalletChange{{ID: walletID, Balance: 1150, Version: 3, Status: models.WalletActive}}, nil
+			},
+			GetWalletStatesFunc: func(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+				// Строку изменили в обход сервиса: +1000.
+				return []models.WalletChange{{ID: walletID, Balance: 1100, Version: 2, Status: models.WalletActive}}, nil
+			},
+		}, nil)
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		_, err = service.FlushAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 2}, calls, "the retry expects the reloaded version")
+		state := service.cachedState(walletID)
+		assert.Equal(t, int64(1150), state.balance.Load())
+		assert.Equal(t, int64(3), state.version)
+		assert.Equal(t, int64(1), service.metrics.flushConflicts.Load())
+	})
+
+	t.Run("Repeated conflict fails the flush", func(t *testing.T) {
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1}, nil
+			},
+			BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+				return nil, &custom_err.ConflictError{WalletIDs: []uuid.UUID{walletID}}
+			},
+			GetWalletStatesFunc: func(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+				return []models.WalletChange{{ID: walletID, Balance: 1100, Version: 2, Status: models.WalletActive}}, nil
+			},
+		}, nil)
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		_, err = service.FlushAll(ctx)
+		assert.ErrorIs(t, err, custom_err.ErrConflict)
+		state := service.cachedState(walletID)
+		assert.Equal(t, int64(1150), state.balance.Load(), "the cache follows the reloaded row and keeps the operation")
+		assert.Equal(t, int64(2), state.version)
+		assert.True(t, state.dirty.Load())
+	})
+
+	t.Run("Own flush is not a conflict", func(t *testing.T) {
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			return []models.WalletChange{{ID: walletID, Balance: 150, Version: 2, Status: models.WalletActive}}, nil
+		})
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		_, err = service.FlushAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(150), service.cachedState(walletID).balance.Load())
+		assert.Zero(t, service.metrics.flushConflicts.Load())
+	})
+
+	t.Run("Wallet being flushed is not collected twice", func(t *testing.T) {
+		service := newService(nil)
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		shard := service.getShard(walletID)
+		require.Len(t, service.collectDirty(shard, maxBatchSize), 1)
+		assert.Empty(t, service.collectDirty(shard, maxBatchSize))
+	})
+
+	t.Run("Failed snapshot returns operations to the wallet", func(t *testing.T) {
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			return nil, errors.New("db is down")
+		})
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		shard := service.getShard(walletID)
+		snapshots := flattenGroups(service.collectDirty(shard, maxBatchSize))
+		_, err = service.persistSnapshots(snapshots)
+		require.Error(t, err)
+
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10})
+		require.NoError(t, err)
+		service.releaseSnapshots(snapshots)
+
+		state := shard.wallets[walletID]
+		require.Len(t, state.ops, 2)
+		assert.Equal(t, int64(50), state.ops[0].Amount, "older operation must stay first")
+		assert.True(t, state.dirty.Load())
+		assert.False(t, state.flushing.Load())
+	})
+
+	t.Run("Wallet stays dirty while unflushed operations remain", func(t *testing.T) {
+		service := newService(nil)
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		shard := service.getShard(walletID)
+		snapshots := flattenGroups(service.collectDirty(shard, maxBatchSize))
+
+		// Баланс вернулся к снимку, но две новые операции ещё не записаны.
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 5})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 5})
+		require.NoError(t, err)
+
+		_, err = service.persistSnapshots(snapshots)
+		require.NoError(t, err)
+		snapshots[0].state.markFlushed(snapshots[0].seq, nil)
+
+		assert.True(t, shard.wallets[walletID].dirty.Load())
+	})
+}
+
+func TestShard_dirtySet(t *testing.T) {
+	ctx := context.Background()
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100, Version: 1}, nil
+		},
+	}, nil)
+	ids := walletsInShard(0, 3)
+	shard := service.shards[0]
+	for _, id := range ids {
+		_, err := service.GetWalletByID(ctx, id)
+		require.NoError(t, err)
+	}
+	assert.Empty(t, shard.dirty, "clean wallets are not tracked")
+
+	_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: ids[0], OperationType: models.DepositOperation, Amount: 10})
+	require.NoError(t, err)
+	_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: ids[1], OperationType: models.DepositOperation, Amount: 10})
+	require.NoError(t, err)
+	assert.Len(t, shard.dirty, 2)
+	assert.Equal(t, 2, shard.dirtyCount())
+
+	// Записанный кошелек убирается из набора при следующем обходе.
+	_, err = service.flushShard(ctx, shard)
+	require.NoError(t, err)
+	assert.Zero(t, shard.dirtyCount())
+	assert.Empty(t, shard.dirty)
+
+	// И возвращается в него, снова став грязным.
+	_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: ids[0], OperationType: models.DepositOperation, Amount: 10})
+	require.NoError(t, err)
+	refs := shard.dirtyStates(maxBatchSize)
+	require.Len(t, refs, 1)
+	assert.Equal(t, ids[0], refs[0].id)
+
+	// Кошелек, который уже пишут, остаётся грязным, но не выдаётся повторно.
+	require.Len(t, service.collectDirty(shard, maxBatchSize), 1)
+	assert.Empty(t, shard.dirtyStates(maxBatchSize))
+	assert.Equal(t, 1, shard.dirtyCount())
+}
+
+func TestWalletService_FlushAll(t *testing.T) {
+	ctx := context.Background()
+
+	var persisted []models.Operation
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100}, nil
+		},
+		BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			persisted = append(persisted, ops...)
+			return nil, nil
+		},
+	}, nil)
+
+	first, second := walletsInDifferentShards()
+	_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: first, OperationType: models.DepositOperation, Amount: 1})
+	require.NoError(t, err)
+	_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: second, OperationType: models.WithdrawOperation, Amount: 1})
+	require.NoError(t, err)
+
+	flushed, err := service.FlushAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, flushed)
+
+	assert.Equal(t, map[uuid.UUID]int64{first: 1, second: -1}, sumByWallet(persisted))
+	assert.False(t, service.getShard(first).wallets[first].dirty.Load())
+	assert.False(t, service.getShard(second).wallets[second].dirty.Load())
+}
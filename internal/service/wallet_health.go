@@ -0,0 +1,50 @@
+package service
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FlushWorkerHealth — состояние фонового воркера flush'а.
+type FlushWorkerHealth struct {
+	Worker int
+	// LastSuccess — конец последнего тика, в котором все шарды воркера записаны
+	// или записывать было нечего. Нулевое, если воркер не запущен.
+	LastSuccess time.Time
+}
+
+// RetryQueueHealth — заполненность очереди повторов.
+type RetryQueueHealth struct {
+	Length   int
+	Capacity int
+}
+
+// flushWorkerState — время последнего успешного тика воркера в UnixNano.
+type flushWorkerState struct {
+	lastSuccess atomic.Int64
+}
+
+// FlushWorkers возвращает состояние воркеров flush'а. Застрявший или
+// постоянно падающий воркер виден по давнему LastSuccess.
+func (s *WalletService) FlushWorkers() []FlushWorkerHealth {
+	workers := make([]FlushWorkerHealth, numFlushWorkers)
+	for i := range workers {
+		workers[i].Worker = i
+		if ts := s.flushWorkers[i].lastSuccess.Load(); ts != 0 {
+			workers[i].LastSuccess = time.Unix(0, ts)
+		}
+	}
+	return workers
+}
+
+// RetryQueue возвращает заполненность очереди повторов.
+func (s *WalletService) RetryQueue() RetryQueueHealth {
+	return RetryQueueHealth{Length: len(s.retryQueue), Capacity: cap(s.retryQueue)}
+}
+
+// Stopping сообщает, что сервис остановлен или останавливается и не принимает изменения.
+func (s *WalletService) Stopping() bool {
+	s.lifecycle.RLock()
+	defer s.lifecycle.RUnlock()
+	return s.closing
+}
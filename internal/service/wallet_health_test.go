@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_Health(t *testing.T) {
+	service := NewWalletService(&mockRepository{}, nil)
+
+	for _, w := range service.FlushWorkers() {
+		assert.True(t, w.LastSuccess.IsZero(), "workers are not started yet")
+	}
+	assert.Equal(t, RetryQueueHealth{Length: 0, Capacity: cap(service.retryQueue)}, service.RetryQueue())
+	assert.False(t, service.Stopping())
+
+	start := time.Now()
+	service.Start()
+	require.Eventually(t, func() bool {
+		for _, w := range service.FlushWorkers() {
+			if w.LastSuccess.Before(start) {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := service.Stop(context.Background())
+	require.NoError(t, err)
+	assert.True(t, service.Stopping())
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+// ListOperations возвращает страницу истории кошелька, новые операции первыми.
+// Операции, ещё не записанные в БД, берутся из кэша, поэтому история согласована
+// с балансом, который отдаёт GetWalletByID.
+func (s *WalletService) ListOperations(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) (_ *models.OperationPage, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.ListOperations", trace.WithAttributes(walletAttr(walletID)))
+	defer func() { endSpan(span, err) }()
+	const op = "service.ListOperations"
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultHistoryLimit
+	}
+	if filter.Limit > MaxHistoryLimit {
+		filter.Limit = MaxHistoryLimit
+	}
+
+	leave, err := s.enterShards(walletID)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	state, err := s.getShard(walletID).loadStateIntoCacheIfExists(ctx, walletID, s.repo)
+	if err != nil {
+		if errors.Is(err, custom_err.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Сначала кэш, потом БД: операция, записанная между чтениями, попадёт хотя бы в одно из них.
+	pending := state.pendingOps()
+
+	// Лишняя запись показывает, есть ли следующая страница.
+	dbFilter := filter
+	dbFilter.Limit = filter.Limit + 1
+	stored, err := s.repo.ListOperations(ctx, walletID, dbFilter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	seen := make(map[uuid.UUID]bool, len(stored))
+	items := make([]models.Operation, 0, len(stored)+len(pending))
+	for _, operation := range stored {
+		seen[operation.ID] = true
+		items = append(items, operation)
+	}
+	for _, operation := range pending {
+		if !seen[operation.ID] && filter.Matches(operation) {
+			seen[operation.ID] = true
+			items = append(items, operation)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.After(items[j].CreatedAt)
+		}
+		return bytes.Compare(items[i].ID[:], items[j].ID[:]) > 0
+	})
+
+	page := &models.OperationPage{Items: items}
+	if len(items) > filter.Limit {
+		page.Items = items[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = models.OperationCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+	}
+	return page, nil
+}
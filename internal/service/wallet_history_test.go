@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyRepository имитирует таблицу operations: фильтрует, сортирует и ограничивает выборку как БД.
+func historyRepository(stored *[]models.Operation) *mockRepository {
+	return &mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 1000}, nil
+		},
+		ListOperationsFunc: func(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) ([]models.Operation, error) {
+			var result []models.Operation
+			for _, op := range *stored {
+				if op.WalletID == walletID && filter.Matches(op) {
+					result = append(result, op)
+				}
+			}
+			sort.Slice(result, func(i, j int) bool {
+				if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+					return result[i].CreatedAt.After(result[j].CreatedAt)
+				}
+				return bytes.Compare(result[i].ID[:], result[j].ID[:]) > 0
+			})
+			if len(result) > filter.Limit {
+				result = result[:filter.Limit]
+			}
+			return result, nil
+		},
+		BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			*stored = append(*stored, ops...)
+			return nil, nil
+		},
+	}
+}
+
+func TestWalletService_ListOperations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Unflushed operations are included", func(t *testing.T) {
+		var stored []models.Operation
+		service := NewWalletService(historyRepository(&stored), nil)
+		walletID := uuid.New()
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 20})
+		require.NoError(t, err)
+
+		page, err := service.ListOperations(ctx, walletID, models.OperationFilter{})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 2)
+		assert.Empty(t, page.NextCursor)
+
+		var sum int64
+		for _, op := range page.Items {
+			sum += op.Amount
+		}
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, wallet.Balance, 1000+sum, "history must be consistent with the balance")
+	})
+
+	t.Run("Operation being flushed is listed once", func(t *testing.T) {
+		var stored []models.Operation
+		service := NewWalletService(historyRepository(&stored), nil)
+		walletID := uuid.New()
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+
+		// Снимок уже в БД, но ещё не отмечен записанным.
+		snapshots := flattenGroups(service.collectDirty(service.getShard(walletID), maxBatchSize))
+		_, err = service.persistSnapshots(snapshots)
+		require.NoError(t, err)
+
+		page, err := service.ListOperations(ctx, walletID, models.OperationFilter{})
+		require.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+	})
+
+	t.Run("Pages cover history without gaps", func(t *testing.T) {
+		var stored []models.Operation
+		service := NewWalletService(historyRepository(&stored), nil)
+		walletID := uuid.New()
+
+		base := time.Now().UTC().Truncate(time.Microsecond).Add(-time.Hour)
+		for i := 0; i < 7; i++ {
+			stored = append(stored, models.Operation{
+				ID: uuid.New(), WalletID: walletID, Type: models.DepositOperation, Amount: 1,
+				// Две операции с одинаковым временем проверяют порядок по id.
+				CreatedAt: base.Add(time.Duration(i/2) * time.Minute),
+			})
+		}
+		for i := 0; i < 3; i++ {
+			_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 1})
+			require.NoError(t, err)
+		}
+
+		var all []models.Operation
+		filter := models.OperationFilter{Limit: 3}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 10, "pagination must terminate")
+			page, err := service.ListOperations(ctx, walletID, filter)
+			require.NoError(t, err)
+			all = append(all, page.Items...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor, err := models.ParseOperationCursor(page.NextCursor)
+			require.NoError(t, err)
+			filter.Cursor = &cursor
+		}
+
+		require.Len(t, all, 10)
+		seen := make(map[uuid.UUID]bool)
+		for i, op := range all {
+			assert.False(t, seen[op.ID], "operation listed twice")
+			seen[op.ID] = true
+			if i > 0 {
+				prev := models.OperationCursor{CreatedAt: all[i-1].CreatedAt, ID: all[i-1].ID}
+				assert.True(t, prev.After(op), "operations must be ordered newest first")
+			}
+		}
+	})
+
+	t.Run("Filters apply to unflushed operations", func(t *testing.T) {
+		var stored []models.Operation
+		service := NewWalletService(historyRepository(&stored), nil)
+		walletID := uuid.New()
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 20})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 200})
+		require.NoError(t, err)
+
+		minAmount, maxAmount := int64(10), int64(100)
+		page, err := service.ListOperations(ctx, walletID, models.OperationFilter{
+			Types:     []models.OperationType{models.WithdrawOperation},
+			MinAmount: &minAmount,
+			MaxAmount: &maxAmount,
+		})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, int64(-20), page.Items[0].Amount)
+	})
+
+	t.Run("Error - Not Found", func(t *testing.T) {
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return nil, custom_err.ErrNotFound
+			},
+		}, nil)
+
+		_, err := service.ListOperations(ctx, uuid.New(), models.OperationFilter{})
+		assert.ErrorIs(t, err, custom_err.ErrNotFound)
+	})
+}
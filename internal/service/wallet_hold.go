@@ -0,0 +1,412 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultHoldExpiryInterval = time.Second
+	// holdExpiryBatch ограничивает число холдов, освобождаемых за один проход.
+	holdExpiryBatch = 500
+)
+
+// WithHoldExpiryInterval задаёт, как часто освобождается резерв холдов с истёкшим сроком.
+func WithHoldExpiryInterval(interval time.Duration) Option {
+	return func(s *WalletService) {
+		if interval > 0 {
+			s.holdExpiryInterval = interval
+		}
+	}
+}
+
+// CreateHold резервирует средства на кошельке до истечения срока холда.
+// Холд и резерв сначала пишутся в БД, поэтому переживают перезапуск. Повтор
+// запроса с тем же RequestID возвращает уже созданный холд.
+func (s *WalletService) CreateHold(ctx context.Context, walletID uuid.UUID, req models.CreateHoldRequest) (_ *models.Hold, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.CreateHold", trace.WithAttributes(walletAttr(walletID)))
+	defer func() { endSpan(span, err) }()
+	const op = "service.CreateHold"
+
+	if req.RequestID != uuid.Nil {
+		existing, err := s.holdByRequestID(ctx, walletID, req)
+		if !errors.Is(err, custom_err.ErrNotFound) {
+			return existing, holdError(op, err)
+		}
+	}
+
+	state, release, err := s.beginHold(ctx, walletID)
+	if err != nil {
+		return nil, holdError(op, err)
+	}
+	defer release()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	hold := models.Hold{
+		ID:        uuid.New(),
+		WalletID:  walletID,
+		Amount:    req.Amount,
+		Status:    models.HoldActive,
+		RequestID: req.RequestID,
+		ExpiresAt: now.Add(time.Duration(req.ExpiresIn) * time.Second),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	// В режиме cache списания проверяются по reserved кэша: пока транзакция
+	// холда идёт без mu, его сумма резервируется в кэше заранее.
+	var reserve int64
+	if s.consistency == ConsistencyCache {
+		if state.balance.Load()-state.reserved.Load() < hold.Amount {
+			return nil, custom_err.ErrInsufficientFunds
+		}
+		reserve = hold.Amount
+	}
+	err = s.changeFunds(ctx, state, walletID, reserve, func(tx pgx.Tx, row *models.WalletChange) error {
+		if err := checkStatus(row.Status, -hold.Amount); err != nil {
+			return err
+		}
+		if s.available(state, *row) < hold.Amount {
+			return custom_err.ErrInsufficientFunds
+		}
+		if err := s.repo.CreateHoldTx(ctx, tx, hold); err != nil {
+			return err
+		}
+		row.Reserved += hold.Amount
+		return nil
+	})
+	if errors.Is(err, custom_err.ErrDuplicateRequest) && req.RequestID != uuid.Nil {
+		// Параллельный запрос с тем же requestId успел создать холд: результат — его холд.
+		existing, err := s.holdByRequestID(ctx, walletID, req)
+		return existing, holdError(op, err)
+	}
+	if err != nil {
+		return nil, holdError(op, err)
+	}
+	return &hold, nil
+}
+
+// holdByRequestID возвращает холд, уже созданный запросом req, или
+// custom_err.ErrNotFound. Холд с другими параметрами — custom_err.ErrDuplicateRequest.
+func (s *WalletService) holdByRequestID(ctx context.Context, walletID uuid.UUID, req models.CreateHoldRequest) (*models.Hold, error) {
+	existing, err := s.repo.GetHoldByRequestID(ctx, walletID, req.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	if !existing.Matches(req) {
+		return nil, custom_err.ErrDuplicateRequest
+	}
+	return existing, nil
+}
+
+// CaptureHold списывает с кошелька весь холд или его часть. Списание остаётся
+// в истории операцией WITHDRAW, резерв холда освобождается целиком. Повтор
+// списания на ту же сумму возвращает уже списанный холд.
+func (s *WalletService) CaptureHold(ctx context.Context, walletID, holdID uuid.UUID, req models.CaptureHoldRequest) (_ *models.Hold, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.CaptureHold", trace.WithAttributes(walletAttr(walletID), attribute.String("hold.id", holdID.String())))
+	defer func() { endSpan(span, err) }()
+	const op = "service.CaptureHold"
+
+	state, release, err := s.beginHold(ctx, walletID)
+	if err != nil {
+		return nil, holdError(op, err)
+	}
+	defer release()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var hold *models.Hold
+	err = s.changeFunds(ctx, state, walletID, 0, func(tx pgx.Tx, row *models.WalletChange) error {
+		var err error
+		if hold, err = s.lockHold(ctx, tx, walletID, holdID); err != nil {
+			return err
+		}
+		amount := hold.Amount
+		if req.Amount != nil {
+			amount = *req.Amount
+		}
+		switch {
+		case hold.Status == models.HoldCaptured && hold.CapturedAmount == amount:
+			return errAlreadyApplied
+		case hold.Status != models.HoldActive:
+			return custom_err.ErrHoldNotActive
+		case !now.Before(hold.ExpiresAt):
+			// Резерв освободит holdExpirer.
+			return custom_err.ErrHoldExpired
+		case amount > hold.Amount:
+			return custom_err.ErrCaptureExceedsHold
+		}
+		if err := checkStatus(row.Status, -amount); err != nil {
+			return err
+		}
+		// Списание идёт из резерва, но операции, появившиеся после beginHold, и
+		// отложенные в dead letter в строку не попали: баланс в БД не должен уйти в минус.
+		if row.Balance < amount {
+			return custom_err.ErrInsufficientFunds
+		}
+
+		operation := models.Operation{
+			ID:        uuid.New(),
+			WalletID:  walletID,
+			Type:      models.WithdrawOperation,
+			Amount:    -amount,
+			CreatedAt: now,
+		}
+		if err := s.repo.CreateOperationTx(ctx, tx, operation); err != nil {
+			return err
+		}
+		hold.Status = models.HoldCaptured
+		hold.CapturedAmount = amount
+		hold.OperationID = &operation.ID
+		hold.UpdatedAt = now
+		if err := s.repo.UpdateHoldTx(ctx, tx, *hold); err != nil {
+			return err
+		}
+		row.Balance -= amount
+		row.Reserved -= hold.Amount
+		return nil
+	})
+	if err != nil && !errors.Is(err, errAlreadyApplied) {
+		return nil, holdError(op, err)
+	}
+	return hold, nil
+}
+
+// VoidHold отменяет холд и освобождает его резерв. Повторная отмена возвращает
+// уже отменённый холд.
+func (s *WalletService) VoidHold(ctx context.Context, walletID, holdID uuid.UUID) (_ *models.Hold, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.VoidHold", trace.WithAttributes(walletAttr(walletID), attribute.String("hold.id", holdID.String())))
+	defer func() { endSpan(span, err) }()
+	const op = "service.VoidHold"
+
+	state, release, err := s.beginHold(ctx, walletID)
+	if err != nil {
+		return nil, holdError(op, err)
+	}
+	defer release()
+
+	hold, err := s.releaseHold(ctx, state, walletID, holdID, models.HoldVoided)
+	if err != nil && !errors.Is(err, errAlreadyApplied) {
+		return nil, holdError(op, err)
+	}
+	return hold, nil
+}
+
+// beginHold регистрирует пишущую операцию над кошельком и возвращает его
+// состояние под mu. Холды меняют строку кошелька в БД, поэтому незаписанные
+// операции кошелька сначала записываются flush'ем: иначе баланс строки не
+// учитывал бы их. Если операции успевают появиться снова, попытка повторяется
+// до syncMaxRetries раз. mu отпускается на время транзакции холда, см.
+// changeFunds. release снимает всё это в обратном порядке.
+func (s *WalletService) beginHold(ctx context.Context, walletID uuid.UUID) (_ *WalletState, release func(), err error) {
+	done, err := s.beginWrite()
+	if err != nil {
+		return nil, nil, err
+	}
+	leave, err := s.enterShards(walletID)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	for attempt := 0; attempt < s.syncMaxRetries; attempt++ {
+		state, err := s.lockState(ctx, walletID)
+		if err != nil {
+			leave()
+			done()
+			return nil, nil, err
+		}
+		if len(state.ops) == 0 && len(state.inflight) == 0 {
+			return state, func() {
+				state.mu.Unlock()
+				leave()
+				done()
+			}, nil
+		}
+		state.mu.Unlock()
+
+		if err := s.flushWallet(ctx, stateRef{id: walletID, state: state}); err != nil {
+			leave()
+			done()
+			return nil, nil, err
+		}
+	}
+	leave()
+	done()
+	return nil, nil, custom_err.ErrMaxRetriesExceeded
+}
+
+// releaseHold освобождает резерв активного холда и переводит его в status:
+// voided или expired. Если холд уже в status, возвращает его с errAlreadyApplied.
+// state получено из beginHold.
+func (s *WalletService) releaseHold(ctx context.Context, state *WalletState, walletID, holdID uuid.UUID, status models.HoldStatus) (*models.Hold, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var hold *models.Hold
+	err := s.changeFunds(ctx, state, walletID, 0, func(tx pgx.Tx, row *models.WalletChange) error {
+		var err error
+		if hold, err = s.lockHold(ctx, tx, walletID, holdID); err != nil {
+			return err
+		}
+		switch hold.Status {
+		case status:
+			return errAlreadyApplied
+		case models.HoldActive:
+		default:
+			return custom_err.ErrHoldNotActive
+		}
+		hold.Status = status
+		hold.UpdatedAt = now
+		if err := s.repo.UpdateHoldTx(ctx, tx, *hold); err != nil {
+			return err
+		}
+		row.Reserved -= hold.Amount
+		return nil
+	})
+	return hold, err
+}
+
+// lockHold блокирует холд кошелька. Холд другого кошелька считается ненайденным.
+func (s *WalletService) lockHold(ctx context.Context, tx pgx.Tx, walletID, holdID uuid.UUID) (*models.Hold, error) {
+	hold, err := s.repo.GetHoldForUpdateTx(ctx, tx, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if hold.WalletID != walletID {
+		return nil, custom_err.ErrNotFound
+	}
+	return hold, nil
+}
+
+// changeFunds выполняет fn в транзакции над заблокированной строкой кошелька и
+// записывает баланс и резерв, которые fn оставила в row. После коммита кэш
+// приводится к записанной строке. Вызывается под state.mu, но на время
+// транзакции его отпускает: операции с холдами одного кошелька упорядочивает
+// блокировка строки в БД, а запись поверх строки, изменённой flush'ем,
+// отклоняет проверка версии. reserve — сумма, которую транзакция резервирует:
+// до коммита она учитывается в reserved кэша.
+func (s *WalletService) changeFunds(ctx context.Context, state *WalletState, walletID uuid.UUID, reserve int64, fn func(tx pgx.Tx, row *models.WalletChange) error) error {
+	state.holds++
+	state.reserving += reserve
+	state.reserved.Add(reserve)
+	state.mu.Unlock()
+
+	var committed models.WalletChange
+	var delta int64
+	err := s.inTxWithRetry(ctx, func(tx pgx.Tx) error {
+		row, err := s.repo.GetWalletStateTx(ctx, tx, walletID)
+		if err != nil {
+			return err
+		}
+		version, balance := row.Version, row.Balance
+		if err := fn(tx, &row); err != nil {
+			return err
+		}
+		if err := s.repo.UpdateFundsWithOptimisticLockTx(ctx, tx, walletID, row.Balance, row.Reserved, version); err != nil {
+			return err
+		}
+		row.Version = version + 1
+		committed, delta = row, row.Balance-balance
+		return nil
+	})
+
+	// Пока holds не 0, кошелек не вытесняется, и состояние остаётся в кэше.
+	state.mu.Lock()
+	state.holds--
+	state.reserving -= reserve
+	state.reserved.Add(-reserve)
+	if err != nil {
+		return err
+	}
+	// В режиме cache у кошелька могут быть незаписанные операции, applyCommitted их сохранит.
+	state.applyCommitted(committed, delta)
+	return nil
+}
+
+// available возвращает, сколько можно списать или зарезервировать на кошельке
+// со строкой row. В режиме cache часть операций ещё не записана в БД, и берётся
+// меньший из балансов кэша и строки: резерв не должен опираться на средства,
+// которых нет хотя бы в одном из них.
+func (s *WalletService) available(state *WalletState, row models.WalletChange) int64 {
+	if s.consistency == ConsistencyCache {
+		return min(state.balance.Load(), row.Balance) - row.Reserved
+	}
+	return row.Balance - row.Reserved
+}
+
+func holdError(op string, err error) error {
+	if isFinalOutcome(err) || errors.Is(err, custom_err.ErrNotFound) ||
+		errors.Is(err, custom_err.ErrHoldNotActive) || errors.Is(err, custom_err.ErrHoldExpired) ||
+		errors.Is(err, custom_err.ErrCaptureExceedsHold) || errors.Is(err, custom_err.ErrDuplicateRequest) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// holdExpirer периодически освобождает резерв холдов с истёкшим сроком. Холды
+// хранятся в БД, поэтому истёкшие, пока сервис не работал, освобождаются после запуска.
+func (s *WalletService) holdExpirer() {
+	ticker := time.NewTicker(s.holdExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		expired, err := s.expireHolds(ctx, time.Now())
+		cancel()
+		if err != nil {
+			log.Printf("[Holds] Failed to release expired holds: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("[Holds] Released %d expired holds", expired)
+		}
+	}
+}
+
+// expireHolds освобождает резерв холдов, истёкших к now, на кошельках, которые
+// обслуживает этот экземпляр, и возвращает их число.
+func (s *WalletService) expireHolds(ctx context.Context, now time.Time) (int, error) {
+	const op = "service.expireHolds"
+
+	holds, err := s.repo.ListExpiredHolds(ctx, now, holdExpiryBatch)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	expired := 0
+	for _, hold := range holds {
+		// Холды чужих шардов освобождает их владелец.
+		if !s.OwnsShard(ShardIndex(hold.WalletID)) {
+			continue
+		}
+		state, release, err := s.beginHold(ctx, hold.WalletID)
+		if err != nil {
+			if errors.Is(err, custom_err.ErrServiceStopping) {
+				return expired, nil
+			}
+			log.Printf("[Holds] Hold %s: %v", hold.ID, err)
+			continue
+		}
+		_, err = s.releaseHold(ctx, state, hold.WalletID, hold.ID, models.HoldExpired)
+		release()
+		switch {
+		case err == nil:
+			expired++
+			s.metrics.holdsExpired.Add(1)
+		case errors.Is(err, errAlreadyApplied), errors.Is(err, custom_err.ErrHoldNotActive):
+			// Холд успели списать или отменить.
+		default:
+			log.Printf("[Holds] Hold %s: %v", hold.ID, err)
+		}
+	}
+	return expired, nil
+}
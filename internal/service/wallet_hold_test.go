@@ -0,0 +1,400 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHoldRepository дополняет newSyncRepository таблицей holds.
+func newHoldRepository(table *syncTable) *mockRepository {
+	repo := newSyncRepository(table)
+	repo.CreateHoldTxFunc = func(ctx context.Context, tx pgx.Tx, hold models.Hold) error {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		table.holds[hold.ID] = hold
+		return nil
+	}
+	repo.GetHoldForUpdateTxFunc = func(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.Hold, error) {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		hold, ok := table.holds[id]
+		if !ok {
+			return nil, custom_err.ErrNotFound
+		}
+		return &hold, nil
+	}
+	repo.UpdateHoldTxFunc = func(ctx context.Context, tx pgx.Tx, hold models.Hold) error {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		table.holds[hold.ID] = hold
+		return nil
+	}
+	repo.GetHoldByRequestIDFunc = func(ctx context.Context, walletID, requestID uuid.UUID) (*models.Hold, error) {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		for _, hold := range table.holds {
+			if hold.WalletID == walletID && hold.RequestID == requestID {
+				return &hold, nil
+			}
+		}
+		return nil, custom_err.ErrNotFound
+	}
+	repo.BulkApplyOperationsFunc = func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		for _, operation := range ops {
+			table.balances[operation.WalletID] += operation.Amount
+			table.ops = append(table.ops, operation)
+		}
+		changes := make([]models.WalletChange, 0, len(walletIDs))
+		for _, id := range walletIDs {
+			table.versions[id]++
+			changes = append(changes, models.WalletChange{ID: id, Balance: table.balances[id], Reserved: table.reserved[id], Version: table.versions[id], Status: models.WalletActive})
+		}
+		return changes, nil
+	}
+	repo.ListExpiredHoldsFunc = func(ctx context.Context, now time.Time, limit int) ([]models.Hold, error) {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		var expired []models.Hold
+		for _, hold := range table.holds {
+			if hold.Status == models.HoldActive && !hold.ExpiresAt.After(now) && len(expired) < limit {
+				expired = append(expired, hold)
+			}
+		}
+		return expired, nil
+	}
+	return repo
+}
+
+func newHoldTable(balances map[uuid.UUID]int64) *syncTable {
+	table := &syncTable{
+		balances: balances,
+		versions: make(map[uuid.UUID]int64),
+		reserved: make(map[uuid.UUID]int64),
+		holds:    make(map[uuid.UUID]models.Hold),
+	}
+	for id := range balances {
+		table.versions[id] = 1
+	}
+	return table
+}
+
+func TestWalletService_Holds(t *testing.T) {
+	ctx := context.Background()
+	modes := []ConsistencyMode{ConsistencyCache, ConsistencySync}
+
+	hold := func(amount int64) models.CreateHoldRequest {
+		return models.CreateHoldRequest{Amount: amount, ExpiresIn: 60}
+	}
+	amount := func(v int64) *int64 { return &v }
+
+	for _, mode := range modes {
+		newService := func(table *syncTable) *WalletService {
+			return NewWalletService(newHoldRepository(table), &mockTxManager{}, WithConsistencyMode(mode))
+		}
+
+		t.Run(string(mode)+"/Hold reduces available balance", func(t *testing.T) {
+			walletID := uuid.New()
+			table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+			service := newService(table)
+
+			created, err := service.CreateHold(ctx, walletID, hold(70))
+			require.NoError(t, err)
+			assert.Equal(t, models.HoldActive, created.Status)
+			assert.Equal(t, int64(70), table.reserved[walletID])
+			assert.Equal(t, int64(2), table.versions[walletID])
+
+			wallet, err := service.GetWalletByID(ctx, walletID)
+			require.NoError(t, err)
+			assert.Equal(t, int64(100), wallet.Balance)
+			assert.Equal(t, int64(70), wallet.Reserved)
+			assert.Equal(t, int64(30), wallet.Available)
+
+			_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 50})
+			assert.ErrorIs(t, err, custom_err.ErrInsufficientFunds, "reserved funds cannot be withdrawn")
+			_, err = service.CreateHold(ctx, walletID, hold(40))
+			assert.ErrorIs(t, err, custom_err.ErrInsufficientFunds, "reserved funds cannot be held twice")
+
+			_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 30})
+			require.NoError(t, err)
+		})
+
+		t.Run(string(mode)+"/Partial capture releases the whole hold", func(t *testing.T) {
+			walletID := uuid.New()
+			table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+			service := newService(table)
+
+			created, err := service.CreateHold(ctx, walletID, hold(70))
+			require.NoError(t, err)
+
+			captured, err := service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{Amount: amount(50)})
+			require.NoError(t, err)
+			assert.Equal(t, models.HoldCaptured, captured.Status)
+			assert.Equal(t, int64(50), captured.CapturedAmount)
+			require.NotNil(t, captured.OperationID)
+
+			assert.Equal(t, int64(50), table.balances[walletID])
+			assert.Equal(t, int64(0), table.reserved[walletID])
+			require.Len(t, table.ops, 1)
+			assert.Equal(t, *captured.OperationID, table.ops[0].ID)
+			assert.Equal(t, models.WithdrawOperation, table.ops[0].Type)
+			assert.Equal(t, int64(-50), table.ops[0].Amount)
+
+			wallet, err := service.GetWalletByID(ctx, walletID)
+			require.NoError(t, err)
+			assert.Equal(t, int64(50), wallet.Balance)
+			assert.Equal(t, int64(50), wallet.Available)
+
+			again, err := service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{Amount: amount(50)})
+			require.NoError(t, err, "repeated capture returns the captured hold")
+			assert.Equal(t, captured.OperationID, again.OperationID)
+			assert.Len(t, table.ops, 1)
+
+			_, err = service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{})
+			assert.ErrorIs(t, err, custom_err.ErrHoldNotActive)
+		})
+
+		t.Run(string(mode)+"/Capture is checked against the hold", func(t *testing.T) {
+			walletID, otherID := uuid.New(), uuid.New()
+			table := newHoldTable(map[uuid.UUID]int64{walletID: 100, otherID: 100})
+			service := newService(table)
+
+			created, err := service.CreateHold(ctx, walletID, hold(70))
+			require.NoError(t, err)
+
+			_, err = service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{Amount: amount(71)})
+			assert.ErrorIs(t, err, custom_err.ErrCaptureExceedsHold)
+			_, err = service.CaptureHold(ctx, otherID, created.ID, models.CaptureHoldRequest{})
+			assert.ErrorIs(t, err, custom_err.ErrNotFound, "hold of another wallet")
+			_, err = service.CaptureHold(ctx, walletID, uuid.New(), models.CaptureHoldRequest{})
+			assert.ErrorIs(t, err, custom_err.ErrNotFound)
+
+			expired := table.holds[created.ID]
+			expired.ExpiresAt = time.Now().Add(-time.Second)
+			table.holds[created.ID] = expired
+			_, err = service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{})
+			assert.ErrorIs(t, err, custom_err.ErrHoldExpired)
+
+			assert.Empty(t, table.ops)
+			assert.Equal(t, int64(100), table.balances[walletID])
+			assert.Equal(t, int64(70), table.reserved[walletID])
+		})
+
+		t.Run(string(mode)+"/Void releases the hold", func(t *testing.T) {
+			walletID := uuid.New()
+			table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+			service := newService(table)
+
+			created, err := service.CreateHold(ctx, walletID, hold(70))
+			require.NoError(t, err)
+
+			voided, err := service.VoidHold(ctx, walletID, created.ID)
+			require.NoError(t, err)
+			assert.Equal(t, models.HoldVoided, voided.Status)
+			assert.Equal(t, int64(0), table.reserved[walletID])
+
+			_, err = service.VoidHold(ctx, walletID, created.ID)
+			require.NoError(t, err, "repeated void returns the voided hold")
+			assert.Equal(t, int64(0), table.reserved[walletID])
+
+			_, err = service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{})
+			assert.ErrorIs(t, err, custom_err.ErrHoldNotActive)
+
+			wallet, err := service.GetWalletByID(ctx, walletID)
+			require.NoError(t, err)
+			assert.Equal(t, int64(100), wallet.Available)
+		})
+
+		t.Run(string(mode)+"/Request ID makes create idempotent", func(t *testing.T) {
+			walletID := uuid.New()
+			table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+			service := newService(table)
+
+			req := hold(70)
+			req.RequestID = uuid.New()
+			first, err := service.CreateHold(ctx, walletID, req)
+			require.NoError(t, err)
+			second, err := service.CreateHold(ctx, walletID, req)
+			require.NoError(t, err)
+			assert.Equal(t, first.ID, second.ID)
+			assert.Equal(t, int64(70), table.reserved[walletID])
+
+			changed := req
+			changed.Amount = 10
+			_, err = service.CreateHold(ctx, walletID, changed)
+			assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
+
+			changed = req
+			changed.ExpiresIn = 120
+			_, err = service.CreateHold(ctx, walletID, changed)
+			assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
+		})
+	}
+
+	t.Run("Unflushed operations are written before the hold", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newHoldTable(map[uuid.UUID]int64{walletID: 0})
+		service := NewWalletService(newHoldRepository(table), &mockTxManager{})
+
+		// Пополнение есть только в кэше: без flush'а списание холда увело бы строку в минус.
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 100})
+		require.NoError(t, err)
+
+		created, err := service.CreateHold(ctx, walletID, hold(70))
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), table.balances[walletID], "the deposit is flushed first")
+		assert.Equal(t, int64(70), table.reserved[walletID])
+		assert.False(t, service.cachedState(walletID).dirty.Load())
+
+		_, err = service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), table.balances[walletID])
+
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), wallet.Balance)
+		assert.Equal(t, int64(30), wallet.Available)
+	})
+
+	t.Run("Wallet operations proceed while a hold is written", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+		repo := newHoldRepository(table)
+		entered, proceed := make(chan struct{}), make(chan struct{})
+		state := repo.GetWalletStateTxFunc
+		repo.GetWalletStateTxFunc = func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error) {
+			close(entered)
+			<-proceed
+			return state(ctx, tx, walletID)
+		}
+		service := NewWalletService(repo, &mockTxManager{})
+
+		created := make(chan error, 1)
+		go func() {
+			_, err := service.CreateHold(ctx, walletID, hold(70))
+			created <- err
+		}()
+		<-entered
+
+		// Транзакция холда ещё идёт, но кошелек ею не заблокирован.
+		applied := make(chan error, 1)
+		go func() {
+			_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10})
+			applied <- err
+		}()
+		select {
+		case err := <-applied:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("deposit waits for the hold transaction")
+		}
+		// Резерв холда учтён заранее: его средства не списать.
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 50})
+		assert.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 40})
+		require.NoError(t, err)
+
+		close(proceed)
+		require.NoError(t, <-created)
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(70), wallet.Balance)
+		assert.Equal(t, int64(70), wallet.Reserved)
+		assert.Zero(t, wallet.Available)
+
+		_, err = service.FlushAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(70), table.balances[walletID])
+		assert.Equal(t, int64(70), table.reserved[walletID])
+	})
+
+	t.Run("Capture never takes the database balance below zero", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+		service := NewWalletService(newHoldRepository(table), &mockTxManager{})
+
+		created, err := service.CreateHold(ctx, walletID, hold(70))
+		require.NoError(t, err)
+		// Строку изменили в обход сервиса, уведомление ещё не дошло.
+		table.balances[walletID] = 50
+
+		_, err = service.CaptureHold(ctx, walletID, created.ID, models.CaptureHoldRequest{})
+		assert.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+		assert.Equal(t, int64(50), table.balances[walletID])
+	})
+
+	t.Run("Frozen wallet cannot hold funds", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+		repo := newHoldRepository(table)
+		state := repo.GetWalletStateTxFunc
+		repo.GetWalletStateTxFunc = func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error) {
+			row, err := state(ctx, tx, walletID)
+			row.Status = models.WalletFrozen
+			return row, err
+		}
+		service := NewWalletService(repo, &mockTxManager{})
+
+		_, err := service.CreateHold(ctx, walletID, hold(10))
+		assert.ErrorIs(t, err, custom_err.ErrWalletFrozen)
+		assert.Empty(t, table.holds)
+	})
+}
+
+func TestWalletService_ExpireHolds(t *testing.T) {
+	ctx := context.Background()
+	walletID := uuid.New()
+	table := newHoldTable(map[uuid.UUID]int64{walletID: 100})
+	service := NewWalletService(newHoldRepository(table), &mockTxManager{})
+
+	expiring, err := service.CreateHold(ctx, walletID, models.CreateHoldRequest{Amount: 30, ExpiresIn: 60})
+	require.NoError(t, err)
+	_, err = service.CreateHold(ctx, walletID, models.CreateHoldRequest{Amount: 20, ExpiresIn: 3600})
+	require.NoError(t, err)
+
+	// Экземпляр перезапустился: холды и резерв остались только в БД.
+	service = NewWalletService(newHoldRepository(table), &mockTxManager{})
+
+	expired, err := service.expireHolds(ctx, time.Now().Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, models.HoldExpired, table.holds[expiring.ID].Status)
+	assert.Equal(t, int64(20), table.reserved[walletID])
+	assert.Equal(t, int64(1), service.metrics.holdsExpired.Load())
+
+	wallet, err := service.GetWalletByID(ctx, walletID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(80), wallet.Available)
+
+	expired, err = service.expireHolds(ctx, time.Now().Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, expired)
+
+	t.Run("Holds of other shards are left to their owner", func(t *testing.T) {
+		otherID := uuid.New()
+		table.balances[otherID] = 100
+		table.versions[otherID] = 1
+		held, err := service.CreateHold(ctx, otherID, models.CreateHoldRequest{Amount: 10, ExpiresIn: 60})
+		require.NoError(t, err)
+
+		shard := service.shards[ShardIndex(otherID)]
+		shard.lease.Lock()
+		shard.owned = false
+		shard.lease.Unlock()
+
+		expired, err := service.expireHolds(ctx, time.Now().Add(2*time.Minute))
+		require.NoError(t, err)
+		assert.Zero(t, expired)
+		assert.Equal(t, models.HoldActive, table.holds[held.ID].Status)
+	})
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultIdempotencyWindow = 10 * time.Minute
+	idempotencySweepInterval = time.Minute
+)
+
+// WithIdempotencyWindow задаёт, сколько исход запроса хранится в памяти.
+// Успешные запросы старше окна дедуплицируются по таблице operations.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(s *WalletService) {
+		s.idempotency.window = window
+	}
+}
+
+type idempotencyKey struct {
+	walletID  uuid.UUID
+	requestID uuid.UUID
+}
+
+// idempotencyEntry — исход запроса. Пока done не закрыт, запрос выполняется,
+// и повторы с тем же requestId ждут его результата. fingerprint описывает
+// параметры запроса, result — то, что вернул первый запрос.
+type idempotencyEntry struct {
+	fingerprint string
+	done        chan struct{}
+	result      any
+	err         error
+	expiresAt   time.Time
+}
+
+func operationFingerprint(opType models.OperationType, amount int64) string {
+	return fmt.Sprintf("%s:%d", opType, amount)
+}
+
+func transferFingerprint(to uuid.UUID, amount int64) string {
+	return fmt.Sprintf("TRANSFER:%s:%d", to, amount)
+}
+
+type idempotencyShard struct {
+	mu      sync.Mutex
+	entries map[idempotencyKey]*idempotencyEntry
+}
+
+type idempotencyCache struct {
+	window time.Duration
+	shards [numShards]*idempotencyShard
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	c := &idempotencyCache{window: defaultIdempotencyWindow}
+	for i := range c.shards {
+		c.shards[i] = &idempotencyShard{entries: make(map[idempotencyKey]*idempotencyEntry)}
+	}
+	return c
+}
+
+func (c *idempotencyCache) shard(key idempotencyKey) *idempotencyShard {
+	return c.shards[shardIndex(key.requestID)]
+}
+
+// begin регистрирует запрос. owner=true означает, что запрос ещё не выполнялся
+// и вызывающий обязан завершить его через complete или abandon.
+func (c *idempotencyCache) begin(key idempotencyKey, fingerprint string) (entry *idempotencyEntry, owner bool) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if existing, ok := shard.entries[key]; ok {
+		return existing, false
+	}
+	entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	shard.entries[key] = entry
+	return entry, true
+}
+
+// complete запоминает окончательный исход запроса на время окна.
+func (c *idempotencyCache) complete(key idempotencyKey, entry *idempotencyEntry, result any, err error) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	entry.expiresAt = time.Now().Add(c.window)
+	shard.mu.Unlock()
+
+	entry.result = result
+	entry.err = err
+	close(entry.done)
+}
+
+// abandon забывает запрос, исход которого не окончателен (например, БД недоступна),
+// чтобы повтор клиента выполнился заново. Ожидающие повторы получают ту же ошибку.
+func (c *idempotencyCache) abandon(key idempotencyKey, entry *idempotencyEntry, err error) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	if shard.entries[key] == entry {
+		delete(shard.entries, key)
+	}
+	shard.mu.Unlock()
+
+	entry.err = err
+	close(entry.done)
+}
+
+// remember отмечает запрос как успешно выполненный при восстановлении из журнала.
+// balance — баланс кошелька после операции.
+func (c *idempotencyCache) remember(operation models.Operation, balance int64) {
+	if operation.RequestID == uuid.Nil {
+		return
+	}
+	key := idempotencyKey{walletID: operation.WalletID, requestID: operation.RequestID}
+	entry := &idempotencyEntry{
+		fingerprint: operationFingerprint(operation.Type, abs(operation.Amount)),
+		done:        make(chan struct{}),
+		expiresAt:   time.Now().Add(c.window),
+	}
+	if transfer, ok := transferFromOperation(operation); ok {
+		entry.fingerprint = transferFingerprint(transfer.ToWalletID, transfer.Amount)
+		entry.result = transfer
+	} else {
+		// Версия строки кошелька до сверки с БД неизвестна.
+		receipt := receiptFromOperation(operation)
+		receipt.BalanceAfter = &balance
+		entry.result = receipt
+	}
+	close(entry.done)
+
+	shard := c.shard(key)
+	shard.mu.Lock()
+	shard.entries[key] = entry
+	shard.mu.Unlock()
+}
+
+// sweep удаляет исходы старше окна. Исход, чья операция по unflushed ещё не
+// записана в БД, остаётся: без него повтор не найдёт её ни в памяти, ни в
+// таблице operations и применит второй раз.
+func (c *idempotencyCache) sweep(now time.Time, unflushed func(idempotencyKey) bool) int {
+	removed := 0
+	for _, shard := range c.shards {
+		var expired []idempotencyKey
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+				expired = append(expired, key)
+			}
+		}
+		shard.mu.Unlock()
+
+		// unflushed берёт mu кошелька, поэтому вызывается без блокировки шарда.
+		for _, key := range expired {
+			if unflushed != nil && unflushed(key) {
+				continue
+			}
+			shard.mu.Lock()
+			if entry, ok := shard.entries[key]; ok && !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+				delete(shard.entries, key)
+				removed++
+			}
+			shard.mu.Unlock()
+		}
+	}
+	return removed
+}
+
+func (s *WalletService) idempotencySweeper() {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.idempotency.sweep(now, s.unflushedRequest)
+		}
+	}
+}
+
+// unflushedRequest сообщает, что операция запроса key учтена в балансе
+// кошелька, но ещё не записана в БД.
+func (s *WalletService) unflushedRequest(key idempotencyKey) bool {
+	state := s.cachedState(key.walletID)
+	if state == nil {
+		return false
+	}
+	for _, operation := range state.pendingOps() {
+		if operation.RequestID == key.requestID {
+			return true
+		}
+	}
+	return false
+}
+
+// isFinalOutcome сообщает, можно ли отдавать исход повторам запроса. Ошибки
+// инфраструктуры не окончательны: повтор должен выполниться заново.
+func isFinalOutcome(err error) bool {
+	return err == nil ||
+		errors.Is(err, custom_err.ErrInsufficientFunds) ||
+		errors.Is(err, custom_err.ErrWalletFrozen) ||
+		errors.Is(err, custom_err.ErrWalletClosed)
+}
+
+// idempotent выполняет apply не более одного раза для ключа (кошелёк, requestId).
+// Повтор с тем же fingerprint получает исход первого запроса, включая ошибку,
+// повтор с другими параметрами — custom_err.ErrDuplicateRequest. Если исхода нет
+// в памяти, recorded проверяет операцию, найденную по requestId в БД.
+func idempotent[T any](
+	ctx context.Context,
+	s *WalletService,
+	key idempotencyKey,
+	fingerprint string,
+	recorded func(*models.Operation) (T, bool),
+	apply func() (T, error),
+) (T, error) {
+	const op = "service.idempotent"
+	var zero T
+
+	if key.requestID == uuid.Nil {
+		return apply()
+	}
+
+	entry, owner := s.idempotency.begin(key, fingerprint)
+	if !owner {
+		if entry.fingerprint != fingerprint {
+			return zero, custom_err.ErrDuplicateRequest
+		}
+		select {
+		case <-entry.done:
+			result, _ := entry.result.(T)
+			return result, entry.err
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+
+	// В памяти исхода нет: запрос мог быть выполнен до рестарта или раньше окна.
+	existing, err := s.repo.GetOperationByRequestID(ctx, key.walletID, key.requestID)
+	switch {
+	case err == nil:
+		result, ok := recorded(existing)
+		if !ok {
+			s.idempotency.abandon(key, entry, custom_err.ErrDuplicateRequest)
+			return zero, custom_err.ErrDuplicateRequest
+		}
+		s.idempotency.complete(key, entry, result, nil)
+		return result, nil
+	case !errors.Is(err, custom_err.ErrNotFound):
+		err = fmt.Errorf("%s: %w", op, err)
+		s.idempotency.abandon(key, entry, err)
+		return zero, err
+	}
+
+	result, err := apply()
+	if isFinalOutcome(err) {
+		s.idempotency.complete(key, entry, result, err)
+	} else {
+		s.idempotency.abandon(key, entry, err)
+	}
+	return result, err
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_Idempotency(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func(balance int64) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: balance}, nil
+			},
+		}, nil)
+	}
+	balanceOf := func(s *WalletService, id uuid.UUID) int64 {
+		return s.getShard(id).wallets[id].balance.Load()
+	}
+
+	t.Run("Retry is applied once", func(t *testing.T) {
+		service := newService(100)
+		req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.DepositOperation, Amount: 50, RequestID: uuid.New()}
+
+		first, err := service.UpdateBalance(ctx, req)
+		require.NoError(t, err)
+		repeated, err := service.UpdateBalance(ctx, req)
+		require.NoError(t, err)
+
+		assert.Equal(t, first, repeated, "repeat gets the receipt of the first request")
+		assert.Equal(t, int64(150), balanceOf(service, req.WalletID))
+	})
+
+	t.Run("Retry gets the original error", func(t *testing.T) {
+		service := newService(100)
+		req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.WithdrawOperation, Amount: 500, RequestID: uuid.New()}
+
+		_, err := service.UpdateBalance(ctx, req)
+		require.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+
+		// Даже если денег стало достаточно, повтор возвращает исход первого запроса.
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: req.WalletID, OperationType: models.DepositOperation, Amount: 1000})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, req)
+		assert.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+		assert.Equal(t, int64(1100), balanceOf(service, req.WalletID))
+	})
+
+	t.Run("Same request ID with different parameters", func(t *testing.T) {
+		service := newService(100)
+		req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.DepositOperation, Amount: 50, RequestID: uuid.New()}
+		_, err := service.UpdateBalance(ctx, req)
+		require.NoError(t, err)
+
+		req.Amount = 60
+		_, err = service.UpdateBalance(ctx, req)
+		assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
+		assert.Equal(t, int64(150), balanceOf(service, req.WalletID))
+	})
+
+	t.Run("Concurrent retries are applied once", func(t *testing.T) {
+		service := newService(0)
+		req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.DepositOperation, Amount: 10, RequestID: uuid.New()}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := service.UpdateBalance(ctx, req)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int64(10), balanceOf(service, req.WalletID))
+	})
+
+	t.Run("Request already recorded in the database", func(t *testing.T) {
+		walletID, requestID := uuid.New(), uuid.New()
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100}, nil
+			},
+			GetOperationByRequestIDFunc: func(ctx context.Context, wID, rID uuid.UUID) (*models.Operation, error) {
+				return &models.Operation{ID: uuid.New(), WalletID: wID, Type: models.WithdrawOperation, Amount: -30, RequestID: rID}, nil
+			},
+		}, nil)
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 30, RequestID: requestID})
+		require.NoError(t, err)
+		assert.Nil(t, service.getShard(walletID).wallets[walletID], "operation must not be applied again")
+
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 30, RequestID: requestID})
+		assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
+	})
+
+	t.Run("Retry after the window is applied once while unflushed", func(t *testing.T) {
+		service := newService(100)
+		req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.DepositOperation, Amount: 50, RequestID: uuid.New()}
+
+		first, err := service.UpdateBalance(ctx, req)
+		require.NoError(t, err)
+		// Операция ещё не записана в БД, и найти её там повтор не сможет.
+		assert.Zero(t, service.idempotency.sweep(time.Now().Add(2*defaultIdempotencyWindow), service.unflushedRequest))
+
+		repeated, err := service.UpdateBalance(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, first, repeated)
+		assert.Equal(t, int64(150), balanceOf(service, req.WalletID))
+	})
+
+	t.Run("Infrastructure errors are not remembered", func(t *testing.T) {
+		var calls atomic.Int32
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				if calls.Add(1) == 1 {
+					return nil, errors.New("connection reset")
+				}
+				return &models.Wallet{ID: id, Balance: 100}, nil
+			},
+		}, nil)
+		req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.DepositOperation, Amount: 5, RequestID: uuid.New()}
+
+		_, err := service.UpdateBalance(ctx, req)
+		require.Error(t, err)
+		_, err = service.UpdateBalance(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, int64(105), balanceOf(service, req.WalletID))
+	})
+}
+
+func TestIdempotencyCache_sweep(t *testing.T) {
+	cache := newIdempotencyCache()
+	cache.window = time.Minute
+
+	req := models.WalletOperationRequest{WalletID: uuid.New(), OperationType: models.DepositOperation, Amount: 1, RequestID: uuid.New()}
+	key := idempotencyKey{walletID: req.WalletID, requestID: req.RequestID}
+
+	fingerprint := operationFingerprint(req.OperationType, req.Amount)
+	entry, owner := cache.begin(key, fingerprint)
+	require.True(t, owner)
+	assert.Zero(t, cache.sweep(time.Now().Add(time.Hour), nil), "in-flight requests must never be swept")
+
+	cache.complete(key, entry, nil, nil)
+	assert.Zero(t, cache.sweep(time.Now(), nil))
+	unflushed := func(idempotencyKey) bool { return true }
+	assert.Zero(t, cache.sweep(time.Now().Add(2*time.Minute), unflushed), "unflushed requests must not be swept")
+	assert.Equal(t, 1, cache.sweep(time.Now().Add(2*time.Minute), nil))
+
+	_, owner = cache.begin(key, fingerprint)
+	assert.True(t, owner, "expired request is treated as new")
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/journal"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WithJournal включает журналирование: каждая принятая операция попадает на
+// диск до ответа клиенту и переживает падение процесса.
+func WithJournal(j *journal.Journal) Option {
+	return func(s *WalletService) {
+		s.journal = j
+	}
+}
+
+// ReplayJournal восстанавливает в кэше балансы и операции, записанные в журнал,
+// но, возможно, не дошедшие до БД. Восстановленные кошельки помечаются грязными
+// и уходят в БД со следующим flush; уже записанные операции БД отбросит по id.
+// Статус восстановленных кошельков читается из БД. Вызывается один раз до приёма запросов.
+func (s *WalletService) ReplayJournal(ctx context.Context) error {
+	if s.journal == nil {
+		return nil
+	}
+
+	replayed := 0
+	wallets := make(map[uuid.UUID]*WalletState)
+	err := s.journal.Replay(func(rec journal.Record) error {
+		for _, e := range rec.Entries {
+			shard := s.getShard(e.WalletID)
+
+			shard.mu.Lock()
+			state, ok := shard.wallets[e.WalletID]
+			if !ok {
+				state = &WalletState{}
+				shard.insert(e.WalletID, state)
+			}
+			shard.mu.Unlock()
+			wallets[e.WalletID] = state
+
+			state.mu.Lock()
+			state.balance.Store(e.Balance)
+			state.markDirty()
+			state.journalSeq.Store(rec.Seq)
+			if state.pendingSeq.Load() == 0 {
+				state.pendingSeq.Store(rec.Seq)
+			}
+			if e.Operation != nil {
+				state.ops = append(state.ops, *e.Operation)
+				s.metrics.pendingOps.Add(1)
+			}
+			state.mu.Unlock()
+
+			if e.Operation != nil {
+				s.idempotency.remember(*e.Operation, e.Balance)
+			}
+		}
+		replayed++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка восстановления из журнала: %w", err)
+	}
+
+	for id, state := range wallets {
+		wallet, err := s.repo.GetByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, custom_err.ErrNotFound) {
+				// Кошелек удалили в обход сервиса, его операции записать уже некуда.
+				log.Printf("[Journal] Wallet %s from journal no longer exists, dropping it", id)
+				shard := s.getShard(id)
+				shard.mu.Lock()
+				delete(shard.wallets, id)
+				shard.mu.Unlock()
+				s.metrics.pendingOps.Add(-int64(len(state.ops)))
+				continue
+			}
+			return fmt.Errorf("ошибка загрузки статуса кошелька %s: %w", id, err)
+		}
+		state.setStatus(wallet.Status)
+	}
+
+	log.Printf("[Journal] Replayed %d records for %d wallets", replayed, len(wallets))
+	return nil
+}
+
+// journalCheckpointer удаляет сегменты журнала, все записи которых уже есть в БД.
+func (s *WalletService) journalCheckpointer() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := s.journal.Truncate(s.journalWatermark()); err != nil {
+			log.Printf("[Journal] Truncate failed: %v", err)
+		}
+	}
+}
+
+// journalWatermark возвращает seq, записи до которого уже есть в БД и могут быть удалены.
+func (s *WalletService) journalWatermark() uint64 {
+	// NextSeq читается до обхода: запись с меньшим seq уже выставила pendingSeq,
+	// а её кошелек уже в наборе грязных — баланс меняется до записи в журнал.
+	watermark := s.journal.NextSeq()
+	for _, shard := range s.shards {
+		shard.forEachDirty(func(ref stateRef) bool {
+			if p := ref.state.pendingSeq.Load(); p != 0 && p < watermark {
+				watermark = p
+			}
+			return true
+		})
+		// Отложенные в dead letter операции держат журнал до replay.
+		shard.forEachParked(func(ref stateRef) bool {
+			if p := ref.state.parkedSeq.Load(); p != 0 && p < watermark {
+				watermark = p
+			}
+			return true
+		})
+	}
+	return watermark
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"api_wallet/internal/journal"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openServiceJournal(t *testing.T, dir string) *journal.Journal {
+	j, err := journal.Open(journal.Config{Dir: dir, SegmentSize: 1 << 20})
+	require.NoError(t, err)
+	return j
+}
+
+func TestWalletService_JournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	walletID := uuid.New()
+	ctx := context.Background()
+
+	mockRepo := &mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100}, nil
+		},
+	}
+
+	j := openServiceJournal(t, dir)
+	service := NewWalletService(mockRepo, nil, WithJournal(j))
+
+	deposit := models.WalletOperationRequest{
+		WalletID: walletID, OperationType: models.DepositOperation, Amount: 50, RequestID: uuid.New(),
+	}
+	receipt, err := service.UpdateBalance(ctx, deposit)
+	require.NoError(t, err)
+	assert.True(t, receipt.Persisted, "operation is on disk once the journal is synced")
+	_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{
+		WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 30,
+	})
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	// Имитация рестарта: БД о новых операциях не знает, кэш пустой.
+	reopened := openServiceJournal(t, dir)
+	defer reopened.Close()
+	restarted := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100, Status: models.WalletFrozen}, nil
+		},
+	}, nil, WithJournal(reopened))
+	require.NoError(t, restarted.ReplayJournal(ctx))
+
+	shard := restarted.getShard(walletID)
+	shard.mu.RLock()
+	state, ok := shard.wallets[walletID]
+	shard.mu.RUnlock()
+	require.True(t, ok, "replayed wallet must be in cache")
+	assert.Equal(t, int64(120), state.balance.Load())
+	assert.True(t, state.dirty.Load(), "replayed wallet must be flushed again")
+	assert.NotZero(t, state.pendingSeq.Load(), "replayed records must not be truncated before flush")
+	assert.Equal(t, models.WalletFrozen, state.Status(), "status is restored from the database")
+	require.Len(t, state.ops, 2, "journaled operations must be queued for history again")
+	assert.Equal(t, int64(-30), state.ops[1].Amount)
+
+	// Повтор запроса после рестарта получает результат из журнала.
+	repeated, err := restarted.UpdateBalance(ctx, deposit)
+	require.NoError(t, err)
+	assert.Equal(t, receipt.OperationID, repeated.OperationID)
+	assert.Equal(t, receipt.BalanceAfter, repeated.BalanceAfter)
+	assert.True(t, repeated.Persisted)
+	assert.Equal(t, int64(120), state.balance.Load(), "repeat must not be applied again")
+}
+
+func TestWalletService_JournalRejectsInsufficientFunds(t *testing.T) {
+	j := openServiceJournal(t, t.TempDir())
+	defer j.Close()
+
+	mockRepo := &mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 10}, nil
+		},
+	}
+	service := NewWalletService(mockRepo, nil, WithJournal(j))
+
+	walletID := uuid.New()
+	_, err := service.UpdateBalance(context.Background(), models.WalletOperationRequest{
+		WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 50,
+	})
+	require.Error(t, err)
+	assert.Equal(t, uint64(1), j.NextSeq(), "rejected operation must not be journaled")
+}
+
+func TestWalletState_markFlushed(t *testing.T) {
+	t.Run("Clean after flush of all operations", func(t *testing.T) {
+		state := &WalletState{}
+		state.balance.Store(100)
+		state.dirty.Store(true)
+		state.journalSeq.Store(7)
+		state.pendingSeq.Store(3)
+
+		state.markFlushed(7, nil)
+
+		assert.False(t, state.dirty.Load())
+		assert.Zero(t, state.pendingSeq.Load())
+	})
+
+	t.Run("Still dirty after concurrent change", func(t *testing.T) {
+		state := &WalletState{}
+		state.balance.Store(150)
+		state.dirty.Store(true)
+		state.journalSeq.Store(8)
+		state.pendingSeq.Store(3)
+		state.ops = []models.Operation{{ID: uuid.New(), Amount: 50}}
+
+		state.markFlushed(7, nil)
+
+		assert.True(t, state.dirty.Load())
+		assert.Equal(t, uint64(8), state.pendingSeq.Load(), "records up to the flushed seq can be truncated")
+	})
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CreateWallet создаёт кошелек с нулевым балансом. Если id не передан, он генерируется.
+// Кошелек сразу пишется в БД и попадает в кэш.
+func (s *WalletService) CreateWallet(ctx context.Context, id uuid.UUID) (_ *models.Wallet, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.CreateWallet", trace.WithAttributes(walletAttr(id)))
+	defer func() { endSpan(span, err) }()
+	const op = "service.CreateWallet"
+
+	done, err := s.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if id == uuid.Nil {
+		id = uuid.New()
+	}
+
+	wallet, err := s.repo.Create(ctx, id)
+	if err != nil {
+		if errors.Is(err, custom_err.ErrAlreadyExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	shard := s.getShard(id)
+	shard.forgetMissing(id)
+
+	// Кошелек чужого шарда не кэшируем: его обслуживает владелец шарда.
+	if leave, ok := s.tryEnterShard(id); ok {
+		shard.mu.Lock()
+		if _, exists := shard.wallets[id]; !exists {
+			shard.insert(id, newWalletState(wallet))
+			shard.trim()
+		}
+		shard.mu.Unlock()
+		leave()
+	}
+
+	return wallet, nil
+}
+
+// UpdateWalletStatus переводит кошелек в новый статус. Статус сначала пишется в БД,
+// и только потом меняется в кэше; на это время операции по кошельку ждут.
+func (s *WalletService) UpdateWalletStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus) (_ *models.Wallet, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.UpdateWalletStatus", trace.WithAttributes(walletAttr(id), attribute.String("wallet.status", string(status))))
+	defer func() { endSpan(span, err) }()
+	const op = "service.UpdateWalletStatus"
+
+	if !status.IsValid() {
+		return nil, custom_err.ErrInvalidStatusTransition
+	}
+
+	done, err := s.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	leave, err := s.enterShards(id)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	state, err := s.lockState(ctx, id)
+	if err != nil {
+		if errors.Is(err, custom_err.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer state.mu.Unlock()
+
+	current := state.Status()
+	if !current.CanTransitionTo(status) {
+		return nil, fmt.Errorf("%s: %s -> %s: %w", op, current, status, custom_err.ErrInvalidStatusTransition)
+	}
+
+	wallet, err := s.repo.UpdateStatus(ctx, id, status)
+	if err != nil {
+		if errors.Is(err, custom_err.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	state.applyCommitted(models.WalletChange{ID: id, Balance: wallet.Balance, Reserved: wallet.Reserved, Version: wallet.Version, Status: wallet.Status}, 0)
+	state.setStatus(wallet.Status)
+
+	// Баланс в БД может отставать от кэша, отдаём актуальный.
+	wallet.Balance = state.balance.Load()
+	wallet.Available = wallet.Balance - wallet.Reserved
+	return wallet, nil
+}
+
+// lockState загружает существующий кошелек в кэш и захватывает его mu.
+func (s *WalletService) lockState(ctx context.Context, id uuid.UUID) (*WalletState, error) {
+	return retryEvicted(func() (*WalletState, error) {
+		state, err := s.getShard(id).loadStateIntoCacheIfExists(ctx, id, s.repo)
+		if err != nil {
+			return nil, err
+		}
+		return state, state.lock()
+	})
+}
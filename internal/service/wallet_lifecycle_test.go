@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_CreateWallet(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Server generated ID", func(t *testing.T) {
+		var created uuid.UUID
+		service := NewWalletService(&mockRepository{
+			CreateFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				created = id
+				return &models.Wallet{ID: id, Status: models.WalletActive}, nil
+			},
+		}, nil)
+
+		wallet, err := service.CreateWallet(ctx, uuid.Nil)
+		require.NoError(t, err)
+		assert.NotEqual(t, uuid.Nil, wallet.ID)
+		assert.Equal(t, created, wallet.ID)
+
+		// Новый кошелек сразу доступен без похода в БД.
+		got, err := service.GetWalletByID(ctx, wallet.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), got.Balance)
+		assert.Equal(t, models.WalletActive, got.Status)
+	})
+
+	t.Run("Already exists", func(t *testing.T) {
+		service := NewWalletService(&mockRepository{
+			CreateFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return nil, custom_err.ErrAlreadyExists
+			},
+		}, nil)
+
+		_, err := service.CreateWallet(ctx, uuid.New())
+		assert.ErrorIs(t, err, custom_err.ErrAlreadyExists)
+	})
+}
+
+func TestWalletService_UpdateWalletStatus(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func() (*WalletService, uuid.UUID) {
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Status: models.WalletActive}, nil
+			},
+		}, nil)
+		return service, uuid.New()
+	}
+	operation := func(id uuid.UUID, opType models.OperationType) models.WalletOperationRequest {
+		return models.WalletOperationRequest{WalletID: id, OperationType: opType, Amount: 10}
+	}
+
+	t.Run("Frozen wallet accepts only deposits", func(t *testing.T) {
+		service, id := newService()
+
+		wallet, err := service.UpdateWalletStatus(ctx, id, models.WalletFrozen)
+		require.NoError(t, err)
+		assert.Equal(t, models.WalletFrozen, wallet.Status)
+		assert.Equal(t, int64(100), wallet.Balance)
+
+		_, err = service.UpdateBalance(ctx, operation(id, models.WithdrawOperation))
+		assert.ErrorIs(t, err, custom_err.ErrWalletFrozen)
+		_, err = service.UpdateBalance(ctx, operation(id, models.DepositOperation))
+		assert.NoError(t, err)
+
+		_, err = service.UpdateWalletStatus(ctx, id, models.WalletActive)
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, operation(id, models.WithdrawOperation))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Closed wallet rejects everything", func(t *testing.T) {
+		service, id := newService()
+
+		_, err := service.UpdateWalletStatus(ctx, id, models.WalletClosed)
+		require.NoError(t, err)
+
+		_, err = service.UpdateBalance(ctx, operation(id, models.DepositOperation))
+		assert.ErrorIs(t, err, custom_err.ErrWalletClosed)
+		_, err = service.UpdateBalance(ctx, operation(id, models.WithdrawOperation))
+		assert.ErrorIs(t, err, custom_err.ErrWalletClosed)
+	})
+
+	t.Run("Closed is final", func(t *testing.T) {
+		statusUpdates := 0
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Status: models.WalletClosed}, nil
+			},
+			UpdateStatusFunc: func(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error) {
+				statusUpdates++
+				return &models.Wallet{ID: id, Status: status}, nil
+			},
+		}, nil)
+
+		_, err := service.UpdateWalletStatus(ctx, uuid.New(), models.WalletActive)
+		assert.ErrorIs(t, err, custom_err.ErrInvalidStatusTransition)
+		assert.Zero(t, statusUpdates, "invalid transition must not reach the database")
+	})
+
+	t.Run("Wallet not found", func(t *testing.T) {
+		service := NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return nil, custom_err.ErrNotFound
+			},
+		}, nil)
+
+		_, err := service.UpdateWalletStatus(ctx, uuid.New(), models.WalletFrozen)
+		assert.ErrorIs(t, err, custom_err.ErrNotFound)
+	})
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "wallet"
+
+// flushDurationBuckets — границы гистограммы длительности записи в БД, в секундах.
+// Запись ограничена таймаутом в 5 секунд.
+var flushDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		flushDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "flush_duration_seconds",
+			Help:      "Длительность записи пачки операций в БД.",
+			Buckets:   flushDurationBuckets,
+		}),
+	}
+}
+
+// metricsCollector отдаёт Metrics сервиса в Prometheus. Значения читаются в
+// момент опроса, поэтому отдельно их обновлять не нужно.
+type metricsCollector struct {
+	s *WalletService
+
+	flushes        *prometheus.Desc
+	flushesFailed  *prometheus.Desc
+	retries        *prometheus.Desc
+	flushConflicts *prometheus.Desc
+	deadLetters    *prometheus.Desc
+	evicted        *prometheus.Desc
+	writesDelayed  *prometheus.Desc
+	writesRejected *prometheus.Desc
+	loadsShared    *prometheus.Desc
+	negativeHits   *prometheus.Desc
+	holdsExpired   *prometheus.Desc
+	cachedWallets  *prometheus.Desc
+	dirtyWallets   *prometheus.Desc
+	pendingOps     *prometheus.Desc
+	retryQueue     *prometheus.Desc
+}
+
+// Collector возвращает prometheus.Collector с метриками сервиса: счётчиками
+// flush'а, кэша и backpressure, размером кэша, отставанием записи в БД и
+// гистограммой длительности flush'а.
+func (s *WalletService) Collector() prometheus.Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, labels, nil)
+	}
+	return &metricsCollector{
+		s:              s,
+		flushes:        desc("flushes_total", "Записи пачек операций в БД."),
+		flushesFailed:  desc("flushes_failed_total", "Неудачные записи пачек операций в БД."),
+		retries:        desc("flush_retries_total", "Пачки, отправленные в очередь повторов."),
+		flushConflicts: desc("flush_conflicts_total", "Кошельки, строка которых при flush'е оказалась изменена в обход кэша."),
+		deadLetters:    desc("dead_letters_total", "Пачки, отправленные в dead letter."),
+		evicted:        desc("cache_evictions_total", "Кошельки, вытесненные из кэша.", "reason"),
+		writesDelayed:  desc("writes_delayed_total", "Изменения баланса, замедленные backpressure."),
+		writesRejected: desc("writes_rejected_total", "Изменения баланса, отклонённые backpressure."),
+		loadsShared:    desc("cache_loads_shared_total", "Промахи кэша, дождавшиеся загрузки кошелька другим запросом."),
+		negativeHits:   desc("cache_negative_hits_total", "Ответы «не найдено» из кэша отсутствующих кошельков."),
+		holdsExpired:   desc("holds_expired_total", "Холды, резерв которых освобождён по истечении срока."),
+		cachedWallets:  desc("cache_wallets", "Кошельки в кэше."),
+		dirtyWallets:   desc("dirty_wallets", "Кошельки с незаписанными в БД изменениями."),
+		pendingOps:     desc("pending_operations", "Операции, учтённые в кэше, но ещё не записанные в БД."),
+		retryQueue:     desc("retry_queue_length", "Пачки в очереди повторов."),
+	}
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.flushes, c.flushesFailed, c.retries, c.flushConflicts, c.deadLetters, c.evicted,
+		c.writesDelayed, c.writesRejected, c.loadsShared, c.negativeHits, c.holdsExpired,
+		c.cachedWallets, c.dirtyWallets, c.pendingOps, c.retryQueue,
+	} {
+		ch <- d
+	}
+	c.s.metrics.flushDuration.Describe(ch)
+}
+
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	m := c.s.metrics
+	counter := func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge := func(d *prometheus.Desc, v int64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, float64(v))
+	}
+
+	counter(c.flushes, m.flushesTotal.Load())
+	counter(c.flushesFailed, m.flushesFailed.Load())
+	counter(c.retries, m.retriesTotal.Load())
+	counter(c.flushConflicts, m.flushConflicts.Load())
+	counter(c.deadLetters, m.deadLetters.Load())
+	counter(c.evicted, m.evictedLRU.Load(), "lru")
+	counter(c.evicted, m.evictedTTL.Load(), "ttl")
+	counter(c.writesDelayed, m.writesDelayed.Load())
+	counter(c.writesRejected, m.writesRejected.Load())
+	counter(c.loadsShared, m.loadsShared.Load())
+	counter(c.negativeHits, m.negativeHits.Load())
+	counter(c.holdsExpired, m.holdsExpired.Load())
+
+	gauge(c.cachedWallets, c.s.cachedWallets())
+	gauge(c.dirtyWallets, c.s.countDirty())
+	gauge(c.pendingOps, m.pendingOps.Load())
+	gauge(c.retryQueue, int64(len(c.s.retryQueue)))
+
+	m.flushDuration.Collect(ch)
+}
+
+// cachedWallets возвращает число кошельков в кэше.
+func (s *WalletService) cachedWallets() int64 {
+	var total int64
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		total += int64(len(shard.wallets))
+		shard.mu.RUnlock()
+	}
+	return total
+}
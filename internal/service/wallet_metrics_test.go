@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_Collector(t *testing.T) {
+	ctx := context.Background()
+	var bulkErr error
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+		},
+		BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			return nil, bulkErr
+		},
+	}, nil)
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(service.Collector()))
+
+	deposit := func(walletID uuid.UUID) models.WalletOperationRequest {
+		return models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10}
+	}
+	flushed, dirty := uuid.New(), uuid.New()
+	_, err := service.UpdateBalance(ctx, deposit(flushed))
+	require.NoError(t, err)
+	_, err = service.FlushAll(ctx)
+	require.NoError(t, err)
+
+	bulkErr = errors.New("connection refused")
+	_, err = service.UpdateBalance(ctx, deposit(dirty))
+	require.NoError(t, err)
+	_, err = service.UpdateBalance(ctx, deposit(dirty))
+	require.NoError(t, err)
+	_, err = service.FlushAll(ctx)
+	require.Error(t, err)
+
+	expected := `
+# HELP wallet_cache_wallets Кошельки в кэше.
+# TYPE wallet_cache_wallets gauge
+wallet_cache_wallets 2
+# HELP wallet_dirty_wallets Кошельки с незаписанными в БД изменениями.
+# TYPE wallet_dirty_wallets gauge
+wallet_dirty_wallets 1
+# HELP wallet_pending_operations Операции, учтённые в кэше, но ещё не записанные в БД.
+# TYPE wallet_pending_operations gauge
+wallet_pending_operations 2
+# HELP wallet_flushes_total Записи пачек операций в БД.
+# TYPE wallet_flushes_total counter
+wallet_flushes_total 2
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"wallet_cache_wallets", "wallet_dirty_wallets", "wallet_pending_operations", "wallet_flushes_total"))
+
+	var histogram dto.Metric
+	require.NoError(t, service.metrics.flushDuration.Write(&histogram))
+	assert.Equal(t, uint64(2), histogram.GetHistogram().GetSampleCount(), "failed flushes are timed too")
+	problems, err := testutil.GatherAndLint(reg)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// NumShards — число шардов, между которыми распределяются кошельки.
+const NumShards = numShards
+
+// ShardIndex возвращает шард, которому принадлежит кошелек.
+func ShardIndex(id uuid.UUID) int {
+	return shardIndex(id)
+}
+
+// WithShardOwnership включает режим, в котором экземпляр обслуживает только
+// шарды, выданные ему через AcquireShard. Запросы к чужим кошелькам получают
+// custom_err.ErrNotOwner. Изначально экземпляр не владеет ни одним шардом.
+func WithShardOwnership() Option {
+	return func(s *WalletService) {
+		s.leased = true
+		for _, shard := range s.shards {
+			shard.owned = false
+		}
+	}
+}
+
+// enterShards проверяет, что экземпляр владеет шардами кошельков ids, и не даёт
+// снять владение, пока вызывающий не вызовет leave.
+func (s *WalletService) enterShards(ids ...uuid.UUID) (leave func(), err error) {
+	if !s.leased {
+		return func() {}, nil
+	}
+
+	indexes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		index := shardIndex(id)
+		if !containsInt(indexes, index) {
+			indexes = append(indexes, index)
+		}
+	}
+	// Шарды берутся по возрастанию индекса, как и при нескольких mu кошельков.
+	sort.Ints(indexes)
+
+	entered := make([]*Shard, 0, len(indexes))
+	leave = func() {
+		for i := len(entered) - 1; i >= 0; i-- {
+			entered[i].lease.RUnlock()
+		}
+	}
+	for _, index := range indexes {
+		shard := s.shards[index]
+		shard.lease.RLock()
+		if !shard.owned {
+			shard.lease.RUnlock()
+			leave()
+			return nil, custom_err.ErrNotOwner
+		}
+		entered = append(entered, shard)
+	}
+	return leave, nil
+}
+
+// tryEnterShard — неблокирующий enterShards для одного кошелька. Используется,
+// когда вызывающий уже удерживает другой шард и порядок взятия не гарантирован.
+func (s *WalletService) tryEnterShard(id uuid.UUID) (leave func(), ok bool) {
+	if !s.leased {
+		return func() {}, true
+	}
+	shard := s.getShard(id)
+	if !shard.lease.TryRLock() {
+		return nil, false
+	}
+	if !shard.owned {
+		shard.lease.RUnlock()
+		return nil, false
+	}
+	return shard.lease.RUnlock, true
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// AcquireShard начинает обслуживать шард. Кэш шарда мог устареть, пока шардом
+// владел другой экземпляр: чистые кошельки выбрасываются и загрузятся из БД
+// заново, оставшиеся грязные сверяются с БД, кэш отсутствующих сбрасывается.
+func (s *WalletService) AcquireShard(ctx context.Context, index int) error {
+	const op = "service.AcquireShard"
+	shard := s.shards[index]
+
+	shard.lease.Lock()
+	defer shard.lease.Unlock()
+	if shard.owned {
+		return nil
+	}
+
+	shard.clearMissing()
+	dirty := s.evictClean(shard)
+	if len(dirty) > 0 {
+		changes, err := s.repo.GetWalletStates(ctx, dirty)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		for _, change := range changes {
+			s.applyChange(change)
+		}
+	}
+	shard.owned = true
+	return nil
+}
+
+// ReleaseShard перестаёт обслуживать шард: дожидается начатых обращений,
+// записывает грязные кошельки шарда в БД и выбрасывает их из кэша. Возвращает
+// число кошельков, оставшихся незаписанными; их допишет фоновый flush.
+func (s *WalletService) ReleaseShard(ctx context.Context, index int) (unpersisted int, err error) {
+	const op = "service.ReleaseShard"
+	shard := s.shards[index]
+
+	shard.lease.Lock()
+	shard.owned = false
+	shard.lease.Unlock()
+
+	_, err = s.flushShard(ctx, shard)
+	dirty := s.evictClean(shard)
+	if err != nil {
+		return len(dirty), fmt.Errorf("%s: %w", op, err)
+	}
+	if len(dirty) > 0 {
+		return len(dirty), fmt.Errorf("%s: %d кошельков шарда %d не записаны", op, len(dirty), index)
+	}
+	return 0, nil
+}
+
+// OwnsShard сообщает, обслуживает ли экземпляр шард.
+func (s *WalletService) OwnsShard(index int) bool {
+	shard := s.shards[index]
+	shard.lease.RLock()
+	defer shard.lease.RUnlock()
+	return shard.owned
+}
+
+// evictClean выбрасывает из кэша шарда записанные кошельки и возвращает
+// оставшиеся — те, чьи изменения ещё не в БД.
+func (s *WalletService) evictClean(shard *Shard) []uuid.UUID {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	var dirty []uuid.UUID
+	for id, state := range shard.wallets {
+		// В отличие от tryEvict ждём mu: занятый кошелек не должен остаться в кэше чужого шарда.
+		state.mu.Lock()
+		if state.unpersisted() {
+			dirty = append(dirty, id)
+		} else {
+			state.evicted = true
+			delete(shard.wallets, id)
+		}
+		state.mu.Unlock()
+	}
+	return dirty
+}
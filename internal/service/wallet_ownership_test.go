@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_ShardOwnership(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func(bulk func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error)) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Status: models.WalletActive}, nil
+			},
+			BulkApplyOperationsFunc: bulk,
+		}, nil, WithShardOwnership())
+	}
+	deposit := func(walletID uuid.UUID) models.WalletOperationRequest {
+		return models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10}
+	}
+
+	t.Run("Wallets of unowned shards are rejected", func(t *testing.T) {
+		service := newService(nil)
+		walletID := uuid.New()
+
+		_, err := service.GetWalletByID(ctx, walletID)
+		assert.ErrorIs(t, err, custom_err.ErrNotOwner)
+		_, err = service.UpdateBalance(ctx, deposit(walletID))
+		assert.ErrorIs(t, err, custom_err.ErrNotOwner)
+		_, err = service.ListOperations(ctx, walletID, models.OperationFilter{})
+		assert.ErrorIs(t, err, custom_err.ErrNotOwner)
+
+		require.NoError(t, service.AcquireShard(ctx, ShardIndex(walletID)))
+		assert.True(t, service.OwnsShard(ShardIndex(walletID)))
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), wallet.Balance)
+	})
+
+	t.Run("Release flushes and evicts the shard", func(t *testing.T) {
+		var persisted []models.Operation
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			persisted = append(persisted, ops...)
+			return nil, nil
+		})
+		walletID := uuid.New()
+		require.NoError(t, service.AcquireShard(ctx, ShardIndex(walletID)))
+		_, err := service.UpdateBalance(ctx, deposit(walletID))
+		require.NoError(t, err)
+
+		unpersisted, err := service.ReleaseShard(ctx, ShardIndex(walletID))
+		require.NoError(t, err)
+		assert.Zero(t, unpersisted)
+		assert.Equal(t, map[uuid.UUID]int64{walletID: 10}, sumByWallet(persisted))
+		assert.Nil(t, service.cachedState(walletID))
+		assert.False(t, service.OwnsShard(ShardIndex(walletID)))
+		_, err = service.UpdateBalance(ctx, deposit(walletID))
+		assert.ErrorIs(t, err, custom_err.ErrNotOwner)
+	})
+
+	t.Run("Release reports unflushed wallets, acquire reconciles them", func(t *testing.T) {
+		var states []uuid.UUID
+		repo := &mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+			},
+			BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+				return nil, errors.New("db is down")
+			},
+		}
+		service := NewWalletService(repo, nil, WithShardOwnership())
+		walletID := uuid.New()
+		require.NoError(t, service.AcquireShard(ctx, ShardIndex(walletID)))
+		_, err := service.UpdateBalance(ctx, deposit(walletID))
+		require.NoError(t, err)
+
+		unpersisted, err := service.ReleaseShard(ctx, ShardIndex(walletID))
+		assert.Error(t, err)
+		assert.Equal(t, 1, unpersisted)
+		require.NotNil(t, service.cachedState(walletID), "unflushed wallets stay cached")
+
+		// Пока шард был чужим, строку изменил другой экземпляр.
+		repo.GetWalletStatesFunc = func(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+			states = append(states, ids...)
+			return []models.WalletChange{{ID: walletID, Balance: 150, Version: 2, Status: models.WalletActive}}, nil
+		}
+		require.NoError(t, service.AcquireShard(ctx, ShardIndex(walletID)))
+		assert.Equal(t, []uuid.UUID{walletID}, states)
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(160), wallet.Balance)
+	})
+
+	t.Run("Transfer to a wallet of another instance is committed in the database", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		table := &syncTable{
+			balances: map[uuid.UUID]int64{from: 100, to: 0},
+			versions: map[uuid.UUID]int64{from: 0, to: 0},
+		}
+		txManager := &mockTxManager{}
+		service := NewWalletService(newSyncRepository(table), txManager, WithShardOwnership())
+		require.NoError(t, service.AcquireShard(ctx, ShardIndex(from)))
+
+		_, err := service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 40})
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(60), table.balances[from])
+		assert.Equal(t, int64(40), table.balances[to])
+		assert.Len(t, txManager.txs, 1)
+		assert.Equal(t, int64(60), service.cachedState(from).balance.Load())
+		assert.Nil(t, service.cachedState(to), "wallets of other instances are not cached")
+
+		_, err = service.Transfer(ctx, models.TransferRequest{FromWalletID: to, ToWalletID: from, Amount: 10})
+		assert.ErrorIs(t, err, custom_err.ErrNotOwner)
+	})
+}
@@ -2,23 +2,36 @@ package service
 
 import (
 	"api_wallet/internal/custom_err"
+	"api_wallet/internal/journal"
 	"api_wallet/internal/models"
 	"api_wallet/internal/repository"
 	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"log"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // WalletServicer описывает, что должен уметь сервис кошелька.
 type WalletServicer interface {
-	UpdateBalance(ctx context.Context, req models.WalletOperationRequest) error
+	UpdateBalance(ctx context.Context, req models.WalletOperationRequest) (*models.OperationReceipt, error)
 	GetWalletByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	CreateWallet(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	UpdateWalletStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error)
+	Transfer(ctx context.Context, req models.TransferRequest) (*models.Transfer, error)
+	ListOperations(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) (*models.OperationPage, error)
+	CreateHold(ctx context.Context, walletID uuid.UUID, req models.CreateHoldRequest) (*models.Hold, error)
+	CaptureHold(ctx context.Context, walletID, holdID uuid.UUID, req models.CaptureHoldRequest) (*models.Hold, error)
+	VoidHold(ctx context.Context, walletID, holdID uuid.UUID) (*models.Hold, error)
 }
 
 var _ WalletServicer = (*WalletService)(nil)
@@ -35,61 +48,127 @@ const (
 )
 
 type WalletService struct {
-	repo       repository.Wallet // Используем абстрактный интерфейс
-	txManager  TxManager
-	shards     [numShards]*Shard
-	retryQueue chan retryItem
-	metrics    *Metrics
+	repo        repository.Wallet // Используем абстрактный интерфейс
+	txManager   TxManager
+	shards      [numShards]*Shard
+	retryQueue  chan retryItem
+	metrics     *Metrics
+	journal     *journal.Journal
+	idempotency *idempotencyCache
+	changes     ChangeFeed
+	deadLetters DeadLetterStore
+	// backpressure ограничивает приём записей при отставании flush'а, nil — без ограничений.
+	backpressure *Backpressure
+	// cacheTTL — время простоя, после которого чистый кошелек выбрасывается из кэша.
+	cacheTTL time.Duration
+	// leased — шарды раздаются между экземплярами, см. WithShardOwnership.
+	leased bool
+	// holdExpiryInterval — период освобождения холдов с истёкшим сроком.
+	holdExpiryInterval time.Duration
+
+	consistency    ConsistencyMode
+	syncMaxRetries int
+
+	// lifecycle удерживается на чтение каждой пишущей операцией; Stop берёт его
+	// на запись, чтобы дождаться начатых операций и закрыть приём новых.
+	lifecycle sync.RWMutex
+	closing   bool
+	stop      chan struct{}
+	workers   sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	// traces — трейсы запросов, чьи операции ждут flush'а.
+	traces *opTraces
+
+	// flushWorkers — состояние воркеров flush'а для проверки готовности.
+	flushWorkers [numFlushWorkers]flushWorkerState
 }
 
+// Option настраивает необязательные возможности WalletService.
+type Option func(*WalletService)
+
 type Metrics struct {
-	flushesTotal   atomic.Int64
-	flushesFailed  atomic.Int64
-	walletsInCache atomic.Int64
-	retriesTotal   atomic.Int64
+	flushesTotal  atomic.Int64
+	flushesFailed atomic.Int64
+	retriesTotal  atomic.Int64
+	// flushDuration — длительность записи пачек в БД, см. Collector.
+	flushDuration prometheus.Histogram
+	// flushConflicts — конфликты версий при flush'е, по кошелькам.
+	flushConflicts atomic.Int64
+	// evictedLRU и evictedTTL — кошельки, выброшенные из кэша по лимиту и по простою.
+	evictedLRU atomic.Int64
+	evictedTTL atomic.Int64
+	// deadLetters — снимки, отправленные в dead letter.
+	deadLetters atomic.Int64
+	// pendingOps — операции, учтённые в кэше, но ещё не записанные в БД.
+	pendingOps atomic.Int64
+	// dirtyWallets — число грязных кошельков на момент последнего обхода.
+	dirtyWallets atomic.Int64
+	// writesDelayed и writesRejected — записи, замедленные и отклонённые backpressure.
+	writesDelayed  atomic.Int64
+	writesRejected atomic.Int64
+	// loadsShared — промахи кэша, дождавшиеся чужой загрузки кошелька вместо своей;
+	// negativeHits — ответы «не найдено» из кэша отсутствующих кошельков.
+	loadsShared  atomic.Int64
+	negativeHits atomic.Int64
+	// holdsExpired — холды, резерв которых освобождён по истечении срока.
+	holdsExpired atomic.Int64
 }
 
+// retryItem — группа снимков, которую нужно записать одной транзакцией.
 type retryItem struct {
-	walletID uuid.UUID
-	balance  int64
-	attempts int
+	snapshots []walletSnapshot
+	attempts  int
+	// err — ошибка последней попытки записи.
+	err error
 }
 
 // NewWalletService теперь принимает интерфейс repository.Wallet
-func NewWalletService(repo repository.Wallet, txManager TxManager) *WalletService {
+func NewWalletService(repo repository.Wallet, txManager TxManager, opts ...Option) *WalletService {
 	s := &WalletService{
-		repo:       repo,
-		txManager:  txManager,
-		retryQueue: make(chan retryItem, 50000),
-		metrics:    &Metrics{},
-	}
+		repo:        repo,
+		txManager:   txManager,
+		retryQueue:  make(chan retryItem, 50000),
+		metrics:     newMetrics(),
+		idempotency: newIdempotencyCache(),
+		traces:      newOpTraces(),
 
-	for i := 0; i < numShards; i++ {
-		s.shards[i] = &Shard{
-			wallets: make(map[uuid.UUID]*WalletState),
-		}
+		consistency:        ConsistencyCache,
+		syncMaxRetries:     defaultSyncMaxRetries,
+		holdExpiryInterval: defaultHoldExpiryInterval,
+		stop:               make(chan struct{}),
 	}
 
-	// Запускаем фоновые воркеры
-	for i := 0; i < numFlushWorkers; i++ {
-		go s.flusher(i)
+	for i := 0; i < numShards; i++ {
+		s.shards[i] = newShard(s.metrics)
 	}
-	for i := 0; i < 2; i++ {
-		go s.retryWorker(i)
+	for _, opt := range opts {
+		opt(s)
 	}
-	go s.metricsLogger()
 
 	return s
 }
 
-func (s *WalletService) getShard(id uuid.UUID) *Shard {
+func shardIndex(id uuid.UUID) int {
 	hasher := fnv.New64a()
 	hasher.Write(id[:])
-	return s.shards[hasher.Sum64()&(numShards-1)]
+	return int(hasher.Sum64() & (numShards - 1))
 }
 
-func (s *WalletService) GetWalletByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+func (s *WalletService) getShard(id uuid.UUID) *Shard {
+	return s.shards[shardIndex(id)]
+}
+
+func (s *WalletService) GetWalletByID(ctx context.Context, id uuid.UUID) (_ *models.Wallet, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.GetWalletByID", trace.WithAttributes(walletAttr(id)))
+	defer func() { endSpan(span, err) }()
 	const op = "service.GetWalletByID"
+	leave, err := s.enterShards(id)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
 	shard := s.getShard(id)
 
 	state, err := shard.loadStateIntoCacheIfExists(ctx, id, s.repo)
@@ -100,25 +179,161 @@ func (s *WalletService) GetWalletByID(ctx context.Context, id uuid.UUID) (*model
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &models.Wallet{ID: id, Balance: state.balance.Load()}, nil
+	balance, reserved := state.balance.Load(), state.reserved.Load()
+	return &models.Wallet{ID: id, Balance: balance, Reserved: reserved, Available: balance - reserved, Status: state.Status()}, nil
+}
+
+// UpdateBalance применяет операцию к кошельку и возвращает её результат. Запрос
+// с заполненным RequestID выполняется не более одного раза: повтор получает исход
+// первого запроса.
+func (s *WalletService) UpdateBalance(ctx context.Context, req models.WalletOperationRequest) (_ *models.OperationReceipt, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.UpdateBalance", trace.WithAttributes(walletAttr(req.WalletID), attribute.String("operation.type", string(req.OperationType))))
+	defer func() { endSpan(span, err) }()
+	if err := s.admit(ctx); err != nil {
+		return nil, err
+	}
+	done, err := s.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	leave, err := s.enterShards(req.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	key := idempotencyKey{walletID: req.WalletID, requestID: req.RequestID}
+	recorded := func(existing *models.Operation) (*models.OperationReceipt, bool) {
+		return receiptFromOperation(*existing), existing.TransferID == nil &&
+			existing.Type == req.OperationType && abs(existing.Amount) == req.Amount
+	}
+	return idempotent(ctx, s, key, operationFingerprint(req.OperationType, req.Amount), recorded, func() (*models.OperationReceipt, error) {
+		return retryEvicted(func() (*models.OperationReceipt, error) {
+			return s.updateBalance(ctx, req)
+		})
+	})
 }
 
-func (s *WalletService) UpdateBalance(ctx context.Context, req models.WalletOperationRequest) error {
+func (s *WalletService) updateBalance(ctx context.Context, req models.WalletOperationRequest) (*models.OperationReceipt, error) {
 	const op = "service.UpdateBalance"
 	shard := s.getShard(req.WalletID)
 
 	state, err := shard.loadStateIntoCacheIfExists(ctx, req.WalletID, s.repo)
 	if err != nil {
 		if errors.Is(err, custom_err.ErrNotFound) {
-			return err // Пробрасываем ErrNotFound как есть
+			return nil, err // Пробрасываем ErrNotFound как есть
 		}
-		return fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	operation := models.Operation{
+		ID:        uuid.New(),
+		WalletID:  req.WalletID,
+		Type:      req.OperationType,
+		Amount:    req.Amount,
+		RequestID: req.RequestID,
+		// Postgres хранит микросекунды, обрезаем сразу, чтобы кэш и БД совпадали.
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
 	if req.OperationType == models.WithdrawOperation {
-		return state.Withdraw(req.Amount)
+		operation.Amount = -req.Amount
+	}
+	if s.consistency == ConsistencySync {
+		return s.applyOperationSync(ctx, state, operation)
+	}
+	s.traces.remember(ctx, operation)
+	receipt, err := s.applyOperation(state, operation)
+	if err != nil {
+		s.traces.forget([]models.Operation{operation})
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// newReceipt описывает принятую операцию: balance — баланс кошелька после неё,
+// version — версия строки кошелька в БД, от которой он отсчитан.
+func newReceipt(operation models.Operation, balance, version int64, persisted bool) *models.OperationReceipt {
+	receipt := receiptFromOperation(operation)
+	receipt.BalanceAfter = &balance
+	receipt.Version = &version
+	receipt.Persisted = persisted
+	return receipt
+}
+
+// receiptFromOperation описывает операцию из истории, которая уже записана в БД.
+func receiptFromOperation(operation models.Operation) *models.OperationReceipt {
+	return &models.OperationReceipt{
+		OperationID: operation.ID,
+		WalletID:    operation.WalletID,
+		Type:        operation.Type,
+		Amount:      abs(operation.Amount),
+		AcceptedAt:  operation.CreatedAt,
+		Persisted:   true,
+	}
+}
+
+// applyOperation изменяет баланс кошелька и ставит операцию в очередь на запись
+// в историю. Если журнал включён, дожидается записи операции на диск.
+func (s *WalletService) applyOperation(state *WalletState, operation models.Operation) (*models.OperationReceipt, error) {
+	const op = "service.applyOperation"
+
+	if err := state.lock(); err != nil {
+		return nil, err
+	}
+	if s.journal != nil && state.pendingSeq.Load() == 0 {
+		// NextSeq не больше seq, который получит запись ниже, поэтому это безопасная нижняя граница.
+		state.pendingSeq.Store(s.journal.NextSeq())
+	}
+
+	if err := state.checkStatus(operation.Amount); err != nil {
+		state.mu.Unlock()
+		return nil, err
 	}
 
-	state.Add(req.Amount)
-	return nil
+	var balance int64
+	var err error
+	if operation.Amount < 0 {
+		balance, err = state.withdraw(-operation.Amount)
+	} else {
+		balance = state.add(operation.Amount)
+	}
+	if err != nil {
+		state.mu.Unlock()
+		return nil, err
+	}
+	state.ops = append(state.ops, operation)
+	s.metrics.pendingOps.Add(1)
+	receipt := newReceipt(operation, balance, state.version, false)
+
+	if s.journal == nil {
+		state.mu.Unlock()
+		return receipt, nil
+	}
+
+	ticket, err := s.journal.Append(journal.Entry{WalletID: operation.WalletID, Balance: balance, Operation: &operation})
+	if err != nil {
+		state.removePendingOp(operation.ID)
+		s.metrics.pendingOps.Add(-1)
+		state.balance.Add(-operation.Amount)
+		state.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	state.journalSeq.Store(ticket.Seq)
+	state.mu.Unlock()
+
+	if err := ticket.Wait(); err != nil {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		// Если flusher уже забрал операцию, она идёт в БД обычным путём и считается принятой.
+		if !state.removePendingOp(operation.ID) {
+			log.Printf("[Journal] Operation %s accepted without journal: %v", operation.ID, err)
+			return receipt, nil
+		}
+		s.metrics.pendingOps.Add(-1)
+		state.balance.Add(-operation.Amount)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	receipt.Persisted = true
+	return receipt, nil
 }
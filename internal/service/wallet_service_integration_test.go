@@ -64,6 +64,8 @@ func TestWalletService_Integration_Flush(t *testing.T) {
 
 	repo := postgres.NewWalletRepository(pool)
 	service := NewWalletService(repo, pool)
+	service.Start()
+	defer service.Stop(context.Background())
 
 	walletID := uuid.New()
 	initialBalance := int64(1000)
@@ -73,7 +75,7 @@ func TestWalletService_Integration_Flush(t *testing.T) {
 
 	amountToAdd := int64(500)
 	req := models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: amountToAdd}
-	err = service.UpdateBalance(context.Background(), req)
+	_, err = service.UpdateBalance(context.Background(), req)
 	require.NoError(t, err)
 
 	getBalanceFromDB := func() int64 {
@@ -95,3 +97,96 @@ func TestWalletService_Integration_Flush(t *testing.T) {
 
 	t.Log("Flusher successfully updated the balance in the database!")
 }
+
+func TestWalletService_Integration_StopPersistsChanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	pool, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	service := NewWalletService(postgres.NewWalletRepository(pool), pool)
+	service.Start()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(context.Background(), "INSERT INTO wallets (id, balance) VALUES ($1, $2)", walletID, 1000)
+	require.NoError(t, err)
+
+	req := models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 300, RequestID: uuid.New()}
+	_, err = service.UpdateBalance(context.Background(), req)
+	require.NoError(t, err)
+
+	report, err := service.Stop(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, report.Unpersisted)
+
+	var balance int64
+	err = pool.QueryRow(context.Background(), "SELECT balance FROM wallets WHERE id = $1", walletID).Scan(&balance)
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), balance, "Stop must flush without waiting for the flusher tick")
+
+	var ops int
+	err = pool.QueryRow(context.Background(), "SELECT count(*) FROM operations WHERE wallet_id = $1", walletID).Scan(&ops)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ops)
+}
+
+func TestWalletService_Integration_TwoInstances(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	pool, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	newInstance := func() *WalletService {
+		service := NewWalletService(postgres.NewWalletRepository(pool), pool,
+			WithChangeFeed(postgres.NewWalletChangeListener(pool)))
+		service.Start()
+		t.Cleanup(func() { service.Stop(context.Background()) })
+		return service
+	}
+	first, second := newInstance(), newInstance()
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	_, err := pool.Exec(ctx, "INSERT INTO wallets (id, balance) VALUES ($1, $2)", walletID, 1000)
+	require.NoError(t, err)
+
+	// Оба экземпляра держат кошелек в кэше и меняют его независимо.
+	_, err = first.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 100})
+	require.NoError(t, err)
+	_, err = second.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 30})
+	require.NoError(t, err)
+
+	_, err = first.FlushAll(ctx)
+	require.NoError(t, err)
+	_, err = second.FlushAll(ctx)
+	require.NoError(t, err)
+
+	var balance int64
+	err = pool.QueryRow(ctx, "SELECT balance FROM wallets WHERE id = $1", walletID).Scan(&balance)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1070), balance, "flushes of both instances must add up, not overwrite each other")
+
+	var ops int
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM operations WHERE wallet_id = $1", walletID).Scan(&ops)
+	require.NoError(t, err)
+	assert.Equal(t, 2, ops)
+
+	for name, service := range map[string]*WalletService{"first": first, "second": second} {
+		assert.Eventually(t, func() bool {
+			wallet, err := service.GetWalletByID(ctx, walletID)
+			return err == nil && wallet.Balance == 1070
+		}, 5*time.Second, 50*time.Millisecond, "%s instance must see the other instance's change", name)
+	}
+
+	// Смена статуса на одном экземпляре доходит до другого.
+	_, err = first.UpdateWalletStatus(ctx, walletID, models.WalletFrozen)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		wallet, err := second.GetWalletByID(ctx, walletID)
+		return err == nil && wallet.Status == models.WalletFrozen
+	}, 5*time.Second, 50*time.Millisecond)
+}
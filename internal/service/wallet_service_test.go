@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"api_wallet/internal/custom_err"
 	"api_wallet/internal/models"
 	"api_wallet/internal/repository"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -17,9 +19,25 @@ import (
 var _ repository.Wallet = (*mockRepository)(nil)
 
 type mockRepository struct {
-	GetByIDFunc             func(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
-	BulkUpdateBalancesFunc  func(ctx context.Context, wallets map[uuid.UUID]int64) error
-	UpsertWalletBalanceFunc func(ctx context.Context, id uuid.UUID, balance int64) error
+	GetByIDFunc                 func(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	CreateFunc                  func(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	UpdateStatusFunc            func(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error)
+	BulkApplyOperationsFunc     func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error)
+	GetWalletStatesFunc         func(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error)
+	GetOperationByRequestIDFunc func(ctx context.Context, walletID, requestID uuid.UUID) (*models.Operation, error)
+	ListOperationsFunc          func(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) ([]models.Operation, error)
+
+	GetWalletStateTxFunc                  func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error)
+	UpdateBalanceWithOptimisticLockTxFunc func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance int64, expectedVersion int64) error
+	UpdateFundsWithOptimisticLockTxFunc   func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance, newReserved, expectedVersion int64) error
+	CheckOperationExistsTxFunc            func(ctx context.Context, tx pgx.Tx, walletID, requestID uuid.UUID) (bool, error)
+	CreateOperationTxFunc                 func(ctx context.Context, tx pgx.Tx, operation models.Operation) error
+
+	CreateHoldTxFunc       func(ctx context.Context, tx pgx.Tx, hold models.Hold) error
+	GetHoldForUpdateTxFunc func(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.Hold, error)
+	UpdateHoldTxFunc       func(ctx context.Context, tx pgx.Tx, hold models.Hold) error
+	GetHoldByRequestIDFunc func(ctx context.Context, walletID, requestID uuid.UUID) (*models.Hold, error)
+	ListExpiredHoldsFunc   func(ctx context.Context, now time.Time, limit int) ([]models.Hold, error)
 }
 
 func (m *mockRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
@@ -29,20 +47,127 @@ func (m *mockRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Wal
 	return nil, errors.New("GetByIDFunc not implemented")
 }
 
-func (m *mockRepository) BulkUpdateBalances(ctx context.Context, wallets map[uuid.UUID]int64) error {
-	if m.BulkUpdateBalancesFunc != nil {
-		return m.BulkUpdateBalancesFunc(ctx, wallets)
+func (m *mockRepository) Create(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	if m.CreateFunc != nil {
+		return m.CreateFunc(ctx, id)
+	}
+	return &models.Wallet{ID: id, Status: models.WalletActive}, nil
+}
+
+func (m *mockRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus) (*models.Wallet, error) {
+	if m.UpdateStatusFunc != nil {
+		return m.UpdateStatusFunc(ctx, id, status)
+	}
+	return &models.Wallet{ID: id, Status: status}, nil
+}
+
+func (m *mockRepository) BulkApplyOperations(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+	if m.BulkApplyOperationsFunc != nil {
+		return m.BulkApplyOperationsFunc(ctx, walletIDs, ops, versions)
+	}
+	return nil, nil
+}
+
+func (m *mockRepository) GetWalletStates(ctx context.Context, ids []uuid.UUID) ([]models.WalletChange, error) {
+	if m.GetWalletStatesFunc != nil {
+		return m.GetWalletStatesFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+// sumByWallet складывает суммы операций по кошелькам — то, что flush прибавит к балансам в БД.
+func sumByWallet(ops []models.Operation) map[uuid.UUID]int64 {
+	sums := make(map[uuid.UUID]int64)
+	for _, op := range ops {
+		sums[op.WalletID] += op.Amount
+	}
+	return sums
+}
+
+func (m *mockRepository) GetOperationByRequestID(ctx context.Context, walletID, requestID uuid.UUID) (*models.Operation, error) {
+	if m.GetOperationByRequestIDFunc != nil {
+		return m.GetOperationByRequestIDFunc(ctx, walletID, requestID)
+	}
+	return nil, custom_err.ErrNotFound
+}
+
+func (m *mockRepository) ListOperations(ctx context.Context, walletID uuid.UUID, filter models.OperationFilter) ([]models.Operation, error) {
+	if m.ListOperationsFunc != nil {
+		return m.ListOperationsFunc(ctx, walletID, filter)
+	}
+	return nil, nil
+}
+
+func (m *mockRepository) GetWalletStateTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error) {
+	if m.GetWalletStateTxFunc != nil {
+		return m.GetWalletStateTxFunc(ctx, tx, walletID)
+	}
+	return models.WalletChange{}, errors.New("GetWalletStateTxFunc not implemented")
+}
+
+func (m *mockRepository) UpdateBalanceWithOptimisticLockTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance int64, expectedVersion int64) error {
+	if m.UpdateBalanceWithOptimisticLockTxFunc != nil {
+		return m.UpdateBalanceWithOptimisticLockTxFunc(ctx, tx, walletID, newBalance, expectedVersion)
 	}
 	return nil
 }
 
-func (m *mockRepository) UpsertWalletBalance(ctx context.Context, id uuid.UUID, balance int64) error {
-	if m.UpsertWalletBalanceFunc != nil {
-		return m.UpsertWalletBalanceFunc(ctx, id, balance)
+func (m *mockRepository) UpdateFundsWithOptimisticLockTx(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance, newReserved, expectedVersion int64) error {
+	if m.UpdateFundsWithOptimisticLockTxFunc != nil {
+		return m.UpdateFundsWithOptimisticLockTxFunc(ctx, tx, walletID, newBalance, newReserved, expectedVersion)
 	}
 	return nil
 }
 
+func (m *mockRepository) CheckOperationExistsTx(ctx context.Context, tx pgx.Tx, walletID, requestID uuid.UUID) (bool, error) {
+	if m.CheckOperationExistsTxFunc != nil {
+		return m.CheckOperationExistsTxFunc(ctx, tx, walletID, requestID)
+	}
+	return false, nil
+}
+
+func (m *mockRepository) CreateOperationTx(ctx context.Context, tx pgx.Tx, operation models.Operation) error {
+	if m.CreateOperationTxFunc != nil {
+		return m.CreateOperationTxFunc(ctx, tx, operation)
+	}
+	return nil
+}
+
+func (m *mockRepository) CreateHoldTx(ctx context.Context, tx pgx.Tx, hold models.Hold) error {
+	if m.CreateHoldTxFunc != nil {
+		return m.CreateHoldTxFunc(ctx, tx, hold)
+	}
+	return nil
+}
+
+func (m *mockRepository) GetHoldForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.Hold, error) {
+	if m.GetHoldForUpdateTxFunc != nil {
+		return m.GetHoldForUpdateTxFunc(ctx, tx, id)
+	}
+	return nil, custom_err.ErrNotFound
+}
+
+func (m *mockRepository) UpdateHoldTx(ctx context.Context, tx pgx.Tx, hold models.Hold) error {
+	if m.UpdateHoldTxFunc != nil {
+		return m.UpdateHoldTxFunc(ctx, tx, hold)
+	}
+	return nil
+}
+
+func (m *mockRepository) GetHoldByRequestID(ctx context.Context, walletID, requestID uuid.UUID) (*models.Hold, error) {
+	if m.GetHoldByRequestIDFunc != nil {
+		return m.GetHoldByRequestIDFunc(ctx, walletID, requestID)
+	}
+	return nil, custom_err.ErrNotFound
+}
+
+func (m *mockRepository) ListExpiredHolds(ctx context.Context, now time.Time, limit int) ([]models.Hold, error) {
+	if m.ListExpiredHoldsFunc != nil {
+		return m.ListExpiredHoldsFunc(ctx, now, limit)
+	}
+	return nil, nil
+}
+
 func TestWalletService_GetWalletByID(t *testing.T) {
 	walletID := uuid.New()
 
@@ -113,7 +238,7 @@ func TestWalletService_UpdateBalance(t *testing.T) {
 		service := NewWalletService(mockRepo, nil)
 
 		req := models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 50}
-		err := service.UpdateBalance(context.Background(), req)
+		receipt, err := service.UpdateBalance(context.Background(), req)
 
 		require.NoError(t, err)
 
@@ -121,6 +246,38 @@ func TestWalletService_UpdateBalance(t *testing.T) {
 		state := shard.wallets[walletID]
 		assert.Equal(t, int64(150), state.balance.Load())
 		assert.True(t, state.dirty.Load())
+
+		require.Len(t, state.ops, 1)
+		assert.Equal(t, state.ops[0].ID, receipt.OperationID)
+		assert.Equal(t, models.DepositOperation, receipt.Type)
+		assert.Equal(t, int64(50), receipt.Amount)
+		require.NotNil(t, receipt.BalanceAfter)
+		assert.Equal(t, int64(150), *receipt.BalanceAfter)
+		assert.Equal(t, state.ops[0].CreatedAt, receipt.AcceptedAt)
+		assert.False(t, receipt.Persisted, "without a journal the operation lives only in memory until flush")
+	})
+
+	t.Run("Success - Operation queued for history", func(t *testing.T) {
+		mockRepo := &mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100}, nil
+			},
+		}
+		service := NewWalletService(mockRepo, nil)
+
+		requestID := uuid.New()
+		req := models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 40, RequestID: requestID}
+		receipt, err := service.UpdateBalance(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, int64(40), receipt.Amount, "receipt amount is positive, the direction is in the type")
+		assert.Equal(t, int64(60), *receipt.BalanceAfter)
+
+		state := service.getShard(walletID).wallets[walletID]
+		require.Len(t, state.ops, 1)
+		assert.Equal(t, int64(-40), state.ops[0].Amount, "withdrawals are recorded with a negative amount")
+		assert.Equal(t, models.WithdrawOperation, state.ops[0].Type)
+		assert.Equal(t, requestID, state.ops[0].RequestID)
+		assert.NotEqual(t, uuid.Nil, state.ops[0].ID)
 	})
 
 	t.Run("Error - Insufficient Funds", func(t *testing.T) {
@@ -132,7 +289,7 @@ func TestWalletService_UpdateBalance(t *testing.T) {
 		service := NewWalletService(mockRepo, nil)
 
 		req := models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 200}
-		err := service.UpdateBalance(context.Background(), req)
+		_, err := service.UpdateBalance(context.Background(), req)
 
 		require.Error(t, err)
 		assert.True(t, errors.Is(err, custom_err.ErrInsufficientFunds))
@@ -141,6 +298,7 @@ func TestWalletService_UpdateBalance(t *testing.T) {
 		state := shard.wallets[walletID]
 		assert.Equal(t, int64(100), state.balance.Load())
 		assert.False(t, state.dirty.Load())
+		assert.Empty(t, state.ops, "rejected operation must not be recorded")
 	})
 
 	t.Run("Error - Wallet Not Found on Update", func(t *testing.T) {
@@ -152,7 +310,7 @@ func TestWalletService_UpdateBalance(t *testing.T) {
 		service := NewWalletService(mockRepo, nil)
 
 		req := models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 100}
-		err := service.UpdateBalance(context.Background(), req)
+		_, err := service.UpdateBalance(context.Background(), req)
 
 		require.Error(t, err)
 		assert.True(t, errors.Is(err, custom_err.ErrNotFound))
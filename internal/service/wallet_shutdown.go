@@ -0,0 +1,157 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const shutdownFlushAttempts = 3
+
+// ShutdownReport — итог остановки сервиса.
+type ShutdownReport struct {
+	// Flushed — сколько кошельков записано финальным flush'ем.
+	Flushed int
+	// Unpersisted — кошельки, изменения которых так и не попали в БД.
+	Unpersisted []UnpersistedWallet
+	// Journaled — изменения Unpersisted сохранены в журнале и будут
+	// восстановлены при следующем запуске.
+	Journaled bool
+}
+
+type UnpersistedWallet struct {
+	ID         uuid.UUID
+	Balance    int64
+	PendingOps int
+}
+
+// Start запускает фоновые воркеры: flush, повторы, метрики, очистку окна
+// идемпотентности и журнала, подписку на изменения других экземпляров,
+// вытеснение простаивающих кошельков, подсчёт отставания flush'а,
+// освобождение холдов с истёкшим сроком. Повторный вызов ничего не делает.
+func (s *WalletService) Start() {
+	s.startOnce.Do(func() {
+		for i := 0; i < numFlushWorkers; i++ {
+			s.spawn(func() { s.flusher(i) })
+		}
+		for i := 0; i < 2; i++ {
+			s.spawn(func() { s.retryWorker(i) })
+		}
+		s.spawn(s.metricsLogger)
+		s.spawn(s.idempotencySweeper)
+		s.spawn(s.holdExpirer)
+		if s.journal != nil {
+			s.spawn(s.journalCheckpointer)
+		}
+		if s.changes != nil {
+			s.spawn(s.changeListener)
+		}
+		if s.cacheTTL > 0 {
+			s.spawn(s.cacheEvictor)
+		}
+		if s.backpressure != nil {
+			s.spawn(s.backlogSampler)
+		}
+	})
+}
+
+func (s *WalletService) spawn(fn func()) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn()
+	}()
+}
+
+// beginWrite регистрирует пишущую операцию. Вызывающий обязан вызвать done,
+// когда операция завершится. После Stop возвращает custom_err.ErrServiceStopping.
+func (s *WalletService) beginWrite() (done func(), err error) {
+	s.lifecycle.RLock()
+	if s.closing {
+		s.lifecycle.RUnlock()
+		return nil, custom_err.ErrServiceStopping
+	}
+	return s.lifecycle.RUnlock, nil
+}
+
+// Stop останавливает сервис: перестаёт принимать изменения, дожидается начатых
+// операций и воркеров, затем синхронно записывает в БД все грязные кошельки,
+// включая ожидавшие в очереди повторов. Чтение продолжает работать.
+// Ошибка означает, что часть изменений не записана; они перечислены в отчёте.
+func (s *WalletService) Stop(ctx context.Context) (*ShutdownReport, error) {
+	const op = "service.Stop"
+
+	s.lifecycle.Lock()
+	s.closing = true
+	s.lifecycle.Unlock()
+
+	s.stopOnce.Do(func() { close(s.stop) })
+	workersDone := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		log.Printf("[Shutdown] Workers did not stop in time: %v", ctx.Err())
+	}
+
+	// Снимки из очереди повторов возвращаются кошелькам и уходят финальным flush'ем.
+	for drained := false; !drained; {
+		select {
+		case item := <-s.retryQueue:
+			s.releaseSnapshots(item.snapshots)
+		default:
+			drained = true
+		}
+	}
+
+	report := &ShutdownReport{Journaled: s.journal != nil}
+	var flushErr error
+	for attempt := 1; attempt <= shutdownFlushAttempts; attempt++ {
+		var flushed int
+		flushed, flushErr = s.FlushAll(ctx)
+		report.Flushed += flushed
+		if flushErr == nil {
+			break
+		}
+		log.Printf("[Shutdown] Final flush attempt %d failed: %v", attempt, flushErr)
+		if attempt == shutdownFlushAttempts {
+			break
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	report.Unpersisted = s.unpersistedWallets()
+	if len(report.Unpersisted) > 0 {
+		return report, fmt.Errorf("%s: %d кошельков не записаны в БД (последняя ошибка: %v)", op, len(report.Unpersisted), flushErr)
+	}
+	return report, nil
+}
+
+func (s *WalletService) unpersistedWallets() []UnpersistedWallet {
+	var result []UnpersistedWallet
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for id, state := range shard.wallets {
+			state.mu.Lock()
+			if state.unpersisted() {
+				result = append(result, UnpersistedWallet{ID: id, Balance: state.balance.Load(), PendingOps: len(state.parked) + len(state.inflight) + len(state.ops)})
+			}
+			state.mu.Unlock()
+		}
+		shard.mu.RUnlock()
+	}
+	return result
+}
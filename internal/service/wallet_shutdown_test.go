@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletService_Stop(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func(bulk func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error)) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Status: models.WalletActive}, nil
+			},
+			BulkApplyOperationsFunc: bulk,
+		}, nil)
+	}
+
+	t.Run("Dirty wallets and queued retries are flushed", func(t *testing.T) {
+		var mu sync.Mutex
+		var persisted []models.Operation
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			persisted = append(persisted, ops...)
+			return nil, nil
+		})
+		service.Start()
+
+		first, second := walletsInDifferentShards()
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: first, OperationType: models.DepositOperation, Amount: 10})
+		require.NoError(t, err)
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: second, OperationType: models.WithdrawOperation, Amount: 10})
+		require.NoError(t, err)
+
+		// Снимок, ожидающий повтора, тоже должен попасть в финальный flush.
+		groups := service.collectDirty(service.getShard(second), maxBatchSize)
+		for _, group := range groups {
+			service.retryQueue <- retryItem{snapshots: group}
+		}
+
+		report, err := service.Stop(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, report.Unpersisted)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, map[uuid.UUID]int64{first: 10, second: -10}, sumByWallet(persisted))
+	})
+
+	t.Run("Writes are rejected after Stop, reads keep working", func(t *testing.T) {
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			return nil, nil
+		})
+		walletID := uuid.New()
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10})
+		require.NoError(t, err)
+
+		_, err = service.Stop(ctx)
+		require.NoError(t, err)
+
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10})
+		assert.ErrorIs(t, err, custom_err.ErrServiceStopping)
+		_, err = service.Transfer(ctx, models.TransferRequest{FromWalletID: walletID, ToWalletID: uuid.New(), Amount: 1})
+		assert.ErrorIs(t, err, custom_err.ErrServiceStopping)
+		_, err = service.CreateWallet(ctx, uuid.New())
+		assert.ErrorIs(t, err, custom_err.ErrServiceStopping)
+
+		wallet, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(110), wallet.Balance)
+	})
+
+	t.Run("Unpersisted wallets are reported", func(t *testing.T) {
+		service := newService(func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			return nil, errors.New("db is down")
+		})
+		walletID := uuid.New()
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 10})
+		require.NoError(t, err)
+
+		stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		report, err := service.Stop(stopCtx)
+		require.Error(t, err)
+		require.Len(t, report.Unpersisted, 1)
+		assert.Equal(t, UnpersistedWallet{ID: walletID, Balance: 110, PendingOps: 1}, report.Unpersisted[0])
+		assert.False(t, report.Journaled)
+	})
+
+	t.Run("Workers exit on Stop", func(t *testing.T) {
+		service := newService(nil)
+		service.Start()
+
+		_, err := service.Stop(ctx)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			service.workers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("workers are still running after Stop")
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ConsistencyMode определяет, когда изменение баланса попадает в БД.
+type ConsistencyMode string
+
+const (
+	// ConsistencyCache — изменения применяются в памяти и пишутся в БД фоновым flush'ем.
+	ConsistencyCache ConsistencyMode = "cache"
+	// ConsistencySync — каждое изменение фиксируется в БД до ответа клиенту.
+	ConsistencySync ConsistencyMode = "sync"
+)
+
+const (
+	defaultSyncMaxRetries = 5
+	syncRetryBackoff      = 10 * time.Millisecond
+)
+
+func (m ConsistencyMode) IsValid() bool {
+	switch m {
+	case ConsistencyCache, ConsistencySync:
+		return true
+	}
+	return false
+}
+
+// WithConsistencyMode задаёт режим записи. В режиме sync операции и переводы
+// коммитятся в БД транзакцией с проверкой version до ответа клиенту.
+func WithConsistencyMode(mode ConsistencyMode) Option {
+	return func(s *WalletService) {
+		s.consistency = mode
+	}
+}
+
+// WithSyncMaxRetries задаёт число попыток транзакции в режиме sync при конфликте версий.
+func WithSyncMaxRetries(n int) Option {
+	return func(s *WalletService) {
+		if n > 0 {
+			s.syncMaxRetries = n
+		}
+	}
+}
+
+// errAlreadyApplied прерывает транзакцию, когда операция с этим requestId уже есть в БД.
+var errAlreadyApplied = errors.New("операция уже применена")
+
+// isRetryable сообщает, имеет ли смысл повторить транзакцию целиком.
+func isRetryable(err error) bool {
+	if errors.Is(err, custom_err.ErrConflict) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	// serialization_failure и deadlock_detected
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// inTxWithRetry выполняет fn в транзакции и повторяет её при конфликтах,
+// но не больше syncMaxRetries раз.
+func (s *WalletService) inTxWithRetry(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	for attempt := 0; attempt < s.syncMaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * syncRetryBackoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err := s.inTx(ctx, fn)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return custom_err.ErrMaxRetriesExceeded
+}
+
+func (s *WalletService) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.txManager.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// applyOperationSync фиксирует операцию в БД и только после коммита обновляет кэш.
+// state.mu удерживается на всё время транзакции, чтобы кэш менялся в порядке коммитов.
+func (s *WalletService) applyOperationSync(ctx context.Context, state *WalletState, operation models.Operation) (*models.OperationReceipt, error) {
+	const op = "service.applyOperationSync"
+
+	if err := state.lock(); err != nil {
+		return nil, err
+	}
+	defer state.mu.Unlock()
+
+	var row models.WalletChange
+	err := s.inTxWithRetry(ctx, func(tx pgx.Tx) error {
+		current, err := s.repo.GetWalletStateTx(ctx, tx, operation.WalletID)
+		if err != nil {
+			return err
+		}
+		row = current
+
+		if operation.RequestID != uuid.Nil {
+			exists, err := s.repo.CheckOperationExistsTx(ctx, tx, operation.WalletID, operation.RequestID)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return errAlreadyApplied
+			}
+		}
+		if err := checkStatus(current.Status, operation.Amount); err != nil {
+			return err
+		}
+		// Зарезервированное холдами списать нельзя.
+		if operation.Amount < 0 && current.Balance-current.Reserved+operation.Amount < 0 {
+			return custom_err.ErrInsufficientFunds
+		}
+
+		if err := s.repo.UpdateBalanceWithOptimisticLockTx(ctx, tx, operation.WalletID, current.Balance+operation.Amount, current.Version); err != nil {
+			return err
+		}
+		if err := s.repo.CreateOperationTx(ctx, tx, operation); err != nil {
+			return err
+		}
+		row.Balance += operation.Amount
+		row.Version++
+		return nil
+	})
+	applied := errors.Is(err, errAlreadyApplied)
+	if applied {
+		err = nil
+	}
+	if err == nil || isFinalOutcome(err) {
+		// БД — источник истины: кэш получает то, что в ней зафиксировано.
+		state.balance.Store(row.Balance)
+		state.reserved.Store(row.Reserved + state.reserving)
+		state.version = row.Version
+		state.setStatus(row.Status)
+	}
+	if err != nil {
+		if isFinalOutcome(err) || errors.Is(err, custom_err.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if applied {
+		// Запрос с этим requestId успел выполниться параллельно: результат — его операция.
+		existing, err := s.repo.GetOperationByRequestID(ctx, operation.WalletID, operation.RequestID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return receiptFromOperation(*existing), nil
+	}
+	return newReceipt(operation, row.Balance, row.Version, true), nil
+}
+
+// transferSync фиксирует обе проводки перевода одной транзакцией. Строки
+// кошельков блокируются в том же порядке, что и mu, поэтому параллельные
+// встречные переводы не взаимоблокируются ни в процессе, ни в БД. legs — кошельки
+// перевода, которые обслуживает этот экземпляр; источник среди них всегда есть.
+// applied означает, что перевод с requestId списания уже есть в БД и ничего не записано.
+func (s *WalletService) transferSync(ctx context.Context, legs []stateRef, debit, credit models.Operation) (applied bool, err error) {
+	const op = "service.transferSync"
+
+	unlock, err := lockLiveStates(legs)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	var fromState *WalletState
+	for _, leg := range legs {
+		if leg.id == debit.WalletID {
+			fromState = leg.state
+		}
+	}
+	ordered := orderedIDs(debit.WalletID, credit.WalletID)
+	var rows map[uuid.UUID]models.WalletChange
+	err = s.inTxWithRetry(ctx, func(tx pgx.Tx) error {
+		rows = make(map[uuid.UUID]models.WalletChange, 2)
+		for _, id := range ordered {
+			row, err := s.repo.GetWalletStateTx(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			rows[id] = row
+		}
+
+		if debit.RequestID != uuid.Nil {
+			exists, err := s.repo.CheckOperationExistsTx(ctx, tx, debit.WalletID, debit.RequestID)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return errAlreadyApplied
+			}
+		}
+		from, to := rows[debit.WalletID], rows[credit.WalletID]
+		if err := checkStatus(from.Status, debit.Amount); err != nil {
+			return err
+		}
+		if err := checkStatus(to.Status, credit.Amount); err != nil {
+			return err
+		}
+		if s.available(fromState, from)+debit.Amount < 0 {
+			return custom_err.ErrInsufficientFunds
+		}
+
+		for _, operation := range []models.Operation{debit, credit} {
+			row := rows[operation.WalletID]
+			if err := s.repo.UpdateBalanceWithOptimisticLockTx(ctx, tx, operation.WalletID, row.Balance+operation.Amount, row.Version); err != nil {
+				return err
+			}
+			if err := s.repo.CreateOperationTx(ctx, tx, operation); err != nil {
+				return err
+			}
+			row.Balance += operation.Amount
+			row.Version++
+			rows[operation.WalletID] = row
+		}
+		return nil
+	})
+	applied = errors.Is(err, errAlreadyApplied)
+	if applied {
+		err = nil
+	}
+	if err == nil || isFinalOutcome(err) {
+		for _, leg := range legs {
+			if row, ok := rows[leg.id]; ok {
+				// Уже записанный перевод этой транзакцией не изменён: в баланс строки он вошёл раньше.
+				var delta int64
+				if err == nil && !applied {
+					delta = credit.Amount
+					if leg.id == debit.WalletID {
+						delta = debit.Amount
+					}
+				}
+				// В режиме cache у источника могут быть незаписанные операции, applyCommitted их сохранит.
+				leg.state.applyCommitted(row, delta)
+			}
+		}
+	}
+	if err != nil {
+		if isFinalOutcome(err) || errors.Is(err, custom_err.ErrNotFound) {
+			return false, err
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return applied, nil
+}
+
+func orderedIDs(a, b uuid.UUID) []uuid.UUID {
+	if lessID(b, a) {
+		return []uuid.UUID{b, a}
+	}
+	return []uuid.UUID{a, b}
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockTx struct {
+	pgx.Tx
+	committed bool
+}
+
+func (t *mockTx) Commit(ctx context.Context) error {
+	t.committed = true
+	return nil
+}
+
+func (t *mockTx) Rollback(ctx context.Context) error {
+	return nil
+}
+
+type mockTxManager struct {
+	mu  sync.Mutex
+	txs []*mockTx
+}
+
+func (m *mockTxManager) Begin(ctx context.Context) (pgx.Tx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := &mockTx{}
+	m.txs = append(m.txs, tx)
+	return tx, nil
+}
+
+// syncTable имитирует таблицу wallets с колонкой version и таблицу operations.
+// Изменения видны только после коммита транзакции.
+type syncTable struct {
+	mu       sync.Mutex
+	balances map[uuid.UUID]int64
+	versions map[uuid.UUID]int64
+	reserved map[uuid.UUID]int64
+	holds    map[uuid.UUID]models.Hold
+	ops      []models.Operation
+	locked   []uuid.UUID
+}
+
+func newSyncRepository(table *syncTable) *mockRepository {
+	return &mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			balance, ok := table.balances[id]
+			if !ok {
+				return nil, custom_err.ErrNotFound
+			}
+			return &models.Wallet{ID: id, Balance: balance, Reserved: table.reserved[id]}, nil
+		},
+		GetWalletStateTxFunc: func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID) (models.WalletChange, error) {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			balance, ok := table.balances[walletID]
+			if !ok {
+				return models.WalletChange{}, custom_err.ErrNotFound
+			}
+			table.locked = append(table.locked, walletID)
+			return models.WalletChange{ID: walletID, Balance: balance, Reserved: table.reserved[walletID], Version: table.versions[walletID], Status: models.WalletActive}, nil
+		},
+		UpdateBalanceWithOptimisticLockTxFunc: func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance int64, expectedVersion int64) error {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			if table.versions[walletID] != expectedVersion {
+				return custom_err.ErrConflict
+			}
+			table.balances[walletID] = newBalance
+			table.versions[walletID]++
+			return nil
+		},
+		UpdateFundsWithOptimisticLockTxFunc: func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance, newReserved, expectedVersion int64) error {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			if table.versions[walletID] != expectedVersion {
+				return custom_err.ErrConflict
+			}
+			table.balances[walletID] = newBalance
+			table.reserved[walletID] = newReserved
+			table.versions[walletID]++
+			return nil
+		},
+		CheckOperationExistsTxFunc: func(ctx context.Context, tx pgx.Tx, walletID, requestID uuid.UUID) (bool, error) {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			for _, op := range table.ops {
+				if op.WalletID == walletID && op.RequestID == requestID {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		CreateOperationTxFunc: func(ctx context.Context, tx pgx.Tx, operation models.Operation) error {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			table.ops = append(table.ops, operation)
+			return nil
+		},
+		GetOperationByRequestIDFunc: func(ctx context.Context, walletID, requestID uuid.UUID) (*models.Operation, error) {
+			table.mu.Lock()
+			defer table.mu.Unlock()
+			for _, op := range table.ops {
+				if op.WalletID == walletID && op.RequestID == requestID {
+					return &op, nil
+				}
+			}
+			return nil, custom_err.ErrNotFound
+		},
+	}
+}
+
+func TestWalletService_SyncMode(t *testing.T) {
+	ctx := context.Background()
+
+	newTable := func(balances map[uuid.UUID]int64) *syncTable {
+		table := &syncTable{balances: balances, versions: make(map[uuid.UUID]int64)}
+		for id := range balances {
+			table.versions[id] = 1
+		}
+		return table
+	}
+
+	t.Run("Operation is committed before returning", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newTable(map[uuid.UUID]int64{walletID: 100})
+		txManager := &mockTxManager{}
+		service := NewWalletService(newSyncRepository(table), txManager, WithConsistencyMode(ConsistencySync))
+
+		requestID := uuid.New()
+		receipt, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 30, RequestID: requestID})
+		require.NoError(t, err)
+		assert.True(t, receipt.Persisted)
+		assert.Equal(t, int64(70), *receipt.BalanceAfter)
+		assert.Equal(t, int64(2), *receipt.Version, "version of the row that includes the operation")
+
+		assert.Equal(t, int64(70), table.balances[walletID])
+		assert.Equal(t, int64(2), table.versions[walletID])
+		require.Len(t, table.ops, 1)
+		assert.Equal(t, int64(-30), table.ops[0].Amount)
+		assert.Equal(t, requestID, table.ops[0].RequestID)
+		assert.Equal(t, table.ops[0].ID, receipt.OperationID)
+		require.Len(t, txManager.txs, 1)
+		assert.True(t, txManager.txs[0].committed)
+
+		state := service.getShard(walletID).wallets[walletID]
+		assert.Equal(t, int64(70), state.balance.Load(), "cache must follow the committed balance")
+		assert.False(t, state.dirty.Load(), "nothing is left for the flusher")
+		assert.Empty(t, state.ops)
+	})
+
+	t.Run("Cache is refreshed from the database", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newTable(map[uuid.UUID]int64{walletID: 100})
+		service := NewWalletService(newSyncRepository(table), &mockTxManager{}, WithConsistencyMode(ConsistencySync))
+
+		_, err := service.GetWalletByID(ctx, walletID)
+		require.NoError(t, err)
+		// Кто-то изменил строку в обход сервиса.
+		table.balances[walletID] = 10
+
+		_, err = service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.WithdrawOperation, Amount: 50})
+		require.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+		assert.Equal(t, int64(10), service.getShard(walletID).wallets[walletID].balance.Load())
+		assert.Empty(t, table.ops)
+	})
+
+	t.Run("Conflicts are retried", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newTable(map[uuid.UUID]int64{walletID: 100})
+		repo := newSyncRepository(table)
+		update := repo.UpdateBalanceWithOptimisticLockTxFunc
+		conflicts := 2
+		repo.UpdateBalanceWithOptimisticLockTxFunc = func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance int64, expectedVersion int64) error {
+			if conflicts > 0 {
+				conflicts--
+				return custom_err.ErrConflict
+			}
+			return update(ctx, tx, walletID, newBalance, expectedVersion)
+		}
+		txManager := &mockTxManager{}
+		service := NewWalletService(repo, txManager, WithConsistencyMode(ConsistencySync))
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 5})
+		require.NoError(t, err)
+		assert.Len(t, txManager.txs, 3)
+		assert.Equal(t, int64(105), table.balances[walletID])
+	})
+
+	t.Run("Retries are bounded", func(t *testing.T) {
+		walletID := uuid.New()
+		table := newTable(map[uuid.UUID]int64{walletID: 100})
+		repo := newSyncRepository(table)
+		repo.UpdateBalanceWithOptimisticLockTxFunc = func(ctx context.Context, tx pgx.Tx, walletID uuid.UUID, newBalance int64, expectedVersion int64) error {
+			return custom_err.ErrConflict
+		}
+		txManager := &mockTxManager{}
+		service := NewWalletService(repo, txManager, WithConsistencyMode(ConsistencySync), WithSyncMaxRetries(3))
+
+		_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: walletID, OperationType: models.DepositOperation, Amount: 5})
+		require.ErrorIs(t, err, custom_err.ErrMaxRetriesExceeded)
+		assert.Len(t, txManager.txs, 3)
+		for _, tx := range txManager.txs {
+			assert.False(t, tx.committed)
+		}
+		assert.Equal(t, int64(100), service.getShard(walletID).wallets[walletID].balance.Load())
+	})
+
+	t.Run("Operation already in the database is not applied again", func(t *testing.T) {
+		walletID := uuid.New()
+		requestID := uuid.New()
+		table := newTable(map[uuid.UUID]int64{walletID: 100})
+		table.ops = append(table.ops, models.Operation{ID: uuid.New(), WalletID: walletID, Amount: 5, RequestID: requestID})
+		service := NewWalletService(newSyncRepository(table), &mockTxManager{}, WithConsistencyMode(ConsistencySync))
+
+		// Обходим проверку в памяти, как если бы запрос параллельно выполнил другой экземпляр.
+		state, err := service.getShard(walletID).loadStateIntoCacheIfExists(ctx, walletID, service.repo)
+		require.NoError(t, err)
+		receipt, err := service.applyOperationSync(ctx, state, models.Operation{ID: uuid.New(), WalletID: walletID, Amount: 5, RequestID: requestID})
+		require.NoError(t, err)
+		// Результат — операция, которую записал первый запрос.
+		assert.Equal(t, table.ops[0].ID, receipt.OperationID)
+		assert.Nil(t, receipt.BalanceAfter)
+		assert.Equal(t, int64(100), table.balances[walletID])
+		assert.Len(t, table.ops, 1)
+	})
+
+	t.Run("Transfer commits both legs in one transaction", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		table := newTable(map[uuid.UUID]int64{from: 100, to: 0})
+		txManager := &mockTxManager{}
+		service := NewWalletService(newSyncRepository(table), txManager, WithConsistencyMode(ConsistencySync))
+
+		transfer, err := service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 40})
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(60), table.balances[from])
+		assert.Equal(t, int64(40), table.balances[to])
+		require.Len(t, table.ops, 2)
+		assert.Equal(t, transfer.ID, *table.ops[0].TransferID)
+		assert.Equal(t, transfer.ID, *table.ops[1].TransferID)
+		assert.Len(t, txManager.txs, 1)
+		assert.Equal(t, orderedIDs(from, to), table.locked, "rows must be locked in id order")
+		assert.Equal(t, int64(40), service.getShard(to).wallets[to].balance.Load())
+	})
+
+	t.Run("Transfer already in the database returns the recorded transfer", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		table := newTable(map[uuid.UUID]int64{from: 100, to: 0})
+		service := NewWalletService(newSyncRepository(table), &mockTxManager{}, WithConsistencyMode(ConsistencySync))
+
+		req := models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 40, RequestID: uuid.New()}
+		first, err := service.Transfer(ctx, req)
+		require.NoError(t, err)
+
+		// Обходим проверку в памяти, как если бы запрос параллельно выполнил другой экземпляр.
+		second, err := service.transfer(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, first.ID, second.ID)
+		assert.Equal(t, first.CreatedAt, second.CreatedAt)
+		assert.Equal(t, int64(60), table.balances[from])
+		assert.Len(t, table.ops, 2)
+
+		req.Amount = 10
+		_, err = service.transfer(ctx, req)
+		assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
+	})
+	t.Run("Replayed transfer does not change a balance being flushed", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		requestID := uuid.New()
+		table := newTable(map[uuid.UUID]int64{from: 60, to: 40})
+		table.ops = append(table.ops, models.Operation{ID: uuid.New(), WalletID: from, Amount: -40, RequestID: requestID})
+		service := NewWalletService(newSyncRepository(table), &mockTxManager{})
+
+		state, err := service.getShard(from).loadStateIntoCacheIfExists(ctx, from, service.repo)
+		require.NoError(t, err)
+		state.add(30)
+		state.ops = []models.Operation{{ID: uuid.New(), WalletID: from, Amount: 30}}
+		state.snapshot()
+
+		applied, err := service.transferSync(ctx, []stateRef{{id: from, state: state}},
+			models.Operation{ID: uuid.New(), WalletID: from, Amount: -40, RequestID: requestID},
+			models.Operation{ID: uuid.New(), WalletID: to, Amount: 40})
+		require.NoError(t, err)
+		assert.True(t, applied)
+		assert.Equal(t, int64(90), state.balance.Load(), "the transfer is already in the row")
+	})
+}
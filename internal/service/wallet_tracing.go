@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("api_wallet/internal/service")
+
+// maxTracedOps ограничивает число операций, ждущих flush'а, для которых
+// запоминается трейс запроса. Сверх лимита flush просто не ссылается на запрос.
+const maxTracedOps = 100000
+
+// endSpan отмечает ошибку в спане и завершает его.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func walletAttr(id uuid.UUID) attribute.KeyValue {
+	return attribute.String("wallet.id", id.String())
+}
+
+// cacheAttr — как запрос получил кошелек: hit, miss, shared или negative.
+func cacheAttr(result string) attribute.KeyValue {
+	return attribute.String("wallet.cache", result)
+}
+
+// opTraces связывает незаписанные операции с трейсами запросов, которые их
+// создали, чтобы спан flush'а ссылался на эти запросы.
+type opTraces struct {
+	mu    sync.Mutex
+	spans map[uuid.UUID]trace.SpanContext
+}
+
+func newOpTraces() *opTraces {
+	return &opTraces{spans: make(map[uuid.UUID]trace.SpanContext)}
+}
+
+// remember запоминает трейс ctx для операций. Вызывается до того, как операции
+// станут видны flusher'у; если операция не принята, нужен forget.
+func (t *opTraces) remember(ctx context.Context, ops ...models.Operation) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsSampled() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, op := range ops {
+		if len(t.spans) >= maxTracedOps {
+			return
+		}
+		t.spans[op.ID] = sc
+	}
+}
+
+// links возвращает ссылки на трейсы запросов, создавших операции снимков.
+// Несколько операций одного запроса дают одну ссылку.
+func (t *opTraces) links(snapshots []walletSnapshot) []trace.Link {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) == 0 {
+		return nil
+	}
+	var links []trace.Link
+	seen := make(map[trace.SpanID]bool)
+	for _, snap := range snapshots {
+		for _, op := range snap.ops {
+			sc, ok := t.spans[op.ID]
+			if !ok || seen[sc.SpanID()] {
+				continue
+			}
+			seen[sc.SpanID()] = true
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return links
+}
+
+// forget забывает трейсы операций: они записаны в БД или больше не ждут flush'а.
+func (t *opTraces) forget(ops []models.Operation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) == 0 {
+		return
+	}
+	for _, op := range ops {
+		delete(t.spans, op.ID)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spansOnce sync.Once
+	spans     *tracetest.SpanRecorder
+)
+
+// recordSpans включает запись спанов сервиса. Глобальный провайдер
+// привязывается к tracer один раз, поэтому recorder общий для всех тестов.
+func recordSpans() *tracetest.SpanRecorder {
+	spansOnce.Do(func() {
+		spans = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	})
+	return spans
+}
+
+// endedSpan возвращает завершённый спан с именем name из трейса traceID.
+func endedSpan(recorder *tracetest.SpanRecorder, traceID trace.TraceID, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID && span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestWalletService_Tracing(t *testing.T) {
+	recorder := recordSpans()
+	tracer := otel.Tracer("test")
+
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100, Version: 1, Status: models.WalletActive}, nil
+		},
+		BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			return nil, nil
+		},
+	}, nil)
+	from, to := walletsInDifferentShards()
+
+	ctx, request := tracer.Start(context.Background(), "request")
+	_, err := service.UpdateBalance(ctx, models.WalletOperationRequest{WalletID: from, OperationType: models.DepositOperation, Amount: 10})
+	require.NoError(t, err)
+	request.End()
+	transferCtx, transferRequest := tracer.Start(context.Background(), "transfer request")
+	_, err = service.Transfer(transferCtx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 5})
+	require.NoError(t, err)
+	transferRequest.End()
+
+	// Спаны сервиса и загрузки кошелька — дочерние к запросу.
+	traceID := request.SpanContext().TraceID()
+	update := endedSpan(recorder, traceID, "WalletService.UpdateBalance")
+	require.NotNil(t, update)
+	assert.Equal(t, request.SpanContext().SpanID(), update.Parent().SpanID())
+	load := endedSpan(recorder, traceID, "WalletService.loadWallet")
+	require.NotNil(t, load)
+	assert.Equal(t, update.SpanContext().SpanID(), load.Parent().SpanID())
+
+	_, err = service.FlushAll(context.Background())
+	require.NoError(t, err)
+
+	// Flush идёт своим трейсом и ссылается на оба запроса.
+	var linked []trace.SpanID
+	for _, span := range recorder.Ended() {
+		if span.Name() != "WalletService.flush" {
+			continue
+		}
+		assert.False(t, span.Parent().IsValid())
+		for _, link := range span.Links() {
+			linked = append(linked, link.SpanContext.SpanID())
+		}
+	}
+	transfer := endedSpan(recorder, transferRequest.SpanContext().TraceID(), "WalletService.Transfer")
+	require.NotNil(t, transfer)
+	assert.Contains(t, linked, update.SpanContext().SpanID())
+	assert.Contains(t, linked, transfer.SpanContext().SpanID())
+
+	assert.Empty(t, service.traces.spans, "flushed operations are forgotten")
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/journal"
+	"api_wallet/internal/models"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type stateRef struct {
+	id    uuid.UUID
+	state *WalletState
+}
+
+// lockStates блокирует mu нескольких кошельков в порядке возрастания id.
+// Все, кто держит больше одного mu, берут их только так, поэтому взаимных блокировок нет.
+func lockStates(refs []stateRef) (unlock func()) {
+	sorted := make([]stateRef, len(refs))
+	copy(sorted, refs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return lessID(sorted[i].id, sorted[j].id)
+	})
+
+	for _, ref := range sorted {
+		ref.state.mu.Lock()
+	}
+	return func() {
+		for i := len(sorted) - 1; i >= 0; i-- {
+			sorted[i].state.mu.Unlock()
+		}
+	}
+}
+
+// lockLiveStates — lockStates, который отказывается, если какое-то из состояний
+// уже выброшено из кэша.
+func lockLiveStates(refs []stateRef) (unlock func(), err error) {
+	unlock = lockStates(refs)
+	for _, ref := range refs {
+		if ref.state.evicted {
+			unlock()
+			return nil, errStateEvicted
+		}
+	}
+	return unlock, nil
+}
+
+func lessID(a, b uuid.UUID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// transferFromOperation восстанавливает перевод по его списывающей проводке.
+func transferFromOperation(operation models.Operation) (*models.Transfer, bool) {
+	if operation.TransferID == nil || operation.CounterpartyID == nil || operation.Amount >= 0 {
+		return nil, false
+	}
+	return &models.Transfer{
+		ID:           *operation.TransferID,
+		FromWalletID: operation.WalletID,
+		ToWalletID:   *operation.CounterpartyID,
+		Amount:       -operation.Amount,
+		CreatedAt:    operation.CreatedAt,
+	}, true
+}
+
+// commitTransfer фиксирует перевод в БД через transferSync. Если перевод с тем
+// же requestId успел записать параллельный запрос, результат — его перевод.
+func (s *WalletService) commitTransfer(ctx context.Context, legs []stateRef, transfer *models.Transfer, debit, credit models.Operation) (*models.Transfer, error) {
+	const op = "service.Transfer"
+
+	applied, err := s.transferSync(ctx, legs, debit, credit)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return transfer, nil
+	}
+	existing, err := s.repo.GetOperationByRequestID(ctx, debit.WalletID, debit.RequestID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	recorded, ok := transferFromOperation(*existing)
+	if !ok || recorded.ToWalletID != transfer.ToWalletID || recorded.Amount != transfer.Amount {
+		return nil, custom_err.ErrDuplicateRequest
+	}
+	return recorded, nil
+}
+
+// Transfer атомарно переводит средства между двумя кошельками. Перевод оставляет
+// две связанные общим TransferID операции, которые попадают в БД одной транзакцией.
+// Идемпотентность обеспечивается по паре (кошелек-источник, requestId).
+func (s *WalletService) Transfer(ctx context.Context, req models.TransferRequest) (_ *models.Transfer, err error) {
+	ctx, span := tracer.Start(ctx, "WalletService.Transfer", trace.WithAttributes(attribute.String("wallet.from", req.FromWalletID.String()), attribute.String("wallet.to", req.ToWalletID.String())))
+	defer func() { endSpan(span, err) }()
+	if req.FromWalletID == req.ToWalletID {
+		return nil, custom_err.ErrSameWallet
+	}
+	if err := s.admit(ctx); err != nil {
+		return nil, err
+	}
+	done, err := s.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	leave, err := s.enterShards(req.FromWalletID)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	key := idempotencyKey{walletID: req.FromWalletID, requestID: req.RequestID}
+	recorded := func(existing *models.Operation) (*models.Transfer, bool) {
+		transfer, ok := transferFromOperation(*existing)
+		if !ok || transfer.ToWalletID != req.ToWalletID || transfer.Amount != req.Amount {
+			return nil, false
+		}
+		return transfer, true
+	}
+	return idempotent(ctx, s, key, transferFingerprint(req.ToWalletID, req.Amount), recorded, func() (*models.Transfer, error) {
+		return retryEvicted(func() (*models.Transfer, error) {
+			return s.transfer(ctx, req)
+		})
+	})
+}
+
+func (s *WalletService) transfer(ctx context.Context, req models.TransferRequest) (*models.Transfer, error) {
+	const op = "service.Transfer"
+
+	from, err := s.getShard(req.FromWalletID).loadStateIntoCacheIfExists(ctx, req.FromWalletID, s.repo)
+	if err != nil {
+		if errors.Is(err, custom_err.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	leaveTo, toLocal := s.tryEnterShard(req.ToWalletID)
+	var to *WalletState
+	if toLocal {
+		defer leaveTo()
+		to, err = s.getShard(req.ToWalletID).loadStateIntoCacheIfExists(ctx, req.ToWalletID, s.repo)
+		if err != nil {
+			if errors.Is(err, custom_err.ErrNotFound) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	transfer := &models.Transfer{
+		ID:           uuid.New(),
+		FromWalletID: req.FromWalletID,
+		ToWalletID:   req.ToWalletID,
+		Amount:       req.Amount,
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+	}
+	debit := models.Operation{
+		ID:             uuid.New(),
+		WalletID:       req.FromWalletID,
+		Type:           models.WithdrawOperation,
+		Amount:         -req.Amount,
+		RequestID:      req.RequestID,
+		TransferID:     &transfer.ID,
+		CounterpartyID: &transfer.ToWalletID,
+		CreatedAt:      transfer.CreatedAt,
+	}
+	// requestId остаётся только у списания: у получателя свои ключи идемпотентности.
+	credit := models.Operation{
+		ID:             uuid.New(),
+		WalletID:       req.ToWalletID,
+		Type:           models.DepositOperation,
+		Amount:         req.Amount,
+		TransferID:     &transfer.ID,
+		CounterpartyID: &transfer.FromWalletID,
+		CreatedAt:      transfer.CreatedAt,
+	}
+
+	if !toLocal {
+		// Получателя обслуживает другой экземпляр: обе проводки фиксируются в БД
+		// сразу, его кэш обновит уведомление об изменении строки.
+		return s.commitTransfer(ctx, []stateRef{{id: req.FromWalletID, state: from}}, transfer, debit, credit)
+	}
+
+	legs := []stateRef{{id: req.FromWalletID, state: from}, {id: req.ToWalletID, state: to}}
+	if s.consistency == ConsistencySync {
+		return s.commitTransfer(ctx, legs, transfer, debit, credit)
+	}
+
+	unlock, err := lockLiveStates(legs)
+	if err != nil {
+		return nil, err
+	}
+	if s.journal != nil {
+		for _, leg := range legs {
+			if leg.state.pendingSeq.Load() == 0 {
+				leg.state.pendingSeq.Store(s.journal.NextSeq())
+			}
+		}
+	}
+
+	if err := from.checkStatus(debit.Amount); err != nil {
+		unlock()
+		return nil, err
+	}
+	if err := to.checkStatus(credit.Amount); err != nil {
+		unlock()
+		return nil, err
+	}
+	fromBalance, err := from.withdraw(req.Amount)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	toBalance := to.add(req.Amount)
+	from.ops = append(from.ops, debit)
+	to.ops = append(to.ops, credit)
+	s.metrics.pendingOps.Add(2)
+	s.traces.remember(ctx, debit, credit)
+
+	if s.journal == nil {
+		unlock()
+		return transfer, nil
+	}
+
+	// Обе проводки — одна запись журнала: после падения перевод восстановится целиком или не восстановится вовсе.
+	ticket, err := s.journal.Append(
+		journal.Entry{WalletID: req.FromWalletID, Balance: fromBalance, Operation: &debit},
+		journal.Entry{WalletID: req.ToWalletID, Balance: toBalance, Operation: &credit},
+	)
+	if err != nil {
+		s.revertTransfer(from, to, debit, credit)
+		unlock()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	from.journalSeq.Store(ticket.Seq)
+	to.journalSeq.Store(ticket.Seq)
+	unlock()
+
+	if err := ticket.Wait(); err != nil {
+		unlock := lockStates(legs)
+		defer unlock()
+		// Если flusher уже забрал хотя бы одну проводку, перевод идёт в БД обычным путём и считается принятым.
+		if !from.hasPendingOp(debit.ID) || !to.hasPendingOp(credit.ID) {
+			log.Printf("[Journal] Transfer %s accepted without journal: %v", transfer.ID, err)
+			return transfer, nil
+		}
+		s.revertTransfer(from, to, debit, credit)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return transfer, nil
+}
+
+// revertTransfer откатывает обе проводки перевода. Вызывается под mu обоих кошельков.
+func (s *WalletService) revertTransfer(from, to *WalletState, debit, credit models.Operation) {
+	s.metrics.pendingOps.Add(-2)
+	s.traces.forget([]models.Operation{debit, credit})
+	from.removePendingOp(debit.ID)
+	from.balance.Add(-debit.Amount)
+	to.removePendingOp(credit.ID)
+	to.balance.Add(-credit.Amount)
+}
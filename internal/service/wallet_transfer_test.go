@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"api_wallet/internal/custom_err"
+	"api_wallet/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walletsInDifferentShards возвращает два id, попадающих в разные шарды.
+func walletsInDifferentShards() (uuid.UUID, uuid.UUID) {
+	first := uuid.New()
+	for {
+		second := uuid.New()
+		if shardIndex(first) != shardIndex(second) {
+			return first, second
+		}
+	}
+}
+
+func TestWalletService_Transfer(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func(statuses map[uuid.UUID]models.WalletStatus) *WalletService {
+		return NewWalletService(&mockRepository{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+				return &models.Wallet{ID: id, Balance: 100, Status: statuses[id]}, nil
+			},
+		}, nil)
+	}
+	balanceOf := func(s *WalletService, id uuid.UUID) int64 {
+		return s.getShard(id).wallets[id].balance.Load()
+	}
+
+	t.Run("Success - Legs are linked", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		service := newService(nil)
+
+		transfer, err := service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 40, RequestID: uuid.New()})
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(60), balanceOf(service, from))
+		assert.Equal(t, int64(140), balanceOf(service, to))
+
+		debit := service.getShard(from).wallets[from].ops
+		credit := service.getShard(to).wallets[to].ops
+		require.Len(t, debit, 1)
+		require.Len(t, credit, 1)
+		assert.Equal(t, int64(-40), debit[0].Amount)
+		assert.Equal(t, int64(40), credit[0].Amount)
+		assert.Equal(t, transfer.ID, *debit[0].TransferID)
+		assert.Equal(t, transfer.ID, *credit[0].TransferID)
+		assert.Equal(t, to, *debit[0].CounterpartyID)
+		assert.Equal(t, from, *credit[0].CounterpartyID)
+		assert.Equal(t, uuid.Nil, credit[0].RequestID, "request ID belongs to the debit leg only")
+	})
+
+	t.Run("Error - Insufficient Funds", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		service := newService(nil)
+
+		_, err := service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 500})
+		require.ErrorIs(t, err, custom_err.ErrInsufficientFunds)
+
+		assert.Equal(t, int64(100), balanceOf(service, from))
+		assert.Equal(t, int64(100), balanceOf(service, to))
+		assert.Empty(t, service.getShard(to).wallets[to].ops)
+	})
+
+	t.Run("Error - Same Wallet", func(t *testing.T) {
+		id := uuid.New()
+		_, err := newService(nil).Transfer(ctx, models.TransferRequest{FromWalletID: id, ToWalletID: id, Amount: 1})
+		assert.ErrorIs(t, err, custom_err.ErrSameWallet)
+	})
+
+	t.Run("Statuses of both wallets are enforced", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+
+		_, err := newService(map[uuid.UUID]models.WalletStatus{from: models.WalletFrozen}).
+			Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 10})
+		assert.ErrorIs(t, err, custom_err.ErrWalletFrozen)
+
+		_, err = newService(map[uuid.UUID]models.WalletStatus{to: models.WalletFrozen}).
+			Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 10})
+		assert.NoError(t, err, "frozen wallet still accepts incoming transfers")
+
+		service := newService(map[uuid.UUID]models.WalletStatus{to: models.WalletClosed})
+		_, err = service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 10})
+		assert.ErrorIs(t, err, custom_err.ErrWalletClosed)
+		assert.Equal(t, int64(100), balanceOf(service, from))
+	})
+
+	t.Run("Retry is applied once", func(t *testing.T) {
+		from, to := walletsInDifferentShards()
+		service := newService(nil)
+		req := models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 10, RequestID: uuid.New()}
+
+		first, err := service.Transfer(ctx, req)
+		require.NoError(t, err)
+		second, err := service.Transfer(ctx, req)
+		require.NoError(t, err)
+
+		assert.Equal(t, first.ID, second.ID)
+		assert.Equal(t, int64(90), balanceOf(service, from))
+
+		req.Amount = 20
+		_, err = service.Transfer(ctx, req)
+		assert.ErrorIs(t, err, custom_err.ErrDuplicateRequest)
+	})
+
+	t.Run("Opposite transfers do not deadlock", func(t *testing.T) {
+		a, b := walletsInDifferentShards()
+		service := newService(nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, _ = service.Transfer(ctx, models.TransferRequest{FromWalletID: a, ToWalletID: b, Amount: 1})
+			}()
+			go func() {
+				defer wg.Done()
+				_, _ = service.Transfer(ctx, models.TransferRequest{FromWalletID: b, ToWalletID: a, Amount: 1})
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int64(200), balanceOf(service, a)+balanceOf(service, b), "money must be conserved")
+	})
+}
+
+func TestWalletService_flushTransferGroup(t *testing.T) {
+	ctx := context.Background()
+	from, to := walletsInDifferentShards()
+
+	var gotIDs []uuid.UUID
+	var gotOps []models.Operation
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100}, nil
+		},
+		BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			gotIDs, gotOps = walletIDs, ops
+			return nil, nil
+		},
+	}, nil)
+
+	_, err := service.Transfer(ctx, models.TransferRequest{FromWalletID: from, ToWalletID: to, Amount: 25})
+	require.NoError(t, err)
+
+	groups := service.collectDirty(service.getShard(from), maxBatchSize)
+	require.Len(t, groups, 1)
+	require.Len(t, groups[0], 2, "counterparty wallet must be flushed in the same transaction")
+
+	assert.Empty(t, service.collectDirty(service.getShard(to), maxBatchSize), "counterparty is already being flushed")
+
+	changes, err := service.persistSnapshots(groups[0])
+	require.NoError(t, err)
+	service.completeSnapshots(groups[0], changes)
+
+	assert.ElementsMatch(t, []uuid.UUID{from, to}, gotIDs)
+	assert.Equal(t, map[uuid.UUID]int64{from: -25, to: 25}, sumByWallet(gotOps))
+	assert.False(t, service.getShard(to).wallets[to].dirty.Load())
+}
+
+func TestWalletService_flushLongTransferChain(t *testing.T) {
+	ctx := context.Background()
+
+	var calls int
+	var gotIDs []uuid.UUID
+	service := NewWalletService(&mockRepository{
+		GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: id, Balance: 100}, nil
+		},
+		BulkApplyOperationsFunc: func(ctx context.Context, walletIDs []uuid.UUID, ops []models.Operation, versions map[uuid.UUID]int64) ([]models.WalletChange, error) {
+			calls++
+			gotIDs = walletIDs
+			return nil, nil
+		},
+	}, nil)
+
+	// Цепочка переводов связывает 100 кошельков в одну группу.
+	wallets := make([]uuid.UUID, 100)
+	for i := range wallets {
+		wallets[i] = uuid.New()
+	}
+	for i := 1; i < len(wallets); i++ {
+		_, err := service.Transfer(ctx, models.TransferRequest{FromWalletID: wallets[i-1], ToWalletID: wallets[i], Amount: 1})
+		require.NoError(t, err)
+	}
+
+	_, err := service.FlushAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls, "legs of every transfer must be written in one transaction")
+	assert.ElementsMatch(t, wallets, gotIDs)
+}
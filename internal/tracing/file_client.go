@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// FileClient — otlptrace.Client, который дописывает каждый запрос экспорта в
+// файл одной строкой OTLP JSON (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding).
+type FileClient struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileClient(path string) *FileClient {
+	return &FileClient{path: path}
+}
+
+func (c *FileClient) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("ошибка создания каталога трейсов: %w", err)
+	}
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия файла трейсов: %w", err)
+	}
+	c.file = file
+	return nil
+}
+
+func (c *FileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+func (c *FileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	line, err := marshalOTLP(&collectortrace.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования трейсов: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return errors.New("файл трейсов закрыт")
+	}
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+// idFields — поля-идентификаторы, которые OTLP JSON кодирует в hex, а не в base64,
+// как остальные bytes в protobuf JSON.
+var idFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+// marshalOTLP кодирует запрос в OTLP JSON: protobuf JSON с числовыми enum и
+// идентификаторами в hex.
+func marshalOTLP(req *collectortrace.ExportTraceServiceRequest) ([]byte, error) {
+	raw, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if err := hexIDs(doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func hexIDs(node any) error {
+	switch v := node.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if str, ok := value.(string); ok && idFields[key] {
+				id, err := base64.StdEncoding.DecodeString(str)
+				if err != nil {
+					return fmt.Errorf("поле %s: %w", key, err)
+				}
+				v[key] = hex.EncodeToString(id)
+				continue
+			}
+			if err := hexIDs(value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, value := range v {
+			if err := hexIDs(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
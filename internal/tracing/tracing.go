@@ -0,0 +1,77 @@
+// Package tracing настраивает OpenTelemetry: провайдер трейсов, экспорт и
+// распространение контекста через заголовки W3C traceparent/tracestate.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// Экспортёры трейсов.
+const (
+	// ExporterNone — спаны не записываются, но контекст трейса из входящих
+	// запросов передаётся дальше.
+	ExporterNone = "none"
+	// ExporterStdout — спаны пишутся в stdout в формате stdouttrace.
+	ExporterStdout = "stdout"
+	// ExporterOTLPFile — спаны пишутся в файл в формате OTLP JSON, по запросу
+	// экспорта на строку; такой файл читает otlpjsonfile receiver коллектора.
+	ExporterOTLPFile = "otlp-file"
+)
+
+type Config struct {
+	ServiceName string
+	Exporter    string
+	// File — путь к файлу для ExporterOTLPFile.
+	File string
+	// SampleRatio — доля трейсов, которые начинаются в сервисе и записываются.
+	// Решение вызывающего из traceparent соблюдается всегда.
+	SampleRatio float64
+}
+
+// Setup устанавливает глобальные провайдер трейсов и propagator. Возвращённая
+// функция дописывает накопленные спаны и закрывает экспорт.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLPFile:
+		if cfg.File == "" {
+			return nil, errors.New("не задан файл для экспорта трейсов")
+		}
+		exporter, err = otlptrace.New(ctx, NewFileClient(cfg.File))
+	default:
+		return nil, fmt.Errorf("неизвестный экспортёр трейсов %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания экспортёра трейсов: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка описания ресурса: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup_OTLPFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "traces", "traces.jsonl")
+
+	shutdown, err := Setup(ctx, Config{ServiceName: "api_wallet_test", Exporter: ExporterOTLPFile, File: path, SampleRatio: 1})
+	require.NoError(t, err)
+
+	_, parent := otel.Tracer("test").Start(ctx, "parent")
+	childCtx := trace.ContextWithSpan(ctx, parent)
+	_, child := otel.Tracer("test").Start(childCtx, "child", trace.WithSpanKind(trace.SpanKindServer))
+	child.End()
+	parent.End()
+	require.NoError(t, shutdown(ctx))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	type otlpSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+	}
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	spans := make(map[string]otlpSpan)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &request))
+		for _, rs := range request.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Contains(t, spans, "parent")
+	require.Contains(t, spans, "child")
+	sc := parent.SpanContext()
+	assert.Equal(t, sc.TraceID().String(), spans["child"].TraceID, "trace ids are hex encoded")
+	assert.Equal(t, sc.SpanID().String(), spans["child"].ParentSpanID)
+	assert.Equal(t, int(trace.SpanKindServer), spans["child"].Kind, "enums are numbers")
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,6 @@
+ALTER TABLE operations ADD COLUMN operation_type TEXT;
+
+UPDATE operations
+SET operation_type = CASE WHEN amount < 0 THEN 'WITHDRAW' ELSE 'DEPOSIT' END;
+
+ALTER TABLE operations ALTER COLUMN operation_type SET NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE wallets
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+    CONSTRAINT wallets_status_check CHECK (status IN ('active', 'frozen', 'closed'));
@@ -0,0 +1,5 @@
+ALTER TABLE operations
+    ADD COLUMN transfer_id UUID NULL,
+    ADD COLUMN counterparty_wallet_id UUID NULL;
+
+CREATE INDEX IF NOT EXISTS idx_operations_transfer_id ON operations (transfer_id) WHERE transfer_id IS NOT NULL;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_operations_wallet_created_id ON operations (wallet_id, created_at DESC, id DESC);
@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION notify_wallet_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('wallet_changes', json_build_object(
+      'id', NEW.id,
+      'balance', NEW.balance,
+      'version', NEW.version,
+      'status', NEW.status
+  )::text);
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_notify_wallet_change
+    AFTER UPDATE ON wallets
+    FOR EACH ROW
+    WHEN (OLD.version IS DISTINCT FROM NEW.version)
+    EXECUTE FUNCTION notify_wallet_change();
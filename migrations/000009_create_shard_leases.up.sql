@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS shard_leases (
+    shard INT PRIMARY KEY,
+    owner_id TEXT NOT NULL,
+    owner_addr TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Живые экземпляры, в том числе ещё не получившие ни одного шарда:
+-- по их числу владельцы решают, сколько шардов отдать.
+CREATE TABLE IF NOT EXISTS cluster_members (
+    instance_id TEXT PRIMARY KEY,
+    addr TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
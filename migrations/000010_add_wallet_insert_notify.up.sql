@@ -0,0 +1,4 @@
+CREATE TRIGGER trigger_notify_wallet_insert
+    AFTER INSERT ON wallets
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_wallet_change();
@@ -0,0 +1,12 @@
+-- Ключи хранятся только в виде SHA-256: сам ключ показывается один раз при создании.
+-- wallet_ids = NULL — ключ не ограничен набором кошельков.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash BYTEA NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    wallet_ids UUID[],
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
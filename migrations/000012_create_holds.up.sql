@@ -0,0 +1,35 @@
+-- reserved — сумма активных холдов кошелька. Меняется в одной транзакции с holds,
+-- поэтому доступный остаток (balance - reserved) всегда согласован с ними.
+ALTER TABLE wallets ADD COLUMN reserved BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS holds (
+    id UUID PRIMARY KEY,
+    wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    captured_amount BIGINT NOT NULL DEFAULT 0,
+    status TEXT NOT NULL
+        CONSTRAINT holds_status_check CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+    request_id UUID NULL,
+    operation_id UUID NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_holds_wallet_request_id ON holds (wallet_id, request_id);
+CREATE INDEX IF NOT EXISTS idx_holds_active_expires_at ON holds (expires_at) WHERE status = 'active';
+
+-- Кэш других экземпляров должен узнавать и об изменении reserved.
+CREATE OR REPLACE FUNCTION notify_wallet_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('wallet_changes', json_build_object(
+      'id', NEW.id,
+      'balance', NEW.balance,
+      'reserved', NEW.reserved,
+      'version', NEW.version,
+      'status', NEW.status
+  )::text);
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,292 @@
+// Package request разбирает JSON-тела запросов строже, чем encoding/json:
+// с ограничением размера, без неизвестных полей и лишних данных после объекта.
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// DefaultMaxBytes — размер тела по умолчанию. Запросы API укладываются в сотни байт.
+const DefaultMaxBytes = 64 << 10
+
+// Ошибки тела целиком. Decode возвращает их внутри *Error.
+var (
+	ErrTooLarge     = errors.New("тело запроса слишком большое")
+	ErrEmpty        = errors.New("пустое тело запроса")
+	ErrSyntax       = errors.New("тело запроса не является JSON")
+	ErrNotObject    = errors.New("тело запроса не является JSON-объектом")
+	ErrTrailingData = errors.New("данные после JSON-объекта")
+)
+
+// Position — место ошибки в теле: смещение в байтах с нуля, строка и столбец с единицы.
+type Position struct {
+	Offset int64
+	Line   int
+	Column int
+}
+
+// Error — тело нельзя разобрать как один JSON-объект.
+type Error struct {
+	// Err — одна из ошибок ErrTooLarge, ErrEmpty, ErrSyntax, ErrNotObject, ErrTrailingData.
+	Err error
+	// Position не заполняется для ErrTooLarge и ErrEmpty.
+	Position Position
+	// Reason — описание синтаксической ошибки от encoding/json.
+	Reason string
+}
+
+func (e *Error) Error() string {
+	msg := e.Err.Error()
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Position.Line > 0 {
+		msg += fmt.Sprintf(" (строка %d, столбец %d)", e.Position.Line, e.Position.Column)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrorKind — вид ошибки в поле.
+type FieldErrorKind string
+
+const (
+	FieldUnknown   FieldErrorKind = "unknown"
+	FieldDuplicate FieldErrorKind = "duplicate"
+	FieldInvalid   FieldErrorKind = "invalid"
+)
+
+// FieldError — ошибка в одном поле объекта.
+type FieldError struct {
+	// Field — имя поля в теле. У FieldInvalid это текущее имя, даже если
+	// клиент прислал устаревшее.
+	Field string
+	Kind  FieldErrorKind
+	// Expected описывает ожидаемое значение у FieldInvalid, например "a UUID".
+	Expected string
+	Position Position
+}
+
+// FieldErrors — ошибки во всех полях тела. Поля без ошибок при этом разобраны.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, f := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %s (строка %d, столбец %d)", f.Field, f.Kind, f.Position.Line, f.Position.Column))
+	}
+	return "ошибки в полях запроса: " + strings.Join(msgs, "; ")
+}
+
+// Decoder разбирает тела запросов. Безопасен для одновременного использования.
+type Decoder struct {
+	maxBytes int64
+	aliases  map[string]string
+}
+
+type Option func(*Decoder)
+
+// WithMaxBytes ограничивает размер тела.
+func WithMaxBytes(n int64) Option {
+	return func(d *Decoder) {
+		d.maxBytes = n
+	}
+}
+
+// WithAliases задаёт устаревшие имена полей: ключ — старое имя, значение — текущее.
+// Старое имя принимается только в структурах, где есть поле с текущим именем.
+func WithAliases(aliases map[string]string) Option {
+	return func(d *Decoder) {
+		for old, name := range aliases {
+			d.aliases[old] = name
+		}
+	}
+}
+
+func NewDecoder(opts ...Option) *Decoder {
+	d := &Decoder{
+		maxBytes: DefaultMaxBytes,
+		aliases:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Names возвращает имя поля и его устаревшие имена: под любым из них поле
+// может прийти в теле.
+func (d *Decoder) Names(field string) []string {
+	names := []string{field}
+	for old, name := range d.aliases {
+		if name == field {
+			names = append(names, old)
+		}
+	}
+	slices.Sort(names[1:])
+	return names
+}
+
+// Decode разбирает JSON-объект из r в структуру, на которую указывает dst.
+// Поля сопоставляются с тегами json точно, с учётом регистра. Ошибка тела
+// целиком — *Error, ошибки в полях — FieldErrors.
+func (d *Decoder) Decode(r io.Reader, dst any) error {
+	data, err := io.ReadAll(io.LimitReader(r, d.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > d.maxBytes {
+		return &Error{Err: ErrTooLarge}
+	}
+	start := skip(data, 0, "")
+	if start == len(data) {
+		return &Error{Err: ErrEmpty}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		var syntax *json.SyntaxError
+		switch {
+		case errors.As(err, &syntax):
+			return &Error{Err: ErrSyntax, Position: positionOf(data, syntax.Offset-1), Reason: syntax.Error()}
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return &Error{Err: ErrSyntax, Position: positionOf(data, int64(len(data))), Reason: "unexpected end of JSON input"}
+		}
+		return err
+	}
+	if rest := skip(data, int(dec.InputOffset()), ""); rest < len(data) {
+		return &Error{Err: ErrTrailingData, Position: positionOf(data, int64(rest))}
+	}
+	if data[start] != '{' {
+		return &Error{Err: ErrNotObject, Position: positionOf(data, int64(start))}
+	}
+
+	return d.decodeFields(data, reflect.ValueOf(dst).Elem())
+}
+
+// decodeFields разбирает поля синтаксически верного объекта по одному, чтобы
+// ошибка в одном поле не мешала разобрать остальные.
+func (d *Decoder) decodeFields(data []byte, v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+	seen := make(map[string]bool, len(fields))
+	var errs FieldErrors
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		keyAt := skip(data, int(dec.InputOffset()), ",")
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		valueAt := skip(data, int(dec.InputOffset()), ":")
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+
+		key := tok.(string)
+		name := key
+		if current, ok := d.aliases[key]; ok {
+			if _, ok := fields[current]; ok {
+				name = current
+			}
+		}
+		i, ok := fields[name]
+		switch {
+		case !ok:
+			errs = append(errs, FieldError{Field: key, Kind: FieldUnknown, Position: positionOf(data, int64(keyAt))})
+			continue
+		case seen[name]:
+			errs = append(errs, FieldError{Field: key, Kind: FieldDuplicate, Position: positionOf(data, int64(keyAt))})
+			continue
+		}
+		seen[name] = true
+
+		field := v.Field(i)
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			errs = append(errs, FieldError{
+				Field:    name,
+				Kind:     FieldInvalid,
+				Expected: expectedType(field.Type()),
+				Position: positionOf(data, int64(valueAt)),
+			})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// fieldsOf возвращает индексы экспортируемых полей структуры по имени в JSON.
+func fieldsOf(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// expectedType описывает для клиента, значение какого типа ожидается в поле.
+func expectedType(t reflect.Type) string {
+	switch {
+	case t == uuidType:
+		return "a UUID"
+	case t.Kind() == reflect.Slice && t.Elem() == uuidType:
+		return "an array of UUIDs"
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		return "an array of strings"
+	case t.Kind() == reflect.String:
+		return "a string"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return "an integer"
+	case t.Kind() == reflect.Bool:
+		return "a boolean"
+	}
+	return "a valid value"
+}
+
+// skip пропускает пробельные символы и разделители seps, начиная с i.
+func skip(data []byte, i int, seps string) int {
+	for i < len(data) && (strings.IndexByte(" \t\r\n", data[i]) >= 0 || strings.IndexByte(seps, data[i]) >= 0) {
+		i++
+	}
+	return i
+}
+
+func positionOf(data []byte, offset int64) Position {
+	offset = max(0, min(offset, int64(len(data))))
+	before := data[:offset]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return Position{Offset: offset, Line: line, Column: column}
+}
@@ -0,0 +1,130 @@
+package request
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type operationRequest struct {
+	WalletID  uuid.UUID `json:"walletId"`
+	Type      string    `json:"operationType"`
+	Amount    int64     `json:"amount"`
+	RequestID uuid.UUID `json:"requestId"`
+	internal  string
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	walletID := uuid.MustParse("a7c9a494-386b-436d-8a58-29b7a3f754a3")
+	decoder := NewDecoder(WithMaxBytes(256), WithAliases(map[string]string{"valletId": "walletId", "sum": "total"}))
+
+	testCases := []struct {
+		name           string
+		body           string
+		expectedReq    operationRequest
+		expectedErr    error
+		expectedPos    Position
+		expectedFields FieldErrors
+	}{
+		{
+			name:        "Valid Body",
+			body:        `{"walletId":"a7c9a494-386b-436d-8a58-29b7a3f754a3","operationType":"DEPOSIT","amount":100}`,
+			expectedReq: operationRequest{WalletID: walletID, Type: "DEPOSIT", Amount: 100},
+		},
+		{
+			name:        "Legacy Alias",
+			body:        `{"valletId":"a7c9a494-386b-436d-8a58-29b7a3f754a3","amount":1}`,
+			expectedReq: operationRequest{WalletID: walletID, Amount: 1},
+		},
+		{
+			name:        "Too Large",
+			body:        `{"operationType":"` + strings.Repeat("A", 300) + `"}`,
+			expectedErr: ErrTooLarge,
+		},
+		{
+			name:        "Empty",
+			body:        " \n ",
+			expectedErr: ErrEmpty,
+		},
+		{
+			name:        "Syntax Error Position",
+			body:        "{\n  \"amount\": 1,\n  \"walletId\": x\n}",
+			expectedErr: ErrSyntax,
+			expectedPos: Position{Offset: 31, Line: 3, Column: 15},
+		},
+		{
+			name:        "Unexpected End",
+			body:        `{"amount":1`,
+			expectedErr: ErrSyntax,
+			expectedPos: Position{Offset: 11, Line: 1, Column: 12},
+		},
+		{
+			name:        "Trailing Data",
+			body:        `{"amount":1} {"amount":2}`,
+			expectedErr: ErrTrailingData,
+			expectedPos: Position{Offset: 13, Line: 1, Column: 14},
+		},
+		{
+			name:        "Not An Object",
+			body:        ` [1]`,
+			expectedErr: ErrNotObject,
+			expectedPos: Position{Offset: 1, Line: 1, Column: 2},
+		},
+		{
+			name:        "Field Errors",
+			body:        `{"amount":"100", "walletID":"x", "operationType":"DEPOSIT", "amount":5, "internal":"x", "sum":1}`,
+			expectedReq: operationRequest{Type: "DEPOSIT"},
+			expectedFields: FieldErrors{
+				{Field: "amount", Kind: FieldInvalid, Expected: "an integer", Position: Position{Offset: 10, Line: 1, Column: 11}},
+				// Поля сопоставляются с учётом регистра.
+				{Field: "walletID", Kind: FieldUnknown, Position: Position{Offset: 17, Line: 1, Column: 18}},
+				{Field: "amount", Kind: FieldDuplicate, Position: Position{Offset: 60, Line: 1, Column: 61}},
+				{Field: "internal", Kind: FieldUnknown, Position: Position{Offset: 72, Line: 1, Column: 73}},
+				// Алиас другой структуры здесь не действует.
+				{Field: "sum", Kind: FieldUnknown, Position: Position{Offset: 88, Line: 1, Column: 89}},
+			},
+		},
+		{
+			name:        "Alias And Current Name Together",
+			body:        `{"walletId":"a7c9a494-386b-436d-8a58-29b7a3f754a3","valletId":"a7c9a494-386b-436d-8a58-29b7a3f754a3"}`,
+			expectedReq: operationRequest{WalletID: walletID},
+			expectedFields: FieldErrors{
+				{Field: "valletId", Kind: FieldDuplicate, Position: Position{Offset: 51, Line: 1, Column: 52}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req operationRequest
+			err := decoder.Decode(strings.NewReader(tc.body), &req)
+
+			switch {
+			case tc.expectedErr != nil:
+				require.ErrorIs(t, err, tc.expectedErr)
+				var decodeErr *Error
+				require.True(t, errors.As(err, &decodeErr))
+				assert.Equal(t, tc.expectedPos, decodeErr.Position)
+				return
+			case tc.expectedFields != nil:
+				var fieldErrs FieldErrors
+				require.True(t, errors.As(err, &fieldErrs), "unexpected error %v", err)
+				assert.Equal(t, tc.expectedFields, fieldErrs)
+			default:
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedReq, req)
+		})
+	}
+}
+
+func TestDecoder_Names(t *testing.T) {
+	decoder := NewDecoder(WithAliases(map[string]string{"valletId": "walletId", "wallet_id": "walletId", "sum": "amount"}))
+
+	assert.Equal(t, []string{"walletId", "valletId", "wallet_id"}, decoder.Names("walletId"))
+	assert.Equal(t, []string{"toWalletId"}, decoder.Names("toWalletId"))
+}